@@ -0,0 +1,82 @@
+// Command mailsink is a stand-in for an SMTP relay, for trying email
+// digests locally. It accepts every message and prints it instead of
+// delivering it.
+//
+// Run the server with SMTP_HOST=localhost SMTP_PORT=1025.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+func main() {
+	addr := flag.String("addr", ":1025", "listen address")
+	flag.Parse()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("SMTP stand-in listening on", *addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		go serve(conn)
+	}
+}
+
+// serve speaks just enough SMTP for a client that sends one message at a
+// time without TLS or authentication.
+func serve(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 mailsink ready")
+
+	var from string
+	var to []string
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 mailsink")
+		case "MAIL":
+			from, to = arg, nil
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			to = append(to, arg)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			lines, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+			log.Printf("message %s %s\n%s\n", from, strings.Join(to, " "), strings.Join(lines, "\n"))
+			tp.PrintfLine("250 OK")
+		case "RSET":
+			from, to = "", nil
+			tp.PrintfLine("250 OK")
+		case "NOOP":
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Command pushstub is a stand-in for a Web Push service, for trying push
+// notifications locally. It accepts every push and logs it. Endpoints whose
+// path starts with /gone/ answer 410 Gone, as a real push service does for
+// an expired subscription.
+//
+// Run the server with PUSH_ALLOW_PRIVATE=true and subscribe with an endpoint
+// such as http://localhost:8089/push/device-1.
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+func main() {
+	addr := flag.String("addr", ":8089", "listen address")
+	flag.Parse()
+
+	http.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 8<<10))
+
+		log.Printf("push to %s: %d bytes, encoding=%s ttl=%s urgency=%s authorization=%.40s...",
+			r.URL.Path,
+			len(body),
+			r.Header.Get("Content-Encoding"),
+			r.Header.Get("TTL"),
+			r.Header.Get("Urgency"),
+			r.Header.Get("Authorization"),
+		)
+
+		if strings.HasPrefix(r.URL.Path, "/gone/") {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	log.Println("push service stand-in listening on", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
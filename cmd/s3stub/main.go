@@ -0,0 +1,68 @@
+// Command s3stub is a stand-in for an S3-compatible object store, for trying
+// message archival locally. It stores objects as files under a directory,
+// one subdirectory per bucket, and does not check signatures.
+//
+// Run the server with ARCHIVE_S3_ENDPOINT=http://localhost:9000 and
+// ARCHIVE_S3_BUCKET=chat-archive.
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	dir := flag.String("dir", filepath.Join(os.TempDir(), "s3stub"), "directory to store objects in")
+	flag.Parse()
+
+	http.HandleFunc("/{bucket}/{key}", func(w http.ResponseWriter, r *http.Request) {
+		bucket, key := r.PathValue("bucket"), r.PathValue("key")
+		if strings.HasPrefix(bucket, ".") || strings.HasPrefix(key, ".") {
+			http.Error(w, "invalid name", http.StatusBadRequest)
+			return
+		}
+		path := filepath.Join(*dir, bucket, key)
+
+		log.Printf("%s %s/%s authorization=%.60s...", r.Method, bucket, key, r.Header.Get("Authorization"))
+
+		switch r.Method {
+		case http.MethodPut:
+			if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			f, err := os.Create(path)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			_, err = io.Copy(f, r.Body)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodGet, http.MethodHead:
+			http.ServeFile(w, r, path)
+		case http.MethodDelete:
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	log.Println("S3 stand-in listening on", *addr, "storing objects in", *dir)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/config"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/db"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/server"
+)
+
+// runArchive archives and restores months of messages from the command
+// line:
+//
+//	server archive run [MONTH]
+//	server archive list
+//	server archive restore MONTH
+//
+// MONTH is written as 2006-01. Without one, run archives every month that
+// ARCHIVE_AFTER_MONTHS says is due.
+func runArchive(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: server archive run [MONTH] | list | restore MONTH")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var month time.Time
+	if fs.NArg() == 2 {
+		var err error
+		if month, err = time.Parse("2006-01", fs.Arg(1)); err != nil {
+			fs.Usage()
+			os.Exit(2)
+		}
+	}
+
+	dbConn := openDB(cfg)
+	archiver := server.NewArchiver(dbConn, cfg)
+
+	switch fs.Arg(0) {
+	case "run":
+		var archived []models.MessageArchive
+		var err error
+		if month.IsZero() {
+			if cfg.ArchiveAfterMonths == 0 {
+				log.Fatal("ARCHIVE_AFTER_MONTHS is not set; give the month to archive")
+			}
+			archived, err = archiver.ArchiveDue(time.Now())
+		} else {
+			var ar *models.MessageArchive
+			if ar, err = archiver.Archive(month); ar != nil {
+				archived = append(archived, *ar)
+			}
+		}
+		for _, ar := range archived {
+			fmt.Printf("archived %d messages of %s to %s\n", ar.Messages, ar.Month, ar.Object)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(archived) == 0 {
+			fmt.Println("nothing to archive")
+		}
+	case "list":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		var archives []models.MessageArchive
+		if err := dbConn.Order("month, archived_at").Find(&archives).Error; err != nil {
+			log.Fatal(err)
+		}
+		for _, ar := range archives {
+			state := "archived " + ar.ArchivedAt.Local().Format("2006-01-02 15:04:05")
+			if ar.RestoredAt != nil {
+				state = "restored " + ar.RestoredAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%s  %8d messages  %-40s %s\n", ar.Month, ar.Messages, ar.Object, state)
+		}
+	case "restore":
+		if month.IsZero() {
+			fs.Usage()
+			os.Exit(2)
+		}
+		restored, err := archiver.Restore(month)
+		for _, ar := range restored {
+			if ar.RestoredAt != nil {
+				fmt.Printf("restored %d messages from %s\n", ar.Messages, ar.Object)
+			}
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if db.Partitioned(dbConn) {
+			fmt.Printf("partition %s is attached\n", db.PartitionName(month))
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
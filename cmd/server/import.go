@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/config"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/importer"
+)
+
+// runImport imports a Slack or WhatsApp export from the command line:
+//
+//	server import [flags] slack|whatsapp FILE
+func runImport(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: server import [flags] slack|whatsapp FILE")
+		fs.PrintDefaults()
+	}
+
+	var opts importer.Options
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "report what would be imported without writing anything")
+	fs.BoolVar(&opts.CreatePlaceholders, "create-users", false, "create placeholder users for participants that match no user")
+	fs.StringVar(&opts.DateOrder, "date-order", "", `WhatsApp date order, "dmy" or "mdy" (detected when empty)`)
+	fs.StringVar(&opts.Timezone, "timezone", "", "WhatsApp time zone (default UTC)")
+	fs.Func("user", "map a participant to a user, as NAME_OR_ID=USERNAME_OR_EMAIL (repeatable)", func(v string) error {
+		from, to, ok := strings.Cut(v, "=")
+		if !ok || from == "" || to == "" {
+			return fmt.Errorf("expected NAME_OR_ID=USERNAME_OR_EMAIL")
+		}
+		if opts.Users == nil {
+			opts.Users = map[string]string{}
+		}
+		opts.Users[from] = to
+		return nil
+	})
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	source, path := fs.Arg(0), fs.Arg(1)
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	dbConn := openDB(cfg)
+
+	var report *importer.Report
+	switch source {
+	case importer.SourceSlack:
+		var info os.FileInfo
+		if info, err = f.Stat(); err != nil {
+			log.Fatal(err)
+		}
+		report, err = importer.ImportSlack(dbConn, f, info.Size(), opts)
+	case importer.SourceWhatsApp:
+		report, err = importer.ImportWhatsApp(dbConn, f, opts)
+	default:
+		err = importer.ErrUnknownSource
+	}
+	if err != nil {
+		log.Fatal("import failed: ", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
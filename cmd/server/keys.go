@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/config"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/encryption"
+)
+
+// runKeys manages message encryption keys from the command line:
+//
+//	server keys status
+//	server keys rotate
+//	server keys reencrypt
+//	server keys decrypt
+//
+// rotate retires every conversation key; reencrypt then moves content to
+// the new keys at once, instead of waiting for the server's hourly pass.
+// decrypt stores all content as plaintext again, before encryption is
+// turned off.
+func runKeys(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("keys", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: server keys status | rotate | reencrypt | decrypt")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	dbConn := openDB(cfg)
+	reencryptor := &encryption.Reencryptor{DB: dbConn}
+
+	switch fs.Arg(0) {
+	case "status":
+		status, err := encryption.GetStatus(dbConn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%d messages stored as plaintext\n", status.Plaintext)
+		fmt.Printf("%d messages under retired keys\n", status.Retired)
+
+		ids := make([]string, 0, len(status.MasterKeys))
+		for id := range status.MasterKeys {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			fmt.Printf("%d conversation keys wrapped by master key %s\n", status.MasterKeys[id], id)
+		}
+	case "rotate":
+		if !encryption.Enabled(dbConn) {
+			log.Fatal("ENCRYPTION_MASTER_KEYS is not set")
+		}
+		retired, err := encryption.Rotate(dbConn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("retired %d conversation keys\n", retired)
+	case "reencrypt":
+		rewrapped, messages, err := reencryptor.Pass()
+		fmt.Printf("rewrapped %d conversation keys, re-encrypted %d messages\n", rewrapped, messages)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "decrypt":
+		decrypted, err := reencryptor.DecryptAll()
+		fmt.Printf("decrypted %d messages\n", decrypted)
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
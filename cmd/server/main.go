@@ -3,9 +3,9 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/config"
-	"github.com/dakshcodez/real_time_chat_application_backend/internal/db"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/server"
 	"github.com/joho/godotenv"
@@ -18,13 +18,38 @@ func main() {
 	}
 
 	cfg := config.Load()
-	dbConn := db.Connect(cfg.DBUrl)
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			runImport(cfg, os.Args[2:])
+		case "migrate":
+			runMigrate(cfg, os.Args[2:])
+		case "archive":
+			runArchive(cfg, os.Args[2:])
+		case "keys":
+			runKeys(cfg, os.Args[2:])
+		case "backup":
+			runBackup(cfg, os.Args[2:])
+		case "restore":
+			runRestore(cfg, os.Args[2:])
+		default:
+			log.Fatalf("unknown command %q; commands: archive, backup, import, keys, migrate, restore", os.Args[1])
+		}
+		return
+	}
+
+	dbConn := openDB(cfg)
+	replicas := openReplicas(cfg)
 
 	mux := http.NewServeMux()
-	server.RegisterRoutes(mux, dbConn, cfg.JWTSecret)
+	server.RegisterRoutes(mux, dbConn, cfg, replicas...)
+
+	// Resolve the client address behind proxies before anything keys on it
+	handler := middleware.RealIP(cfg.TrustedProxyHops)(mux)
 
 	// Wrap the mux with the CORS middleware
-	handler := middleware.CORS(mux)
+	handler = middleware.CORS(handler)
 
 	log.Printf("Server running on :%s\n", cfg.Port)
 	log.Fatal(http.ListenAndServe(":"+cfg.Port, handler))
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/config"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/db"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/encryption"
+	"gorm.io/gorm"
+)
+
+// runMigrate manages the database schema from the command line:
+//
+//	server migrate up [VERSION]
+//	server migrate down [STEPS]
+//	server migrate status
+func runMigrate(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: server migrate up [VERSION] | down [STEPS] | status")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	n := 0
+	if fs.NArg() == 2 {
+		var err error
+		if n, err = strconv.Atoi(fs.Arg(1)); err != nil || n < 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+	}
+
+	migrator, err := db.NewMigrator(db.Connect(cfg.DBUrl))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch fs.Arg(0) {
+	case "up":
+		applied, err := migrator.Up(n)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		reverted, err := migrator.Down(n)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		list, err := migrator.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range list {
+			name, applied := s.Name, "pending"
+			if name == "" {
+				name = "(unknown to this build)"
+			}
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-40s %s\n", s.Version, name, applied)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// openDB connects to the database and refuses to go on if its schema is
+// behind this build, applying the pending migrations first when
+// MIGRATE_ON_START is set.
+func openDB(cfg *config.Config) *gorm.DB {
+	dbConn := db.Connect(cfg.DBUrl)
+	useEncryption(cfg, dbConn)
+
+	migrator, err := db.NewMigrator(dbConn)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.MigrateOnStart {
+		applied, err := migrator.Up(0)
+		for _, m := range applied {
+			log.Printf("applied migration %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal("migration failed: ", err)
+		}
+	}
+
+	if err := migrator.Check(); err != nil {
+		log.Fatalf("%v; run `server migrate up` or set MIGRATE_ON_START=true", err)
+	}
+	return dbConn
+}
+
+// openReplicas connects to the read replicas in DATABASE_REPLICA_URLS. Their
+// schema is the primary's, so it is not checked.
+func openReplicas(cfg *config.Config) []*gorm.DB {
+	var replicas []*gorm.DB
+	for _, url := range strings.Split(cfg.DBReplicaURLs, ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		replica := db.Connect(url)
+		useEncryption(cfg, replica)
+		replicas = append(replicas, replica)
+	}
+	return replicas
+}
+
+// useEncryption registers the encryption plugin on a connection. It is
+// registered without master keys too, so encrypted content is never served
+// as it is stored.
+func useEncryption(cfg *config.Config, conn *gorm.DB) {
+	var kms encryption.KMS
+	if cfg.EncryptionMasterKeys != "" {
+		local, err := encryption.ParseMasterKeys(cfg.EncryptionMasterKeys)
+		if err != nil {
+			log.Fatal("ENCRYPTION_MASTER_KEYS: ", err)
+		}
+		kms = local
+	}
+	if err := conn.Use(encryption.New(kms)); err != nil {
+		log.Fatal(err)
+	}
+}
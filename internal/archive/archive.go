@@ -0,0 +1,464 @@
+// Package archive moves the messages of old months out of the database into
+// compressed files, and back. On Postgres a month is a partition of
+// messages, which is dropped once its file is stored; on SQLite its rows
+// are deleted.
+//
+// An archive file is gzipped JSON lines, one message per line in the shape
+// the history API returns, with its mentions and import key. Content is
+// written as stored: encrypted content stays encrypted, with the ID of its
+// key, and is put back as it is on restore.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/db"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/encryption"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store/sqlstore"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	pollInterval = time.Hour
+
+	// Messages read or written per query
+	batchSize = 1000
+
+	// archiveLock is the key of the Postgres advisory lock held while a
+	// month is archived or restored, so instances take turns
+	archiveLock int64 = 0x636861745f617263
+)
+
+var (
+	// ErrNotArchived means a month has no archive left to restore.
+	ErrNotArchived = errors.New("month is not archived")
+
+	// ErrChanged means messages were added to or removed from a month while
+	// it was being archived; the next pass tries again.
+	ErrChanged = errors.New("messages changed while being archived")
+)
+
+// record is a message as stored in an archive file.
+type record struct {
+	models.Message
+	ImportKey *string    `json:"import_key,omitempty"`
+	KeyID     *uuid.UUID `json:"key_id,omitempty"`
+}
+
+// Archiver keeps partitions of messages created ahead of time, and moves
+// the months older than AfterMonths to Storage.
+type Archiver struct {
+	DB      *gorm.DB
+	Storage Storage
+
+	// Whole months kept in the database before the current one; 0 never
+	// archives
+	AfterMonths int
+
+	// Partitions created after the current month's
+	AheadMonths int
+
+	// Leave out, on restore, the messages of accounts deleted since they
+	// were archived, as DELETED_USER_MESSAGES=purge removed the rest
+	PurgeDeleted bool
+}
+
+// Run creates partitions and archives old months until the process exits.
+func (a *Archiver) Run() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := db.EnsureMessagePartitions(a.DB, time.Now(), a.AheadMonths); err != nil {
+			log.Println("archive: failed to create partitions:", err)
+		}
+		if a.AfterMonths > 0 {
+			archived, err := a.ArchiveDue(time.Now())
+			for _, ar := range archived {
+				log.Printf("archive: archived %d messages of %s to %s", ar.Messages, ar.Month, ar.Object)
+			}
+			if err != nil {
+				log.Println("archive:", err)
+			}
+		}
+
+		<-ticker.C
+	}
+}
+
+// Cutoff returns the start of the oldest month that is not archived at
+// now.
+func (a *Archiver) Cutoff(now time.Time) time.Time {
+	return db.MonthStart(now).AddDate(0, -a.AfterMonths, 0)
+}
+
+// ArchiveDue archives every month before the cutoff that still has
+// messages, oldest first, and returns the archives it wrote.
+func (a *Archiver) ArchiveDue(now time.Time) ([]models.MessageArchive, error) {
+	cutoff := a.Cutoff(now)
+
+	var archived []models.MessageArchive
+	err := a.locked(func() error {
+		// Months that have stray messages get a partition first, so they
+		// are dropped rather than deleted
+		if err := db.EnsureMessagePartitions(a.DB, now, a.AheadMonths); err != nil {
+			return err
+		}
+
+		for {
+			var oldest db.Timestamp
+			err := a.DB.Model(&models.Message{}).
+				Where("created_at < ?", cutoff).
+				Select("MIN(created_at)").
+				Scan(&oldest).Error
+			if err != nil {
+				return err
+			}
+			if oldest.IsZero() {
+				return nil
+			}
+
+			ar, err := a.archive(db.MonthStart(oldest.Time))
+			if err != nil {
+				return err
+			}
+			archived = append(archived, *ar)
+		}
+	})
+	return archived, err
+}
+
+// Archive archives the messages of the month that starts at month.
+func (a *Archiver) Archive(month time.Time) (*models.MessageArchive, error) {
+	var ar *models.MessageArchive
+	err := a.locked(func() error {
+		var err error
+		ar, err = a.archive(month)
+		return err
+	})
+	return ar, err
+}
+
+func (a *Archiver) archive(month time.Time) (*models.MessageArchive, error) {
+	from, to := month, month.AddDate(0, 1, 0)
+
+	tmp, err := os.CreateTemp("", "chat-archive-*.jsonl.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	count, pairs, err := a.write(tmp, from, to)
+	if err != nil {
+		return nil, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ar := &models.MessageArchive{
+		Month:      month.Format("2006-01"),
+		Object:     fmt.Sprintf("messages-%s-%d.jsonl.gz", month.Format("2006-01"), now.Unix()),
+		Messages:   count,
+		Size:       info.Size(),
+		ArchivedAt: now,
+	}
+	if err := a.Storage.Put(ar.Object, tmp); err != nil {
+		return nil, fmt.Errorf("storing %s: %w", ar.Object, err)
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		// Nothing can be added to the month between counting and dropping
+		// it. SQLite transactions already lock out other writers.
+		partitioned, err := db.LockMessagePartition(tx, month)
+		if err != nil {
+			return err
+		}
+
+		var n int64
+		if err := inMonth(tx, from, to).Count(&n).Error; err != nil {
+			return err
+		}
+		if n != count {
+			return ErrChanged
+		}
+
+		err = tx.Where("message_id IN (?)", inMonth(tx, from, to).Select("id")).
+			Delete(&models.Mention{}).Error
+		if err != nil {
+			return err
+		}
+		if partitioned {
+			_, err = db.DropMessagePartition(tx, month)
+		} else {
+			err = inMonth(tx, from, to).Delete(&models.Message{}).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := refresh(tx, pairs); err != nil {
+			return err
+		}
+		return tx.Create(ar).Error
+	})
+	if err != nil {
+		// The file is of no use if the messages stayed
+		if derr := a.Storage.Delete(ar.Object); derr != nil {
+			log.Printf("archive: failed to delete %s: %v", ar.Object, derr)
+		}
+		return nil, fmt.Errorf("archiving %s: %w", ar.Month, err)
+	}
+	return ar, nil
+}
+
+// write writes the messages sent in [from, to) to w, oldest first, and
+// returns how many there were and the conversations they belong to.
+func (a *Archiver) write(w io.Writer, from, to time.Time) (int64, map[[2]uuid.UUID]bool, error) {
+	buf := bufio.NewWriter(w)
+	gz := gzip.NewWriter(buf)
+	enc := json.NewEncoder(gz)
+
+	var count int64
+	pairs := map[[2]uuid.UUID]bool{}
+	var after *models.Message
+	for {
+		query := inMonth(encryption.Raw(a.DB), from, to).
+			Preload("Mentions", func(db *gorm.DB) *gorm.DB { return db.Order("start_offset") }).
+			Order("created_at, id").
+			Limit(batchSize)
+		if after != nil {
+			query = query.Where("(created_at, id) > (?, ?)", after.CreatedAt, after.ID)
+		}
+
+		var batch []models.Message
+		if err := query.Find(&batch).Error; err != nil {
+			return 0, nil, err
+		}
+		for i := range batch {
+			if err := enc.Encode(record{Message: batch[i], ImportKey: batch[i].ImportKey, KeyID: batch[i].KeyID}); err != nil {
+				return 0, nil, err
+			}
+			pairs[pair(batch[i].SenderID, batch[i].ReceiverID)] = true
+		}
+		count += int64(len(batch))
+
+		if len(batch) < batchSize {
+			break
+		}
+		after = &batch[len(batch)-1]
+	}
+
+	if err := gz.Close(); err != nil {
+		return 0, nil, err
+	}
+	return count, pairs, buf.Flush()
+}
+
+// Restore puts the archived messages of the month that starts at month
+// back into the database, and returns the archives it read. Their files are
+// kept.
+func (a *Archiver) Restore(month time.Time) ([]models.MessageArchive, error) {
+	var archives []models.MessageArchive
+	err := a.locked(func() error {
+		err := a.DB.Where("month = ? AND restored_at IS NULL", month.Format("2006-01")).
+			Order("archived_at").
+			Find(&archives).Error
+		if err != nil {
+			return err
+		}
+		if len(archives) == 0 {
+			return ErrNotArchived
+		}
+
+		if err := db.CreateMessagePartition(a.DB, month); err != nil {
+			return err
+		}
+		for i := range archives {
+			if err := a.restore(&archives[i]); err != nil {
+				return fmt.Errorf("restoring %s: %w", archives[i].Object, err)
+			}
+		}
+		return nil
+	})
+	return archives, err
+}
+
+func (a *Archiver) restore(ar *models.MessageArchive) error {
+	body, err := a.Storage.Get(ar.Object)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	gz, err := gzip.NewReader(bufio.NewReader(body))
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(gz)
+
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		pairs := map[[2]uuid.UUID]bool{}
+		var batch []models.Message
+		insert := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			defer func() { batch = batch[:0] }()
+
+			// Messages that are somehow back already are left alone
+			ids := make([]uuid.UUID, len(batch))
+			for i, m := range batch {
+				ids[i] = m.ID
+			}
+			var existing []uuid.UUID
+			if err := tx.Model(&models.Message{}).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+				return err
+			}
+			purged, err := a.purgedSenders(tx, batch)
+			if err != nil {
+				return err
+			}
+			messages := slices.DeleteFunc(batch, func(m models.Message) bool {
+				return slices.Contains(existing, m.ID) || slices.Contains(purged, m.SenderID)
+			})
+			if len(messages) == 0 {
+				return nil
+			}
+
+			var mentions []models.Mention
+			for _, m := range messages {
+				for _, mention := range m.Mentions {
+					mention.MessageID = m.ID
+					mentions = append(mentions, mention)
+				}
+			}
+			if err := tx.Omit(clause.Associations).Create(&messages).Error; err != nil {
+				return err
+			}
+			if len(mentions) > 0 {
+				return tx.Create(&mentions).Error
+			}
+			return nil
+		}
+
+		for {
+			var rec record
+			err := dec.Decode(&rec)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			m := rec.Message
+			m.ImportKey, m.KeyID = rec.ImportKey, rec.KeyID
+			batch = append(batch, m)
+			pairs[pair(m.SenderID, m.ReceiverID)] = true
+
+			if len(batch) >= batchSize {
+				if err := insert(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := insert(); err != nil {
+			return err
+		}
+
+		if err := refresh(tx, pairs); err != nil {
+			return err
+		}
+		return tx.Model(ar).Update("restored_at", time.Now()).Error
+	})
+}
+
+// purgedSenders returns the senders of messages whose accounts were deleted
+// with their messages purged. Bots always keep theirs.
+func (a *Archiver) purgedSenders(tx *gorm.DB, messages []models.Message) ([]uuid.UUID, error) {
+	if !a.PurgeDeleted {
+		return nil, nil
+	}
+
+	senders := make([]uuid.UUID, 0, len(messages))
+	for _, m := range messages {
+		if !slices.Contains(senders, m.SenderID) {
+			senders = append(senders, m.SenderID)
+		}
+	}
+	var purged []uuid.UUID
+	err := tx.Model(&models.User{}).
+		Where("id IN ? AND deleted_at IS NOT NULL AND is_bot = FALSE", senders).
+		Pluck("id", &purged).Error
+	return purged, err
+}
+
+// locked runs fn while holding the archive lock. Postgres advisory locks
+// belong to a session, so the lock is taken and released on one connection
+// set aside for it. SQLite has only one writer anyway.
+func (a *Archiver) locked(fn func() error) error {
+	if !db.Partitioned(a.DB) {
+		return fn()
+	}
+
+	sqlDB, err := a.DB.DB()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", archiveLock); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", archiveLock)
+
+	return fn()
+}
+
+func inMonth(tx *gorm.DB, from, to time.Time) *gorm.DB {
+	return tx.Model(&models.Message{}).Where("created_at >= ? AND created_at < ?", from, to)
+}
+
+// pair is the key of the conversation between a and b, whichever sent.
+func pair(a, b uuid.UUID) [2]uuid.UUID {
+	if a.String() > b.String() {
+		a, b = b, a
+	}
+	return [2]uuid.UUID{a, b}
+}
+
+// refresh recomputes the conversation summaries of the pairs, whose
+// messages were just archived or restored.
+func refresh(tx *gorm.DB, pairs map[[2]uuid.UUID]bool) error {
+	for p := range pairs {
+		if err := sqlstore.RefreshConversation(tx, p[0], p[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package archive
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Storage holds archive files. Names are made of letters, digits, dots and
+// dashes.
+type Storage interface {
+	Put(name string, body io.ReadSeeker) error
+	Get(name string) (io.ReadCloser, error)
+	Delete(name string) error
+}
+
+// DirStorage keeps archive files in a local directory.
+type DirStorage struct {
+	Dir string
+}
+
+// Put writes to a temporary file and renames it into place, so a file that
+// exists is always complete.
+func (s *DirStorage) Put(name string, body io.ReadSeeker) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, "archive-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.Dir, name))
+}
+
+func (s *DirStorage) Get(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.Dir, name))
+}
+
+func (s *DirStorage) Delete(name string) error {
+	err := os.Remove(filepath.Join(s.Dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store/sqlstore"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// What happens to the messages a user sent when their account is deleted
+const (
+	DeletedMessagesKeep  = "keep"
+	DeletedMessagesPurge = "purge"
+)
+
+// ValidateMessagePolicy checks that policy is one of the DeletedMessages*
+// values.
+func ValidateMessagePolicy(policy string) error {
+	if policy != DeletedMessagesKeep && policy != DeletedMessagesPurge {
+		return fmt.Errorf("%q is not %s or %s", policy, DeletedMessagesKeep, DeletedMessagesPurge)
+	}
+	return nil
+}
+
+// DeleteAccount anonymizes the user record, keeps or purges the messages
+// they sent according to messagePolicy and revokes all of their sessions, API
+// keys, webhooks and push subscriptions. Their end-to-end encryption devices
+// are removed with the envelopes waiting for them. Bots owned by the user
+// are deleted along with it, keeping their messages. It returns the IDs of
+// every deleted account so callers can close their live connections.
+func DeleteAccount(db *gorm.DB, userID uuid.UUID, messagePolicy string) ([]uuid.UUID, error) {
+	if err := ValidateMessagePolicy(messagePolicy); err != nil {
+		return nil, err
+	}
+
+	var deleted []uuid.UUID
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteAccount(tx, userID, messagePolicy); err != nil {
+			return err
+		}
+		deleted = append(deleted, userID)
+
+		var botIDs []uuid.UUID
+		err := tx.Model(&models.User{}).
+			Where("owner_id = ? AND is_bot = TRUE AND deleted_at IS NULL", userID).
+			Pluck("id", &botIDs).Error
+		if err != nil {
+			return err
+		}
+
+		for _, botID := range botIDs {
+			if err := deleteAccount(tx, botID, DeletedMessagesKeep); err != nil {
+				return err
+			}
+			deleted = append(deleted, botID)
+		}
+
+		return nil
+	})
+
+	return deleted, err
+}
+
+func deleteAccount(tx *gorm.DB, userID uuid.UUID, messagePolicy string) error {
+	var user models.User
+	if err := tx.First(&user, "id = ? AND deleted_at IS NULL", userID).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	anon := strings.ReplaceAll(userID.String(), "-", "")
+	err := tx.Model(&user).Updates(map[string]any{
+		"username":             fmt.Sprintf("deleted_%s", anon),
+		"email":                fmt.Sprintf("%s@deleted.invalid", anon),
+		"password_hash":        "",
+		"is_admin":             false,
+		"failed_login_count":   0,
+		"last_failed_login_at": nil,
+		"locked_until":         nil,
+		"deleted_at":           now,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	if messagePolicy == DeletedMessagesPurge {
+		var others []uuid.UUID
+		err := tx.Model(&models.ConversationSummary{}).
+			Where("user_id = ?", userID).
+			Pluck("other_id", &others).Error
+		if err != nil {
+			return err
+		}
+
+		// Mentions cannot reference the partitioned messages table with a
+		// foreign key, so they are not deleted along with it
+		err = tx.Where("message_id IN (?)", tx.Model(&models.Message{}).Where("sender_id = ?", userID).Select("id")).
+			Delete(&models.Mention{}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("sender_id = ?", userID).Delete(&models.Message{}).Error; err != nil {
+			return err
+		}
+
+		for _, other := range others {
+			if err := sqlstore.RefreshConversation(tx, userID, other); err != nil {
+				return err
+			}
+		}
+	}
+
+	err = tx.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Model(&models.APIKey{}).
+		Where("bot_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Model(&models.WebhookSubscription{}).
+		Where("owner_id = ? AND deleted_at IS NULL", userID).
+		Update("deleted_at", now).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Model(&models.IncomingWebhook{}).
+		Where("owner_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.PushSubscription{}).Error; err != nil {
+		return err
+	}
+	// Envelopes the user sent stay until their recipients fetch them
+	if err := tx.Where("recipient_id = ?", userID).Delete(&models.Envelope{}).Error; err != nil {
+		return err
+	}
+	err = tx.Where("device_id IN (?)", tx.Model(&models.Device{}).Where("user_id = ?", userID).Select("id")).
+		Delete(&models.PreKey{}).Error
+	if err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.Device{}).Error; err != nil {
+		return err
+	}
+	err = tx.Where("user_id = ? OR other_id = ?", userID, userID).Delete(&models.EncryptedContact{}).Error
+	if err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.ConversationSetting{}).Error; err != nil {
+		return err
+	}
+	// Export files are removed by the export worker once their jobs expire
+	err = tx.Model(&models.ExportJob{}).
+		Where("user_id = ? AND status IN ?", userID, []string{models.ExportPending, models.ExportRunning}).
+		Update("status", models.ExportFailed).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&models.ExportJob{}).
+		Where("user_id = ?", userID).
+		Update("expires_at", now).Error
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.DigestedMessage{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.Digest{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", userID).Delete(&models.NotificationPreference{}).Error
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachedList looks passwords up in an offline copy of a breached password
+// corpus laid out like the Pwned Passwords range API: the directory holds one
+// file per 5-character SHA-1 prefix (e.g. "5BAA6" or "5BAA6.txt"), each line
+// of which is "<35-character suffix>:<count>".
+//
+// Only the file for the password's prefix is read, so the full corpus never
+// has to be loaded into memory.
+type BreachedList struct {
+	Dir string
+}
+
+func (b *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	f, err := b.open(prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		hash, _, _ := strings.Cut(line, ":")
+		if strings.EqualFold(hash, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+func (b *BreachedList) open(prefix string) (*os.File, error) {
+	for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+		f, err := os.Open(filepath.Join(b.Dir, name))
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
+	}
+	return nil, fs.ErrNotExist
+}
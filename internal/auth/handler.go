@@ -2,14 +2,27 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/clientip"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/ratelimit"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
 	"gorm.io/gorm"
 )
 
 type Handler struct {
 	DB *gorm.DB
+	Users store.Users
 	Secret string
+	Passwords *Passwords
+
+	// AccountLimiter throttles login attempts per email address, on top of
+	// the per-IP limit applied in front of the handler.
+	AccountLimiter *ratelimit.Limiter
+	OnLockout      LockoutNotifier
 }
 
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
@@ -23,7 +36,7 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := Register(h.DB, body.Username, body.Email, body.Password)
+	err := Register(h.Users, h.Passwords, body.Username, body.Email, body.Password)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -34,21 +47,45 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	var body struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		DeviceName string `json:"device_name"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	user, err := Login(h.DB, body.Email, body.Password)
+	account := strings.ToLower(strings.TrimSpace(body.Email))
+	if h.AccountLimiter != nil && !h.AccountLimiter.Allow("account:"+account) {
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	user, err := Login(h.Users, h.Passwords, body.Email, body.Password, h.OnLockout)
 	if err != nil {
+		var throttled *ThrottledError
+		if errors.As(err, &throttled) {
+			retry := int(throttled.RetryAfter.Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			if throttled.Locked {
+				http.Error(w, "account temporarily locked", http.StatusLocked)
+				return
+			}
+			http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
 		http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
 		return
 	}
 
-	token, _ := GenerateJWT(user.ID, h.Secret)
+	session, err := CreateSession(h.DB, user.ID, body.DeviceName, clientip.FromRequest(r), r.UserAgent())
+	if err != nil {
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	token, _ := GenerateJWT(user.ID, session.ID, h.Secret)
 
 	json.NewEncoder(w).Encode(map[string]string{
 		"token" : token,
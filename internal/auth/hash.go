@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var errMalformedHash = errors.New("malformed password hash")
+
+// Argon2Params are the tunable Argon2id cost parameters.
+type Argon2Params struct {
+	Memory  uint32 // KiB
+	Time    uint32
+	Threads uint8
+}
+
+// Hasher hashes new passwords with the configured algorithm and verifies
+// hashes produced by any supported algorithm, so the configuration can change
+// without locking existing users out.
+type Hasher struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+func (h Hasher) Hash(password string) (string, error) {
+	if h.Algorithm == AlgorithmArgon2id {
+		return h.hashArgon2id(password)
+	}
+
+	// bcrypt silently ignores everything past 72 bytes
+	if len(password) > 72 {
+		return "", errors.New("password must be at most 72 bytes")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify reports whether password matches the stored hash.
+func (h Hasher) Verify(hash, password string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NeedsRehash reports whether the stored hash was produced with a different
+// algorithm or weaker parameters than are currently configured. Hashes with
+// stronger parameters are kept, so lowering the configuration never weakens
+// them.
+func (h Hasher) NeedsRehash(hash string) bool {
+	if h.Algorithm == AlgorithmArgon2id {
+		params, _, _, err := decodeArgon2id(hash)
+		return err != nil || params.weakerThan(h.Argon2)
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.BcryptCost
+}
+
+// weakerThan reports whether any parameter is below the one in want.
+func (p Argon2Params) weakerThan(want Argon2Params) bool {
+	return p.Memory < want.Memory || p.Time < want.Time || p.Threads < want.Threads
+}
+
+func (h Hasher) hashArgon2id(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	p := h.Argon2
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argon2KeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// decodeArgon2id parses the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func decodeArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return p, nil, nil, errMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errMalformedHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, errMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, errMalformedHash
+	}
+
+	return p, salt, key, nil
+}
@@ -8,17 +8,27 @@ import (
 	"github.com/google/uuid"
 )
 
-func GenerateJWT(userID uuid.UUID, secret string) (string, error) {
+// TokenTTL is how long an issued JWT (and therefore its session) stays valid.
+const TokenTTL = 24 * time.Hour
+
+// Claims are the identity fields carried by a JWT.
+type Claims struct {
+	UserID    uuid.UUID
+	SessionID uuid.UUID
+}
+
+func GenerateJWT(userID, sessionID uuid.UUID, secret string) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id" : userID.String(),
-		"exp" : time.Now().Add(24 * time.Hour).Unix(),
+		"sid" : sessionID.String(),
+		"exp" : time.Now().Add(TokenTTL).Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(secret))
 }
 
-func ParseJWT(tokenString, secret string) (uuid.UUID, error) {
+func ParseJWT(tokenString, secret string) (*Claims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
@@ -27,18 +37,33 @@ func ParseJWT(tokenString, secret string) (uuid.UUID, error) {
 	})
 
 	if err != nil || !token.Valid {
-		return uuid.Nil, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return uuid.Nil, errors.New("invalid claims")
+		return nil, errors.New("invalid claims")
 	}
 
 	userIDStr, ok := claims["user_id"].(string)
 	if !ok {
-		return uuid.Nil, errors.New("user_id missing")
+		return nil, errors.New("user_id missing")
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return nil, err
 	}
 
-	return uuid.Parse(userIDStr)
-}
\ No newline at end of file
+	// Tokens issued before sessions existed cannot be revoked, so they are
+	// no longer accepted.
+	sidStr, ok := claims["sid"].(string)
+	if !ok {
+		return nil, errors.New("sid missing")
+	}
+	sessionID, err := uuid.Parse(sidStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Claims{UserID: userID, SessionID: sessionID}, nil
+}
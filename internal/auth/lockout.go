@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+)
+
+// After backoffAfter consecutive failures every further attempt has to wait
+// twice as long as the previous one, up to maxBackoff. Reaching lockoutAfter
+// failures locks the account for lockoutDuration.
+const (
+	backoffAfter    = 3
+	maxBackoff      = time.Minute
+	lockoutAfter    = 10
+	lockoutDuration = 15 * time.Minute
+)
+
+// LockoutNotifier is called whenever an account gets locked.
+type LockoutNotifier func(user *models.User, until time.Time)
+
+// LogLockout is the default LockoutNotifier.
+func LogLockout(user *models.User, until time.Time) {
+	log.Printf("account %s locked until %s after repeated failed logins", user.ID, until.Format(time.RFC3339))
+}
+
+// ThrottledError is returned by Login when the account may not attempt a
+// login right now.
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account locked, retry in %s", e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("too many failed attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// loginDelay is how long to wait after the last failure before another
+// attempt is allowed.
+func loginDelay(failures int) time.Duration {
+	if failures < backoffAfter {
+		return 0
+	}
+	delay := time.Duration(math.Pow(2, float64(failures-backoffAfter))) * time.Second
+	if delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
+
+func checkThrottle(user *models.User, now time.Time) error {
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		return &ThrottledError{RetryAfter: user.LockedUntil.Sub(now), Locked: true}
+	}
+
+	if user.LastFailedLoginAt != nil {
+		next := user.LastFailedLoginAt.Add(loginDelay(user.FailedLoginCount))
+		if now.Before(next) {
+			return &ThrottledError{RetryAfter: next.Sub(now)}
+		}
+	}
+
+	return nil
+}
+
+// recordFailedLogin counts a failed attempt against the user and locks the
+// account once the limit is reached. The returned error is what Login
+// reports to the caller.
+func recordFailedLogin(users store.Users, user *models.User, now time.Time, onLockout LockoutNotifier) error {
+	count, err := users.RecordFailedLogin(user.ID, now)
+	if err != nil {
+		return err
+	}
+	user.FailedLoginCount = count
+
+	if count < lockoutAfter {
+		return ErrInvalidCredentials
+	}
+
+	until := now.Add(lockoutDuration)
+	if err := users.LockLogin(user.ID, until); err != nil {
+		return err
+	}
+
+	if onLockout != nil {
+		onLockout(user, until)
+	}
+
+	return &ThrottledError{RetryAfter: lockoutDuration, Locked: true}
+}
+
+// UnlockUser clears any lockout and failed-attempt history for the user.
+func UnlockUser(users store.Users, userID uuid.UUID) error {
+	return users.ResetFailedLogins(userID)
+}
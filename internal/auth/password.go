@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ErrBreachedPassword = errors.New("password appears in a known data breach, choose another")
+
+// PolicyError reports a password the policy refuses.
+type PolicyError struct {
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+// PasswordPolicy describes which passwords are accepted on registration and
+// password change.
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+func (p PasswordPolicy) Validate(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return &PolicyError{fmt.Sprintf("password must be at least %d characters", p.MinLength)}
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		return &PolicyError{"password must contain an uppercase letter"}
+	}
+	if p.RequireLower && !lower {
+		return &PolicyError{"password must contain a lowercase letter"}
+	}
+	if p.RequireDigit && !digit {
+		return &PolicyError{"password must contain a digit"}
+	}
+	if p.RequireSymbol && !symbol {
+		return &PolicyError{"password must contain a symbol"}
+	}
+
+	return nil
+}
+
+// Passwords bundles everything needed to accept, store and verify
+// passwords.
+type Passwords struct {
+	Policy   PasswordPolicy
+	Hasher   Hasher
+	Breached *BreachedList
+}
+
+// Check validates a new password against the policy and the breached
+// password list.
+func (p *Passwords) Check(password string) error {
+	if err := p.Policy.Validate(password); err != nil {
+		return err
+	}
+
+	if p.Breached == nil {
+		return nil
+	}
+
+	breached, err := p.Breached.Contains(password)
+	if err != nil {
+		// A broken list should not block every signup
+		log.Println("breached password lookup failed:", err)
+		return nil
+	}
+	if breached {
+		return ErrBreachedPassword
+	}
+
+	return nil
+}
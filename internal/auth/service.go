@@ -2,14 +2,21 @@ package auth
 
 import (
 	"errors"
+	"log"
 	"strings"
+	"time"
 
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
-	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
 )
 
-func Register(db *gorm.DB, username, email, password string) error {
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrSamePassword       = errors.New("new password must differ from the current one")
+)
+
+func Register(users store.Users, pw *Passwords, username, email, password string) error {
 	username = strings.TrimSpace(username)
 	email = strings.ToLower(strings.TrimSpace(email))
 	password = strings.TrimSpace(password)
@@ -23,11 +30,11 @@ func Register(db *gorm.DB, username, email, password string) error {
 	if password == "" {
 		return errors.New("password is required")
 	}
+	if err := pw.Check(password); err != nil {
+		return err
+	}
 
-	hash, err := bcrypt.GenerateFromPassword(
-		[]byte(password),
-		bcrypt.DefaultCost,
-	)
+	hash, err := pw.Hasher.Hash(password)
 	if err != nil {
 		return err
 	}
@@ -35,30 +42,80 @@ func Register(db *gorm.DB, username, email, password string) error {
 	user := models.User{
 		Username:     username,
 		Email:        email,
-		PasswordHash: string(hash),
+		PasswordHash: hash,
 	}
 
-	return db.Create(&user).Error
+	err = users.Create(&user)
+	if errors.Is(err, store.ErrConflict) {
+		return errors.New("username or email is already taken")
+	}
+	return err
 }
 
-func Login(db *gorm.DB, email, password string) (*models.User, error) {
+func Login(users store.Users, pw *Passwords, email, password string, onLockout LockoutNotifier) (*models.User, error) {
 	email = strings.ToLower(strings.TrimSpace(email))
 	password = strings.TrimSpace(password)
 
-	var user models.User
-	err := db.Where("email = ?", email).First(&user).Error
+	user, err := users.GetByEmail(email)
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, ErrInvalidCredentials
+	}
+
+	now := time.Now()
+	if err := checkThrottle(user, now); err != nil {
+		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword(
-		[]byte(user.PasswordHash),
-		[]byte(password),
-	)
+	if !pw.Hasher.Verify(user.PasswordHash, password) {
+		return nil, recordFailedLogin(users, user, now, onLockout)
+	}
+
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		if err := users.ResetFailedLogins(user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	// The plaintext is only available here, so this is where hashes made
+	// with an old algorithm or cost get upgraded
+	if pw.Hasher.NeedsRehash(user.PasswordHash) {
+		if err := setPassword(users, pw, user.ID, password); err != nil {
+			log.Println("password rehash failed:", err)
+		}
+	}
+
+	return user, nil
+}
+
+// ChangePassword replaces the user's password after verifying the current
+// one.
+func ChangePassword(users store.Users, pw *Passwords, userID uuid.UUID, current, next string) error {
+	current = strings.TrimSpace(current)
+	next = strings.TrimSpace(next)
+
+	user, err := users.Get(userID)
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return err
 	}
 
-	return &user, nil
+	if !pw.Hasher.Verify(user.PasswordHash, current) {
+		return ErrInvalidCredentials
+	}
+	if next == current {
+		return ErrSamePassword
+	}
+	if err := pw.Check(next); err != nil {
+		return err
+	}
+
+	return setPassword(users, pw, userID, next)
 }
 
+func setPassword(users store.Users, pw *Passwords, userID uuid.UUID, password string) error {
+	hash, err := pw.Hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	return users.SetPasswordHash(userID, hash)
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// sessionTouchInterval limits how often LastUsedAt is written, so an active
+// client does not cause a write on every request.
+const sessionTouchInterval = time.Minute
+
+var ErrSessionRevoked = errors.New("session revoked")
+
+func CreateSession(db *gorm.DB, userID uuid.UUID, deviceName, ip, userAgent string) (*models.Session, error) {
+	if deviceName == "" {
+		deviceName = "Unknown device"
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		UserID:     userID,
+		DeviceName: deviceName,
+		IP:         ip,
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+
+	if err := db.Create(session).Error; err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// ValidateSession checks that the session named in the claims still exists,
+// belongs to the user and has not been revoked, and records its use.
+func ValidateSession(db *gorm.DB, claims *Claims) error {
+	var session models.Session
+	err := db.First(&session, "id = ? AND user_id = ?", claims.SessionID, claims.UserID).Error
+	if err != nil {
+		return ErrSessionRevoked
+	}
+	if session.RevokedAt != nil {
+		return ErrSessionRevoked
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastUsedAt) > sessionTouchInterval {
+		db.Model(&models.Session{}).
+			Where("id = ?", session.ID).
+			Update("last_used_at", now)
+	}
+
+	return nil
+}
+
+// ListSessions returns the user's sessions whose tokens have not yet expired,
+// most recently used first.
+func ListSessions(db *gorm.DB, userID uuid.UUID) ([]models.Session, error) {
+	var sessions []models.Session
+	err := db.
+		Where("user_id = ? AND revoked_at IS NULL AND created_at > ?", userID, time.Now().Add(-TokenTTL)).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+
+	return sessions, err
+}
+
+// RevokeSession revokes one of the user's sessions and drops the push
+// subscriptions made from it. It returns gorm.ErrRecordNotFound if the
+// session does not exist, belongs to someone else or is already revoked.
+func RevokeSession(db *gorm.DB, userID, sessionID uuid.UUID) error {
+	res := db.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return db.Where("session_id = ?", sessionID).Delete(&models.PushSubscription{}).Error
+}
+
+// RevokeOtherSessions revokes every session of the user except keep, along
+// with their push subscriptions, and returns the IDs of the sessions it
+// revoked.
+func RevokeOtherSessions(db *gorm.DB, userID, keep uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := db.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keep).
+		Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	err = db.Model(&models.Session{}).
+		Where("id IN ?", ids).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Where("session_id IN ?", ids).Delete(&models.PushSubscription{}).Error
+
+	return ids, err
+}
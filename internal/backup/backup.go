@@ -0,0 +1,430 @@
+// Package backup writes a logical dump of the database to a directory and
+// restores one into an empty database, to clone a deployment or seed
+// another from it.
+//
+// A dump is a manifest and one gzipped JSON lines file per table, each line
+// a row as an array of values in the order of the columns the manifest
+// lists. Times are written as RFC 3339 strings and binary values as base64,
+// and restore turns them back by the column types of the database it writes
+// to. Rows are written as stored: encrypted message content and wrapped
+// conversation keys stay encrypted, so a deployment restored into needs the
+// same ENCRYPTION_MASTER_KEYS to read them.
+package backup
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// FormatVersion changes whenever a dump can no longer be read the way
+	// earlier ones were
+	FormatVersion = 1
+
+	ManifestName = "manifest.json"
+)
+
+// Tables others refer to come first, so that restoring them in order
+// satisfies foreign keys. The rest follow by name.
+var first = []string{"users", "messages"}
+
+var (
+	// ErrIncomplete means a backup was interrupted before every table was
+	// written.
+	ErrIncomplete = errors.New("backup is incomplete; run it again with -resume")
+
+	// ErrExists means the directory already holds a backup.
+	ErrExists = errors.New("directory already holds a backup; choose another or pass -resume")
+)
+
+// Manifest describes a dump. It is rewritten after every table, so an
+// interrupted backup can be resumed, and marked Complete after the last.
+//
+// Every table of a complete dump holds the rows of one snapshot, taken at
+// SnapshotAt: a resumed backup keeps a table written before the
+// interruption only if its rows are the same in the snapshot of the
+// resumed run.
+type Manifest struct {
+	Format        int       `json:"format"`
+	Dialect       string    `json:"dialect"`
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	SnapshotAt    time.Time `json:"snapshot_at"`
+	Complete      bool      `json:"complete"`
+	Tables        []Table   `json:"tables"`
+}
+
+// TakenAt returns when the snapshot of the dump was taken. Manifests
+// written before they recorded it give the start of the backup instead.
+func (m *Manifest) TakenAt() time.Time {
+	if m.SnapshotAt.IsZero() {
+		return m.CreatedAt
+	}
+	return m.SnapshotAt
+}
+
+// Table is the file of one table in a dump. Digest does not depend on the
+// order of the rows, so it tells whether a table changed even though rows
+// come back in another order.
+type Table struct {
+	Name     string    `json:"name"`
+	File     string    `json:"file"`
+	Columns  []string  `json:"columns"`
+	Rows     int64     `json:"rows"`
+	Bytes    int64     `json:"bytes"`
+	SHA256   string    `json:"sha256"`
+	Digest   string    `json:"digest"`
+	DumpedAt time.Time `json:"dumped_at"`
+}
+
+// Backup dumps every table but schema_migrations into dir, reading them in
+// one snapshot; the schema version goes in the manifest instead.
+//
+// With resume, the tables of an interrupted backup in dir are read again in
+// a new snapshot; a table whose file is intact and whose rows have not
+// changed keeps its file, and the others are dumped again, so the backup
+// is still of a single snapshot. Without resume, dir must not hold a backup
+// yet.
+func Backup(conn *gorm.DB, dir string, resume bool) (*Manifest, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	kept := map[string]Table{}
+	prev, err := ReadManifest(dir)
+	switch {
+	case err == nil && !resume:
+		return nil, ErrExists
+	case err == nil && prev.Complete:
+		return prev, nil
+	case err == nil:
+		for _, t := range prev.Tables {
+			if Verify(dir, t) == nil {
+				kept[t.Name] = t
+			}
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	m := &Manifest{
+		Format:    FormatVersion,
+		Dialect:   conn.Dialector.Name(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if prev != nil {
+		m.CreatedAt = prev.CreatedAt
+	}
+
+	// Repeatable read gives Postgres one snapshot for the whole dump; a
+	// SQLite read transaction has one anyway
+	opts := &sql.TxOptions{ReadOnly: true}
+	if m.Dialect == "postgres" {
+		opts.Isolation = sql.LevelRepeatableRead
+	}
+
+	err = conn.Transaction(func(tx *gorm.DB) error {
+		// The snapshot is taken by the first query
+		m.SnapshotAt = time.Now().UTC()
+		err := tx.Raw("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&m.SchemaVersion).Error
+		if err != nil {
+			return err
+		}
+		if prev != nil && prev.SchemaVersion != m.SchemaVersion {
+			return fmt.Errorf("schema changed from version %d to %d since the backup started; start a new one",
+				prev.SchemaVersion, m.SchemaVersion)
+		}
+
+		tables, err := listTables(tx)
+		if err != nil {
+			return err
+		}
+		for _, name := range tables {
+			t, ok := kept[name]
+			if ok {
+				if ok, err = unchanged(tx, t); err != nil {
+					return fmt.Errorf("checking %s: %w", name, err)
+				}
+			}
+			if !ok {
+				if t, err = dumpTable(tx, dir, name); err != nil {
+					return fmt.Errorf("dumping %s: %w", name, err)
+				}
+			}
+			m.Tables = append(m.Tables, t)
+			if err := writeManifest(dir, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Complete = true
+	if err := writeManifest(dir, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// listTables returns the tables to dump in the order to restore them.
+// Partitions of messages are read through messages.
+func listTables(tx *gorm.DB) ([]string, error) {
+	query := "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite\\_%' ESCAPE '\\'"
+	if tx.Dialector.Name() == "postgres" {
+		query = `SELECT c.relname FROM pg_class c
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p') AND NOT c.relispartition`
+	}
+
+	var names []string
+	if err := tx.Raw(query).Scan(&names).Error; err != nil {
+		return nil, err
+	}
+	names = slices.DeleteFunc(names, func(name string) bool { return name == "schema_migrations" })
+
+	slices.SortFunc(names, func(a, b string) int {
+		ia, ib := slices.Index(first, a), slices.Index(first, b)
+		switch {
+		case ia >= 0 && ib >= 0:
+			return ia - ib
+		case ia >= 0:
+			return -1
+		case ib >= 0:
+			return 1
+		}
+		return cmp.Compare(a, b)
+	})
+	return names, nil
+}
+
+// dumpTable streams the rows of a table to a temporary file and renames it
+// into place once complete.
+func dumpTable(tx *gorm.DB, dir, name string) (Table, error) {
+	t := Table{Name: name, File: name + ".jsonl.gz", DumpedAt: time.Now().UTC()}
+
+	tmp, err := os.CreateTemp(dir, name+"-*.tmp")
+	if err != nil {
+		return t, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	sum := &checksum{hash: sha256.New()}
+	buf := bufio.NewWriter(io.MultiWriter(tmp, sum))
+	zw := gzip.NewWriter(buf)
+
+	t.Columns, t.Rows, t.Digest, err = readTable(tx, name, func(line []byte) error {
+		_, err := zw.Write(line)
+		return err
+	})
+	if err != nil {
+		return t, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return t, err
+	}
+	if err := buf.Flush(); err != nil {
+		return t, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return t, err
+	}
+	if err := tmp.Close(); err != nil {
+		return t, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, t.File)); err != nil {
+		return t, err
+	}
+
+	t.Bytes, t.SHA256 = sum.n, hex.EncodeToString(sum.hash.Sum(nil))
+	return t, nil
+}
+
+// unchanged reports whether the table holds the rows of its file in the
+// transaction's snapshot. Files written before tables had digests never
+// match.
+func unchanged(tx *gorm.DB, t Table) (bool, error) {
+	if t.Digest == "" {
+		return false, nil
+	}
+	columns, rows, digest, err := readTable(tx, t.Name, nil)
+	if err != nil {
+		return false, err
+	}
+	return slices.Equal(columns, t.Columns) && rows == t.Rows && digest == t.Digest, nil
+}
+
+// readTable reads the rows of a table and hands each to emit, when set, as
+// a line of JSON. It returns the columns, the number of rows and their
+// digest.
+func readTable(tx *gorm.DB, name string, emit func(line []byte) error) ([]string, int64, string, error) {
+	rows, err := tx.Raw("SELECT * FROM " + tx.Statement.Quote(name)).Rows()
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	var (
+		n      int64
+		digest rowDigest
+	)
+	values := make([]any, len(columns))
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, 0, "", err
+		}
+		for i, v := range values {
+			values[i] = encodeValue(v)
+		}
+		line, err := json.Marshal(values)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		line = append(line, '\n')
+
+		digest.add(line)
+		if emit != nil {
+			if err := emit(line); err != nil {
+				return nil, 0, "", err
+			}
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, "", err
+	}
+	return columns, n, digest.String(), nil
+}
+
+// rowDigest adds up the SHA-256 of every row as 256-bit numbers, so the
+// result does not depend on the order the rows are read in.
+type rowDigest [4]uint64
+
+func (d *rowDigest) add(line []byte) {
+	h := sha256.Sum256(line)
+	var carry uint64
+	for i := len(d) - 1; i >= 0; i-- {
+		d[i], carry = bits.Add64(d[i], binary.BigEndian.Uint64(h[i*8:]), carry)
+	}
+}
+
+func (d *rowDigest) String() string {
+	var b [32]byte
+	for i, w := range d {
+		binary.BigEndian.PutUint64(b[i*8:], w)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// encodeValue turns a value read from the database into one JSON keeps
+// intact.
+func encodeValue(v any) any {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	}
+	return v
+}
+
+// checksum hashes and counts what is written to a file.
+type checksum struct {
+	hash hash.Hash
+	n    int64
+}
+
+func (c *checksum) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return c.hash.Write(p)
+}
+
+// Verify checks the size and checksum of a table's file.
+func Verify(dir string, t Table) error {
+	f, err := os.Open(filepath.Join(dir, t.File))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sum := &checksum{hash: sha256.New()}
+	if _, err := io.Copy(sum, f); err != nil {
+		return err
+	}
+	if sum.n != t.Bytes || hex.EncodeToString(sum.hash.Sum(nil)) != t.SHA256 {
+		return fmt.Errorf("%s does not match its checksum", t.File)
+	}
+	return nil
+}
+
+// ReadManifest reads the manifest of the backup in dir. The error wraps
+// fs.ErrNotExist when there is none.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", ManifestName, err)
+	}
+	return &m, nil
+}
+
+// writeManifest replaces the manifest in dir.
+func writeManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "manifest-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, ManifestName))
+}
@@ -0,0 +1,262 @@
+package backup_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/backup"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/db"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func open(t *testing.T, migrate bool) *gorm.DB {
+	t.Helper()
+	conn, err := db.Open("sqlite:" + filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if !migrate {
+		return conn
+	}
+
+	m, err := db.NewMigrator(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// seed adds two users and a message between them.
+func seed(t *testing.T, conn *gorm.DB) (alice, bob models.User) {
+	t.Helper()
+	alice = models.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x"}
+	bob = models.User{Username: "bob", Email: "bob@example.com", PasswordHash: "x"}
+	for _, u := range []*models.User{&alice, &bob} {
+		if err := conn.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(t, conn, alice, bob, "hello bob")
+	return alice, bob
+}
+
+func send(t *testing.T, conn *gorm.DB, from, to models.User, content string) {
+	t.Helper()
+	m := models.Message{SenderID: from.ID, ReceiverID: to.ID, Content: content}
+	if err := conn.Create(&m).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func table(t *testing.T, m *backup.Manifest, name string) backup.Table {
+	t.Helper()
+	for _, tb := range m.Tables {
+		if tb.Name == name {
+			return tb
+		}
+	}
+	t.Fatalf("backup has no table %s", name)
+	return backup.Table{}
+}
+
+func usernames(t *testing.T, conn *gorm.DB) []string {
+	t.Helper()
+	var names []string
+	if err := conn.Model(&models.User{}).Order("username").Pluck("username", &names).Error; err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func contents(t *testing.T, conn *gorm.DB) []string {
+	t.Helper()
+	var list []string
+	if err := conn.Model(&models.Message{}).Order("content").Pluck("content", &list).Error; err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	src := open(t, true)
+	alice, _ := seed(t, src)
+	dir := t.TempDir()
+
+	m, err := backup.Backup(src, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Complete || m.Dialect != "sqlite" || m.SchemaVersion == 0 {
+		t.Fatalf("manifest = %+v", m)
+	}
+	if users := table(t, m, "users"); users.Rows != 2 {
+		t.Fatalf("users has %d rows, want 2", users.Rows)
+	}
+	if _, err := backup.Backup(src, dir, false); err != backup.ErrExists {
+		t.Fatalf("second backup into the directory: err = %v", err)
+	}
+
+	dst := open(t, false)
+	restored, applied, err := backup.Restore(dst, dir, backup.RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) == 0 || applied[len(applied)-1].Version != restored.SchemaVersion {
+		t.Fatalf("applied %d migrations up to the wrong version", len(applied))
+	}
+	if got := usernames(t, dst); strings.Join(got, ",") != "alice,bob" {
+		t.Fatalf("users = %q", got)
+	}
+	if got := contents(t, dst); len(got) != 1 || got[0] != "hello bob" {
+		t.Fatalf("messages = %q", got)
+	}
+
+	var u models.User
+	if err := dst.Take(&u, "id = ?", alice.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if u.Email != alice.Email || u.PasswordHash != alice.PasswordHash || !u.CreatedAt.Equal(alice.CreatedAt) {
+		t.Fatalf("restored user = %+v, want %+v", u, alice)
+	}
+
+	// Restore refuses a database that holds rows
+	if _, _, err := backup.Restore(dst, dir, backup.RestoreOptions{}); err == nil {
+		t.Fatal("restore into a database with rows succeeded")
+	}
+}
+
+func TestResumeRedumpsChangedTables(t *testing.T) {
+	src := open(t, true)
+	alice, bob := seed(t, src)
+	dir := t.TempDir()
+
+	first, err := backup.Backup(src, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pretend the backup stopped after users and messages
+	interrupted := *first
+	interrupted.Complete = false
+	interrupted.Tables = first.Tables[:2]
+	if interrupted.Tables[0].Name != "users" || interrupted.Tables[1].Name != "messages" {
+		t.Fatalf("tables are dumped in the order %s, %s", interrupted.Tables[0].Name, interrupted.Tables[1].Name)
+	}
+	data, err := json.Marshal(&interrupted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, backup.ManifestName), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := backup.Restore(open(t, false), dir, backup.RestoreOptions{}); err != backup.ErrIncomplete {
+		t.Fatalf("restore of an interrupted backup: err = %v", err)
+	}
+
+	// Rows change before it is resumed
+	if err := src.Model(&bob).Update("username", "robert").Error; err != nil {
+		t.Fatal(err)
+	}
+	send(t, src, bob, alice, "hi alice")
+
+	m, err := backup.Backup(src, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Complete || !m.SnapshotAt.After(first.SnapshotAt) {
+		t.Fatalf("manifest = %+v", m)
+	}
+	for _, name := range []string{"users", "messages"} {
+		before, after := table(t, first, name), table(t, m, name)
+		if after.SHA256 == before.SHA256 || !after.DumpedAt.After(before.DumpedAt) {
+			t.Fatalf("%s was kept although its rows changed", name)
+		}
+	}
+	if sessions := table(t, m, "sessions"); sessions.Rows != 0 {
+		t.Fatalf("sessions has %d rows", sessions.Rows)
+	}
+
+	dst := open(t, false)
+	if _, _, err := backup.Restore(dst, dir, backup.RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := usernames(t, dst); strings.Join(got, ",") != "alice,robert" {
+		t.Fatalf("users = %q", got)
+	}
+	if got := contents(t, dst); strings.Join(got, ",") != "hello bob,hi alice" {
+		t.Fatalf("messages = %q", got)
+	}
+}
+
+func TestResumeKeepsUnchangedTables(t *testing.T) {
+	src := open(t, true)
+	seed(t, src)
+	dir := t.TempDir()
+
+	first, err := backup.Backup(src, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupted := *first
+	interrupted.Complete = false
+	interrupted.Tables = first.Tables[:2]
+	data, err := json.Marshal(&interrupted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, backup.ManifestName), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := backup.Backup(src, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"users", "messages"} {
+		if before, after := table(t, first, name), table(t, m, name); !after.DumpedAt.Equal(before.DumpedAt) {
+			t.Fatalf("%s was dumped again although its rows did not change", name)
+		}
+	}
+}
+
+func TestRestoreRejectsCorruptFile(t *testing.T) {
+	src := open(t, true)
+	seed(t, src)
+	dir := t.TempDir()
+
+	m, err := backup.Backup(src, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, table(t, m, "messages").File)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := open(t, false)
+	_, applied, err := backup.Restore(dst, dir, backup.RestoreOptions{})
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("err = %v, want a checksum error", err)
+	}
+	if len(applied) != 0 || dst.Migrator().HasTable("users") {
+		t.Fatal("restore wrote to the database before checking every file")
+	}
+}
@@ -0,0 +1,313 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	// Rows inserted per statement
+	batchSize = 1000
+
+	// Bound values per statement, well under the limits of Postgres and
+	// SQLite, for tables with many columns
+	maxParams = 30000
+)
+
+// RestoreOptions changes what Restore does.
+type RestoreOptions struct {
+	// Resume continues a restore that was interrupted: a table that holds
+	// rows already gets the rows of its file after that many.
+	Resume bool
+
+	// Skip lists tables not to restore, such as webhook subscriptions a
+	// staging copy should not deliver to.
+	Skip []string
+}
+
+// Restore loads the backup in dir into conn, whose tables must be empty.
+// Every file is checked against its checksum before anything is written.
+// The schema is migrated up to the backup's version first; a database
+// already past it, or a backup of a version this build does not know, is
+// refused. It returns the backup's manifest and the migrations it applied.
+func Restore(conn *gorm.DB, dir string, opts RestoreOptions) (*Manifest, []db.Migration, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.Format != FormatVersion {
+		return m, nil, fmt.Errorf("backup format %d is not supported by this build", m.Format)
+	}
+	if !m.Complete {
+		return m, nil, ErrIncomplete
+	}
+	for _, t := range m.Tables {
+		if err := Verify(dir, t); err != nil {
+			return m, nil, err
+		}
+	}
+
+	applied, err := migrateTo(conn, m.SchemaVersion)
+	if err != nil {
+		return m, applied, err
+	}
+
+	// Check every table before writing to any
+	type pending struct {
+		Table
+		kinds []kind
+		done  int64
+	}
+	var plan []pending
+	for _, t := range m.Tables {
+		if slices.Contains(opts.Skip, t.Name) {
+			continue
+		}
+		kinds, err := columnKinds(conn, t)
+		if err != nil {
+			return m, applied, err
+		}
+
+		var done int64
+		if err := conn.Table(t.Name).Count(&done).Error; err != nil {
+			return m, applied, err
+		}
+		switch {
+		case done > 0 && !opts.Resume:
+			return m, applied, fmt.Errorf("table %s is not empty; restore into an empty database", t.Name)
+		case done > t.Rows:
+			return m, applied, fmt.Errorf("table %s holds more rows than the backup", t.Name)
+		}
+		plan = append(plan, pending{Table: t, kinds: kinds, done: done})
+	}
+
+	for _, p := range plan {
+		if p.done == p.Rows {
+			continue
+		}
+		if err := restoreTable(conn, dir, p.Table, p.kinds, p.done); err != nil {
+			return m, applied, fmt.Errorf("restoring %s: %w", p.Name, err)
+		}
+	}
+	return m, applied, nil
+}
+
+// migrateTo brings the schema to version, which must be no older than the
+// database's.
+func migrateTo(conn *gorm.DB, version int) ([]db.Migration, error) {
+	migrator, err := db.NewMigrator(conn)
+	if err != nil {
+		return nil, err
+	}
+	status, err := migrator.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	current, known := 0, false
+	for _, s := range status {
+		if s.AppliedAt != nil {
+			current = max(current, s.Version)
+		}
+		if s.Version == version && s.Name != "" {
+			known = true
+		}
+	}
+
+	switch {
+	case current > version:
+		return nil, fmt.Errorf("database schema is at version %d, past the backup's %d; restore into a new database", current, version)
+	case current == version:
+		return nil, nil
+	case !known:
+		return nil, fmt.Errorf("backup has schema version %d, which this build does not know; use a newer build", version)
+	}
+	return migrator.Up(version)
+}
+
+// kind is how a value read back from a dump is stored in a column.
+type kind int
+
+const (
+	kindOther kind = iota
+	kindTime
+	kindBytes
+	kindBool
+	kindInt
+)
+
+func kindOf(databaseType string) kind {
+	t := strings.ToUpper(databaseType)
+	switch {
+	case strings.Contains(t, "TIME") || t == "DATE":
+		return kindTime
+	case t == "BYTEA" || t == "BLOB":
+		return kindBytes
+	case strings.HasPrefix(t, "BOOL"):
+		return kindBool
+	case strings.Contains(t, "INT"):
+		return kindInt
+	}
+	return kindOther
+}
+
+// columnKinds checks that the table has the columns of the backup and
+// returns their kinds in the backup's order.
+func columnKinds(conn *gorm.DB, t Table) ([]kind, error) {
+	rows, err := conn.Raw("SELECT * FROM " + conn.Statement.Quote(t.Name) + " WHERE 1 = 0").Rows()
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %w", t.Name, err)
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]kind, len(types))
+	for _, ct := range types {
+		byName[ct.Name()] = kindOf(ct.DatabaseTypeName())
+	}
+
+	if len(byName) != len(t.Columns) {
+		return nil, fmt.Errorf("table %s has %d columns, the backup %d", t.Name, len(byName), len(t.Columns))
+	}
+	kinds := make([]kind, len(t.Columns))
+	for i, col := range t.Columns {
+		k, ok := byName[col]
+		if !ok {
+			return nil, fmt.Errorf("table %s has no column %s", t.Name, col)
+		}
+		kinds[i] = k
+	}
+	return kinds, nil
+}
+
+// restoreTable inserts the rows of a table's file after the first skip, in
+// batches.
+func restoreTable(conn *gorm.DB, dir string, t Table, kinds []kind, skip int64) error {
+	f, err := os.Open(filepath.Join(dir, t.File))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	dec := json.NewDecoder(zr)
+	dec.UseNumber()
+
+	// Statements carry message content; keep them out of the slow query log
+	quiet := conn.Session(&gorm.Session{Logger: logger.Discard})
+
+	perBatch := min(batchSize, maxParams/len(t.Columns))
+	batch := make([]any, 0, perBatch*len(t.Columns))
+	var n int64
+	for {
+		var row []any
+		err := dec.Decode(&row)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		n++
+		if n <= skip {
+			continue
+		}
+		if len(row) != len(t.Columns) {
+			return fmt.Errorf("row %d has %d values for %d columns", n, len(row), len(t.Columns))
+		}
+
+		for i, v := range row {
+			if row[i], err = decodeValue(v, kinds[i]); err != nil {
+				return fmt.Errorf("row %d, column %s: %w", n, t.Columns[i], err)
+			}
+		}
+		batch = append(batch, row...)
+
+		if len(batch) == cap(batch) {
+			if err := insert(quiet, t, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := insert(quiet, t, batch); err != nil {
+			return err
+		}
+	}
+
+	if n != t.Rows {
+		return fmt.Errorf("%s has %d rows, the manifest says %d", t.File, n, t.Rows)
+	}
+	return nil
+}
+
+// insert writes rows, whose values follow each other in values, in one
+// statement.
+func insert(conn *gorm.DB, t Table, values []any) error {
+	cols := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		cols[i] = conn.Statement.Quote(col)
+	}
+	row := "(" + strings.Repeat("?, ", len(cols)-1) + "?)"
+	rows := strings.Repeat(row+", ", len(values)/len(cols)-1) + row
+
+	sql := "INSERT INTO " + conn.Statement.Quote(t.Name) + " (" + strings.Join(cols, ", ") + ") VALUES " + rows
+	return conn.Exec(sql, values...).Error
+}
+
+// decodeValue turns a value of a dump back into one for a column of kind k.
+func decodeValue(v any, k kind) (any, error) {
+	switch v := v.(type) {
+	case string:
+		switch k {
+		case kindTime:
+			return time.Parse(time.RFC3339Nano, v)
+		case kindBytes:
+			return base64.StdEncoding.DecodeString(v)
+		}
+		return v, nil
+	case json.Number:
+		switch k {
+		case kindBool:
+			// SQLite stores booleans as numbers
+			return v.String() != "0", nil
+		case kindInt:
+			return v.Int64()
+		}
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case bool:
+		if k == kindInt {
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,16 @@
+package clientip
+
+import (
+	"net"
+	"net/http"
+)
+
+// FromRequest returns the IP address of the remote end of the request
+// without the port.
+func FromRequest(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,188 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/notify"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+)
+
+const (
+	shrug = `¯\_(ツ)_/¯`
+
+	maxReminderDelay = 30 * 24 * time.Hour
+)
+
+func registerBuiltins(r *Registry) {
+	r.Register(Command{
+		Name:        "me",
+		Description: "Send an action message",
+		Usage:       "/me <action>",
+		Run:         runMe,
+	})
+	r.Register(Command{
+		Name:        "shrug",
+		Description: "Append " + shrug + " to your message",
+		Usage:       "/shrug [message]",
+		Run:         runShrug,
+	})
+	r.Register(Command{
+		Name:        "remind",
+		Description: "Get a reminder in this conversation later",
+		Usage:       "/remind <duration> <text>, e.g. /remind 2h check the deploy",
+		Run:         r.runRemind,
+	})
+	r.Register(Command{
+		Name:        "mute",
+		Description: "Mute this conversation, indefinitely or for a while",
+		Usage:       "/mute [duration|off], e.g. /mute 8h",
+		Run:         r.runMute,
+	})
+	r.Register(Command{
+		Name:        "help",
+		Description: "List the available commands",
+		Usage:       "/help",
+		Run:         r.runHelp,
+	})
+}
+
+func runMe(_ context.Context, inv *Invocation) (*Result, error) {
+	if inv.Args == "" {
+		return nil, userError("usage: /me <action>")
+	}
+	return &Result{Post: &Post{Content: inv.Args, Format: models.FormatAction}}, nil
+}
+
+func runShrug(_ context.Context, inv *Invocation) (*Result, error) {
+	return &Result{Post: &Post{Content: strings.TrimSpace(inv.Args + " " + shrug)}}, nil
+}
+
+// checkOther returns a user error unless the other participant of the
+// conversation is an account that still exists.
+func (r *Registry) checkOther(inv *Invocation) error {
+	other, err := r.Users.Get(inv.OtherID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && other.DeletedAt != nil) {
+		return userError("no such user")
+	}
+	return err
+}
+
+func (r *Registry) runRemind(ctx context.Context, inv *Invocation) (*Result, error) {
+	if err := r.checkOther(inv); err != nil {
+		return nil, err
+	}
+
+	when, text, _ := strings.Cut(inv.Args, " ")
+	text = strings.TrimSpace(text)
+
+	delay, err := parseDuration(when)
+	if err != nil || text == "" {
+		return nil, userError("usage: /remind <duration> <text>, e.g. /remind 2h check the deploy")
+	}
+	if delay > maxReminderDelay {
+		return nil, userError("reminders can be at most 30 days away")
+	}
+
+	reminder := models.Reminder{
+		UserID:  inv.UserID,
+		OtherID: inv.OtherID,
+		Text:    text,
+		DueAt:   time.Now().Add(delay),
+	}
+	if err := r.DB.WithContext(ctx).Create(&reminder).Error; err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Ephemeral: fmt.Sprintf("I'll remind you at %s.", reminder.DueAt.UTC().Format("2006-01-02 15:04 MST")),
+	}, nil
+}
+
+// runMute changes the same settings as PUT /conversations/{userId}/settings.
+func (r *Registry) runMute(ctx context.Context, inv *Invocation) (*Result, error) {
+	if inv.OtherID == inv.UserID {
+		return nil, userError("you cannot mute a conversation with yourself")
+	}
+	if err := r.checkOther(inv); err != nil {
+		return nil, err
+	}
+
+	db := r.DB.WithContext(ctx)
+	setting, err := notify.ConversationSetting(db, inv.UserID, inv.OtherID)
+	if err != nil {
+		return nil, err
+	}
+
+	setting.Muted, setting.MutedUntil = true, nil
+	reply := "Conversation muted. Use /mute off to unmute."
+
+	switch inv.Args {
+	case "", "forever":
+	case "off":
+		setting.Muted = false
+		reply = "Conversation unmuted."
+	default:
+		d, err := parseDuration(inv.Args)
+		if err != nil {
+			return nil, userError("usage: /mute [duration|off], e.g. /mute 8h")
+		}
+		until := time.Now().Add(d)
+		setting.MutedUntil = &until
+		reply = fmt.Sprintf("Conversation muted until %s.", until.UTC().Format("2006-01-02 15:04 MST"))
+	}
+
+	if err := db.Save(&setting).Error; err != nil {
+		return nil, err
+	}
+	return &Result{Ephemeral: reply}, nil
+}
+
+func (r *Registry) runHelp(_ context.Context, _ *Invocation) (*Result, error) {
+	cmds, err := r.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("Available commands:")
+	for _, c := range cmds {
+		fmt.Fprintf(&b, "\n%s: %s", c.Usage, c.Description)
+	}
+	b.WriteString("\nStart a message with // to send it as text.")
+
+	return &Result{Ephemeral: b.String()}, nil
+}
+
+// parseDuration accepts time.ParseDuration syntax plus whole days ("2d")
+// and weeks ("1w").
+func parseDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+
+	switch {
+	case strings.HasSuffix(s, "d"), strings.HasSuffix(s, "w"):
+		unit := 24 * time.Hour
+		if strings.HasSuffix(s, "w") {
+			unit *= 7
+		}
+		var n int
+		n, err = strconv.Atoi(s[:len(s)-1])
+		if n > 10000 {
+			err = errors.New("duration too long")
+		}
+		d = time.Duration(n) * unit
+	default:
+		d, err = time.ParseDuration(s)
+	}
+
+	if err != nil || d <= 0 {
+		return 0, errors.New("invalid duration")
+	}
+	return d, nil
+}
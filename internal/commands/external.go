@@ -0,0 +1,110 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/webhook"
+)
+
+// ExternalTimeout bounds a call to an external command endpoint.
+const ExternalTimeout = 3 * time.Second
+
+const maxExternalResponse = 64 << 10
+
+// External responses choose where their text goes
+const (
+	ResponseEphemeral = "ephemeral"
+	ResponseInChannel = "in_channel"
+)
+
+type externalRequest struct {
+	Command            string    `json:"command"`
+	Args               string    `json:"args"`
+	UserID             string    `json:"user_id"`
+	Username           string    `json:"username"`
+	ConversationUserID string    `json:"conversation_user_id"`
+	Timestamp          time.Time `json:"timestamp"`
+}
+
+type externalResponse struct {
+	ResponseType string `json:"response_type"`
+	webhook.IncomingPayload
+}
+
+func (r *Registry) external(ext *models.SlashCommand) *Command {
+	return &Command{
+		Name:        ext.Name,
+		Description: ext.Description,
+		Usage:       ext.Usage,
+		Run: func(ctx context.Context, inv *Invocation) (*Result, error) {
+			res, err := r.callExternal(ctx, ext, inv)
+			if err != nil {
+				log.Printf("command /%s: %v", ext.Name, err)
+				return nil, userError(fmt.Sprintf("/%s is not responding, try again later", ext.Name))
+			}
+			return res, nil
+		},
+	}
+}
+
+// callExternal POSTs the invocation to the command's URL, signed like an
+// outgoing webhook, and turns the reply into a result.
+func (r *Registry) callExternal(ctx context.Context, ext *models.SlashCommand, inv *Invocation) (*Result, error) {
+	body, err := json.Marshal(externalRequest{
+		Command:            ext.Name,
+		Args:               inv.Args,
+		UserID:             inv.UserID.String(),
+		Username:           inv.Username,
+		ConversationUserID: inv.OtherID.String(),
+		Timestamp:          time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ExternalTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ext.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chat-commands/1")
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(ext.Secret, time.Now().Unix(), body))
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("endpoint responded with %s", resp.Status)
+	}
+
+	var reply externalResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxExternalResponse)).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if err := reply.Normalize(); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+
+	if reply.ResponseType == ResponseInChannel {
+		return &Result{Post: &Post{
+			Content:     reply.Text,
+			Format:      reply.Format,
+			Attachments: reply.Attachments,
+		}}, nil
+	}
+	return &Result{Ephemeral: reply.Text}, nil
+}
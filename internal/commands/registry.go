@@ -0,0 +1,209 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrInvalidName    = errors.New("command names are 1-32 lowercase letters, digits, - or _, starting with a letter")
+	ErrNameTaken      = errors.New("a built-in command has this name")
+)
+
+// Error is a failure meant to be shown to the user who ran the command, such
+// as a usage message. Other errors are internal.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func userError(msg string) error {
+	return &Error{Message: msg}
+}
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// Invocation is one use of a command in a conversation.
+type Invocation struct {
+	UserID   uuid.UUID
+	Username string
+
+	// The other participant of the conversation the command was typed in
+	OtherID uuid.UUID
+
+	Name string
+	Args string
+}
+
+// Post is a message a command sends to the conversation on the user's
+// behalf.
+type Post struct {
+	Content     string
+	Format      string
+	Attachments models.Attachments
+}
+
+// Result is what a command produced. Ephemeral text is shown only to the
+// client that ran the command.
+type Result struct {
+	Ephemeral string
+	Post      *Post
+}
+
+type Handler func(ctx context.Context, inv *Invocation) (*Result, error)
+
+type Command struct {
+	Name        string
+	Description string
+	Usage       string
+	Builtin     bool
+	Run         Handler
+}
+
+// Registry resolves command names to built-in handlers, then to external
+// commands registered in the database.
+type Registry struct {
+	DB     *gorm.DB
+	Users  store.Users
+	Client *http.Client
+
+	mu       sync.RWMutex
+	builtins map[string]*Command
+}
+
+// NewRegistry returns a registry with the built-in commands registered.
+// External commands are called through client.
+func NewRegistry(db *gorm.DB, users store.Users, client *http.Client) *Registry {
+	r := &Registry{
+		DB:       db,
+		Users:    users,
+		Client:   client,
+		builtins: make(map[string]*Command),
+	}
+	registerBuiltins(r)
+	return r
+}
+
+// Register adds a built-in command, replacing any with the same name.
+func (r *Registry) Register(cmd Command) {
+	cmd.Builtin = true
+
+	r.mu.Lock()
+	r.builtins[cmd.Name] = &cmd
+	r.mu.Unlock()
+}
+
+// ValidateName checks that name can be used for an external command.
+func (r *Registry) ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return ErrInvalidName
+	}
+
+	r.mu.RLock()
+	_, taken := r.builtins[name]
+	r.mu.RUnlock()
+	if taken {
+		return ErrNameTaken
+	}
+	return nil
+}
+
+func (r *Registry) Lookup(name string) (*Command, error) {
+	r.mu.RLock()
+	cmd, ok := r.builtins[name]
+	r.mu.RUnlock()
+	if ok {
+		return cmd, nil
+	}
+
+	var ext models.SlashCommand
+	if err := r.DB.First(&ext, "name = ?", name).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownCommand
+		}
+		return nil, err
+	}
+	return r.external(&ext), nil
+}
+
+// List returns every command whose name starts with prefix, sorted by name.
+func (r *Registry) List(prefix string) ([]*Command, error) {
+	var list []*Command
+
+	r.mu.RLock()
+	for name, cmd := range r.builtins {
+		if strings.HasPrefix(name, prefix) {
+			list = append(list, cmd)
+		}
+	}
+	r.mu.RUnlock()
+
+	var exts []models.SlashCommand
+	if err := r.DB.Where("name LIKE ? ESCAPE '\\'", escapeLike(prefix)+"%").Find(&exts).Error; err != nil {
+		return nil, err
+	}
+	for i := range exts {
+		list = append(list, r.external(&exts[i]))
+	}
+
+	slices.SortFunc(list, func(a, b *Command) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return list, nil
+}
+
+// Run looks up and runs the command named in inv.
+func (r *Registry) Run(ctx context.Context, inv *Invocation) (*Result, error) {
+	cmd, err := r.Lookup(inv.Name)
+	if err != nil {
+		return nil, err
+	}
+	return cmd.Run(ctx, inv)
+}
+
+// Parse splits a message into a command name and its arguments. It reports
+// false for ordinary text, including text escaped with a leading "//" and
+// text such as paths whose first word cannot be a command name.
+func Parse(content string) (name string, args string, ok bool) {
+	rest, found := strings.CutPrefix(content, "/")
+	if !found || strings.HasPrefix(rest, "/") {
+		return "", "", false
+	}
+
+	name = rest
+	if i := strings.IndexFunc(rest, unicode.IsSpace); i >= 0 {
+		name, args = rest[:i], rest[i:]
+	}
+	name = strings.ToLower(name)
+	if !namePattern.MatchString(name) {
+		return "", "", false
+	}
+	return name, strings.TrimSpace(args), true
+}
+
+// Unescape turns "//text" into "/text", so messages can start with a slash.
+func Unescape(content string) string {
+	if strings.HasPrefix(content, "//") {
+		return content[1:]
+	}
+	return content
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const reminderPollInterval = 15 * time.Second
+
+// Reminders shows due reminders to their users.
+type Reminders struct {
+	DB *gorm.DB
+
+	// Deliver sends data to the user's live connections and reports whether
+	// there were any. Reminders for offline users stay pending until they
+	// reconnect.
+	Deliver func(userID string, data []byte) bool
+}
+
+// Run delivers due reminders until the process exits.
+func (r *Reminders) Run() {
+	ticker := time.NewTicker(reminderPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.deliverDue()
+	}
+}
+
+func (r *Reminders) deliverDue() {
+	var batch []models.Reminder
+	err := r.DB.
+		Where("delivered_at IS NULL AND due_at <= ?", time.Now()).
+		FindInBatches(&batch, 100, func(tx *gorm.DB, _ int) error {
+			r.deliver(batch)
+			return nil
+		}).Error
+	if err != nil {
+		log.Println("reminders: failed to load due reminders:", err)
+	}
+}
+
+func (r *Reminders) deliver(due []models.Reminder) {
+	for _, rem := range due {
+		data, _ := json.Marshal(map[string]any{
+			"type":                 "reminder",
+			"id":                   rem.ID,
+			"content":              rem.Text,
+			"conversation_user_id": rem.OtherID,
+			"created":              rem.CreatedAt,
+		})
+		if !r.Deliver(rem.UserID.String(), data) {
+			continue
+		}
+
+		r.DB.Model(&models.Reminder{}).
+			Where("id = ?", rem.ID).
+			Update("delivered_at", time.Now())
+	}
+}
@@ -1,11 +1,102 @@
 package config
 
-import "os"
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
 
 type Config struct {
 	DBUrl     string
 	JWTSecret string
 	Port      string
+
+	// Apply pending schema migrations at startup instead of refusing to run
+	MigrateOnStart bool
+
+	// Read replicas, as database URLs separated by commas. Reads that
+	// tolerate lag go to them, except for a user's reads shortly after
+	// their own writes; failing replicas are skipped for a while.
+	DBReplicaURLs                string
+	ReplicaReadYourWritesSeconds int
+	ReplicaRetrySeconds          int
+
+	// Number of reverse proxies in front of the server whose
+	// X-Forwarded-For entries can be trusted
+	TrustedProxyHops int
+
+	// WebSocket authentication
+	WSAllowQueryToken bool
+	WSTicketBindIP    bool
+
+	// Password policy
+	PasswordMinLength     int
+	PasswordRequireUpper  bool
+	PasswordRequireLower  bool
+	PasswordRequireDigit  bool
+	PasswordRequireSymbol bool
+	BreachedPasswordsDir  string
+
+	// Password hashing; existing hashes are upgraded on login when these change
+	PasswordHash   string
+	BcryptCost     int
+	Argon2MemoryKB int
+	Argon2Time     int
+	Argon2Threads  int
+
+	// What happens to a deleted user's sent messages: "keep" or "purge"
+	DeletedUserMessages string
+
+	// Outgoing webhooks
+	WebhookMaxAttempts   int
+	WebhookRetentionDays int
+	WebhookAllowPrivate  bool
+
+	// Web Push; disabled unless a VAPID private key is set
+	VAPIDPrivateKey  string
+	VAPIDSubject     string
+	PushAllowPrivate bool
+
+	// Public base URL of the server, used for links in emails
+	PublicURL string
+
+	// Outgoing email; disabled unless an SMTP host is set
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// Unread-message email digests
+	DigestUnreadMinutes   int
+	DigestIntervalMinutes int
+
+	// Background conversation exports
+	ExportDir      string
+	ExportTTLHours int
+
+	// Largest Slack or WhatsApp export accepted by the import endpoint
+	ImportMaxMB int
+
+	// Monthly message partitions created ahead of the current month
+	PartitionMonthsAhead int
+
+	// Months kept in the database before the current one; older months are
+	// archived. 0 never archives.
+	ArchiveAfterMonths int
+
+	// Where archives are stored: an S3-compatible bucket if an endpoint is
+	// set, otherwise a local directory
+	ArchiveDir         string
+	ArchiveS3Endpoint  string
+	ArchiveS3Bucket    string
+	ArchiveS3Region    string
+	ArchiveS3AccessKey string
+	ArchiveS3SecretKey string
+
+	// Master keys for message encryption, as ID:KEY pairs with the current
+	// key first; message content is stored as plaintext when empty
+	EncryptionMasterKeys string
 }
 
 func Load() *Config {
@@ -13,9 +104,95 @@ func Load() *Config {
 	if port == "" {
 		port = "8080"
 	}
+
 	return &Config{
 		DBUrl:     os.Getenv("DATABASE_URL"),
 		JWTSecret: os.Getenv("JWT_SECRET"),
 		Port:      port,
+
+		MigrateOnStart: envBool("MIGRATE_ON_START", false),
+
+		DBReplicaURLs:                os.Getenv("DATABASE_REPLICA_URLS"),
+		ReplicaReadYourWritesSeconds: envInt("REPLICA_READ_YOUR_WRITES_SECONDS", 5),
+		ReplicaRetrySeconds:          envInt("REPLICA_RETRY_SECONDS", 30),
+
+		TrustedProxyHops: envInt("TRUSTED_PROXY_HOPS", 0),
+
+		WSAllowQueryToken: envBool("WS_ALLOW_QUERY_TOKEN", false),
+		WSTicketBindIP:    envBool("WS_TICKET_BIND_IP", false),
+
+		PasswordMinLength:     envInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordRequireUpper:  envBool("PASSWORD_REQUIRE_UPPER", false),
+		PasswordRequireLower:  envBool("PASSWORD_REQUIRE_LOWER", false),
+		PasswordRequireDigit:  envBool("PASSWORD_REQUIRE_DIGIT", false),
+		PasswordRequireSymbol: envBool("PASSWORD_REQUIRE_SYMBOL", false),
+		BreachedPasswordsDir:  os.Getenv("BREACHED_PASSWORDS_DIR"),
+
+		PasswordHash:   envString("PASSWORD_HASH", "bcrypt"),
+		BcryptCost:     envInt("BCRYPT_COST", 10),
+		Argon2MemoryKB: envInt("ARGON2_MEMORY_KB", 64*1024),
+		Argon2Time:     envInt("ARGON2_TIME", 3),
+		Argon2Threads:  envInt("ARGON2_THREADS", 2),
+
+		DeletedUserMessages: envString("DELETED_USER_MESSAGES", "keep"),
+
+		WebhookMaxAttempts:   envInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookRetentionDays: envInt("WEBHOOK_RETENTION_DAYS", 7),
+		WebhookAllowPrivate:  envBool("WEBHOOK_ALLOW_PRIVATE", false),
+
+		VAPIDPrivateKey:  os.Getenv("VAPID_PRIVATE_KEY"),
+		VAPIDSubject:     os.Getenv("VAPID_SUBJECT"),
+		PushAllowPrivate: envBool("PUSH_ALLOW_PRIVATE", false),
+
+		PublicURL: envString("PUBLIC_URL", "http://localhost:"+port),
+
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     envInt("SMTP_PORT", 587),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:     envString("SMTP_FROM", "Chat <noreply@localhost>"),
+
+		DigestUnreadMinutes:   envInt("DIGEST_UNREAD_MINUTES", 60),
+		DigestIntervalMinutes: envInt("DIGEST_INTERVAL_MINUTES", 360),
+
+		ExportDir:      envString("EXPORT_DIR", filepath.Join(os.TempDir(), "chat-exports")),
+		ExportTTLHours: envInt("EXPORT_TTL_HOURS", 24),
+
+		ImportMaxMB: envInt("IMPORT_MAX_MB", 512),
+
+		PartitionMonthsAhead: envInt("PARTITION_MONTHS_AHEAD", 3),
+
+		ArchiveAfterMonths: envInt("ARCHIVE_AFTER_MONTHS", 0),
+		ArchiveDir:         envString("ARCHIVE_DIR", "archive"),
+		ArchiveS3Endpoint:  os.Getenv("ARCHIVE_S3_ENDPOINT"),
+		ArchiveS3Bucket:    os.Getenv("ARCHIVE_S3_BUCKET"),
+		ArchiveS3Region:    envString("ARCHIVE_S3_REGION", "us-east-1"),
+		ArchiveS3AccessKey: os.Getenv("ARCHIVE_S3_ACCESS_KEY"),
+		ArchiveS3SecretKey: os.Getenv("ARCHIVE_S3_SECRET_KEY"),
+
+		EncryptionMasterKeys: os.Getenv("ENCRYPTION_MASTER_KEYS"),
+	}
+}
+
+func envString(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
 	}
-}
\ No newline at end of file
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envBool(key string, fallback bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
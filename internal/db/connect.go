@@ -0,0 +1,71 @@
+package db
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Connect opens the database. It does not touch the schema; see Migrator.
+func Connect(dsn string) *gorm.DB {
+	db, err := Open(dsn)
+	if err != nil {
+		log.Fatal("failed to connect database", err)
+	}
+
+	return db
+}
+
+// Open opens the database named by dsn. A DSN starting with sqlite: names a
+// SQLite file, as in sqlite:chat.db or sqlite:///var/lib/chat.db, or
+// sqlite::memory: for a database that lives as long as the process;
+// anything else is a Postgres URL or connection string.
+func Open(dsn string) (*gorm.DB, error) {
+	// Configure custom GORM logger
+	newLogger := logger.New(
+		log.New(os.Stdout, "\r\n", log.LstdFlags),
+		logger.Config{
+			SlowThreshold:             2 * time.Second, // Raise threshold to 2s to accommodate remote DB latency
+			LogLevel:                  logger.Warn,     // Only log warnings and errors
+			IgnoreRecordNotFoundError: true,            // Ignore normal record-not-found warnings
+			Colorful:                  true,
+		},
+	)
+
+	var dialector gorm.Dialector
+	if path, ok := cutSQLiteScheme(dsn); ok {
+		dialector = openSQLite(path)
+	} else {
+		dialector = postgres.Open(dsn)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: newLogger,
+
+		// Unique violations come back as gorm.ErrDuplicatedKey whatever
+		// the driver
+		TranslateError: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Callback().Create().Before("gorm:create").Register("app:assign_uuids", assignUUIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func cutSQLiteScheme(dsn string) (string, bool) {
+	if path, ok := strings.CutPrefix(dsn, "sqlite://"); ok {
+		return path, true
+	}
+	return strings.CutPrefix(dsn, "sqlite:")
+}
@@ -0,0 +1,346 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/db/migrations"
+	"gorm.io/gorm"
+)
+
+// migrationLock is the key of the Postgres advisory lock held while
+// migrations are applied, so that instances starting together apply each
+// one only once. Any constant works as long as nothing else uses it.
+const migrationLock int64 = 0x636861745f6d6967
+
+// migrationSQL holds the statements the migrator needs in one dialect.
+type migrationSQL struct {
+	// lock and unlock are empty when the dialect has no lock to take. A
+	// second process migrating a SQLite file at the same time fails to
+	// record the version instead, which rolls its migration back.
+	lock, unlock string
+
+	createTable string
+	tableExists string
+	record      string
+	forget      string
+}
+
+var migrationDialects = map[string]migrationSQL{
+	"postgres": {
+		lock:   "SELECT pg_advisory_lock($1)",
+		unlock: "SELECT pg_advisory_unlock($1)",
+		createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)`,
+		tableExists: "SELECT to_regclass('schema_migrations') IS NOT NULL",
+		record:      "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+		forget:      "DELETE FROM schema_migrations WHERE version = $1",
+	},
+	"sqlite": {
+		createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version integer PRIMARY KEY,
+			name text NOT NULL,
+			applied_at datetime NOT NULL
+		)`,
+		tableExists: "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'",
+		record:      "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		forget:      "DELETE FROM schema_migrations WHERE version = ?",
+	},
+}
+
+var ErrSchemaOutdated = errors.New("database schema is out of date")
+
+var migrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one version of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus describes a migration known to this build or recorded as
+// applied in the database. Name is empty for a version applied by a newer
+// build.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name,omitempty"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// LoadMigrations reads the migrations in fsys, ordered by version.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		match := migrationFile.FindStringSubmatch(e.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version", e.Name())
+		}
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Migrator applies and reverts the embedded migrations, recording them in
+// the schema_migrations table. Each migration runs in a transaction
+// together with its record, so a failed one leaves nothing behind.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+
+	stmts migrationSQL
+}
+
+// NewMigrator returns the migrator of db, with the migrations of its
+// dialect.
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	dialect := db.Dialector.Name()
+	statements, ok := migrationDialects[dialect]
+	if !ok {
+		return nil, fmt.Errorf("no migrations for %s databases", dialect)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	fsys, err := fs.Sub(migrations.FS, dialect)
+	if err != nil {
+		return nil, err
+	}
+	list, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: sqlDB, Migrations: list, stmts: statements}, nil
+}
+
+// Up applies the pending migrations up to and including target, or all of
+// them when target is 0, and returns those it applied.
+func (m *Migrator) Up(target int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.Migrations {
+			if target > 0 && mig.Version > target {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			err := m.run(ctx, conn, mig.Up, m.stmts.record, mig.Version, mig.Name, time.Now())
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns
+// those it reverted.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	known := map[int]Migration{}
+	for _, mig := range m.Migrations {
+		known[mig.Version] = mig
+	}
+
+	var done []Migration
+	err := m.locked(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		for _, v := range versions[:min(steps, len(versions))] {
+			mig, ok := known[v]
+			if !ok {
+				return fmt.Errorf("migration %d was applied by a newer build and cannot be reverted by this one", v)
+			}
+			err := m.run(ctx, conn, mig.Down, m.stmts.forget, mig.Version)
+			if err != nil {
+				return fmt.Errorf("reverting migration %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists the known and applied migrations by version.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	ctx := context.Background()
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []MigrationStatus
+	for _, mig := range m.Migrations {
+		s := MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if at, ok := applied[mig.Version]; ok {
+			s.AppliedAt = &at
+			delete(applied, mig.Version)
+		}
+		list = append(list, s)
+	}
+	for v, at := range applied {
+		list = append(list, MigrationStatus{Version: v, AppliedAt: &at})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Check returns ErrSchemaOutdated when a migration of this build has not
+// been applied. Versions applied by a newer build are accepted, so older
+// instances keep running during a rolling deploy.
+func (m *Migrator) Check() error {
+	list, err := m.Status()
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, s := range list {
+		if s.AppliedAt == nil {
+			pending = append(pending, fmt.Sprintf("%04d_%s", s.Version, s.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d pending migrations %v", ErrSchemaOutdated, len(pending), pending)
+	}
+	return nil
+}
+
+// locked runs fn on a single connection holding the migration lock, after
+// making sure schema_migrations exists.
+func (m *Migrator) locked(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Advisory locks belong to the session, so locking, migrating and
+	// unlocking all happen on this connection
+	if m.stmts.lock != "" {
+		if _, err := conn.ExecContext(ctx, m.stmts.lock, migrationLock); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, m.stmts.unlock, migrationLock)
+	}
+
+	if _, err := conn.ExecContext(ctx, m.stmts.createTable); err != nil {
+		return err
+	}
+
+	return fn(ctx, conn)
+}
+
+// applied returns when each recorded migration was applied. A database that
+// was never migrated has none.
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, m.stmts.tableExists).Scan(&exists)
+	if err != nil || !exists {
+		return map[int]time.Time{}, err
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// run executes a migration script and the statement that records it in one
+// transaction.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
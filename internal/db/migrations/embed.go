@@ -0,0 +1,13 @@
+// Package migrations holds the versioned SQL migrations of the database
+// schema, one directory per dialect. Each version has an up and a down
+// file, named NNNN_description.up.sql and NNNN_description.down.sql, and
+// runs in a single transaction.
+//
+// Versions are numbered in step across dialects: a schema change adds the
+// same version to postgres/ and sqlite/.
+package migrations
+
+import "embed"
+
+//go:embed postgres/*.sql sqlite/*.sql
+var FS embed.FS
@@ -0,0 +1,249 @@
+-- The schema as GORM's AutoMigrate created it before versioned migrations.
+-- Every statement is conditional, so databases created by AutoMigrate are
+-- adopted as they are.
+
+CREATE TABLE IF NOT EXISTS users (
+    id uuid DEFAULT gen_random_uuid(),
+    username text NOT NULL,
+    email text NOT NULL,
+    password_hash text NOT NULL,
+    is_admin boolean DEFAULT false,
+    is_bot boolean DEFAULT false,
+    owner_id uuid,
+    failed_login_count bigint DEFAULT 0,
+    last_failed_login_at timestamptz,
+    locked_until timestamptz,
+    deleted_at timestamptz,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT uni_users_username UNIQUE (username),
+    CONSTRAINT uni_users_email UNIQUE (email)
+);
+CREATE INDEX IF NOT EXISTS idx_users_owner_id ON users (owner_id);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id uuid DEFAULT gen_random_uuid(),
+    sender_id text NOT NULL,
+    receiver_id text NOT NULL,
+    content text NOT NULL,
+    format text NOT NULL DEFAULT '',
+    attachments text,
+    is_bot boolean DEFAULT false,
+    is_deleted boolean DEFAULT false,
+    edited_at timestamptz,
+    sender_name text NOT NULL DEFAULT '',
+    is_read boolean DEFAULT false,
+    read_at timestamptz,
+    import_key text,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_import_key ON messages (import_key);
+CREATE INDEX IF NOT EXISTS idx_messages_receiver_id ON messages (receiver_id);
+CREATE INDEX IF NOT EXISTS idx_messages_sender_id ON messages (sender_id);
+
+CREATE TABLE IF NOT EXISTS mentions (
+    id uuid DEFAULT gen_random_uuid(),
+    message_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    username text NOT NULL,
+    start_offset bigint NOT NULL,
+    length bigint NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_messages_mentions FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_mentions_user_id ON mentions (user_id);
+CREATE INDEX IF NOT EXISTS idx_mentions_message_id ON mentions (message_id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    device_name text,
+    ip text,
+    user_agent text,
+    created_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id uuid DEFAULT gen_random_uuid(),
+    bot_id uuid NOT NULL,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    key_hash text NOT NULL,
+    scopes text NOT NULL,
+    rate_limit bigint NOT NULL,
+    created_at timestamptz,
+    last_used_at timestamptz,
+    expires_at timestamptz,
+    revoked_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_key_hash ON api_keys (key_hash);
+CREATE INDEX IF NOT EXISTS idx_api_keys_bot_id ON api_keys (bot_id);
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id uuid DEFAULT gen_random_uuid(),
+    owner_id uuid NOT NULL,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text NOT NULL,
+    all_users boolean DEFAULT false,
+    created_at timestamptz,
+    deleted_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_deleted_at ON webhook_subscriptions (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_owner_id ON webhook_subscriptions (owner_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id uuid DEFAULT gen_random_uuid(),
+    subscription_id uuid NOT NULL,
+    event text NOT NULL,
+    payload text NOT NULL,
+    status text NOT NULL,
+    attempts bigint DEFAULT 0,
+    next_attempt_at timestamptz,
+    last_status_code bigint,
+    last_error text,
+    created_at timestamptz,
+    delivered_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription_id ON webhook_deliveries (subscription_id);
+
+CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+    id uuid DEFAULT gen_random_uuid(),
+    delivery_id uuid NOT NULL,
+    subscription_id uuid NOT NULL,
+    event text NOT NULL,
+    payload text NOT NULL,
+    attempts bigint,
+    last_error text,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_dead_letters_subscription_id ON webhook_dead_letters (subscription_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_webhook_dead_letters_delivery_id ON webhook_dead_letters (delivery_id);
+
+CREATE TABLE IF NOT EXISTS incoming_webhooks (
+    id uuid DEFAULT gen_random_uuid(),
+    owner_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    token_hash text NOT NULL,
+    rate_limit bigint NOT NULL,
+    created_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_incoming_webhooks_token_hash ON incoming_webhooks (token_hash);
+CREATE INDEX IF NOT EXISTS idx_incoming_webhooks_owner_id ON incoming_webhooks (owner_id);
+
+CREATE TABLE IF NOT EXISTS slash_commands (
+    id uuid DEFAULT gen_random_uuid(),
+    name text NOT NULL,
+    description text NOT NULL,
+    usage text NOT NULL,
+    url text NOT NULL,
+    secret text NOT NULL,
+    created_by uuid NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_slash_commands_name ON slash_commands (name);
+
+CREATE TABLE IF NOT EXISTS reminders (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    other_id uuid NOT NULL,
+    text text NOT NULL,
+    due_at timestamptz NOT NULL,
+    delivered_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_reminders_due ON reminders (due_at, delivered_at);
+
+CREATE TABLE IF NOT EXISTS conversation_settings (
+    user_id uuid,
+    other_id uuid,
+    muted boolean DEFAULT false,
+    muted_until timestamptz,
+    notify text NOT NULL DEFAULT 'all',
+    updated_at timestamptz,
+    PRIMARY KEY (user_id, other_id)
+);
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id uuid,
+    quiet_hours_enabled boolean DEFAULT false,
+    quiet_start bigint NOT NULL DEFAULT 0,
+    quiet_end bigint NOT NULL DEFAULT 0,
+    timezone text NOT NULL DEFAULT 'UTC',
+    email_digest_disabled boolean DEFAULT false,
+    updated_at timestamptz,
+    PRIMARY KEY (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    session_id uuid,
+    endpoint text NOT NULL,
+    p256dh text NOT NULL,
+    auth text NOT NULL,
+    user_agent text,
+    created_at timestamptz,
+    last_used_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_push_subscriptions_endpoint ON push_subscriptions (endpoint);
+CREATE INDEX IF NOT EXISTS idx_push_subscriptions_session_id ON push_subscriptions (session_id);
+CREATE INDEX IF NOT EXISTS idx_push_subscriptions_user_id ON push_subscriptions (user_id);
+
+CREATE TABLE IF NOT EXISTS digests (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    message_count bigint NOT NULL,
+    sent_at timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_digests_user_id ON digests (user_id);
+
+CREATE TABLE IF NOT EXISTS digested_messages (
+    message_id uuid,
+    user_id uuid NOT NULL,
+    digest_id uuid,
+    created_at timestamptz,
+    PRIMARY KEY (message_id)
+);
+CREATE INDEX IF NOT EXISTS idx_digested_messages_digest_id ON digested_messages (digest_id);
+CREATE INDEX IF NOT EXISTS idx_digested_messages_user_id ON digested_messages (user_id);
+
+CREATE TABLE IF NOT EXISTS export_jobs (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    other_id uuid NOT NULL,
+    format text NOT NULL,
+    since timestamptz,
+    until timestamptz,
+    status text NOT NULL,
+    started_at timestamptz,
+    error text,
+    size bigint,
+    expires_at timestamptz,
+    created_at timestamptz,
+    completed_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_export_jobs_expires_at ON export_jobs (expires_at);
+CREATE INDEX IF NOT EXISTS idx_export_jobs_status ON export_jobs (status);
+CREATE INDEX IF NOT EXISTS idx_export_jobs_user_id ON export_jobs (user_id);
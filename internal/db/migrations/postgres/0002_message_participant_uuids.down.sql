@@ -0,0 +1,3 @@
+ALTER TABLE messages
+    ALTER COLUMN sender_id TYPE text USING sender_id::text,
+    ALTER COLUMN receiver_id TYPE text USING receiver_id::text;
@@ -0,0 +1,5 @@
+-- AutoMigrate stored the sender and receiver of messages as text, since the
+-- model had no column type, so they could not be joined with users.id.
+ALTER TABLE messages
+    ALTER COLUMN sender_id TYPE uuid USING sender_id::uuid,
+    ALTER COLUMN receiver_id TYPE uuid USING receiver_id::uuid;
@@ -0,0 +1,4 @@
+-- The pg_trgm extension is left installed; other objects may use it.
+DROP INDEX IF EXISTS idx_messages_unread;
+DROP INDEX IF EXISTS idx_users_email_trgm;
+DROP INDEX IF EXISTS idx_users_username_trgm;
@@ -0,0 +1,10 @@
+-- User search matches any part of the username or email with LIKE, which
+-- only a trigram index can serve.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users USING gin (username gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING gin (email gin_trgm_ops);
+
+-- Unread counts, read receipts and digests only look at the few messages
+-- that are still unread.
+CREATE INDEX IF NOT EXISTS idx_messages_unread ON messages (receiver_id, sender_id)
+    WHERE is_read = false AND is_deleted = false;
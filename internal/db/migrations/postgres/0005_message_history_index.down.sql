@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_messages_sender_id ON messages (sender_id);
+DROP INDEX IF EXISTS idx_messages_conversation;
@@ -0,0 +1,5 @@
+-- History pages through one direction of a conversation at a time by
+-- (created_at, id), so each page is a range scan of this index. It starts
+-- with sender_id, which makes the index on sender_id alone redundant.
+CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages (sender_id, receiver_id, created_at DESC, id DESC);
+DROP INDEX IF EXISTS idx_messages_sender_id;
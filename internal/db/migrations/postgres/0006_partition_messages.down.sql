@@ -0,0 +1,40 @@
+-- Archived months that were not restored stay in their archive files.
+DROP TABLE IF EXISTS message_archives;
+
+CREATE TABLE messages_unpartitioned (
+    id uuid DEFAULT gen_random_uuid(),
+    sender_id uuid NOT NULL,
+    receiver_id uuid NOT NULL,
+    content text NOT NULL,
+    format text NOT NULL DEFAULT '',
+    attachments text,
+    is_bot boolean DEFAULT false,
+    is_deleted boolean DEFAULT false,
+    edited_at timestamptz,
+    sender_name text NOT NULL DEFAULT '',
+    is_read boolean DEFAULT false,
+    read_at timestamptz,
+    import_key text,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+
+INSERT INTO messages_unpartitioned (id, sender_id, receiver_id, content, format, attachments, is_bot, is_deleted,
+    edited_at, sender_name, is_read, read_at, import_key, created_at)
+SELECT id, sender_id, receiver_id, content, format, attachments, is_bot, is_deleted,
+    edited_at, sender_name, is_read, read_at, import_key, created_at
+FROM messages;
+
+DROP TABLE messages;
+ALTER TABLE messages_unpartitioned RENAME TO messages;
+ALTER TABLE messages RENAME CONSTRAINT messages_unpartitioned_pkey TO messages_pkey;
+
+CREATE UNIQUE INDEX idx_messages_import_key ON messages (import_key);
+CREATE INDEX idx_messages_receiver_id ON messages (receiver_id);
+CREATE INDEX idx_messages_conversation ON messages (sender_id, receiver_id, created_at DESC, id DESC);
+CREATE INDEX idx_messages_unread ON messages (receiver_id, sender_id)
+    WHERE is_read = false AND is_deleted = false;
+
+DELETE FROM mentions WHERE NOT EXISTS (SELECT 1 FROM messages WHERE messages.id = mentions.message_id);
+ALTER TABLE mentions
+    ADD CONSTRAINT fk_messages_mentions FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE;
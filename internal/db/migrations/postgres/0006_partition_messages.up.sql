@@ -0,0 +1,87 @@
+-- Messages are partitioned by the month they were sent in, so a month can be
+-- archived by detaching its partition rather than deleting rows. The server
+-- creates the partitions of coming months as it runs; this migration
+-- creates those the existing messages need and a few ahead. The default
+-- partition catches anything else, such as imported history.
+--
+-- The primary key and unique indexes of a partitioned table must include
+-- the partition key, so a message ID is only unique together with its
+-- created_at, and mentions can no longer reference messages with a foreign
+-- key.
+ALTER TABLE mentions DROP CONSTRAINT IF EXISTS fk_messages_mentions;
+
+ALTER TABLE messages RENAME TO messages_unpartitioned;
+ALTER TABLE messages_unpartitioned DROP CONSTRAINT messages_pkey;
+DROP INDEX IF EXISTS idx_messages_import_key;
+DROP INDEX IF EXISTS idx_messages_receiver_id;
+DROP INDEX IF EXISTS idx_messages_conversation;
+DROP INDEX IF EXISTS idx_messages_unread;
+
+CREATE TABLE messages (
+    id uuid DEFAULT gen_random_uuid(),
+    sender_id uuid NOT NULL,
+    receiver_id uuid NOT NULL,
+    content text NOT NULL,
+    format text NOT NULL DEFAULT '',
+    attachments text,
+    is_bot boolean DEFAULT false,
+    is_deleted boolean DEFAULT false,
+    edited_at timestamptz,
+    sender_name text NOT NULL DEFAULT '',
+    is_read boolean DEFAULT false,
+    read_at timestamptz,
+    import_key text,
+    created_at timestamptz NOT NULL,
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+-- Imported messages keep the time they were sent elsewhere, so the import
+-- key and creation time together still identify them
+CREATE UNIQUE INDEX idx_messages_import_key ON messages (import_key, created_at);
+CREATE INDEX idx_messages_receiver_id ON messages (receiver_id);
+CREATE INDEX idx_messages_conversation ON messages (sender_id, receiver_id, created_at DESC, id DESC);
+CREATE INDEX idx_messages_unread ON messages (receiver_id, sender_id)
+    WHERE is_read = false AND is_deleted = false;
+
+CREATE TABLE messages_default PARTITION OF messages DEFAULT;
+
+DO $$
+DECLARE
+    month_start timestamp;
+    stop timestamp;
+BEGIN
+    SELECT date_trunc('month', COALESCE(MIN(created_at), now()) AT TIME ZONE 'UTC') INTO month_start
+    FROM messages_unpartitioned;
+    stop := date_trunc('month', now() AT TIME ZONE 'UTC') + interval '3 months';
+
+    WHILE month_start <= stop LOOP
+        EXECUTE format('CREATE TABLE %I PARTITION OF messages FOR VALUES FROM (%L) TO (%L)',
+            'messages_' || to_char(month_start, '"y"YYYY"m"MM'),
+            to_char(month_start, 'YYYY-MM-DD') || ' 00:00:00+00',
+            to_char(month_start + interval '1 month', 'YYYY-MM-DD') || ' 00:00:00+00');
+        month_start := month_start + interval '1 month';
+    END LOOP;
+END
+$$;
+
+INSERT INTO messages (id, sender_id, receiver_id, content, format, attachments, is_bot, is_deleted,
+    edited_at, sender_name, is_read, read_at, import_key, created_at)
+SELECT id, sender_id, receiver_id, content, format, attachments, is_bot, is_deleted,
+    edited_at, sender_name, is_read, read_at, import_key, COALESCE(created_at, now())
+FROM messages_unpartitioned;
+
+DROP TABLE messages_unpartitioned;
+
+-- One row per archive file written for a month; a month archived again
+-- after more messages were imported into it has several
+CREATE TABLE message_archives (
+    id uuid DEFAULT gen_random_uuid(),
+    month text NOT NULL,
+    object text NOT NULL,
+    messages bigint NOT NULL,
+    size bigint NOT NULL,
+    archived_at timestamptz NOT NULL,
+    restored_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_message_archives_month ON message_archives (month);
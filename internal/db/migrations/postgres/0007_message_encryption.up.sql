@@ -0,0 +1,21 @@
+-- Message content is encrypted with a data key per conversation, which is
+-- stored wrapped by a master key. A conversation has one current key at a
+-- time; retired keys are kept for the messages and archives that still use
+-- them.
+CREATE TABLE conversation_keys (
+    id uuid DEFAULT gen_random_uuid(),
+    user_a uuid NOT NULL,
+    user_b uuid NOT NULL,
+    master_key_id text NOT NULL,
+    wrapped_key bytea NOT NULL,
+    created_at timestamptz NOT NULL,
+    retired_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_conversation_keys_current ON conversation_keys (user_a, user_b) WHERE retired_at IS NULL;
+CREATE INDEX idx_conversation_keys_master_key_id ON conversation_keys (master_key_id);
+
+-- The data key a message's content is encrypted with; NULL for plaintext,
+-- as every message stored before this migration is.
+ALTER TABLE messages ADD COLUMN key_id uuid;
+CREATE INDEX idx_messages_key_id ON messages (key_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS encrypted_contacts;
+DROP TABLE IF EXISTS envelopes;
+DROP TABLE IF EXISTS pre_keys;
+DROP TABLE IF EXISTS devices;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_digests_pending;
+ALTER TABLE digests DROP COLUMN status;
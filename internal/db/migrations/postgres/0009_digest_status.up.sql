@@ -0,0 +1,5 @@
+-- A digest is recorded as pending before its email is sent, outside any
+-- transaction, and marked sent afterwards. Digests recorded before this
+-- migration were sent in the transaction that recorded them.
+ALTER TABLE digests ADD COLUMN status text NOT NULL DEFAULT 'sent';
+CREATE INDEX idx_digests_pending ON digests (sent_at) WHERE status = 'pending';
@@ -0,0 +1,9 @@
+-- When each user's data last changed, so that every instance routing reads
+-- to replicas sends that user's reads to the primary for a while after.
+-- Rows older than the read-your-writes window are pruned.
+CREATE TABLE recent_writes (
+    user_id uuid,
+    written_at timestamptz NOT NULL,
+    PRIMARY KEY (user_id)
+);
+CREATE INDEX idx_recent_writes_written_at ON recent_writes (written_at);
@@ -0,0 +1,12 @@
+-- Single-use tickets for opening a WebSocket, kept in the database so that
+-- a ticket issued by one instance can be redeemed on another. Only a hash
+-- of each ticket is stored; expired ones are deleted as new ones are issued.
+CREATE TABLE ws_tickets (
+    ticket_hash text,
+    user_id uuid NOT NULL,
+    session_id uuid NOT NULL,
+    ip text NOT NULL DEFAULT '',
+    expires_at timestamptz NOT NULL,
+    PRIMARY KEY (ticket_hash)
+);
+CREATE INDEX idx_ws_tickets_expires_at ON ws_tickets (expires_at);
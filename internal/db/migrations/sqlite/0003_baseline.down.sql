@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS export_jobs;
+DROP TABLE IF EXISTS digested_messages;
+DROP TABLE IF EXISTS digests;
+DROP TABLE IF EXISTS push_subscriptions;
+DROP TABLE IF EXISTS notification_preferences;
+DROP TABLE IF EXISTS conversation_settings;
+DROP TABLE IF EXISTS reminders;
+DROP TABLE IF EXISTS slash_commands;
+DROP TABLE IF EXISTS incoming_webhooks;
+DROP TABLE IF EXISTS webhook_dead_letters;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_subscriptions;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS mentions;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,254 @@
+-- SQLite databases start at the Postgres schema as of version 3. UUIDs are
+-- stored as text and generated by the application, and times are stored as
+-- UTC text, which sorts in time order.
+
+CREATE TABLE users (
+    id text,
+    username text NOT NULL,
+    email text NOT NULL,
+    password_hash text NOT NULL,
+    is_admin boolean DEFAULT false,
+    is_bot boolean DEFAULT false,
+    owner_id text,
+    failed_login_count integer DEFAULT 0,
+    last_failed_login_at datetime,
+    locked_until datetime,
+    deleted_at datetime,
+    created_at datetime,
+    updated_at datetime,
+    PRIMARY KEY (id),
+    CONSTRAINT uni_users_username UNIQUE (username),
+    CONSTRAINT uni_users_email UNIQUE (email)
+);
+CREATE INDEX idx_users_owner_id ON users (owner_id);
+
+CREATE TABLE messages (
+    id text,
+    sender_id text NOT NULL,
+    receiver_id text NOT NULL,
+    content text NOT NULL,
+    format text NOT NULL DEFAULT '',
+    attachments text,
+    is_bot boolean DEFAULT false,
+    is_deleted boolean DEFAULT false,
+    edited_at datetime,
+    sender_name text NOT NULL DEFAULT '',
+    is_read boolean DEFAULT false,
+    read_at datetime,
+    import_key text,
+    created_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_messages_import_key ON messages (import_key);
+CREATE INDEX idx_messages_receiver_id ON messages (receiver_id);
+CREATE INDEX idx_messages_sender_id ON messages (sender_id);
+
+CREATE TABLE mentions (
+    id text,
+    message_id text NOT NULL,
+    user_id text NOT NULL,
+    username text NOT NULL,
+    start_offset integer NOT NULL,
+    length integer NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_messages_mentions FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_mentions_user_id ON mentions (user_id);
+CREATE INDEX idx_mentions_message_id ON mentions (message_id);
+
+CREATE TABLE sessions (
+    id text,
+    user_id text NOT NULL,
+    device_name text,
+    ip text,
+    user_agent text,
+    created_at datetime,
+    last_used_at datetime,
+    revoked_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE api_keys (
+    id text,
+    bot_id text NOT NULL,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    key_hash text NOT NULL,
+    scopes text NOT NULL,
+    rate_limit integer NOT NULL,
+    created_at datetime,
+    last_used_at datetime,
+    expires_at datetime,
+    revoked_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_api_keys_key_hash ON api_keys (key_hash);
+CREATE INDEX idx_api_keys_bot_id ON api_keys (bot_id);
+
+CREATE TABLE webhook_subscriptions (
+    id text,
+    owner_id text NOT NULL,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text NOT NULL,
+    all_users boolean DEFAULT false,
+    created_at datetime,
+    deleted_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_webhook_subscriptions_deleted_at ON webhook_subscriptions (deleted_at);
+CREATE INDEX idx_webhook_subscriptions_owner_id ON webhook_subscriptions (owner_id);
+
+CREATE TABLE webhook_deliveries (
+    id text,
+    subscription_id text NOT NULL,
+    event text NOT NULL,
+    payload text NOT NULL,
+    status text NOT NULL,
+    attempts integer DEFAULT 0,
+    next_attempt_at datetime,
+    last_status_code integer,
+    last_error text,
+    created_at datetime,
+    delivered_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+CREATE INDEX idx_webhook_deliveries_subscription_id ON webhook_deliveries (subscription_id);
+
+CREATE TABLE webhook_dead_letters (
+    id text,
+    delivery_id text NOT NULL,
+    subscription_id text NOT NULL,
+    event text NOT NULL,
+    payload text NOT NULL,
+    attempts integer,
+    last_error text,
+    created_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_webhook_dead_letters_subscription_id ON webhook_dead_letters (subscription_id);
+CREATE UNIQUE INDEX idx_webhook_dead_letters_delivery_id ON webhook_dead_letters (delivery_id);
+
+CREATE TABLE incoming_webhooks (
+    id text,
+    owner_id text NOT NULL,
+    user_id text NOT NULL,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    token_hash text NOT NULL,
+    rate_limit integer NOT NULL,
+    created_at datetime,
+    last_used_at datetime,
+    revoked_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_incoming_webhooks_token_hash ON incoming_webhooks (token_hash);
+CREATE INDEX idx_incoming_webhooks_owner_id ON incoming_webhooks (owner_id);
+
+CREATE TABLE slash_commands (
+    id text,
+    name text NOT NULL,
+    description text NOT NULL,
+    usage text NOT NULL,
+    url text NOT NULL,
+    secret text NOT NULL,
+    created_by text NOT NULL,
+    created_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_slash_commands_name ON slash_commands (name);
+
+CREATE TABLE reminders (
+    id text,
+    user_id text NOT NULL,
+    other_id text NOT NULL,
+    text text NOT NULL,
+    due_at datetime NOT NULL,
+    delivered_at datetime,
+    created_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_reminders_due ON reminders (due_at, delivered_at);
+
+CREATE TABLE conversation_settings (
+    user_id text,
+    other_id text,
+    muted boolean DEFAULT false,
+    muted_until datetime,
+    notify text NOT NULL DEFAULT 'all',
+    updated_at datetime,
+    PRIMARY KEY (user_id, other_id)
+);
+
+CREATE TABLE notification_preferences (
+    user_id text,
+    quiet_hours_enabled boolean DEFAULT false,
+    quiet_start integer NOT NULL DEFAULT 0,
+    quiet_end integer NOT NULL DEFAULT 0,
+    timezone text NOT NULL DEFAULT 'UTC',
+    email_digest_disabled boolean DEFAULT false,
+    updated_at datetime,
+    PRIMARY KEY (user_id)
+);
+
+CREATE TABLE push_subscriptions (
+    id text,
+    user_id text NOT NULL,
+    session_id text,
+    endpoint text NOT NULL,
+    p256dh text NOT NULL,
+    auth text NOT NULL,
+    user_agent text,
+    created_at datetime,
+    last_used_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_push_subscriptions_endpoint ON push_subscriptions (endpoint);
+CREATE INDEX idx_push_subscriptions_session_id ON push_subscriptions (session_id);
+CREATE INDEX idx_push_subscriptions_user_id ON push_subscriptions (user_id);
+
+CREATE TABLE digests (
+    id text,
+    user_id text NOT NULL,
+    message_count integer NOT NULL,
+    sent_at datetime NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_digests_user_id ON digests (user_id);
+
+CREATE TABLE digested_messages (
+    message_id text,
+    user_id text NOT NULL,
+    digest_id text,
+    created_at datetime,
+    PRIMARY KEY (message_id)
+);
+CREATE INDEX idx_digested_messages_digest_id ON digested_messages (digest_id);
+CREATE INDEX idx_digested_messages_user_id ON digested_messages (user_id);
+
+CREATE TABLE export_jobs (
+    id text,
+    user_id text NOT NULL,
+    other_id text NOT NULL,
+    format text NOT NULL,
+    since datetime,
+    until datetime,
+    status text NOT NULL,
+    started_at datetime,
+    error text,
+    size integer,
+    expires_at datetime,
+    created_at datetime,
+    completed_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_export_jobs_expires_at ON export_jobs (expires_at);
+CREATE INDEX idx_export_jobs_status ON export_jobs (status);
+CREATE INDEX idx_export_jobs_user_id ON export_jobs (user_id);
+
+-- Unread counts, read receipts and digests only look at the few messages
+-- that are still unread.
+CREATE INDEX idx_messages_unread ON messages (receiver_id, sender_id)
+    WHERE is_read = false AND is_deleted = false;
@@ -0,0 +1,40 @@
+-- One row per participant of each conversation, so the conversation list is
+-- a single indexed query instead of an aggregation over messages.
+CREATE TABLE conversation_summaries (
+    user_id text NOT NULL,
+    other_id text NOT NULL,
+    last_message_id text,
+    last_activity datetime NOT NULL,
+    unread_count integer NOT NULL DEFAULT 0,
+    unread_mentions integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, other_id)
+);
+CREATE INDEX idx_conversation_summaries_list ON conversation_summaries (user_id, last_activity DESC, other_id DESC);
+
+-- Fill the table in from the messages sent so far. Each message appears in
+-- the conversation of its sender and, as incoming, in that of its receiver.
+INSERT INTO conversation_summaries (user_id, other_id, last_message_id, last_activity, unread_count, unread_mentions)
+WITH views AS (
+    SELECT sender_id AS user_id, receiver_id AS other_id, id, created_at, is_read, false AS incoming
+    FROM messages WHERE is_deleted = false AND sender_id <> receiver_id
+    UNION ALL
+    SELECT receiver_id, sender_id, id, created_at, is_read, true
+    FROM messages WHERE is_deleted = false
+),
+ranked AS (
+    SELECT views.*, ROW_NUMBER() OVER (PARTITION BY user_id, other_id ORDER BY created_at DESC, id DESC) AS recency
+    FROM views
+),
+counts AS (
+    SELECT user_id, other_id,
+        SUM(CASE WHEN incoming AND NOT is_read THEN 1 ELSE 0 END) AS unread_count,
+        SUM(CASE WHEN incoming AND NOT is_read AND EXISTS (
+            SELECT 1 FROM mentions WHERE mentions.message_id = ranked.id AND mentions.user_id = ranked.user_id
+        ) THEN 1 ELSE 0 END) AS unread_mentions
+    FROM ranked
+    GROUP BY user_id, other_id
+)
+SELECT ranked.user_id, ranked.other_id, ranked.id, ranked.created_at, counts.unread_count, counts.unread_mentions
+FROM ranked
+JOIN counts ON counts.user_id = ranked.user_id AND counts.other_id = ranked.other_id
+WHERE ranked.recency = 1;
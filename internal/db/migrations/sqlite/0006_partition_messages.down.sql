@@ -0,0 +1,4 @@
+DROP TABLE message_archives;
+
+DROP INDEX idx_messages_import_key;
+CREATE UNIQUE INDEX idx_messages_import_key ON messages (import_key);
@@ -0,0 +1,19 @@
+-- SQLite has no partitioning, so archiving a month deletes its messages.
+-- Imported messages are identified by their import key and creation time,
+-- as on Postgres, where unique indexes must include the partition key.
+DROP INDEX idx_messages_import_key;
+CREATE UNIQUE INDEX idx_messages_import_key ON messages (import_key, created_at);
+
+-- One row per archive file written for a month; a month archived again
+-- after more messages were imported into it has several
+CREATE TABLE message_archives (
+    id text,
+    month text NOT NULL,
+    object text NOT NULL,
+    messages integer NOT NULL,
+    size integer NOT NULL,
+    archived_at datetime NOT NULL,
+    restored_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_message_archives_month ON message_archives (month);
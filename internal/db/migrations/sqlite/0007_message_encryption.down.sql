@@ -0,0 +1,5 @@
+-- Encrypted content cannot be decrypted by SQL; run `server keys decrypt`
+-- before reverting, or the messages it left encrypted are unreadable.
+DROP INDEX IF EXISTS idx_messages_key_id;
+ALTER TABLE messages DROP COLUMN key_id;
+DROP TABLE IF EXISTS conversation_keys;
@@ -0,0 +1,21 @@
+-- Message content is encrypted with a data key per conversation, which is
+-- stored wrapped by a master key. A conversation has one current key at a
+-- time; retired keys are kept for the messages and archives that still use
+-- them.
+CREATE TABLE conversation_keys (
+    id text,
+    user_a text NOT NULL,
+    user_b text NOT NULL,
+    master_key_id text NOT NULL,
+    wrapped_key blob NOT NULL,
+    created_at datetime NOT NULL,
+    retired_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_conversation_keys_current ON conversation_keys (user_a, user_b) WHERE retired_at IS NULL;
+CREATE INDEX idx_conversation_keys_master_key_id ON conversation_keys (master_key_id);
+
+-- The data key a message's content is encrypted with; NULL for plaintext,
+-- as every message stored before this migration is.
+ALTER TABLE messages ADD COLUMN key_id text;
+CREATE INDEX idx_messages_key_id ON messages (key_id);
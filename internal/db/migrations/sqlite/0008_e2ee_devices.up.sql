@@ -0,0 +1,51 @@
+-- Public keys of the devices taking part in end-to-end encryption, and the
+-- ciphertext relayed to them. The server cannot read either.
+CREATE TABLE devices (
+    id text,
+    user_id text NOT NULL,
+    name text,
+    identity_key text NOT NULL,
+    signed_prekey_key_id integer NOT NULL,
+    signed_prekey_public_key text NOT NULL,
+    signed_prekey_signature text NOT NULL,
+    created_at datetime,
+    updated_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_devices_user_id ON devices (user_id);
+
+-- One-time prekeys, each deleted when a bundle hands it out
+CREATE TABLE pre_keys (
+    id text,
+    device_id text NOT NULL,
+    key_id integer NOT NULL,
+    public_key text NOT NULL,
+    created_at datetime,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_pre_keys_device_key ON pre_keys (device_id, key_id);
+
+-- Ciphertext waiting for its device to acknowledge it
+CREATE TABLE envelopes (
+    id text,
+    sender_id text NOT NULL,
+    sender_device_id text NOT NULL,
+    recipient_id text NOT NULL,
+    recipient_device_id text NOT NULL,
+    ciphertext_type integer NOT NULL,
+    ciphertext text NOT NULL,
+    created_at datetime NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_envelopes_recipient_device ON envelopes (recipient_device_id, created_at);
+CREATE INDEX idx_envelopes_sender_id ON envelopes (sender_id);
+CREATE INDEX idx_envelopes_recipient_id ON envelopes (recipient_id);
+
+-- Pairs of users who exchanged encrypted messages, in both directions
+CREATE TABLE encrypted_contacts (
+    user_id text,
+    other_id text,
+    created_at datetime,
+    PRIMARY KEY (user_id, other_id)
+);
+CREATE INDEX idx_encrypted_contacts_other_id ON encrypted_contacts (other_id);
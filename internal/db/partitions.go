@@ -0,0 +1,178 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// On Postgres, messages are partitioned by the month they were sent in,
+// with a default partition for months that have none (see migration 0006).
+// SQLite has no partitioning, and the functions below do nothing there.
+
+// partitionLock is the key of the Postgres advisory lock held while a
+// partition of messages is created, so instances starting together do not
+// race to create the same one.
+const partitionLock int64 = 0x636861745f707274
+
+// Partitioned reports whether messages is partitioned in db.
+func Partitioned(db *gorm.DB) bool {
+	return db.Dialector.Name() == "postgres"
+}
+
+// MonthStart returns the start of the month of t, in UTC. Partitions hold
+// UTC months.
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// PartitionName returns the name of the partition of messages holding the
+// month that starts at month.
+func PartitionName(month time.Time) string {
+	return "messages_" + month.Format("y2006m01")
+}
+
+// EnsureMessagePartitions creates the partitions for the month of now and
+// the ahead months after it, and for the months of any messages in the
+// default partition, which it moves into them.
+func EnsureMessagePartitions(db *gorm.DB, now time.Time, ahead int) error {
+	if !Partitioned(db) {
+		return nil
+	}
+
+	var months []time.Time
+	for i := 0; i <= ahead; i++ {
+		months = append(months, MonthStart(now).AddDate(0, i, 0))
+	}
+
+	stray, err := strayMonths(db)
+	if err != nil {
+		return err
+	}
+	months = append(months, stray...)
+
+	for _, month := range months {
+		if err := CreateMessagePartition(db, month); err != nil {
+			return fmt.Errorf("creating %s: %w", PartitionName(month), err)
+		}
+	}
+	return nil
+}
+
+// CreateMessagePartition creates the partition for the month that starts
+// at month, unless it exists.
+func CreateMessagePartition(db *gorm.DB, month time.Time) error {
+	if !Partitioned(db) {
+		return nil
+	}
+
+	name := PartitionName(month)
+	from, to := month, month.AddDate(0, 1, 0)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", partitionLock).Error; err != nil {
+			return err
+		}
+		if exists, err := tableExists(tx, name); err != nil || exists {
+			return err
+		}
+
+		create := fmt.Sprintf("CREATE TABLE %s PARTITION OF messages FOR VALUES FROM ('%s') TO ('%s')",
+			name, from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+		var stray int64
+		err := tx.Raw("SELECT COUNT(*) FROM messages_default WHERE created_at >= ? AND created_at < ?", from, to).
+			Scan(&stray).Error
+		if err != nil {
+			return err
+		}
+		if stray == 0 {
+			return tx.Exec(create).Error
+		}
+
+		// Postgres refuses to create a partition for rows the default
+		// partition holds, so they are moved while it is detached
+		steps := []struct {
+			sql  string
+			args []any
+		}{
+			{"ALTER TABLE messages DETACH PARTITION messages_default", nil},
+			{create, nil},
+			{"INSERT INTO messages SELECT * FROM messages_default WHERE created_at >= ? AND created_at < ?", []any{from, to}},
+			{"DELETE FROM messages_default WHERE created_at >= ? AND created_at < ?", []any{from, to}},
+			{"ALTER TABLE messages ATTACH PARTITION messages_default DEFAULT", nil},
+		}
+		for _, step := range steps {
+			if err := tx.Exec(step.sql, step.args...).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LockMessagePartition locks the partition for the month that starts at
+// month against any other use until the caller's transaction ends, and
+// reports whether there is one.
+func LockMessagePartition(tx *gorm.DB, month time.Time) (bool, error) {
+	if !Partitioned(tx) {
+		return false, nil
+	}
+
+	name := PartitionName(month)
+	if exists, err := tableExists(tx, name); err != nil || !exists {
+		return false, err
+	}
+	if err := tx.Exec("LOCK TABLE " + name + " IN ACCESS EXCLUSIVE MODE").Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DropMessagePartition detaches and drops the partition for the month that
+// starts at month, and reports whether there was one. It runs in the
+// caller's transaction.
+func DropMessagePartition(tx *gorm.DB, month time.Time) (bool, error) {
+	if !Partitioned(tx) {
+		return false, nil
+	}
+
+	name := PartitionName(month)
+	if exists, err := tableExists(tx, name); err != nil || !exists {
+		return false, err
+	}
+	if err := tx.Exec("ALTER TABLE messages DETACH PARTITION " + name).Error; err != nil {
+		return false, err
+	}
+	if err := tx.Exec("DROP TABLE " + name).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// strayMonths returns the months of the messages in the default partition.
+func strayMonths(db *gorm.DB) ([]time.Time, error) {
+	rows, err := db.Raw("SELECT DISTINCT date_trunc('month', created_at AT TIME ZONE 'UTC') FROM messages_default").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var months []time.Time
+	for rows.Next() {
+		var month time.Time
+		if err := rows.Scan(&month); err != nil {
+			return nil, err
+		}
+		months = append(months, MonthStart(month))
+	}
+	return months, rows.Err()
+}
+
+func tableExists(tx *gorm.DB, name string) (bool, error) {
+	var exists bool
+	err := tx.Raw("SELECT to_regclass(?) IS NOT NULL", name).Scan(&exists).Error
+	return exists, err
+}
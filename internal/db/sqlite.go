@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"time"
+
+	gosqlite "github.com/glebarez/go-sqlite"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// sqliteParams are added to every SQLite DSN. Foreign keys are off by
+// default in SQLite, and LIKE is made case sensitive as it is in Postgres.
+// Transactions take the write lock when they begin, so that two of them
+// wait for each other instead of failing when both come to write.
+const sqliteParams = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=case_sensitive_like(1)&_txlock=immediate"
+
+var sqliteDriver = &gosqlite.Driver{}
+
+// openSQLite returns the dialector of the SQLite database at path, which
+// may carry driver parameters after a '?'.
+func openSQLite(path string) gorm.Dialector {
+	memory := strings.HasPrefix(path, ":memory:")
+
+	dsn := path
+	if strings.Contains(dsn, "?") {
+		dsn += "&" + sqliteParams
+	} else {
+		dsn += "?" + sqliteParams
+	}
+	if !memory {
+		// Readers do not wait for the writer
+		dsn += "&_pragma=journal_mode(wal)"
+	}
+
+	conn := sql.OpenDB(sqliteConnector{dsn: dsn})
+	if memory {
+		// Every connection to :memory: would open a database of its own
+		conn.SetMaxOpenConns(1)
+	}
+	return sqlite.Dialector{Conn: conn}
+}
+
+// sqliteConnector opens connections that convert every time to UTC before
+// writing it. SQLite keeps times as text and compares them as strings,
+// which only follows time order when they share an offset.
+type sqliteConnector struct {
+	dsn string
+}
+
+func (c sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := sqliteDriver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return utcConn{conn.(sqliteConn)}, nil
+}
+
+func (sqliteConnector) Driver() driver.Driver {
+	return sqliteDriver
+}
+
+// sqliteConn is what database/sql uses of a driver connection. Embedding
+// it in utcConn keeps all of it visible.
+type sqliteConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+}
+
+type utcConn struct {
+	sqliteConn
+}
+
+func (utcConn) CheckNamedValue(nv *driver.NamedValue) error {
+	v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	if t, ok := v.(time.Time); ok {
+		v = t.UTC()
+	}
+	nv.Value = v
+	return nil
+}
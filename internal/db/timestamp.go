@@ -0,0 +1,47 @@
+package db
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// timestampLayout is how the SQLite driver writes times.
+const timestampLayout = "2006-01-02 15:04:05.999999999-07:00"
+
+// Timestamp scans a time computed by a query, such as MAX(created_at).
+// SQLite only returns time.Time for columns declared as times, and returns
+// anything computed from them as the text it stores. NULL, as from MIN over
+// no rows, scans as the zero time.
+type Timestamp struct {
+	time.Time
+}
+
+func (t *Timestamp) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v
+		return nil
+	case string:
+		return t.parse(v)
+	case []byte:
+		return t.parse(string(v))
+	}
+	return fmt.Errorf("cannot scan %T into a timestamp", value)
+}
+
+func (t *Timestamp) parse(s string) error {
+	parsed, err := time.Parse(timestampLayout, s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+func (t Timestamp) Value() (driver.Value, error) {
+	return t.Time, nil
+}
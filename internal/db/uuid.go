@@ -0,0 +1,43 @@
+package db
+
+import (
+	"reflect"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var uuidType = reflect.TypeOf(uuid.UUID{})
+
+// assignUUIDs gives records with a UUID primary key a new ID before they
+// are created, unless the caller already chose one. IDs are generated here
+// rather than by the database, which SQLite could not do.
+func assignUUIDs(db *gorm.DB) {
+	if db.Statement.Schema == nil {
+		return
+	}
+	field := db.Statement.Schema.PrioritizedPrimaryField
+	if field == nil || field.FieldType != uuidType {
+		return
+	}
+
+	ctx := db.Statement.Context
+	assign := func(record reflect.Value) {
+		record = reflect.Indirect(record)
+		if record.Kind() != reflect.Struct {
+			return
+		}
+		if _, zero := field.ValueOf(ctx, record); zero {
+			db.AddError(field.Set(ctx, record, uuid.New()))
+		}
+	}
+
+	switch value := db.Statement.ReflectValue; value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			assign(value.Index(i))
+		}
+	case reflect.Struct:
+		assign(value)
+	}
+}
@@ -0,0 +1,313 @@
+package digest
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/mail"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/notify"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	pollInterval = 5 * time.Minute
+
+	// Most messages considered for one digest; the rest wait for the next
+	maxMessages = 200
+
+	// Messages listed per conversation, newest first
+	maxPerConversation = 5
+
+	// Longest message preview, in characters
+	maxPreview = 200
+
+	// How long a digest may stay pending before the job assumes the
+	// instance sending it died, and releases its messages to be sent again
+	pendingTimeout = time.Hour
+)
+
+// Job emails users a digest of messages they have left unread for a while.
+// Every message is considered once: the ones listed in a digest, or left
+// out because the recipient's notification settings silence them, are
+// recorded as digested.
+type Job struct {
+	DB     *gorm.DB
+	Mailer mail.Mailer
+
+	// Messages unread for this long go into a digest
+	UnreadAfter time.Duration
+
+	// A user gets at most one digest per Interval
+	Interval time.Duration
+
+	// Public base URL of the server, for unsubscribe links
+	BaseURL string
+
+	// Signs unsubscribe links
+	Secret string
+}
+
+// Run sends digests until the process exits.
+func (j *Job) Run() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		j.sendDue(time.Now())
+	}
+}
+
+func (j *Job) sendDue(now time.Time) {
+	if err := j.releaseStale(now); err != nil {
+		log.Println("digest: failed to release stale digests:", err)
+	}
+
+	var userIDs []uuid.UUID
+	err := j.pending(now).
+		Joins("JOIN users ON users.id = messages.receiver_id").
+		Joins("LEFT JOIN notification_preferences ON notification_preferences.user_id = messages.receiver_id").
+		Where("users.deleted_at IS NULL AND users.is_bot = FALSE").
+		Where("notification_preferences.email_digest_disabled IS NULL OR notification_preferences.email_digest_disabled = FALSE").
+		Where("NOT EXISTS (SELECT 1 FROM digests WHERE digests.user_id = messages.receiver_id AND digests.sent_at > ?)", now.Add(-j.Interval)).
+		Distinct("messages.receiver_id").
+		Pluck("messages.receiver_id", &userIDs).Error
+	if err != nil {
+		log.Println("digest: failed to find recipients:", err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		if err := j.digestUser(userID, now); err != nil {
+			log.Printf("digest: failed to send digest to %s: %v", userID, err)
+		}
+	}
+}
+
+// pending selects the messages that are due for a digest.
+func (j *Job) pending(now time.Time) *gorm.DB {
+	return j.DB.Model(&models.Message{}).
+		Where("messages.is_read = FALSE AND messages.is_deleted = FALSE").
+		Where("messages.sender_id <> messages.receiver_id").
+		Where("messages.created_at <= ?", now.Add(-j.UnreadAfter)).
+		Where("NOT EXISTS (SELECT 1 FROM digested_messages WHERE digested_messages.message_id = messages.id)")
+}
+
+func (j *Job) digestUser(userID uuid.UUID, now time.Time) error {
+	var user models.User
+	if err := j.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return err
+	}
+
+	pref, err := notify.Preference(j.DB, userID)
+	if err != nil {
+		return err
+	}
+	// Try again after the quiet hours
+	if pref.InQuietHours(now) {
+		return nil
+	}
+
+	var messages []models.Message
+	err = j.pending(now).
+		Preload("Mentions").
+		Where("messages.receiver_id = ?", userID).
+		Order("messages.created_at").
+		Limit(maxMessages).
+		Find(&messages).Error
+	if err != nil {
+		return err
+	}
+
+	var included []models.Message
+	for _, m := range messages {
+		mentioned := slices.ContainsFunc(m.Mentions, func(mention models.Mention) bool {
+			return mention.UserID == userID
+		})
+		allowed, err := notify.Allowed(j.DB, userID, m.SenderID, mentioned, now)
+		if err != nil {
+			return err
+		}
+		if allowed {
+			included = append(included, m)
+		}
+	}
+
+	var email *mail.Message
+	if len(included) > 0 {
+		email, err = j.render(&user, &pref, included)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The rows are committed before the email is sent, so no transaction is
+	// held open while talking to the mail server. Their primary keys stop
+	// another instance from digesting the same messages at the same time.
+	var digest *models.Digest
+	err = j.DB.Transaction(func(tx *gorm.DB) error {
+		var digestID *uuid.UUID
+		if email != nil {
+			digest = &models.Digest{
+				UserID:       userID,
+				MessageCount: len(included),
+				Status:       models.DigestPending,
+				SentAt:       now,
+			}
+			if err := tx.Create(digest).Error; err != nil {
+				return err
+			}
+			digestID = &digest.ID
+		}
+
+		rows := make([]models.DigestedMessage, 0, len(messages))
+		for _, m := range messages {
+			row := models.DigestedMessage{MessageID: m.ID, UserID: userID}
+			if slices.ContainsFunc(included, func(i models.Message) bool { return i.ID == m.ID }) {
+				row.DigestID = digestID
+			}
+			rows = append(rows, row)
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil || digest == nil {
+		return err
+	}
+
+	if err := j.Mailer.Send(email); err != nil {
+		// Give the messages back for the next run
+		if rerr := j.release(digest.ID); rerr != nil {
+			log.Printf("digest: failed to release digest %s: %v", digest.ID, rerr)
+		}
+		return err
+	}
+
+	return j.DB.Model(digest).Updates(map[string]any{
+		"status":  models.DigestSent,
+		"sent_at": time.Now(),
+	}).Error
+}
+
+// release deletes a pending digest and the records of its messages, so that
+// they are digested again.
+func (j *Job) release(digestID uuid.UUID) error {
+	return j.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ? AND status = ?", digestID, models.DigestPending).Delete(&models.Digest{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return tx.Where("digest_id = ?", digestID).Delete(&models.DigestedMessage{}).Error
+	})
+}
+
+// releaseStale releases the digests left pending by an instance that
+// stopped while sending them. Their email may have gone out, so this is
+// the one case where a message can be emailed twice.
+func (j *Job) releaseStale(now time.Time) error {
+	var ids []uuid.UUID
+	err := j.DB.Model(&models.Digest{}).
+		Where("status = ? AND sent_at < ?", models.DigestPending, now.Add(-pendingTimeout)).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := j.release(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// render builds the digest email, grouping the messages by conversation.
+// Conversations are listed by their oldest unread message.
+func (j *Job) render(user *models.User, pref *models.NotificationPreference, messages []models.Message) (*mail.Message, error) {
+	var senderIDs []uuid.UUID
+	bySender := map[uuid.UUID][]models.Message{}
+	for _, m := range messages {
+		if _, ok := bySender[m.SenderID]; !ok {
+			senderIDs = append(senderIDs, m.SenderID)
+		}
+		bySender[m.SenderID] = append(bySender[m.SenderID], m)
+	}
+
+	var senders []models.User
+	if err := j.DB.Where("id IN ?", senderIDs).Find(&senders).Error; err != nil {
+		return nil, err
+	}
+	names := map[uuid.UUID]string{}
+	for i := range senders {
+		names[senders[i].ID] = senders[i].DisplayName()
+	}
+
+	loc, err := time.LoadLocation(pref.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\n", user.Username)
+	fmt.Fprintf(&b, "You have %s waiting for you.\n", plural(len(messages), "unread message"))
+
+	for _, senderID := range senderIDs {
+		unread := bySender[senderID]
+		fmt.Fprintf(&b, "\n%s (%d unread)\n", names[senderID], len(unread))
+
+		shown := unread[max(0, len(unread)-maxPerConversation):]
+		for i := len(shown) - 1; i >= 0; i-- {
+			m := shown[i]
+			name := names[senderID]
+			if m.SenderName != "" {
+				name = m.SenderName
+			}
+			fmt.Fprintf(&b, "  [%s] %s\n", m.CreatedAt.In(loc).Format("Jan 2 15:04"), preview(&m, name))
+		}
+		if hidden := len(unread) - len(shown); hidden > 0 {
+			fmt.Fprintf(&b, "  ...and %s\n", plural(hidden, "earlier message"))
+		}
+	}
+
+	unsubscribe := j.unsubscribeURL(user.ID)
+	fmt.Fprintf(&b, "\n--\nYou get this email because messages sent to you went unread for %s.\n", j.UnreadAfter)
+	fmt.Fprintf(&b, "Stop these emails: %s\n", unsubscribe)
+
+	return &mail.Message{
+		To:      user.Email,
+		Subject: fmt.Sprintf("You have %s", plural(len(messages), "unread message")),
+		Text:    b.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}, nil
+}
+
+func (j *Job) unsubscribeURL(userID uuid.UUID) string {
+	return strings.TrimRight(j.BaseURL, "/") + "/digest/unsubscribe?token=" +
+		url.QueryEscape(UnsubscribeToken(j.Secret, userID))
+}
+
+func preview(m *models.Message, sender string) string {
+	content := strings.Join(strings.Fields(m.Content), " ")
+	if m.Format == models.FormatAction {
+		content = "* " + sender + " " + content
+	}
+
+	if utf8.RuneCountInString(content) > maxPreview {
+		content = string([]rune(content)[:maxPreview]) + "…"
+	}
+	return content
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,45 @@
+package digest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidUnsubscribeToken = errors.New("invalid unsubscribe token")
+
+// UnsubscribeToken returns the token in a user's unsubscribe link. It does
+// not expire, so links in old digests keep working.
+func UnsubscribeToken(secret string, userID uuid.UUID) string {
+	return userID.String() + "." + unsubscribeMAC(secret, userID)
+}
+
+// VerifyUnsubscribeToken returns the user an unsubscribe token was issued
+// for.
+func VerifyUnsubscribeToken(secret, token string) (uuid.UUID, error) {
+	id, mac, ok := strings.Cut(token, ".")
+	if !ok {
+		return uuid.Nil, ErrInvalidUnsubscribeToken
+	}
+
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, ErrInvalidUnsubscribeToken
+	}
+
+	if !hmac.Equal([]byte(mac), []byte(unsubscribeMAC(secret, userID))) {
+		return uuid.Nil, ErrInvalidUnsubscribeToken
+	}
+	return userID, nil
+}
+
+func unsubscribeMAC(secret string, userID uuid.UUID) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("digest-unsubscribe:"))
+	mac.Write([]byte(userID.String()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
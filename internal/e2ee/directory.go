@@ -0,0 +1,482 @@
+// Package e2ee keeps the public key directory for end-to-end encrypted
+// messages and relays their ciphertext. Keys and ciphertext are opaque to
+// the server: it checks their size and encoding, never their contents.
+package e2ee
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/events"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Devices a user may register
+	MaxDevices = 10
+
+	// One-time prekeys accepted per upload, and stored per device
+	MaxPreKeysPerUpload = 100
+	MaxPreKeys          = 500
+
+	// Decoded size limits of keys and signatures
+	maxKeyBytes       = 128
+	maxSignatureBytes = 128
+	maxNameLength     = 64
+)
+
+var (
+	ErrDeviceNotFound = errors.New("device not found")
+	ErrTooManyDevices = fmt.Errorf("at most %d devices can be registered", MaxDevices)
+	ErrTooManyPreKeys = fmt.Errorf("at most %d one-time prekeys can be stored per device", MaxPreKeys)
+	ErrNoDevices      = errors.New("user has no devices")
+)
+
+// InvalidError reports key material the server refused.
+type InvalidError struct {
+	Message string
+}
+
+func (e *InvalidError) Error() string {
+	return e.Message
+}
+
+// How a device's identity key changed, in IdentityKeyChanged events
+const (
+	IdentityAdded   = "added"
+	IdentityChanged = "changed"
+	IdentityRemoved = "removed"
+)
+
+// Keys are the public keys a device publishes. PreKeys may be empty.
+type Keys struct {
+	IdentityKey  string              `json:"identity_key"`
+	SignedPreKey models.SignedPreKey `json:"signed_prekey"`
+	PreKeys      []models.PreKey     `json:"prekeys"`
+}
+
+// Bundle is what a sender needs to start a session with one device. PreKey
+// is nil once the device ran out of one-time prekeys.
+type Bundle struct {
+	DeviceID     uuid.UUID           `json:"device_id"`
+	IdentityKey  string              `json:"identity_key"`
+	SignedPreKey models.SignedPreKey `json:"signed_prekey"`
+	PreKey       *models.PreKey      `json:"prekey"`
+}
+
+// Directory stores the devices of users and their public keys.
+type Directory struct {
+	DB *gorm.DB
+
+	// Told when identity keys change, so that clients can warn about
+	// changed safety numbers. May be nil.
+	Events events.Sink
+}
+
+// Devices lists the devices of the user, oldest first.
+func (d *Directory) Devices(userID uuid.UUID) ([]models.Device, error) {
+	var devices []models.Device
+	err := d.DB.Where("user_id = ?", userID).Order("created_at, id").Find(&devices).Error
+	return devices, err
+}
+
+// Device loads a device of the user.
+func (d *Directory) Device(userID, deviceID uuid.UUID) (*models.Device, error) {
+	var device models.Device
+	err := d.DB.Where("id = ? AND user_id = ?", deviceID, userID).Take(&device).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// PreKeyCounts returns how many one-time prekeys each of the devices has
+// left, so that clients know when to upload more.
+func (d *Directory) PreKeyCounts(deviceIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	var rows []struct {
+		DeviceID uuid.UUID
+		Count    int64
+	}
+	err := d.DB.Model(&models.PreKey{}).
+		Select("device_id, COUNT(*) AS count").
+		Where("device_id IN ?", deviceIDs).
+		Group("device_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uuid.UUID]int64, len(rows))
+	for _, r := range rows {
+		counts[r.DeviceID] = r.Count
+	}
+	return counts, nil
+}
+
+// Register adds a device for the user with the given keys.
+func (d *Directory) Register(userID uuid.UUID, name string, keys Keys) (*models.Device, error) {
+	if err := keys.validate(); err != nil {
+		return nil, err
+	}
+	if len(name) > maxNameLength {
+		return nil, &InvalidError{fmt.Sprintf("name must be at most %d characters", maxNameLength)}
+	}
+
+	device := &models.Device{
+		UserID:       userID,
+		Name:         name,
+		IdentityKey:  keys.IdentityKey,
+		SignedPreKey: keys.SignedPreKey,
+	}
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		// Serialises registrations of the user, so that two at once
+		// cannot both pass the limit
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Take(&models.User{}, "id = ?", userID).Error
+		if err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&models.Device{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= MaxDevices {
+			return ErrTooManyDevices
+		}
+
+		if err := tx.Create(device).Error; err != nil {
+			return err
+		}
+		return addPreKeys(tx, device.ID, keys.PreKeys)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.identityChanged(device, IdentityAdded)
+	return device, nil
+}
+
+// Replace swaps all the keys of a device, as when the app is reinstalled.
+// The one-time prekeys it had are dropped.
+func (d *Directory) Replace(userID, deviceID uuid.UUID, keys Keys) (*models.Device, error) {
+	if err := keys.validate(); err != nil {
+		return nil, err
+	}
+
+	var device *models.Device
+	var previous string
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		device, err = lockDevice(tx, userID, deviceID)
+		if err != nil {
+			return err
+		}
+		previous = device.IdentityKey
+
+		device.IdentityKey = keys.IdentityKey
+		device.SignedPreKey = keys.SignedPreKey
+		if err := tx.Save(device).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("device_id = ?", device.ID).Delete(&models.PreKey{}).Error; err != nil {
+			return err
+		}
+		return addPreKeys(tx, device.ID, keys.PreKeys)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if device.IdentityKey != previous {
+		d.identityChanged(device, IdentityChanged)
+	}
+	return device, nil
+}
+
+// SetSignedPreKey replaces the signed prekey of a device, which clients
+// rotate every so often.
+func (d *Directory) SetSignedPreKey(userID, deviceID uuid.UUID, key models.SignedPreKey) (*models.Device, error) {
+	if err := validateSignedPreKey(key); err != nil {
+		return nil, err
+	}
+
+	var device *models.Device
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		device, err = lockDevice(tx, userID, deviceID)
+		if err != nil {
+			return err
+		}
+		device.SignedPreKey = key
+		return tx.Save(device).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// AddPreKeys stores more one-time prekeys for a device, and returns how
+// many it has now. Keys whose ID the device already has are skipped.
+func (d *Directory) AddPreKeys(userID, deviceID uuid.UUID, preKeys []models.PreKey) (int64, error) {
+	if len(preKeys) == 0 {
+		return 0, &InvalidError{"prekeys must not be empty"}
+	}
+	if err := validatePreKeys(preKeys); err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockDevice(tx, userID, deviceID); err != nil {
+			return err
+		}
+		if err := addPreKeys(tx, deviceID, preKeys); err != nil {
+			return err
+		}
+
+		err := tx.Model(&models.PreKey{}).Where("device_id = ?", deviceID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > MaxPreKeys {
+			return ErrTooManyPreKeys
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Remove deletes a device with its prekeys and the envelopes it had not
+// acknowledged yet.
+func (d *Directory) Remove(userID, deviceID uuid.UUID) error {
+	var device *models.Device
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		device, err = lockDevice(tx, userID, deviceID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("device_id = ?", deviceID).Delete(&models.PreKey{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("recipient_device_id = ?", deviceID).Delete(&models.Envelope{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(device).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	d.identityChanged(device, IdentityRemoved)
+	return nil
+}
+
+// Bundles returns a bundle for each device of the user, or only for
+// deviceID when it is not nil. Every bundle claims one of its device's
+// one-time prekeys, which no other bundle will contain.
+func (d *Directory) Bundles(userID uuid.UUID, deviceID *uuid.UUID) ([]Bundle, error) {
+	var bundles []Bundle
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("user_id = ?", userID)
+		if deviceID != nil {
+			query = query.Where("id = ?", *deviceID)
+		}
+		var devices []models.Device
+		if err := query.Order("created_at, id").Find(&devices).Error; err != nil {
+			return err
+		}
+		if len(devices) == 0 {
+			if deviceID != nil {
+				return ErrDeviceNotFound
+			}
+			return ErrNoDevices
+		}
+
+		bundles = make([]Bundle, 0, len(devices))
+		for _, device := range devices {
+			preKey, err := claimPreKey(tx, device.ID)
+			if err != nil {
+				return err
+			}
+			bundles = append(bundles, Bundle{
+				DeviceID:     device.ID,
+				IdentityKey:  device.IdentityKey,
+				SignedPreKey: device.SignedPreKey,
+				PreKey:       preKey,
+			})
+		}
+		return nil
+	})
+	return bundles, err
+}
+
+// claimPreKey deletes and returns the oldest one-time prekey of the device,
+// or nil when it has none left. Bundles fetched at the same time skip the
+// prekeys the others are claiming.
+func claimPreKey(tx *gorm.DB, deviceID uuid.UUID) (*models.PreKey, error) {
+	for {
+		var preKey models.PreKey
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("device_id = ?", deviceID).
+			Order("key_id").
+			Limit(1).
+			Find(&preKey).Error
+		if err != nil {
+			return nil, err
+		}
+		if preKey.ID == uuid.Nil {
+			return nil, nil
+		}
+
+		res := tx.Delete(&preKey)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		// Without row locks another transaction may have claimed it
+		// first; take the next one then
+		if res.RowsAffected == 1 {
+			return &preKey, nil
+		}
+	}
+}
+
+// lockDevice loads a device of the user for update.
+func lockDevice(tx *gorm.DB, userID, deviceID uuid.UUID) (*models.Device, error) {
+	var device models.Device
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND user_id = ?", deviceID, userID).
+		Take(&device).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+func addPreKeys(tx *gorm.DB, deviceID uuid.UUID, preKeys []models.PreKey) error {
+	if len(preKeys) == 0 {
+		return nil
+	}
+
+	rows := make([]models.PreKey, len(preKeys))
+	for i, k := range preKeys {
+		rows[i] = models.PreKey{
+			DeviceID:  deviceID,
+			KeyID:     k.KeyID,
+			PublicKey: k.PublicKey,
+		}
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "device_id"}, {Name: "key_id"}},
+		DoNothing: true,
+	}).Create(&rows).Error
+}
+
+// identityChanged tells the owner of the device, and everyone they talk
+// to, that the safety numbers they share with the owner changed.
+func (d *Directory) identityChanged(device *models.Device, change string) {
+	if d.Events == nil {
+		return
+	}
+
+	userIDs, err := d.contacts(device.UserID)
+	if err != nil {
+		log.Println("e2ee: failed to load contacts:", err)
+		userIDs = nil
+	}
+	userIDs = append(userIDs, device.UserID)
+
+	data := map[string]any{
+		"user_id":   device.UserID,
+		"device_id": device.ID,
+		"change":    change,
+	}
+	if change != IdentityRemoved {
+		data["identity_key"] = device.IdentityKey
+	}
+	d.Events.Emit(events.IdentityKeyChanged, userIDs, data)
+}
+
+// contacts lists the users the user has a conversation with, or exchanged
+// encrypted messages with.
+func (d *Directory) contacts(userID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := d.DB.Raw(`
+		SELECT other_id FROM conversation_summaries WHERE user_id = ?
+		UNION SELECT other_id FROM encrypted_contacts WHERE user_id = ?`,
+		userID, userID).
+		Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// A user's notes to self are not a contact
+	contacts := ids[:0]
+	for _, id := range ids {
+		if id != userID {
+			contacts = append(contacts, id)
+		}
+	}
+	return contacts, nil
+}
+
+func (k Keys) validate() error {
+	if err := validateKey("identity_key", k.IdentityKey, maxKeyBytes); err != nil {
+		return err
+	}
+	if err := validateSignedPreKey(k.SignedPreKey); err != nil {
+		return err
+	}
+	return validatePreKeys(k.PreKeys)
+}
+
+func validateSignedPreKey(k models.SignedPreKey) error {
+	if err := validateKey("signed_prekey.public_key", k.PublicKey, maxKeyBytes); err != nil {
+		return err
+	}
+	return validateKey("signed_prekey.signature", k.Signature, maxSignatureBytes)
+}
+
+func validatePreKeys(preKeys []models.PreKey) error {
+	if len(preKeys) > MaxPreKeysPerUpload {
+		return &InvalidError{fmt.Sprintf("at most %d prekeys can be uploaded at once", MaxPreKeysPerUpload)}
+	}
+	seen := make(map[int64]bool, len(preKeys))
+	for _, k := range preKeys {
+		if seen[k.KeyID] {
+			return &InvalidError{fmt.Sprintf("prekey %d is given twice", k.KeyID)}
+		}
+		seen[k.KeyID] = true
+		if err := validateKey("prekeys.public_key", k.PublicKey, maxKeyBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateKey checks that value is standard base64 of at most max bytes.
+func validateKey(field, value string, max int) error {
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return &InvalidError{field + " must be non-empty base64"}
+	}
+	if len(raw) > max {
+		return &InvalidError{fmt.Sprintf("%s must be at most %d bytes", field, max)}
+	}
+	return nil
+}
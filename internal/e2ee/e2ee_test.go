@@ -0,0 +1,339 @@
+package e2ee_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/db"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/e2ee"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// eachDatabase runs test against a migrated SQLite file, and against the
+// Postgres database in TEST_DATABASE_URL with its tables emptied when the
+// variable is set.
+func eachDatabase(t *testing.T, test func(t *testing.T, d *e2ee.Directory)) {
+	t.Run("sqlite", func(t *testing.T) {
+		conn, err := db.Open("sqlite:" + filepath.Join(t.TempDir(), "chat.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { closeDB(conn) })
+		migrate(t, conn)
+		test(t, &e2ee.Directory{DB: conn})
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("TEST_DATABASE_URL")
+		if dsn == "" {
+			t.Skip("TEST_DATABASE_URL is not set")
+		}
+		conn, err := db.Open(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { closeDB(conn) })
+		migrate(t, conn)
+		truncate(t, conn)
+		test(t, &e2ee.Directory{DB: conn})
+	})
+}
+
+func migrate(t *testing.T, conn *gorm.DB) {
+	t.Helper()
+	migrator, err := db.NewMigrator(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// truncate empties every table but schema_migrations.
+func truncate(t *testing.T, conn *gorm.DB) {
+	t.Helper()
+	var tables []string
+	err := conn.Raw(`SELECT tablename FROM pg_tables
+		WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'`).Scan(&tables).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range tables {
+		tables[i] = conn.Statement.Quote(name)
+	}
+	if err := conn.Exec("TRUNCATE " + strings.Join(tables, ", ") + " CASCADE").Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func closeDB(conn *gorm.DB) {
+	if sqlDB, err := conn.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+func key(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func newUser(t *testing.T, d *e2ee.Directory, name string) uuid.UUID {
+	t.Helper()
+	u := models.User{Username: name, Email: name + "@example.com", PasswordHash: "x"}
+	if err := d.DB.Create(&u).Error; err != nil {
+		t.Fatal(err)
+	}
+	return u.ID
+}
+
+// newDevice registers a device with preKeys one-time prekeys, numbered
+// from 1.
+func newDevice(t *testing.T, d *e2ee.Directory, userID uuid.UUID, name string, preKeys int) uuid.UUID {
+	t.Helper()
+	keys := e2ee.Keys{
+		IdentityKey:  key(name + " identity"),
+		SignedPreKey: models.SignedPreKey{KeyID: 1, PublicKey: key(name + " signed"), Signature: key(name + " signature")},
+	}
+	for i := 1; i <= preKeys; i++ {
+		keys.PreKeys = append(keys.PreKeys, models.PreKey{KeyID: int64(i), PublicKey: key(name + " prekey")})
+	}
+	device, err := d.Register(userID, name, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return device.ID
+}
+
+func outgoing(deviceIDs ...uuid.UUID) []e2ee.Outgoing {
+	list := make([]e2ee.Outgoing, len(deviceIDs))
+	for i, id := range deviceIDs {
+		list[i] = e2ee.Outgoing{DeviceID: id, CiphertextType: 1, Ciphertext: key("for " + id.String())}
+	}
+	return list
+}
+
+func sorted(ids ...uuid.UUID) []uuid.UUID {
+	return slices.SortedFunc(slices.Values(ids), func(a, b uuid.UUID) int {
+		return slices.Compare(a[:], b[:])
+	})
+}
+
+func envelopeCount(t *testing.T, d *e2ee.Directory) int64 {
+	t.Helper()
+	var n int64
+	if err := d.DB.Model(&models.Envelope{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// conversation is alice, sending from her phone, and bob, with two devices
+// each.
+type conversation struct {
+	alice, bob          uuid.UUID
+	phone, laptop       uuid.UUID
+	bobPhone, bobLaptop uuid.UUID
+}
+
+func newConversation(t *testing.T, d *e2ee.Directory) conversation {
+	t.Helper()
+	var c conversation
+	c.alice, c.bob = newUser(t, d, "alice"), newUser(t, d, "bob")
+	c.phone = newDevice(t, d, c.alice, "alice phone", 0)
+	c.laptop = newDevice(t, d, c.alice, "alice laptop", 0)
+	c.bobPhone = newDevice(t, d, c.bob, "bob phone", 0)
+	c.bobLaptop = newDevice(t, d, c.bob, "bob laptop", 0)
+	return c
+}
+
+func TestRelay(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, d *e2ee.Directory) {
+		c := newConversation(t, d)
+
+		envelopes, err := d.Relay(c.alice, c.phone, c.bob, outgoing(c.bobPhone, c.bobLaptop, c.laptop))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(envelopes) != 3 {
+			t.Fatalf("stored %d envelopes, want 3", len(envelopes))
+		}
+		for _, e := range envelopes {
+			want := c.bob
+			if e.RecipientDeviceID == c.laptop {
+				want = c.alice
+			}
+			if e.RecipientID != want || e.SenderID != c.alice || e.SenderDeviceID != c.phone {
+				t.Fatalf("envelope = %+v", e)
+			}
+		}
+
+		pending, err := d.Pending(c.bob, c.bobLaptop, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 1 || pending[0].Ciphertext != key("for "+c.bobLaptop.String()) {
+			t.Fatalf("pending = %+v", pending)
+		}
+		if ok, err := d.Ack(c.bob, c.bobLaptop, pending[0].ID); err != nil || !ok {
+			t.Fatalf("ack = %v, %v", ok, err)
+		}
+		if ok, _ := d.Ack(c.bob, c.bobLaptop, pending[0].ID); ok {
+			t.Fatal("envelope acknowledged twice")
+		}
+	})
+}
+
+func TestRelayMissingDevice(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, d *e2ee.Directory) {
+		c := newConversation(t, d)
+
+		// Neither bob's laptop nor alice's own laptop
+		_, err := d.Relay(c.alice, c.phone, c.bob, outgoing(c.bobPhone))
+		var mismatch *e2ee.MismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("err = %v, want a mismatch", err)
+		}
+		if want := sorted(c.laptop, c.bobLaptop); !slices.Equal(mismatch.Missing, want) || len(mismatch.Extra) != 0 {
+			t.Fatalf("mismatch = %+v, want missing %v", mismatch, want)
+		}
+		if n := envelopeCount(t, d); n != 0 {
+			t.Fatalf("stored %d envelopes", n)
+		}
+	})
+}
+
+func TestRelayExtraDevice(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, d *e2ee.Directory) {
+		c := newConversation(t, d)
+		removed := newDevice(t, d, c.bob, "bob tablet", 0)
+		if err := d.Remove(c.bob, removed); err != nil {
+			t.Fatal(err)
+		}
+		stranger := newDevice(t, d, newUser(t, d, "carol"), "carol phone", 0)
+
+		// A removed device, someone else's, the sending device itself and
+		// a device given twice
+		list := outgoing(c.bobPhone, c.bobLaptop, c.laptop, removed, stranger, c.phone, c.bobPhone)
+		_, err := d.Relay(c.alice, c.phone, c.bob, list)
+		var mismatch *e2ee.MismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("err = %v, want a mismatch", err)
+		}
+		if want := sorted(removed, stranger, c.phone, c.bobPhone); !slices.Equal(mismatch.Extra, want) || len(mismatch.Missing) != 0 {
+			t.Fatalf("mismatch = %+v, want extra %v", mismatch, want)
+		}
+		if n := envelopeCount(t, d); n != 0 {
+			t.Fatalf("stored %d envelopes", n)
+		}
+	})
+}
+
+func TestRelayUnknownSender(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, d *e2ee.Directory) {
+		c := newConversation(t, d)
+
+		// Bob's device is not alice's to send from
+		_, err := d.Relay(c.alice, c.bobPhone, c.bob, outgoing(c.bobPhone, c.bobLaptop, c.phone, c.laptop))
+		if !errors.Is(err, e2ee.ErrDeviceNotFound) {
+			t.Fatalf("err = %v, want ErrDeviceNotFound", err)
+		}
+
+		carol := newUser(t, d, "carol")
+		_, err = d.Relay(c.alice, c.phone, carol, outgoing(c.laptop))
+		if !errors.Is(err, e2ee.ErrNoDevices) {
+			t.Fatalf("err = %v, want ErrNoDevices", err)
+		}
+	})
+}
+
+func TestPreKeysClaimedOnce(t *testing.T) {
+	eachDatabase(t, func(t *testing.T, d *e2ee.Directory) {
+		bob := newUser(t, d, "bob")
+		phone := newDevice(t, d, bob, "bob phone", 2)
+		laptop := newDevice(t, d, bob, "bob laptop", 1)
+
+		var claimed []int64
+		for range 2 {
+			bundles, err := d.Bundles(bob, &phone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bundles) != 1 || bundles[0].PreKey == nil {
+				t.Fatalf("bundles = %+v", bundles)
+			}
+			claimed = append(claimed, bundles[0].PreKey.KeyID)
+		}
+		if !slices.Equal(claimed, []int64{1, 2}) {
+			t.Fatalf("claimed prekeys %v, want 1 then 2", claimed)
+		}
+
+		// Out of prekeys, the phone's bundle has none, and the laptop's
+		// still has its own
+		bundles, err := d.Bundles(bob, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bundles) != 2 || bundles[0].DeviceID != phone || bundles[0].PreKey != nil ||
+			bundles[1].DeviceID != laptop || bundles[1].PreKey == nil {
+			t.Fatalf("bundles = %+v", bundles)
+		}
+
+		counts, err := d.PreKeyCounts([]uuid.UUID{phone, laptop})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts[phone] != 0 || counts[laptop] != 0 {
+			t.Fatalf("prekeys left = %v", counts)
+		}
+	})
+}
+
+func TestPreKeysClaimedOnceConcurrently(t *testing.T) {
+	const preKeys, fetches = 20, 30
+
+	eachDatabase(t, func(t *testing.T, d *e2ee.Directory) {
+		bob := newUser(t, d, "bob")
+		phone := newDevice(t, d, bob, "bob phone", preKeys)
+
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			claimed []int64
+			failed  []error
+		)
+		for range fetches {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				bundles, err := d.Bundles(bob, &phone)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					failed = append(failed, err)
+					return
+				}
+				if bundles[0].PreKey != nil {
+					claimed = append(claimed, bundles[0].PreKey.KeyID)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(failed) > 0 {
+			t.Fatal(errors.Join(failed...))
+		}
+		slices.Sort(claimed)
+		if len(claimed) != preKeys || len(slices.Compact(claimed)) != preKeys {
+			t.Fatalf("%d fetches claimed prekeys %v, want each of %d once", fetches, claimed, preKeys)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package e2ee
+
+import (
+	"encoding/base64"
+	"fmt"
+	"slices"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Decoded size limit of the ciphertext for one device
+const MaxCiphertextBytes = 64 << 10
+
+// Outgoing is the ciphertext a sender encrypted for one device.
+type Outgoing struct {
+	DeviceID       uuid.UUID `json:"device_id"`
+	CiphertextType int       `json:"ciphertext_type"`
+	Ciphertext     string    `json:"ciphertext"`
+}
+
+// MismatchError reports that a message was not encrypted for exactly the
+// devices it must reach. The sender fetches bundles for the missing devices,
+// forgets the extra ones, and sends again.
+type MismatchError struct {
+	Missing []uuid.UUID `json:"missing"`
+	Extra   []uuid.UUID `json:"extra"`
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("message is missing %d devices and has %d extra", len(e.Missing), len(e.Extra))
+}
+
+// Relay stores an envelope for every device of the recipient, and for the
+// sender's other devices so that they see what was sent. The ciphertext
+// must cover exactly those devices, or a *MismatchError is returned and
+// nothing is stored.
+func (d *Directory) Relay(senderID, senderDeviceID, recipientID uuid.UUID, outgoing []Outgoing) ([]models.Envelope, error) {
+	for _, o := range outgoing {
+		raw, err := base64.StdEncoding.DecodeString(o.Ciphertext)
+		if err != nil || len(raw) == 0 {
+			return nil, &InvalidError{"ciphertext must be non-empty base64"}
+		}
+		if len(raw) > MaxCiphertextBytes {
+			return nil, &InvalidError{fmt.Sprintf("ciphertext must be at most %d bytes", MaxCiphertextBytes)}
+		}
+	}
+
+	var envelopes []models.Envelope
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		var devices []models.Device
+		err := tx.Select("id", "user_id").
+			Where("user_id IN ?", []uuid.UUID{senderID, recipientID}).
+			Find(&devices).Error
+		if err != nil {
+			return err
+		}
+
+		// The sending device may have been removed since it connected
+		owners := map[uuid.UUID]uuid.UUID{}
+		senderKnown, recipientDevices := false, 0
+		for _, device := range devices {
+			if device.UserID == recipientID {
+				recipientDevices++
+			}
+			if device.ID == senderDeviceID && device.UserID == senderID {
+				senderKnown = true
+				continue
+			}
+			owners[device.ID] = device.UserID
+		}
+		if !senderKnown {
+			return ErrDeviceNotFound
+		}
+		if recipientDevices == 0 {
+			return ErrNoDevices
+		}
+		if err := checkDevices(owners, outgoing); err != nil {
+			return err
+		}
+
+		envelopes = make([]models.Envelope, len(outgoing))
+		for i, o := range outgoing {
+			envelopes[i] = models.Envelope{
+				SenderID:          senderID,
+				SenderDeviceID:    senderDeviceID,
+				RecipientID:       owners[o.DeviceID],
+				RecipientDeviceID: o.DeviceID,
+				CiphertextType:    o.CiphertextType,
+				Ciphertext:        o.Ciphertext,
+			}
+		}
+		if err := tx.Create(&envelopes).Error; err != nil {
+			return err
+		}
+
+		if senderID == recipientID {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create([]models.EncryptedContact{
+			{UserID: senderID, OtherID: recipientID},
+			{UserID: recipientID, OtherID: senderID},
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return envelopes, nil
+}
+
+// checkDevices compares the devices the ciphertext is for with owners, the
+// devices it must be for.
+func checkDevices(owners map[uuid.UUID]uuid.UUID, outgoing []Outgoing) error {
+	mismatch := &MismatchError{}
+	covered := make(map[uuid.UUID]bool, len(outgoing))
+	for _, o := range outgoing {
+		if _, ok := owners[o.DeviceID]; !ok || covered[o.DeviceID] {
+			mismatch.Extra = append(mismatch.Extra, o.DeviceID)
+		}
+		covered[o.DeviceID] = true
+	}
+	for id := range owners {
+		if !covered[id] {
+			mismatch.Missing = append(mismatch.Missing, id)
+		}
+	}
+
+	if len(mismatch.Missing) == 0 && len(mismatch.Extra) == 0 {
+		return nil
+	}
+	slices.SortFunc(mismatch.Missing, compareUUIDs)
+	slices.SortFunc(mismatch.Extra, compareUUIDs)
+	return mismatch
+}
+
+func compareUUIDs(a, b uuid.UUID) int {
+	return slices.Compare(a[:], b[:])
+}
+
+// Pending lists the envelopes a device has not acknowledged, oldest first.
+func (d *Directory) Pending(userID, deviceID uuid.UUID, limit int) ([]models.Envelope, error) {
+	var envelopes []models.Envelope
+	err := d.DB.
+		Where("recipient_device_id = ? AND recipient_id = ?", deviceID, userID).
+		Order("created_at, id").
+		Limit(limit).
+		Find(&envelopes).Error
+	return envelopes, err
+}
+
+// Ack deletes an envelope once its device has decrypted it, and reports
+// whether the device had it.
+func (d *Directory) Ack(userID, deviceID, envelopeID uuid.UUID) (bool, error) {
+	res := d.DB.
+		Where("id = ? AND recipient_device_id = ? AND recipient_id = ?", envelopeID, deviceID, userID).
+		Delete(&models.Envelope{})
+	return res.RowsAffected > 0, res.Error
+}
@@ -0,0 +1,354 @@
+package encryption_test
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/db"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/encryption"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// fixture is a migrated SQLite database with two users, reached through
+// connections that each have their own master keys.
+type fixture struct {
+	t    *testing.T
+	path string
+
+	alice, bob uuid.UUID
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := &fixture{t: t, path: filepath.Join(t.TempDir(), "chat.db")}
+
+	conn := f.open("")
+	m, err := db.NewMigrator(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"alice", "bob"} {
+		u := models.User{Username: name, Email: name + "@example.com", PasswordHash: "x"}
+		if err := conn.Create(&u).Error; err != nil {
+			t.Fatal(err)
+		}
+		if name == "alice" {
+			f.alice = u.ID
+		} else {
+			f.bob = u.ID
+		}
+	}
+	return f
+}
+
+// open connects with the master keys, given as ENCRYPTION_MASTER_KEYS is;
+// with none, the plugin has no KMS.
+func (f *fixture) open(masterKeys string) *gorm.DB {
+	f.t.Helper()
+	conn, err := db.Open("sqlite:" + f.path)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	var kms encryption.KMS
+	if masterKeys != "" {
+		local, err := encryption.ParseMasterKeys(masterKeys)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		kms = local
+	}
+	if err := conn.Use(encryption.New(kms)); err != nil {
+		f.t.Fatal(err)
+	}
+	return conn
+}
+
+func (f *fixture) send(conn *gorm.DB, content string) *models.Message {
+	f.t.Helper()
+	m := &models.Message{SenderID: f.alice, ReceiverID: f.bob, Content: content}
+	if err := conn.Create(m).Error; err != nil {
+		f.t.Fatal(err)
+	}
+	return m
+}
+
+// stored reads a message as it is in the database.
+func (f *fixture) stored(conn *gorm.DB, id uuid.UUID) models.Message {
+	f.t.Helper()
+	var m models.Message
+	if err := encryption.Raw(conn).Take(&m, "id = ?", id).Error; err != nil {
+		f.t.Fatal(err)
+	}
+	return m
+}
+
+func (f *fixture) status(conn *gorm.DB) *encryption.Status {
+	f.t.Helper()
+	status, err := encryption.GetStatus(conn)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return status
+}
+
+func masterKey(t *testing.T, id string) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+func TestSealOnCreateAndUpdate(t *testing.T) {
+	f := newFixture(t)
+	conn := f.open(masterKey(t, "k1"))
+
+	m := f.send(conn, "hello bob")
+	if m.Content != "hello bob" || m.KeyID != nil {
+		t.Fatalf("caller's message changed to %q, key %v", m.Content, m.KeyID)
+	}
+	stored := f.stored(conn, m.ID)
+	if stored.KeyID == nil || stored.Content == "hello bob" {
+		t.Fatalf("created content stored as %q, key %v", stored.Content, stored.KeyID)
+	}
+	created := stored.Content
+
+	// An update by map names the message in the model
+	err := conn.Model(&models.Message{ID: m.ID, SenderID: m.SenderID, ReceiverID: m.ReceiverID}).
+		Updates(map[string]any{"content": "hello again"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored = f.stored(conn, m.ID)
+	if stored.KeyID == nil || stored.Content == "hello again" || stored.Content == created {
+		t.Fatalf("updated content stored as %q, key %v", stored.Content, stored.KeyID)
+	}
+
+	// An update without them fails rather than storing plaintext
+	err = conn.Model(&models.Message{ID: m.ID}).Updates(map[string]any{"content": "leak"}).Error
+	if err == nil {
+		t.Fatal("update without sender and receiver succeeded")
+	}
+
+	// Saving the whole message seals it too
+	var loaded models.Message
+	if err := conn.Take(&loaded, "id = ?", m.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	loaded.Content = "saved"
+	if err := conn.Save(&loaded).Error; err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Content != "saved" || loaded.KeyID != nil {
+		t.Fatalf("caller's message changed to %q, key %v", loaded.Content, loaded.KeyID)
+	}
+	if stored := f.stored(conn, m.ID); stored.KeyID == nil || stored.Content == "saved" {
+		t.Fatalf("saved content stored as %q, key %v", stored.Content, stored.KeyID)
+	}
+
+	// Both directions of the conversation share its key
+	reply := &models.Message{SenderID: f.bob, ReceiverID: f.alice, Content: "hi alice"}
+	if err := conn.Create(reply).Error; err != nil {
+		t.Fatal(err)
+	}
+	if a, b := f.stored(conn, m.ID).KeyID, f.stored(conn, reply.ID).KeyID; *a != *b {
+		t.Fatalf("replies use key %s, messages %s", *b, *a)
+	}
+}
+
+func TestDecryptOnQuery(t *testing.T) {
+	f := newFixture(t)
+	conn := f.open(masterKey(t, "k1"))
+	first := f.send(conn, "one")
+	f.send(conn, "two")
+
+	var messages []models.Message
+	if err := conn.Order("created_at, id").Find(&messages).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].Content != "one" || messages[1].Content != "two" {
+		t.Fatalf("messages = %+v", messages)
+	}
+	for _, m := range messages {
+		if m.KeyID != nil {
+			t.Fatalf("decrypted message %s still has key %s", m.ID, *m.KeyID)
+		}
+	}
+
+	var m models.Message
+	if err := conn.First(&m, "id = ?", first.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if m.Content != "one" {
+		t.Fatalf("content = %q", m.Content)
+	}
+
+	// A connection without master keys refuses encrypted content instead
+	// of serving ciphertext
+	var none []models.Message
+	err := f.open("").Find(&none).Error
+	if !errors.Is(err, encryption.ErrNoMasterKey) {
+		t.Fatalf("err = %v, want ErrNoMasterKey", err)
+	}
+}
+
+func TestScanRowsAndDecrypt(t *testing.T) {
+	f := newFixture(t)
+	keys := masterKey(t, "k1")
+	f.send(f.open(keys), "scanned")
+
+	// A fresh connection has no keys cached; they are loaded before the
+	// rows hold the connection
+	conn := f.open(keys)
+	query := conn.Model(&models.Message{}).Where("sender_id = ?", f.alice)
+	if err := encryption.LoadKeys(query); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var m models.Message
+		if err := conn.ScanRows(rows, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.KeyID == nil {
+			t.Fatal("scanned row is not encrypted")
+		}
+		if err := encryption.Decrypt(conn, &m); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, m.Content)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "scanned" {
+		t.Fatalf("contents = %q", got)
+	}
+}
+
+func TestRotateAndReencrypt(t *testing.T) {
+	f := newFixture(t)
+	oldKey := masterKey(t, "k1")
+	conn := f.open(oldKey)
+	m := f.send(conn, "rotate me")
+	before := *f.stored(conn, m.ID).KeyID
+
+	retired, err := encryption.Rotate(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retired != 1 {
+		t.Fatalf("retired %d keys, want 1", retired)
+	}
+	if status := f.status(conn); status.Retired != 1 || status.Plaintext != 0 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	r := &encryption.Reencryptor{DB: conn}
+	rewrapped, messages, err := r.Pass()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped != 0 || messages != 1 {
+		t.Fatalf("pass rewrapped %d keys and re-encrypted %d messages", rewrapped, messages)
+	}
+	after := *f.stored(conn, m.ID).KeyID
+	if after == before {
+		t.Fatal("message is still under the retired key")
+	}
+	if status := f.status(conn); status.Retired != 0 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	// A new master key in front rewraps every data key, retired ones too
+	newKey := masterKey(t, "k2")
+	rotated := f.open(newKey + "," + oldKey)
+	rewrapped, messages, err = (&encryption.Reencryptor{DB: rotated}).Pass()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped != 2 || messages != 0 {
+		t.Fatalf("pass rewrapped %d keys and re-encrypted %d messages", rewrapped, messages)
+	}
+	if status := f.status(rotated); status.MasterKeys["k2"] != 2 || status.MasterKeys["k1"] != 0 {
+		t.Fatalf("master keys = %v", status.MasterKeys)
+	}
+
+	// The old master key can go now
+	withoutOld := f.open(newKey)
+	var loaded models.Message
+	if err := withoutOld.Take(&loaded, "id = ?", m.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Content != "rotate me" {
+		t.Fatalf("content = %q", loaded.Content)
+	}
+}
+
+func TestLegacyPlaintext(t *testing.T) {
+	f := newFixture(t)
+
+	// Written before encryption was turned on
+	legacy := f.send(f.open(""), "from before")
+	conn := f.open(masterKey(t, "k1"))
+	if stored := f.stored(conn, legacy.ID); stored.KeyID != nil || stored.Content != "from before" {
+		t.Fatalf("legacy content stored as %q, key %v", stored.Content, stored.KeyID)
+	}
+
+	var loaded models.Message
+	if err := conn.Take(&loaded, "id = ?", legacy.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Content != "from before" {
+		t.Fatalf("content = %q", loaded.Content)
+	}
+	if status := f.status(conn); status.Plaintext != 1 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	r := &encryption.Reencryptor{DB: conn}
+	if _, messages, err := r.Pass(); err != nil || messages != 1 {
+		t.Fatalf("pass re-encrypted %d messages: %v", messages, err)
+	}
+	if stored := f.stored(conn, legacy.ID); stored.KeyID == nil || stored.Content == "from before" {
+		t.Fatalf("content stored as %q, key %v after the pass", stored.Content, stored.KeyID)
+	}
+
+	// Turning encryption off again
+	decrypted, err := r.DecryptAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != 1 {
+		t.Fatalf("decrypted %d messages, want 1", decrypted)
+	}
+	var plain []models.Message
+	if err := f.open("").Find(&plain).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(plain) != 1 || plain[0].Content != "from before" {
+		t.Fatalf("messages = %+v", plain)
+	}
+}
@@ -0,0 +1,105 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KMS holds the master keys that data keys are wrapped with. The master
+// keys themselves never leave it, so a key management service can stand
+// behind it.
+type KMS interface {
+	// CurrentKeyID names the master key Wrap uses.
+	CurrentKeyID() string
+
+	// Wrap encrypts a data key with the current master key.
+	Wrap(key []byte) ([]byte, error)
+
+	// Unwrap decrypts a data key that the master key keyID wrapped.
+	Unwrap(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKMS is a KMS whose master keys come from the configuration.
+type LocalKMS struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// ParseMasterKeys reads master keys written as ID:KEY pairs separated by
+// commas, each KEY being 32 random bytes in base64. The first key wraps new
+// data keys; the others only unwrap those they wrapped before it took over.
+func ParseMasterKeys(s string) (*LocalKMS, error) {
+	kms := &LocalKMS{keys: map[string]cipher.AEAD{}}
+	for _, entry := range strings.Split(s, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("master key %q is not written as ID:KEY", entry)
+		}
+		if _, dup := kms.keys[id]; dup {
+			return nil, fmt.Errorf("master key %s is given twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("master key %s must be %d bytes in base64", id, keySize)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		kms.keys[id] = aead
+		if kms.current == "" {
+			kms.current = id
+		}
+	}
+	return kms, nil
+}
+
+func (k *LocalKMS) CurrentKeyID() string {
+	return k.current
+}
+
+func (k *LocalKMS) Wrap(key []byte) ([]byte, error) {
+	return seal(k.keys[k.current], key, []byte(k.current))
+}
+
+func (k *LocalKMS) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %s is not configured", keyID)
+	}
+	return open(aead, wrapped, []byte(keyID))
+}
+
+// keySize is the size of master and data keys, for AES-256.
+const keySize = 32
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which it puts in front of
+// the ciphertext.
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
@@ -0,0 +1,471 @@
+// Package encryption encrypts message content at rest. Each conversation
+// has a data key, stored in conversation_keys wrapped by a master key from a
+// KMS, and content is encrypted with it by AES-256-GCM, bound to the ID of
+// its message. A message's key_id names the key its content is encrypted
+// with, or is NULL while the content is plaintext, as it was before
+// encryption was turned on.
+//
+// A GORM plugin does the work, so code reading and writing messages through
+// GORM only ever sees plaintext: content is encrypted when messages are
+// created or their content updated, and decrypted when they are queried.
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	pluginName = "encryption"
+
+	// Unwrapped data keys kept in memory; the cache starts over when full
+	maxCachedKeys = 10000
+
+	// Key of the instance setting holding the messages whose content was
+	// replaced with ciphertext for the statement
+	sealedSetting = "encryption:sealed"
+)
+
+// ErrNoMasterKey means a message is encrypted but no master key is
+// configured to decrypt it with.
+var ErrNoMasterKey = errors.New("encryption: message content is encrypted but no master key is configured")
+
+var messageType = reflect.TypeOf(models.Message{})
+
+// Plugin encrypts and decrypts message content for a database it is
+// registered with, by db.Use(encryption.New(kms)).
+type Plugin struct {
+	kms KMS
+
+	mu   sync.Mutex
+	keys map[uuid.UUID]cipher.AEAD
+}
+
+// New returns the plugin for master keys held by kms. Without a KMS, new
+// content is stored as plaintext and reading encrypted content fails with
+// ErrNoMasterKey rather than returning ciphertext.
+func New(kms KMS) *Plugin {
+	return &Plugin{kms: kms, keys: map[uuid.UUID]cipher.AEAD{}}
+}
+
+func (p *Plugin) Name() string {
+	return pluginName
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	create := db.Callback().Create()
+	if err := create.Before("gorm:create").After("app:assign_uuids").Register("encryption:seal", p.sealRecords); err != nil {
+		return err
+	}
+	if err := create.After("gorm:create").Register("encryption:unseal", unsealRecords); err != nil {
+		return err
+	}
+
+	update := db.Callback().Update()
+	if err := update.Before("gorm:update").Register("encryption:seal", p.sealUpdate); err != nil {
+		return err
+	}
+	if err := update.After("gorm:update").Register("encryption:unseal", unsealRecords); err != nil {
+		return err
+	}
+
+	return db.Callback().Query().After("gorm:query").Register("encryption:decrypt", p.decryptResults)
+}
+
+// Enabled reports whether new message content written through db is
+// encrypted.
+func Enabled(db *gorm.DB) bool {
+	p := plugin(db)
+	return p != nil && p.kms != nil
+}
+
+// Raw returns a session that reads and writes message content as stored,
+// for code that moves ciphertext around as it is, such as archival.
+func Raw(db *gorm.DB) *gorm.DB {
+	return db.WithContext(context.WithValue(db.Statement.Context, rawKey{}, true))
+}
+
+type rawKey struct{}
+
+func isRaw(db *gorm.DB) bool {
+	raw, _ := db.Statement.Context.Value(rawKey{}).(bool)
+	return raw
+}
+
+// LoadKeys unwraps the keys of the messages query selects ahead of time,
+// for code that decrypts them while it reads rows and its connection is
+// busy, as an export does.
+func LoadKeys(query *gorm.DB) error {
+	p := plugin(query)
+	if p == nil || p.kms == nil {
+		return nil
+	}
+
+	var keys []models.ConversationKey
+	err := query.Session(&gorm.Session{NewDB: true}).
+		Where("id IN (?)", query.Session(&gorm.Session{}).Select("key_id")).
+		Find(&keys).Error
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		if _, err := p.unwrap(&keys[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decrypt decrypts the content of messages loaded without the query
+// callbacks, as by ScanRows.
+func Decrypt(db *gorm.DB, messages ...*models.Message) error {
+	p := plugin(db)
+	for _, m := range messages {
+		if m.KeyID == nil {
+			continue
+		}
+		if p == nil {
+			return ErrNoMasterKey
+		}
+		if err := p.decrypt(db, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func plugin(db *gorm.DB) *Plugin {
+	p, _ := db.Config.Plugins[pluginName].(*Plugin)
+	return p
+}
+
+// sealed is a message whose content was replaced with ciphertext for the
+// statement, and the plaintext to put back afterwards.
+type sealed struct {
+	message *models.Message
+	content string
+}
+
+// sealRecords encrypts the content of the messages being created or saved.
+// Messages that already have a key, such as those restored from an
+// archive, are stored as they are.
+func (p *Plugin) sealRecords(db *gorm.DB) {
+	if db.Error != nil || p.kms == nil || isRaw(db) || db.Statement.Schema == nil || db.Statement.Schema.ModelType != messageType {
+		return
+	}
+
+	var done []sealed
+	walk(db.Statement.ReflectValue, func(m *models.Message) {
+		if db.Error != nil || m.KeyID != nil {
+			return
+		}
+		keyID, aead, err := p.currentKey(db, m.SenderID, m.ReceiverID)
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		content, err := sealContent(aead, m.ID, m.Content)
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		done = append(done, sealed{message: m, content: m.Content})
+		m.Content, m.KeyID = content, &keyID
+	})
+	db.InstanceSet(sealedSetting, done)
+}
+
+// unsealRecords puts back the plaintext of the messages sealRecords
+// encrypted, so the caller's messages are left as they were.
+func unsealRecords(db *gorm.DB) {
+	done, _ := db.InstanceGet(sealedSetting)
+	list, _ := done.([]sealed)
+	for _, s := range list {
+		s.message.Content, s.message.KeyID = s.content, nil
+	}
+}
+
+// sealUpdate encrypts content set by an update. Updates by map have to
+// name the message's ID, sender and receiver in the model, as in
+//
+//	tx.Model(&models.Message{ID: m.ID, SenderID: m.SenderID, ReceiverID: m.ReceiverID}).
+//		Updates(map[string]any{"content": m.Content})
+func (p *Plugin) sealUpdate(db *gorm.DB) {
+	if db.Error != nil || isRaw(db) || db.Statement.Schema == nil || db.Statement.Schema.ModelType != messageType {
+		return
+	}
+
+	values, ok := db.Statement.Dest.(map[string]any)
+	if !ok {
+		p.sealRecords(db)
+		return
+	}
+
+	content, ok := values["content"].(string)
+	if !ok {
+		return
+	}
+	// Copied so the caller's map is left alone
+	values = copyMap(values)
+	db.Statement.Dest = values
+
+	if p.kms == nil {
+		values["key_id"] = nil
+		return
+	}
+	m, _ := db.Statement.Model.(*models.Message)
+	if m == nil || m.ID == uuid.Nil || m.SenderID == uuid.Nil || m.ReceiverID == uuid.Nil {
+		db.AddError(errors.New("encryption: updating message content needs the message's ID, sender and receiver in the model"))
+		return
+	}
+	keyID, aead, err := p.currentKey(db, m.SenderID, m.ReceiverID)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if values["content"], err = sealContent(aead, m.ID, content); err != nil {
+		db.AddError(err)
+		return
+	}
+	values["key_id"] = keyID
+}
+
+// decryptResults decrypts the content of the messages a query loaded,
+// wherever they are in the result.
+func (p *Plugin) decryptResults(db *gorm.DB) {
+	if db.Error != nil || isRaw(db) {
+		return
+	}
+	walk(db.Statement.ReflectValue, func(m *models.Message) {
+		if db.Error != nil || m.KeyID == nil {
+			return
+		}
+		db.AddError(p.decrypt(db, m))
+	})
+}
+
+func (p *Plugin) decrypt(db *gorm.DB, m *models.Message) error {
+	if p.kms == nil {
+		return ErrNoMasterKey
+	}
+	aead, err := p.key(db, *m.KeyID)
+	if err != nil {
+		return err
+	}
+	content, err := openContent(aead, m.ID, m.Content)
+	if err != nil {
+		return fmt.Errorf("encryption: message %s: %w", m.ID, err)
+	}
+	m.Content, m.KeyID = content, nil
+	return nil
+}
+
+// currentKey returns the current key of the conversation between a and b,
+// creating it if there is none. It runs in the statement's transaction, so
+// a key created for a message that is rolled back goes with it.
+func (p *Plugin) currentKey(db *gorm.DB, a, b uuid.UUID) (uuid.UUID, cipher.AEAD, error) {
+	tx := db.Session(&gorm.Session{NewDB: true})
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+
+	find := func() (*models.ConversationKey, error) {
+		var keys []models.ConversationKey
+		err := tx.Where("user_a = ? AND user_b = ? AND retired_at IS NULL", a, b).Limit(1).Find(&keys).Error
+		if err != nil || len(keys) == 0 {
+			return nil, err
+		}
+		return &keys[0], nil
+	}
+
+	key, err := find()
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	if key != nil {
+		aead, err := p.key(db, key.ID)
+		return key.ID, aead, err
+	}
+
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		return uuid.Nil, nil, err
+	}
+	wrapped, err := p.kms.Wrap(raw)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	key = &models.ConversationKey{UserA: a, UserB: b, MasterKeyID: p.kms.CurrentKeyID(), WrappedKey: wrapped}
+
+	// Another transaction may be creating the same conversation's key
+	res := tx.Clauses(clause.OnConflict{
+		Columns:     []clause.Column{{Name: "user_a"}, {Name: "user_b"}},
+		TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "retired_at IS NULL"}}},
+		DoNothing:   true,
+	}).Create(key)
+	if res.Error != nil {
+		return uuid.Nil, nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		if key, err = find(); err != nil || key == nil {
+			return uuid.Nil, nil, errors.Join(err, errors.New("encryption: conversation key vanished"))
+		}
+		aead, err := p.key(db, key.ID)
+		return key.ID, aead, err
+	}
+
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	p.cache(key.ID, aead)
+	return key.ID, aead, nil
+}
+
+// key returns the data key id, unwrapped.
+func (p *Plugin) key(db *gorm.DB, id uuid.UUID) (cipher.AEAD, error) {
+	p.mu.Lock()
+	aead, ok := p.keys[id]
+	p.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	var key models.ConversationKey
+	if err := db.Session(&gorm.Session{NewDB: true}).Take(&key, "id = ?", id).Error; err != nil {
+		return nil, fmt.Errorf("encryption: loading key %s: %w", id, err)
+	}
+	return p.unwrap(&key)
+}
+
+// unwrap unwraps a data key and caches it.
+func (p *Plugin) unwrap(key *models.ConversationKey) (cipher.AEAD, error) {
+	raw, err := p.kms.Unwrap(key.MasterKeyID, key.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: unwrapping key %s: %w", key.ID, err)
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	p.cache(key.ID, aead)
+	return aead, nil
+}
+
+func (p *Plugin) cache(id uuid.UUID, aead cipher.AEAD) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.keys) >= maxCachedKeys {
+		clear(p.keys)
+	}
+	p.keys[id] = aead
+}
+
+// sealContent encrypts the content of the message id, as stored: the nonce
+// and ciphertext in base64.
+func sealContent(aead cipher.AEAD, id uuid.UUID, content string) (string, error) {
+	ciphertext, err := seal(aead, []byte(content), id[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func openContent(aead cipher.AEAD, id uuid.UUID, stored string) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil {
+		return "", err
+	}
+	content, err := open(aead, ciphertext, id[:])
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func copyMap(m map[string]any) map[string]any {
+	c := make(map[string]any, len(m)+1)
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// walk calls fn with every message in v, however deep, such as the last
+// message joined to a conversation summary.
+func walk(v reflect.Value, fn func(*models.Message)) {
+	if !v.IsValid() || !holdsMessages(v.Type()) {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			walk(v.Elem(), fn)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			walk(v.Index(i), fn)
+		}
+	case reflect.Struct:
+		if v.Type() == messageType {
+			if v.CanAddr() {
+				fn(v.Addr().Interface().(*models.Message))
+			}
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				walk(v.Field(i), fn)
+			}
+		}
+	}
+}
+
+var holds sync.Map
+
+// holdsMessages reports whether values of type t can contain a message.
+func holdsMessages(t reflect.Type) bool {
+	if h, ok := holds.Load(t); ok {
+		return h.(bool)
+	}
+	h := typeHoldsMessages(t, map[reflect.Type]bool{})
+	holds.Store(t, h)
+	return h
+}
+
+func typeHoldsMessages(t reflect.Type, seen map[reflect.Type]bool) bool {
+	// A type met again inside itself adds nothing
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return typeHoldsMessages(t.Elem(), seen)
+	case reflect.Interface:
+		return true
+	case reflect.Struct:
+		if t == messageType {
+			return true
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() && typeHoldsMessages(t.Field(i).Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package encryption
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	pollInterval = time.Hour
+
+	// Messages or keys handled per transaction
+	batchSize = 500
+)
+
+// Messages whose content is not under the current key of its conversation
+const stale = "key_id IS NULL OR key_id IN (SELECT id FROM conversation_keys WHERE retired_at IS NOT NULL)"
+
+// Status counts what a pass of the Reencryptor has left to do.
+type Status struct {
+	// Messages stored as plaintext
+	Plaintext int64
+
+	// Messages encrypted with a retired key
+	Retired int64
+
+	// Data keys wrapped by each master key
+	MasterKeys map[string]int64
+}
+
+// GetStatus counts the messages and keys by how they are encrypted.
+func GetStatus(db *gorm.DB) (*Status, error) {
+	status := &Status{MasterKeys: map[string]int64{}}
+	err := db.Model(&models.Message{}).Where("key_id IS NULL").Count(&status.Plaintext).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(&models.Message{}).
+		Where("key_id IN (SELECT id FROM conversation_keys WHERE retired_at IS NOT NULL)").
+		Count(&status.Retired).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		MasterKeyID string
+		Count       int64
+	}
+	err = db.Model(&models.ConversationKey{}).
+		Select("master_key_id, COUNT(*) AS count").
+		Group("master_key_id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		status.MasterKeys[c.MasterKeyID] = c.Count
+	}
+	return status, nil
+}
+
+// Rotate retires the current key of every conversation, and returns how
+// many it retired. New content is encrypted with new keys from then on,
+// and the Reencryptor moves existing content to them. Retired keys are
+// kept, for archives written under them.
+func Rotate(db *gorm.DB) (int64, error) {
+	res := db.Model(&models.ConversationKey{}).
+		Where("retired_at IS NULL").
+		Update("retired_at", time.Now())
+	return res.RowsAffected, res.Error
+}
+
+// Reencryptor keeps stored content under the current keys: it encrypts
+// plaintext content, re-encrypts content under retired keys, and wraps
+// data keys again when the master key changed.
+type Reencryptor struct {
+	DB *gorm.DB
+}
+
+// Run does a pass every hour until the process exits.
+func (r *Reencryptor) Run() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		rewrapped, messages, err := r.Pass()
+		if rewrapped > 0 || messages > 0 {
+			log.Printf("encryption: rewrapped %d keys, re-encrypted %d messages", rewrapped, messages)
+		}
+		if err != nil {
+			log.Println("encryption:", err)
+		}
+
+		<-ticker.C
+	}
+}
+
+// Pass does all there is to do, and returns how many keys it wrapped
+// again and how many messages it encrypted.
+func (r *Reencryptor) Pass() (rewrapped, messages int64, err error) {
+	p := plugin(r.DB)
+	if p == nil || p.kms == nil {
+		return 0, 0, ErrNoMasterKey
+	}
+
+	if rewrapped, err = r.rewrap(p); err != nil {
+		return rewrapped, 0, err
+	}
+	messages, err = r.each(stale, func(tx *gorm.DB, m *models.Message) error {
+		keyID, aead, err := p.currentKey(tx, m.SenderID, m.ReceiverID)
+		if err != nil {
+			return err
+		}
+		content, err := sealContent(aead, m.ID, m.Content)
+		if err != nil {
+			return err
+		}
+		return store(tx, m, content, &keyID)
+	})
+	return rewrapped, messages, err
+}
+
+// DecryptAll stores the content of every message as plaintext again, so
+// encryption can be turned off, and returns how many it decrypted.
+func (r *Reencryptor) DecryptAll() (int64, error) {
+	return r.each("key_id IS NOT NULL", func(tx *gorm.DB, m *models.Message) error {
+		return store(tx, m, m.Content, nil)
+	})
+}
+
+// rewrap wraps the data keys that an old master key wrapped with the
+// current one.
+func (r *Reencryptor) rewrap(p *Plugin) (int64, error) {
+	current := p.kms.CurrentKeyID()
+	var done int64
+	for {
+		var n int
+		err := r.DB.Transaction(func(tx *gorm.DB) error {
+			var keys []models.ConversationKey
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("master_key_id <> ?", current).
+				Limit(batchSize).
+				Find(&keys).Error
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				raw, err := p.kms.Unwrap(key.MasterKeyID, key.WrappedKey)
+				if err != nil {
+					return err
+				}
+				wrapped, err := p.kms.Wrap(raw)
+				if err != nil {
+					return err
+				}
+				err = tx.Model(&key).Updates(map[string]any{
+					"master_key_id": current,
+					"wrapped_key":   wrapped,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+			n = len(keys)
+			return nil
+		})
+		done += int64(n)
+		if err != nil || n < batchSize {
+			return done, err
+		}
+	}
+}
+
+// each calls fn with the messages that match where, decrypted, a batch per
+// transaction, until none is left. fn must store the message so that it no
+// longer matches.
+func (r *Reencryptor) each(where string, fn func(tx *gorm.DB, m *models.Message) error) (int64, error) {
+	var done int64
+	for {
+		var n int
+		err := r.DB.Transaction(func(tx *gorm.DB) error {
+			// Read as stored, with the rows locked so that edits wait
+			var batch []models.Message
+			err := Raw(tx).
+				Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where(where).
+				Limit(batchSize).
+				Find(&batch).Error
+			if err != nil {
+				return err
+			}
+			for i := range batch {
+				if err := Decrypt(tx, &batch[i]); err != nil {
+					return err
+				}
+				if err := fn(tx, &batch[i]); err != nil {
+					return err
+				}
+			}
+			n = len(batch)
+			return nil
+		})
+		done += int64(n)
+		if err != nil || n < batchSize {
+			return done, err
+		}
+	}
+}
+
+// store writes content as stored, and the key it is encrypted with.
+func store(tx *gorm.DB, m *models.Message, content string, keyID *uuid.UUID) error {
+	res := Raw(tx).Model(&models.Message{}).
+		Where("id = ?", m.ID).
+		Updates(map[string]any{
+			"content": content,
+			"key_id":  keyID,
+		})
+	if res.Error == nil && res.RowsAffected == 0 {
+		return fmt.Errorf("encryption: message %s vanished", m.ID)
+	}
+	return res.Error
+}
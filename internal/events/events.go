@@ -0,0 +1,43 @@
+package events
+
+import "github.com/google/uuid"
+
+// Names of the events emitted when chat state changes.
+const (
+	MessageCreated   = "message.created"
+	MessageEdited    = "message.edited"
+	MessageDeleted   = "message.deleted"
+	ConversationRead = "conversation.read"
+
+	// Sent only to the users mentioned in a new or edited message
+	Mentioned = "mentioned"
+
+	// A device of the user was registered, removed, or got a new identity
+	// key, changing the safety numbers others share with the user
+	IdentityKeyChanged = "identity_key.changed"
+)
+
+// All lists every event name, for validating subscriptions.
+var All = []string{
+	MessageCreated,
+	MessageEdited,
+	MessageDeleted,
+	ConversationRead,
+	Mentioned,
+	IdentityKeyChanged,
+}
+
+// Sink receives events. userIDs are the users the event concerns, which
+// decides who may be told about it.
+type Sink interface {
+	Emit(event string, userIDs []uuid.UUID, data any)
+}
+
+// Fanout passes every event to each of its sinks in turn.
+type Fanout []Sink
+
+func (f Fanout) Emit(event string, userIDs []uuid.UUID, data any) {
+	for _, s := range f {
+		s.Emit(event, userIDs, data)
+	}
+}
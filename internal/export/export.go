@@ -0,0 +1,204 @@
+package export
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/encryption"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Export formats
+const (
+	FormatJSON = "json"
+	FormatHTML = "html"
+	FormatText = "txt"
+)
+
+// formatVersion is bumped whenever the layout of the JSON export changes
+// incompatibly.
+const formatVersion = 1
+
+var ErrUnknownFormat = errors.New("format must be json, html or txt")
+
+// Request selects the messages of one conversation to export. Since is
+// inclusive and Until exclusive; either may be nil.
+type Request struct {
+	UserID  uuid.UUID
+	OtherID uuid.UUID
+	Format  string
+	Since   *time.Time
+	Until   *time.Time
+}
+
+// ContentType returns the MIME type of an export format.
+func ContentType(format string) string {
+	switch format {
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	case FormatText:
+		return "text/plain; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+// ValidFormat reports whether format is one of the export formats.
+func ValidFormat(format string) bool {
+	return format == FormatJSON || format == FormatHTML || format == FormatText
+}
+
+// Participant is one side of the exported conversation.
+type Participant struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+}
+
+// Header describes the export as a whole.
+type Header struct {
+	ExportedAt time.Time   `json:"exported_at"`
+	User       Participant `json:"user"`
+	OtherUser  Participant `json:"other_user"`
+	Since      *time.Time  `json:"since,omitempty"`
+	Until      *time.Time  `json:"until,omitempty"`
+}
+
+// Message is a message as it appears in an export. Deleted messages are
+// kept as markers without their content.
+type Message struct {
+	ID          uuid.UUID          `json:"id"`
+	From        uuid.UUID          `json:"from"`
+	To          uuid.UUID          `json:"to"`
+	Sender      string             `json:"sender"`
+	Content     string             `json:"content,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Attachments models.Attachments `json:"attachments,omitempty"`
+	IsBot       bool               `json:"is_bot"`
+	IsDeleted   bool               `json:"is_deleted"`
+	Timestamp   time.Time          `json:"timestamp"`
+	EditedAt    *time.Time         `json:"edited_at,omitempty"`
+	IsRead      bool               `json:"is_read"`
+	ReadAt      *time.Time         `json:"read_at,omitempty"`
+}
+
+// writer renders one export format. Messages arrive oldest first.
+type writer interface {
+	Begin(h *Header) error
+	Message(m *Message) error
+	End() error
+}
+
+func newWriter(format string, w io.Writer) (writer, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonWriter{w: w}, nil
+	case FormatHTML:
+		return &htmlWriter{w: w}, nil
+	case FormatText:
+		return &textWriter{w: w}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// Write streams the conversation to w in the requested format. Messages are
+// read from the database one at a time, so the size of the conversation
+// does not matter. When it fails part way through, w holds a truncated
+// document.
+func Write(db *gorm.DB, w io.Writer, req *Request) error {
+	out, err := newWriter(req.Format, w)
+	if err != nil {
+		return err
+	}
+
+	var user, other models.User
+	if err := db.First(&user, "id = ?", req.UserID).Error; err != nil {
+		return err
+	}
+	if err := db.First(&other, "id = ?", req.OtherID).Error; err != nil {
+		return err
+	}
+	names := map[uuid.UUID]string{
+		user.ID:  user.DisplayName(),
+		other.ID: other.DisplayName(),
+	}
+
+	query := db.Model(&models.Message{}).
+		Where(
+			"(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)",
+			req.UserID, req.OtherID, req.OtherID, req.UserID,
+		).
+		Order("created_at, id")
+	if req.Since != nil {
+		query = query.Where("created_at >= ?", *req.Since)
+	}
+	if req.Until != nil {
+		query = query.Where("created_at < ?", *req.Until)
+	}
+
+	// Rows hold the connection, which is all there is with SQLite in memory
+	if err := encryption.LoadKeys(query); err != nil {
+		return err
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	err = out.Begin(&Header{
+		ExportedAt: time.Now().UTC(),
+		User:       Participant{ID: user.ID, Username: user.DisplayName()},
+		OtherUser:  Participant{ID: other.ID, Username: other.DisplayName()},
+		Since:      req.Since,
+		Until:      req.Until,
+	})
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var m models.Message
+		if err := db.ScanRows(rows, &m); err != nil {
+			return err
+		}
+		if err := encryption.Decrypt(db, &m); err != nil {
+			return err
+		}
+
+		sender := names[m.SenderID]
+		if m.SenderName != "" {
+			sender = m.SenderName
+		}
+
+		em := Message{
+			ID:        m.ID,
+			From:      m.SenderID,
+			To:        m.ReceiverID,
+			Sender:    sender,
+			IsBot:     m.IsBot,
+			IsDeleted: m.IsDeleted,
+			Timestamp: m.CreatedAt.UTC(),
+			EditedAt:  m.EditedAt,
+			IsRead:    m.IsRead,
+			ReadAt:    m.ReadAt,
+		}
+		if !m.IsDeleted {
+			em.Content = m.Content
+			em.Format = m.Format
+			em.Attachments = m.Attachments
+		}
+
+		if err := out.Message(&em); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return out.End()
+}
@@ -0,0 +1,212 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+)
+
+const timeLayout = "2006-01-02 15:04:05 UTC"
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+// rangeText describes the date range of an export for the HTML and text
+// formats.
+func rangeText(h *Header) string {
+	switch {
+	case h.Since != nil && h.Until != nil:
+		return fmt.Sprintf("Messages from %s until %s", formatTime(*h.Since), formatTime(*h.Until))
+	case h.Since != nil:
+		return fmt.Sprintf("Messages from %s", formatTime(*h.Since))
+	case h.Until != nil:
+		return fmt.Sprintf("Messages until %s", formatTime(*h.Until))
+	default:
+		return "All messages"
+	}
+}
+
+// jsonWriter writes one JSON document with the header fields and a
+// "messages" array.
+type jsonWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonWriter) Begin(h *Header) error {
+	header, err := json.Marshal(struct {
+		FormatVersion int `json:"format_version"`
+		*Header
+	}{formatVersion, h})
+	if err != nil {
+		return err
+	}
+
+	// Splice the streamed message array into the header object
+	if _, err := j.w.Write(header[:len(header)-1]); err != nil {
+		return err
+	}
+	_, err = io.WriteString(j.w, `,"messages":[`)
+	return err
+}
+
+func (j *jsonWriter) Message(m *Message) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if j.count > 0 {
+		b = append([]byte(",\n"), b...)
+	}
+	j.count++
+	_, err = j.w.Write(b)
+	return err
+}
+
+func (j *jsonWriter) End() error {
+	_, err := io.WriteString(j.w, "]}\n")
+	return err
+}
+
+// textWriter writes a plain-text transcript.
+type textWriter struct {
+	w io.Writer
+}
+
+func (t *textWriter) Begin(h *Header) error {
+	_, err := fmt.Fprintf(t.w, "Conversation between %s and %s\n%s\nExported %s\n\n",
+		h.User.Username, h.OtherUser.Username, rangeText(h), formatTime(h.ExportedAt))
+	return err
+}
+
+func (t *textWriter) Message(m *Message) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "[%s] ", formatTime(m.Timestamp))
+	switch {
+	case m.IsDeleted:
+		fmt.Fprintf(&b, "%s: [message deleted]\n", m.Sender)
+	case m.Format == models.FormatAction:
+		fmt.Fprintf(&b, "* %s %s\n", m.Sender, indent(m.Content))
+	default:
+		fmt.Fprintf(&b, "%s: %s\n", m.Sender, indent(m.Content))
+	}
+
+	for _, a := range m.Attachments {
+		if a.Title != "" {
+			fmt.Fprintf(&b, "    Attachment: %s <%s>\n", a.Title, a.URL)
+		} else {
+			fmt.Fprintf(&b, "    Attachment: <%s>\n", a.URL)
+		}
+	}
+	if m.EditedAt != nil {
+		fmt.Fprintf(&b, "    (edited %s)\n", formatTime(*m.EditedAt))
+	}
+	if m.ReadAt != nil {
+		fmt.Fprintf(&b, "    (read %s)\n", formatTime(*m.ReadAt))
+	} else if m.IsRead {
+		b.WriteString("    (read)\n")
+	}
+
+	_, err := io.WriteString(t.w, b.String())
+	return err
+}
+
+func (t *textWriter) End() error {
+	return nil
+}
+
+// indent lines the continuation lines of a multi-line message up under the
+// first one.
+func indent(content string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\n", "\n    ")
+}
+
+// htmlWriter writes a self-contained HTML page.
+type htmlWriter struct {
+	w io.Writer
+}
+
+const htmlStyle = `body{font-family:sans-serif;max-width:48rem;margin:2rem auto;padding:0 1rem}
+ol{list-style:none;padding:0}li{margin:.75rem 0}
+.meta{color:#666;font-size:.85em}.deleted{color:#999;font-style:italic}
+.content{white-space:pre-wrap}`
+
+func (h *htmlWriter) Begin(hd *Header) error {
+	title := fmt.Sprintf("Conversation between %s and %s", hd.User.Username, hd.OtherUser.Username)
+	_, err := fmt.Fprintf(h.w, `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>%s</style>
+</head>
+<body>
+<h1>%s</h1>
+<p class="meta">%s. Exported %s.</p>
+<ol>
+`,
+		html.EscapeString(title), htmlStyle, html.EscapeString(title),
+		html.EscapeString(rangeText(hd)), formatTime(hd.ExportedAt))
+	return err
+}
+
+func (h *htmlWriter) Message(m *Message) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, `<li id="m-%s"><span class="meta">%s</span> `, m.ID, formatTime(m.Timestamp))
+	sender := html.EscapeString(m.Sender)
+	content := html.EscapeString(m.Content)
+	switch {
+	case m.IsDeleted:
+		fmt.Fprintf(&b, `<strong>%s</strong>: <span class="deleted">message deleted</span>`, sender)
+	case m.Format == models.FormatAction:
+		fmt.Fprintf(&b, `<span class="content">* <strong>%s</strong> %s</span>`, sender, content)
+	default:
+		fmt.Fprintf(&b, `<strong>%s</strong>: <span class="content">%s</span>`, sender, content)
+	}
+
+	for _, a := range m.Attachments {
+		title := a.Title
+		if title == "" {
+			title = a.URL
+		}
+		if !linkable(a.URL) {
+			fmt.Fprintf(&b, `<br>%s`, html.EscapeString(title))
+			continue
+		}
+		fmt.Fprintf(&b, `<br><a href="%s" rel="noopener noreferrer nofollow">%s</a>`,
+			html.EscapeString(a.URL), html.EscapeString(title))
+	}
+	if m.EditedAt != nil {
+		fmt.Fprintf(&b, `<br><span class="meta">edited %s</span>`, formatTime(*m.EditedAt))
+	}
+	if m.ReadAt != nil {
+		fmt.Fprintf(&b, `<br><span class="meta">read %s</span>`, formatTime(*m.ReadAt))
+	} else if m.IsRead {
+		b.WriteString(`<br><span class="meta">read</span>`)
+	}
+	b.WriteString("</li>\n")
+
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// linkable reports whether an attachment URL is safe to put in a link.
+// Attachments are checked when they are posted; this guards older rows.
+func linkable(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+func (h *htmlWriter) End() error {
+	_, err := io.WriteString(h.w, "</ol>\n</body>\n</html>\n")
+	return err
+}
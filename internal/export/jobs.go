@@ -0,0 +1,303 @@
+package export
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	jobPollInterval = 10 * time.Second
+
+	// A running job is taken over by another worker once its lease has not
+	// been renewed for this long, so an instance that dies mid-export does
+	// not leave it running forever. The worker running it renews the lease
+	// every leaseRenewInterval.
+	jobLease           = 5 * time.Minute
+	leaseRenewInterval = time.Minute
+)
+
+// errLeaseLost means another worker took over a job while it was running.
+var errLeaseLost = errors.New("lease lost to another worker")
+
+// Worker runs export jobs and deletes their files once they expire. Jobs
+// are stored in the database, so with several instances Dir must be shared
+// storage.
+type Worker struct {
+	DB  *gorm.DB
+	Dir string
+
+	// How long a finished export can be downloaded
+	TTL time.Duration
+
+	wake chan struct{}
+}
+
+func NewWorker(db *gorm.DB, dir string, ttl time.Duration) *Worker {
+	return &Worker{
+		DB:   db,
+		Dir:  dir,
+		TTL:  ttl,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Enqueue stores a job for req and wakes the worker.
+func (w *Worker) Enqueue(req *Request) (*models.ExportJob, error) {
+	job := models.ExportJob{
+		UserID:  req.UserID,
+		OtherID: req.OtherID,
+		Format:  req.Format,
+		Since:   req.Since,
+		Until:   req.Until,
+		Status:  models.ExportPending,
+	}
+	if err := w.DB.Create(&job).Error; err != nil {
+		return nil, err
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return &job, nil
+}
+
+// Path is where the file of a finished job is stored.
+func (w *Worker) Path(job *models.ExportJob) string {
+	return filepath.Join(w.Dir, job.ID.String()+"."+job.Format)
+}
+
+// Run processes jobs until the process exits.
+func (w *Worker) Run() {
+	if err := os.MkdirAll(w.Dir, 0o700); err != nil {
+		log.Println("export: failed to create export directory:", err)
+	}
+
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		w.runDue()
+		w.deleteExpired()
+
+		select {
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+func (w *Worker) runDue() {
+	for {
+		var job models.ExportJob
+		err := w.DB.
+			Where("status = ? OR (status = ? AND started_at < ?)",
+				models.ExportPending, models.ExportRunning, time.Now().Add(-jobLease)).
+			Order("created_at").
+			Limit(1).
+			Find(&job).Error
+		if err != nil {
+			log.Println("export: failed to load jobs:", err)
+			return
+		}
+		if job.ID == uuid.Nil {
+			return
+		}
+
+		if l, ok := w.claim(&job); ok {
+			w.run(&job, l)
+		}
+	}
+}
+
+// claim takes a job for this worker by moving its start time, which only
+// succeeds if nobody else did so first.
+func (w *Worker) claim(job *models.ExportJob) (*lease, bool) {
+	query := w.DB.Model(&models.ExportJob{}).Where("id = ? AND status = ?", job.ID, job.Status)
+	if job.StartedAt != nil {
+		query = query.Where("started_at = ?", *job.StartedAt)
+	} else {
+		query = query.Where("started_at IS NULL")
+	}
+
+	l := &lease{db: w.DB, jobID: job.ID, at: leaseTime()}
+	res := query.Updates(map[string]any{
+		"status":     models.ExportRunning,
+		"started_at": l.at,
+	})
+	return l, res.Error == nil && res.RowsAffected == 1
+}
+
+func (w *Worker) run(job *models.ExportJob, l *lease) {
+	stop := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(leaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if !l.renew() {
+					return
+				}
+			}
+		}
+	}()
+
+	size, err := w.write(job, l)
+	close(stop)
+	<-renewed
+
+	if errors.Is(err, errLeaseLost) {
+		log.Printf("export: job %s was taken over by another worker", job.ID)
+		return
+	}
+
+	now := time.Now()
+	updates := map[string]any{
+		"status":       models.ExportDone,
+		"size":         size,
+		"completed_at": now,
+		"expires_at":   now.Add(w.TTL),
+	}
+	if err != nil {
+		log.Printf("export: job %s failed: %v", job.ID, err)
+		updates = map[string]any{
+			"status":       models.ExportFailed,
+			"error":        "export failed",
+			"completed_at": now,
+			"expires_at":   now.Add(w.TTL),
+		}
+	}
+
+	// Only while the job is still this worker's
+	res := w.DB.Model(&models.ExportJob{}).
+		Where("id = ? AND status = ? AND started_at = ?", job.ID, models.ExportRunning, l.current()).
+		Updates(updates)
+	if res.Error != nil {
+		log.Printf("export: failed to finish job %s: %v", job.ID, res.Error)
+	} else if res.RowsAffected == 0 {
+		log.Printf("export: job %s was taken over by another worker", job.ID)
+	}
+}
+
+// lease is a worker's hold on a running job: the start time it last wrote
+// to the job, which it moves forward while the export runs. Another worker
+// taking the job over writes its own, and the lease is lost.
+type lease struct {
+	db    *gorm.DB
+	jobID uuid.UUID
+
+	mu   sync.Mutex
+	at   time.Time
+	lost bool
+}
+
+// leaseTime is the current time at the precision every database stores, so
+// that the start time written can be matched again.
+func leaseTime() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// renew moves the lease forward and reports whether it is still held.
+func (l *lease) renew() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	at := leaseTime()
+	res := l.db.Model(&models.ExportJob{}).
+		Where("id = ? AND status = ? AND started_at = ?", l.jobID, models.ExportRunning, l.at).
+		Update("started_at", at)
+	switch {
+	case res.Error != nil:
+		// Try again next time; the lease lasts several renewals
+		log.Printf("export: failed to renew the lease of job %s: %v", l.jobID, res.Error)
+	case res.RowsAffected == 0:
+		l.lost = true
+	default:
+		l.at = at
+	}
+	return !l.lost
+}
+
+func (l *lease) held() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.lost
+}
+
+func (l *lease) current() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.at
+}
+
+// write exports to a temporary file and renames it into place, so a
+// download never sees a partial file. A job taken over by another worker
+// meanwhile is left to it.
+func (w *Worker) write(job *models.ExportJob, l *lease) (int64, error) {
+	tmp, err := os.CreateTemp(w.Dir, "export-*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	buf := bufio.NewWriter(tmp)
+	err = Write(w.DB, buf, &Request{
+		UserID:  job.UserID,
+		OtherID: job.OtherID,
+		Format:  job.Format,
+		Since:   job.Since,
+		Until:   job.Until,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := buf.Flush(); err != nil {
+		return 0, err
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if !l.held() {
+		return 0, errLeaseLost
+	}
+	if err := os.Rename(tmp.Name(), w.Path(job)); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (w *Worker) deleteExpired() {
+	var expired []models.ExportJob
+	if err := w.DB.Where("expires_at < ?", time.Now()).Find(&expired).Error; err != nil {
+		log.Println("export: failed to load expired jobs:", err)
+		return
+	}
+
+	for i := range expired {
+		if err := os.Remove(w.Path(&expired[i])); err != nil && !os.IsNotExist(err) {
+			log.Printf("export: failed to delete %s: %v", w.Path(&expired[i]), err)
+			continue
+		}
+		w.DB.Delete(&expired[i])
+	}
+}
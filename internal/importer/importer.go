@@ -0,0 +1,364 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store/sqlstore"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Import sources
+const (
+	SourceSlack    = "slack"
+	SourceWhatsApp = "whatsapp"
+)
+
+// What happened to a participant of the imported chats
+const (
+	ParticipantMatched     = "matched"
+	ParticipantCreated     = "created"
+	ParticipantWouldCreate = "would_create"
+	ParticipantUnmapped    = "unmapped"
+)
+
+const batchSize = 500
+
+var ErrUnknownSource = invalid("source must be slack or whatsapp")
+
+// InvalidError is a problem with the export or the options, as opposed to a
+// failure of the database.
+type InvalidError struct {
+	msg string
+}
+
+func (e *InvalidError) Error() string {
+	return e.msg
+}
+
+func invalid(format string, args ...any) error {
+	return &InvalidError{msg: fmt.Sprintf(format, args...)}
+}
+
+// Options controls how an export is imported.
+type Options struct {
+	// Report what would be imported without writing anything
+	DryRun bool
+
+	// Create a placeholder account, which cannot log in, for each
+	// participant that matches no existing user. Without it their messages
+	// are skipped.
+	CreatePlaceholders bool
+
+	// Maps a participant, by their ID in the source (Slack user ID) or
+	// their name, to the username, email or ID of an existing user
+	Users map[string]string
+
+	// WhatsApp only: the order of day and month in dates, "dmy" or "mdy".
+	// Detected from the export when empty.
+	DateOrder string
+
+	// WhatsApp only: the IANA time zone of the timestamps. Default UTC.
+	Timezone string
+}
+
+// ParticipantReport says which user a participant of the imported chats
+// was mapped to.
+type ParticipantReport struct {
+	ExternalID string     `json:"external_id,omitempty"`
+	Name       string     `json:"name"`
+	Action     string     `json:"action"`
+	UserID     *uuid.UUID `json:"user_id,omitempty"`
+	Username   string     `json:"username,omitempty"`
+}
+
+// Report describes the outcome of an import, or for a dry run what the
+// outcome would be.
+type Report struct {
+	Source       string              `json:"source"`
+	DryRun       bool                `json:"dry_run"`
+	Participants []ParticipantReport `json:"participants"`
+
+	Conversations int `json:"conversations"`
+
+	// Messages found in the export that can be imported, split into those
+	// that are new and those already imported by an earlier run
+	Messages   int `json:"messages"`
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+
+	// Messages and conversations left out, by reason
+	Skipped map[string]int `json:"skipped,omitempty"`
+}
+
+func (r *Report) skip(reason string, n int) {
+	if n == 0 {
+		return
+	}
+	if r.Skipped == nil {
+		r.Skipped = map[string]int{}
+	}
+	r.Skipped[reason] += n
+}
+
+// participant is someone who appears in the export.
+type participant struct {
+	externalID string
+	name       string
+	email      string
+
+	// Set once resolved; uuid.Nil when unmapped or not yet created in a
+	// dry run
+	userID uuid.UUID
+	mapped bool
+}
+
+// importer holds the state of one import run.
+type importer struct {
+	db     *gorm.DB
+	opts   Options
+	report *Report
+	batch  []models.Message
+}
+
+func newImporter(db *gorm.DB, source string, opts Options) *importer {
+	return &importer{
+		db:   db,
+		opts: opts,
+		report: &Report{
+			Source:       source,
+			DryRun:       opts.DryRun,
+			Participants: []ParticipantReport{},
+		},
+	}
+}
+
+// resolve maps a participant to a user: an explicit mapping from the
+// options first, then a user with the same email, then a placeholder from
+// an earlier import, then a new placeholder.
+func (im *importer) resolve(p *participant) error {
+	entry := ParticipantReport{
+		ExternalID: p.externalID,
+		Name:       p.name,
+	}
+	defer func() {
+		im.report.Participants = append(im.report.Participants, entry)
+	}()
+
+	var user models.User
+	found := func(action string) {
+		p.userID, p.mapped = user.ID, true
+		entry.Action, entry.UserID, entry.Username = action, &user.ID, user.Username
+	}
+
+	ref, ok := im.opts.Users[p.externalID]
+	if !ok || p.externalID == "" {
+		ref, ok = im.opts.Users[p.name]
+	}
+	if ok {
+		query := im.db.Where("deleted_at IS NULL")
+		if id, err := uuid.Parse(ref); err == nil {
+			query = query.Where("id = ?", id)
+		} else {
+			query = query.Where("username = ? OR email = ?", ref, strings.ToLower(ref))
+		}
+		if err := query.First(&user).Error; err != nil {
+			return invalid("%s is mapped to %q, which matches no user", p.name, ref)
+		}
+		found(ParticipantMatched)
+		return nil
+	}
+
+	if p.email != "" {
+		err := im.db.First(&user, "email = ? AND deleted_at IS NULL", strings.ToLower(p.email)).Error
+		if err == nil {
+			found(ParticipantMatched)
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+
+	email := placeholderEmail(im.report.Source, p)
+	err := im.db.First(&user, "email = ? AND deleted_at IS NULL", email).Error
+	if err == nil {
+		found(ParticipantMatched)
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if !im.opts.CreatePlaceholders {
+		entry.Action = ParticipantUnmapped
+		return nil
+	}
+
+	username, err := im.freeUsername(p.name)
+	if err != nil {
+		return err
+	}
+	entry.Username = username
+
+	if im.opts.DryRun {
+		p.mapped = true
+		entry.Action = ParticipantWouldCreate
+		return nil
+	}
+
+	// Placeholders never log in, so they get an unroutable email and no
+	// password, like bots
+	user = models.User{
+		Username:     username,
+		Email:        email,
+		PasswordHash: "",
+	}
+	if err := im.db.Create(&user).Error; err != nil {
+		return err
+	}
+	found(ParticipantCreated)
+	return nil
+}
+
+// placeholderEmail is derived from the participant's identity in the
+// source, so importing again finds the same placeholder.
+func placeholderEmail(source string, p *participant) string {
+	id := p.externalID
+	if id == "" {
+		id = p.name
+	}
+	sum := sha256.Sum256([]byte(source + ":" + id))
+	return fmt.Sprintf("%s-%s@import.invalid", source, hex.EncodeToString(sum[:8]))
+}
+
+// freeUsername turns a display name into a username nobody has taken yet.
+func (im *importer) freeUsername(name string) (string, error) {
+	base := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '_', r == '.', r == '-':
+			return unicode.ToLower(r)
+		case unicode.IsSpace(r):
+			return '_'
+		default:
+			return -1
+		}
+	}, name)
+	if base == "" {
+		base = "user"
+	}
+	if len([]rune(base)) > 24 {
+		base = string([]rune(base)[:24])
+	}
+
+	candidate := base
+	for i := 2; ; i++ {
+		var count int64
+		if err := im.db.Model(&models.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s_%d", base, i)
+	}
+}
+
+// add queues a message for insertion. Imported messages are history, so
+// they are stored as read.
+func (im *importer) add(m models.Message) error {
+	m.IsRead = true
+	im.batch = append(im.batch, m)
+	im.report.Messages++
+
+	if len(im.batch) >= batchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+// flush inserts the queued messages, skipping those whose import key is
+// already taken. A dry run only counts them.
+func (im *importer) flush() error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	defer func() { im.batch = im.batch[:0] }()
+
+	keys := make([]string, len(im.batch))
+	for i, m := range im.batch {
+		keys[i] = *m.ImportKey
+	}
+
+	if im.opts.DryRun {
+		var existing int64
+		err := im.db.Model(&models.Message{}).Where("import_key IN ?", keys).Count(&existing).Error
+		if err != nil {
+			return err
+		}
+		im.report.Duplicates += int(existing)
+		im.report.Imported += len(im.batch) - int(existing)
+		return nil
+	}
+
+	var inserted int
+	err := im.db.Transaction(func(tx *gorm.DB) error {
+		// The unique index on import keys also covers created_at, as unique
+		// indexes of the partitioned messages table must, so a message
+		// imported again with another time, such as from a WhatsApp export
+		// read in another time zone, is only caught here
+		var taken []string
+		if err := tx.Model(&models.Message{}).Where("import_key IN ?", keys).Pluck("import_key", &taken).Error; err != nil {
+			return err
+		}
+		batch := slices.DeleteFunc(slices.Clone(im.batch), func(m models.Message) bool {
+			return slices.Contains(taken, *m.ImportKey)
+		})
+		if len(batch) == 0 {
+			return nil
+		}
+
+		res := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "import_key"}, {Name: "created_at"}},
+			DoNothing: true,
+		}).Create(&batch)
+		if res.Error != nil {
+			return res.Error
+		}
+		inserted = int(res.RowsAffected)
+
+		// Imported history can land anywhere in a conversation, so its
+		// summaries are recomputed rather than added to
+		refreshed := make(map[[2]uuid.UUID]bool)
+		for _, m := range batch {
+			pair := [2]uuid.UUID{m.SenderID, m.ReceiverID}
+			if refreshed[pair] || refreshed[[2]uuid.UUID{m.ReceiverID, m.SenderID}] {
+				continue
+			}
+			refreshed[pair] = true
+			if err := sqlstore.RefreshConversation(tx, m.SenderID, m.ReceiverID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	im.report.Imported += inserted
+	im.report.Duplicates += len(im.batch) - inserted
+	return nil
+}
+
+func importKey(parts ...string) *string {
+	key := strings.Join(parts, ":")
+	return &key
+}
@@ -0,0 +1,297 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"html"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"gorm.io/gorm"
+)
+
+type slackUser struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Profile struct {
+		Email       string `json:"email"`
+		RealName    string `json:"real_name"`
+		DisplayName string `json:"display_name"`
+	} `json:"profile"`
+}
+
+type slackConversation struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+type slackMessage struct {
+	Type    string `json:"type"`
+	Subtype string `json:"subtype"`
+	User    string `json:"user"`
+	Text    string `json:"text"`
+	TS      string `json:"ts"`
+	Edited  *struct {
+		TS string `json:"ts"`
+	} `json:"edited"`
+	Files []struct {
+		Name       string `json:"name"`
+		Title      string `json:"title"`
+		URLPrivate string `json:"url_private"`
+		Permalink  string `json:"permalink"`
+	} `json:"files"`
+}
+
+// Message subtypes that carry something a user wrote; the others are
+// channel events such as joins
+var slackContentSubtypes = map[string]bool{
+	"":                 true,
+	"me_message":       true,
+	"file_share":       true,
+	"thread_broadcast": true,
+}
+
+var (
+	slackMention = regexp.MustCompile(`<@([A-Z0-9]+)(?:\|[^>]*)?>`)
+	slackLink    = regexp.MustCompile(`<((?:https?|mailto):[^|>]+)(?:\|([^>]+))?>`)
+	slackSpecial = regexp.MustCompile(`<!(here|channel|everyone)[^>]*>`)
+)
+
+// ImportSlack imports the direct messages of a Slack workspace export zip.
+// Channels and group DMs are skipped since conversations here are one to
+// one.
+func ImportSlack(db *gorm.DB, r io.ReaderAt, size int64, opts Options) (*Report, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, invalid("not a zip archive: %v", err)
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[path.Clean(f.Name)] = f
+	}
+
+	var users []slackUser
+	if err := readSlackJSON(files, "users.json", &users); err != nil {
+		return nil, err
+	}
+	var dms []slackConversation
+	if err := readSlackJSON(files, "dms.json", &dms); err != nil {
+		return nil, err
+	}
+
+	im := newImporter(db, SourceSlack, opts)
+
+	for _, name := range []string{"channels.json", "groups.json", "mpims.json"} {
+		var others []slackConversation
+		if _, ok := files[name]; !ok {
+			continue
+		}
+		if err := readSlackJSON(files, name, &others); err != nil {
+			return nil, err
+		}
+		im.report.skip("channels and group conversations", len(others))
+	}
+
+	// Only the users who take part in a DM become participants
+	inDM := map[string]bool{}
+	for _, dm := range dms {
+		for _, id := range dm.Members {
+			inDM[id] = true
+		}
+	}
+
+	participants := map[string]*participant{}
+	names := map[string]string{}
+	for _, u := range users {
+		name := u.Profile.DisplayName
+		if name == "" {
+			name = u.Profile.RealName
+		}
+		if name == "" {
+			name = u.Name
+		}
+		names[u.ID] = name
+
+		if !inDM[u.ID] {
+			continue
+		}
+		p := &participant{
+			externalID: u.ID,
+			name:       name,
+			email:      u.Profile.Email,
+		}
+		if err := im.resolve(p); err != nil {
+			return nil, err
+		}
+		participants[u.ID] = p
+	}
+
+	for _, dm := range dms {
+		if len(dm.Members) != 2 || dm.Members[0] == dm.Members[1] {
+			im.report.skip("channels and group conversations", 1)
+			continue
+		}
+		a, b := participants[dm.Members[0]], participants[dm.Members[1]]
+		if a == nil || b == nil || !a.mapped || !b.mapped {
+			im.report.skip("conversations with unmapped participants", 1)
+			continue
+		}
+
+		im.report.Conversations++
+		if err := im.importSlackDM(files, dm, a, b, names); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := im.flush(); err != nil {
+		return nil, err
+	}
+	return im.report, nil
+}
+
+func (im *importer) importSlackDM(files map[string]*zip.File, dm slackConversation, a, b *participant, names map[string]string) error {
+	// One file per day, named YYYY-MM-DD.json, so sorting puts them in order
+	var days []string
+	for name := range files {
+		if path.Dir(name) == dm.ID && strings.HasSuffix(name, ".json") {
+			days = append(days, name)
+		}
+	}
+	sort.Strings(days)
+
+	for _, day := range days {
+		var messages []slackMessage
+		if err := readSlackJSON(files, day, &messages); err != nil {
+			return err
+		}
+
+		for _, sm := range messages {
+			if sm.Type != "message" || !slackContentSubtypes[sm.Subtype] {
+				im.report.skip("system messages", 1)
+				continue
+			}
+
+			var sender, receiver *participant
+			switch sm.User {
+			case a.externalID:
+				sender, receiver = a, b
+			case b.externalID:
+				sender, receiver = b, a
+			default:
+				im.report.skip("messages from bots and integrations", 1)
+				continue
+			}
+
+			created, err := slackTime(sm.TS)
+			if err != nil {
+				im.report.skip("messages with invalid timestamps", 1)
+				continue
+			}
+
+			m := models.Message{
+				SenderID:   sender.userID,
+				ReceiverID: receiver.userID,
+				Content:    slackText(sm.Text, names),
+				CreatedAt:  created,
+				ImportKey:  importKey(SourceSlack, dm.ID, sm.TS),
+			}
+			if sm.Subtype == "me_message" {
+				m.Format = models.FormatAction
+			}
+			if sm.Edited != nil {
+				if edited, err := slackTime(sm.Edited.TS); err == nil {
+					m.EditedAt = &edited
+				}
+			}
+			for _, f := range sm.Files {
+				url := f.Permalink
+				if url == "" {
+					url = f.URLPrivate
+				}
+				if url == "" {
+					continue
+				}
+				title := f.Title
+				if title == "" {
+					title = f.Name
+				}
+				m.Attachments = append(m.Attachments, models.Attachment{Title: title, URL: url})
+			}
+
+			if m.Content == "" && len(m.Attachments) == 0 {
+				im.report.skip("empty messages", 1)
+				continue
+			}
+			if err := im.add(m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readSlackJSON(files map[string]*zip.File, name string, v any) error {
+	f, ok := files[name]
+	if !ok {
+		return invalid("%s is missing from the export", name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := json.NewDecoder(rc).Decode(v); err != nil {
+		return invalid("%s: %v", name, err)
+	}
+	return nil
+}
+
+// slackTime parses a message timestamp such as "1705312800.000200".
+func slackTime(ts string) (time.Time, error) {
+	secs, micros, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var us int64
+	if micros != "" {
+		if us, err = strconv.ParseInt((micros + "000000")[:6], 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(s, us*1000).UTC(), nil
+}
+
+// slackText turns Slack's markup into plain text: user references become
+// @names and links show their URL.
+func slackText(text string, names map[string]string) string {
+	text = slackMention.ReplaceAllStringFunc(text, func(s string) string {
+		id := slackMention.FindStringSubmatch(s)[1]
+		if name, ok := names[id]; ok {
+			return "@" + name
+		}
+		return "@" + id
+	})
+	text = slackLink.ReplaceAllStringFunc(text, func(s string) string {
+		m := slackLink.FindStringSubmatch(s)
+		if m[2] == "" || m[2] == m[1] {
+			return m[1]
+		}
+		if m[2] == strings.TrimPrefix(m[1], "mailto:") {
+			return m[2]
+		}
+		return m[2] + " (" + m[1] + ")"
+	})
+	text = slackSpecial.ReplaceAllString(text, "@$1")
+	return strings.TrimSpace(html.UnescapeString(text))
+}
@@ -0,0 +1,255 @@
+package importer
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// The start of a message line, in the layouts of Android
+// ("15/01/2024, 10:30 - Name: text") and iOS ("[15/01/2024, 10:30:45] Name:
+// text") exports. Continuation lines of multi-line messages don't match.
+var whatsAppLine = regexp.MustCompile(
+	`^\[?(\d{1,2})[/.-](\d{1,2})[/.-](\d{2,4}),?\s+(\d{1,2})[:.](\d{2})(?:[:.](\d{2}))?[\s\x{202f}\x{a0}]*([AaPp]\.?\s?[Mm]\.?)?\]?\s*(?:-\s+)?(.*)$`,
+)
+
+type whatsAppMessage struct {
+	day, month, year   int
+	hour, minute, secs int
+	pm, am             bool
+	sender             string
+	text               string
+}
+
+// ImportWhatsApp imports a WhatsApp chat exported as text ("Export chat",
+// without media). Only chats between two people can be imported.
+func ImportWhatsApp(db *gorm.DB, r io.Reader, opts Options) (*Report, error) {
+	loc := time.UTC
+	if opts.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(opts.Timezone); err != nil {
+			return nil, invalid("unknown timezone %q", opts.Timezone)
+		}
+	}
+
+	im := newImporter(db, SourceWhatsApp, opts)
+
+	messages, system, err := parseWhatsApp(r)
+	if err != nil {
+		return nil, err
+	}
+	im.report.skip("system messages", system)
+	if len(messages) == 0 {
+		return nil, invalid("no messages found; is this a WhatsApp chat export?")
+	}
+
+	dayFirst, err := whatsAppDayFirst(messages, opts.DateOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lines like "Alice changed the group name" have no sender, but notices
+	// such as "Alice changed the subject to: X" look like messages. The two
+	// people who wrote the most are taken to be the participants.
+	counts := map[string]int{}
+	for _, m := range messages {
+		counts[m.sender]++
+	}
+	senders := make([]string, 0, len(counts))
+	for name := range counts {
+		senders = append(senders, name)
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		if counts[senders[i]] != counts[senders[j]] {
+			return counts[senders[i]] > counts[senders[j]]
+		}
+		return senders[i] < senders[j]
+	})
+	if len(senders) < 2 {
+		return nil, invalid("the chat needs messages from two people")
+	}
+	if len(senders) > 2 && counts[senders[2]]*10 > len(messages) {
+		return nil, invalid("group chats cannot be imported")
+	}
+
+	participants := map[string]*participant{}
+	for _, name := range senders[:2] {
+		p := &participant{name: name}
+		if err := im.resolve(p); err != nil {
+			return nil, err
+		}
+		participants[name] = p
+	}
+	a, b := participants[senders[0]], participants[senders[1]]
+	if !a.mapped || !b.mapped {
+		im.report.skip("conversations with unmapped participants", 1)
+		return im.report, nil
+	}
+	im.report.Conversations = 1
+
+	// The export has no message IDs. A message is identified by the two
+	// users, its time, sender and text, and how many identical messages
+	// came before it, so the same chat exported again, even from the other
+	// phone, produces the same keys.
+	ids := []string{a.userID.String(), b.userID.String()}
+	sort.Strings(ids)
+	seen := map[string]int{}
+
+	for _, m := range messages {
+		sender := participants[m.sender]
+		if sender == nil {
+			im.report.skip("system messages", 1)
+			continue
+		}
+		receiver := a
+		if sender == a {
+			receiver = b
+		}
+
+		created, err := m.time(dayFirst, loc)
+		if err != nil {
+			im.report.skip("messages with invalid timestamps", 1)
+			continue
+		}
+
+		id := strings.Join([]string{ids[0], ids[1], created.Format(time.RFC3339), sender.userID.String(), m.text}, "\x00")
+		seen[id]++
+		sum := sha256.Sum256([]byte(id + "\x00" + strconv.Itoa(seen[id])))
+
+		err = im.add(models.Message{
+			SenderID:   sender.userID,
+			ReceiverID: receiver.userID,
+			Content:    m.text,
+			CreatedAt:  created,
+			ImportKey:  importKey(SourceWhatsApp, hex.EncodeToString(sum[:])),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := im.flush(); err != nil {
+		return nil, err
+	}
+	return im.report, nil
+}
+
+// parseWhatsApp splits the export into messages and counts the lines that
+// are notices rather than messages.
+func parseWhatsApp(r io.Reader) ([]whatsAppMessage, int, error) {
+	var messages []whatsAppMessage
+	system := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	continued := false
+	for scanner.Scan() {
+		// iOS exports mark some lines with invisible direction marks
+		line := strings.TrimLeft(scanner.Text(), "\ufeff\u200e\u200f")
+
+		match := whatsAppLine.FindStringSubmatch(line)
+		if match == nil {
+			// A further line of the previous message
+			if continued {
+				messages[len(messages)-1].text += "\n" + line
+			}
+			continue
+		}
+
+		sender, text, ok := strings.Cut(match[8], ": ")
+		if !ok {
+			system++
+			continued = false
+			continue
+		}
+
+		m := whatsAppMessage{
+			sender: strings.TrimSpace(sender),
+			text:   strings.TrimLeft(text, "\u200e"),
+		}
+		m.day, _ = strconv.Atoi(match[1])
+		m.month, _ = strconv.Atoi(match[2])
+		m.year, _ = strconv.Atoi(match[3])
+		m.hour, _ = strconv.Atoi(match[4])
+		m.minute, _ = strconv.Atoi(match[5])
+		m.secs, _ = strconv.Atoi(match[6])
+		if ampm := strings.ToLower(match[7]); ampm != "" {
+			m.pm = ampm[0] == 'p'
+			m.am = !m.pm
+		}
+		if m.year < 100 {
+			m.year += 2000
+		}
+
+		messages = append(messages, m)
+		continued = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	for i := range messages {
+		messages[i].text = strings.TrimSpace(messages[i].text)
+	}
+	return messages, system, nil
+}
+
+// whatsAppDayFirst reports whether dates are day/month/year. The export
+// follows the phone's locale, so unless the order is given it is inferred
+// from a date that only fits one way.
+func whatsAppDayFirst(messages []whatsAppMessage, order string) (bool, error) {
+	switch order {
+	case "dmy":
+		return true, nil
+	case "mdy":
+		return false, nil
+	case "":
+	default:
+		return false, invalid(`date order must be "dmy" or "mdy"`)
+	}
+
+	for _, m := range messages {
+		if m.day > 12 {
+			return true, nil
+		}
+		if m.month > 12 {
+			return false, nil
+		}
+	}
+	return false, invalid(`cannot tell the date order from the export; set it to "dmy" or "mdy"`)
+}
+
+func (m *whatsAppMessage) time(dayFirst bool, loc *time.Location) (time.Time, error) {
+	day, month := m.day, m.month
+	if !dayFirst {
+		day, month = month, day
+	}
+
+	hour := m.hour
+	if m.pm || m.am {
+		if hour < 1 || hour > 12 {
+			return time.Time{}, errors.New("invalid hour")
+		}
+		hour %= 12
+		if m.pm {
+			hour += 12
+		}
+	}
+
+	t := time.Date(m.year, time.Month(month), day, hour, m.minute, m.secs, 0, loc)
+	if t.Day() != day || int(t.Month()) != month || t.Hour() != hour || t.Minute() != m.minute {
+		return time.Time{}, errors.New("invalid date")
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,147 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timeout bounds the whole SMTP conversation for one message.
+const Timeout = 30 * time.Second
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+
+	// Extra headers, such as List-Unsubscribe
+	Headers map[string]string
+}
+
+// Mailer sends email.
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// SMTPMailer sends email through an SMTP relay. STARTTLS is used when the
+// server offers it, and credentials are only sent over TLS or to localhost.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+
+	// Sender address, optionally with a display name:
+	// "Chat <noreply@example.com>"
+	From string
+}
+
+func (m *SMTPMailer) Send(msg *Message) error {
+	from, err := netmail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("mail: invalid sender: %w", err)
+	}
+
+	body, err := m.render(msg, from)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	conn, err := net.DialTimeout("tcp", addr, Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(Timeout))
+
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (m *SMTPMailer) render(msg *Message, from *netmail.Address) ([]byte, error) {
+	headers := [][2]string{
+		{"From", from.String()},
+		{"To", msg.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID(from.Address)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	for k, v := range msg.Headers {
+		headers = append(headers, [2]string{k, v})
+	}
+
+	var buf bytes.Buffer
+	for _, h := range headers {
+		if strings.ContainsAny(h[0]+h[1], "\r\n") {
+			return nil, fmt.Errorf("mail: invalid %s header", h[0])
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", h[0], h[1])
+	}
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	text := strings.ReplaceAll(msg.Text, "\r\n", "\n")
+	if _, err := qp.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func messageID(fromAddress string) string {
+	domain := fromAddress[strings.LastIndex(fromAddress, "@")+1:]
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return "<" + hex.EncodeToString(buf) + "@" + domain + ">"
+}
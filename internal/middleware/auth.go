@@ -6,13 +6,16 @@ import (
 	"strings"
 
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
+	"gorm.io/gorm"
 )
 
 type contextKey string
 
 const UserIDKey contextKey = "userID"
 
-func JWTAuth(secret string) func(http.Handler) http.Handler {
+const SessionIDKey contextKey = "sessionID"
+
+func JWTAuth(db *gorm.DB, secret string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -28,14 +31,20 @@ func JWTAuth(secret string) func(http.Handler) http.Handler {
 				return
 			}
 
-			userID, err := auth.ParseJWT(parts[1], secret)
+			claims, err := auth.ParseJWT(parts[1], secret)
 			if err != nil {
 				http.Error(w, "invalid token", http.StatusUnauthorized)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			if err := auth.ValidateSession(db, claims); err != nil {
+				http.Error(w, "session revoked", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
-}
\ No newline at end of file
+}
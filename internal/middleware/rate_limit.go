@@ -3,6 +3,8 @@ package middleware
 import (
 	"net/http"
 
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/clientip"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/ratelimit"
 	"github.com/google/uuid"
 )
@@ -17,6 +19,12 @@ func RateLimit(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
 				return
 			}
 
+			// API keys carry their own limit, applied by APIKeyOrJWTAuth
+			if _, isKey := r.Context().Value(APIKeyKey).(*models.APIKey); isKey {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			if !limiter.Allow(userID.String()) {
 				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
 				return
@@ -26,3 +34,19 @@ func RateLimit(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// RateLimitByIP limits requests per client IP. It is meant for endpoints
+// that are reachable before authentication.
+func RateLimitByIP(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if !limiter.Allow("ip:" + clientip.FromRequest(r)) {
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
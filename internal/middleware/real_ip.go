@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP rewrites r.RemoteAddr to the client address reported by trusted
+// reverse proxies. trustedHops is the number of proxies in front of the
+// server that append to X-Forwarded-For; with 0 the header is ignored, since
+// any client can set it.
+func RealIP(trustedHops int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if trustedHops > 0 {
+				if ip := forwardedFor(r, trustedHops); ip != "" {
+					r.RemoteAddr = net.JoinHostPort(ip, "0")
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedFor returns the address the outermost trusted proxy saw. Entries
+// further left were supplied by the client and cannot be trusted.
+func forwardedFor(r *http.Request, trustedHops int) string {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		for _, part := range strings.Split(h, ",") {
+			hops = append(hops, strings.TrimSpace(part))
+		}
+	}
+
+	if len(hops) < trustedHops {
+		return ""
+	}
+
+	ip := hops[len(hops)-trustedHops]
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	return ip
+}
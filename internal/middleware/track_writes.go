@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+)
+
+// TrackWrites tells reads that the authenticated user may have changed
+// something, after every request that is not a GET, HEAD or OPTIONS, so
+// that their next reads skip the replicas and see it. It must run after
+// authentication.
+func TrackWrites(reads *store.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return
+			}
+			if userID, ok := r.Context().Value(UserIDKey).(uuid.UUID); ok {
+				reads.Wrote(userID)
+			}
+		})
+	}
+}
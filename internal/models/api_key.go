@@ -0,0 +1,34 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey is a long-lived credential for a bot account. Only a hash of the
+// key is stored; the key itself is shown once, when it is created.
+type APIKey struct {
+	ID     uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	BotID  uuid.UUID `gorm:"type:uuid;not null;index" json:"bot_id"`
+	Name   string    `gorm:"not null" json:"name"`
+	Prefix string    `gorm:"not null" json:"prefix"`
+
+	KeyHash string `gorm:"not null;uniqueIndex" json:"-"`
+
+	// Space-separated list of scopes, see auth.ScopeAllows
+	Scopes string `gorm:"type:text;not null" json:"-"`
+
+	// Requests per minute
+	RateLimit int `gorm:"not null" json:"rate_limit"`
+
+	CreatedAt  time.Time  `json:"created"`
+	LastUsedAt *time.Time `json:"last_used,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"-"`
+}
+
+func (k *APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
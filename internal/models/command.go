@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SlashCommand is a command registered by an admin that is answered by an
+// external HTTP endpoint. Built-in commands are not stored.
+type SlashCommand struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	Name        string    `gorm:"not null;uniqueIndex" json:"name"`
+	Description string    `gorm:"not null" json:"description"`
+	Usage       string    `gorm:"not null" json:"usage"`
+	URL         string    `gorm:"not null" json:"url"`
+
+	// Key for the HMAC signature on each call
+	Secret string `gorm:"not null" json:"-"`
+
+	CreatedBy uuid.UUID `gorm:"type:uuid;not null" json:"created_by"`
+	CreatedAt time.Time `json:"created"`
+}
+
+// Reminder is scheduled with /remind and shown to the user when due.
+type Reminder struct {
+	ID      uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID  uuid.UUID `gorm:"type:uuid;not null" json:"user_id"`
+	OtherID uuid.UUID `gorm:"type:uuid;not null" json:"conversation_user_id"`
+	Text    string    `gorm:"type:text;not null" json:"text"`
+	DueAt   time.Time `gorm:"not null;index:idx_reminders_due" json:"due_at"`
+
+	DeliveredAt *time.Time `gorm:"index:idx_reminders_due" json:"delivered_at,omitempty"`
+	CreatedAt   time.Time  `json:"created"`
+}
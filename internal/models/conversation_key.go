@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ConversationKey is a data key that message content of the conversation
+// between UserA and UserB is encrypted with, itself encrypted by the master
+// key MasterKeyID. UserA is the lower of the two IDs. Retired keys are no
+// longer used for new messages but still decrypt old ones.
+type ConversationKey struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserA       uuid.UUID `gorm:"type:uuid;not null"`
+	UserB       uuid.UUID `gorm:"type:uuid;not null"`
+	MasterKeyID string    `gorm:"not null;index"`
+	WrappedKey  []byte    `gorm:"not null"`
+	CreatedAt   time.Time
+	RetiredAt   *time.Time
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Which messages in a conversation notify the user
+const (
+	NotifyAll      = "all"
+	NotifyMentions = "mentions"
+	NotifyNone     = "none"
+)
+
+// ConversationSetting holds one user's preferences for their conversation
+// with another user. A missing row means the defaults.
+type ConversationSetting struct {
+	UserID  uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	OtherID uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+
+	// Muted with no MutedUntil means muted until unmuted
+	Muted      bool       `gorm:"default:false" json:"muted"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+
+	Notify string `gorm:"not null;default:all" json:"notify"`
+
+	UpdatedAt time.Time `json:"updated"`
+}
+
+// IsMuted reports whether the conversation is muted at t.
+func (s *ConversationSetting) IsMuted(t time.Time) bool {
+	return s.Muted && (s.MutedUntil == nil || t.Before(*s.MutedUntil))
+}
+
+// NotificationPreference holds a user's settings that apply to all of their
+// conversations. A missing row means no quiet hours and email digests on.
+type NotificationPreference struct {
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey"`
+
+	// Quiet hours run from QuietStart to QuietEnd, in minutes after midnight
+	// in Timezone, and may wrap past midnight
+	QuietHoursEnabled bool   `gorm:"default:false"`
+	QuietStart        int    `gorm:"not null;default:0"`
+	QuietEnd          int    `gorm:"not null;default:0"`
+	Timezone          string `gorm:"not null;default:UTC"`
+
+	// Opted out of unread-message email digests
+	EmailDigestDisabled bool `gorm:"default:false"`
+
+	UpdatedAt time.Time
+}
+
+// InQuietHours reports whether t falls inside the quiet hours.
+func (p *NotificationPreference) InQuietHours(t time.Time) bool {
+	if !p.QuietHoursEnabled || p.QuietStart == p.QuietEnd {
+		return false
+	}
+
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	if p.QuietStart < p.QuietEnd {
+		return minute >= p.QuietStart && minute < p.QuietEnd
+	}
+	return minute >= p.QuietStart || minute < p.QuietEnd
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ConversationSummary is what the conversation list shows of a conversation,
+// from the point of view of one of its participants. Rows are kept up to
+// date by the transactions that change messages, so listing conversations
+// never aggregates messages. A conversation whose messages are all deleted
+// has no rows.
+type ConversationSummary struct {
+	UserID  uuid.UUID `gorm:"type:uuid;primaryKey"`
+	OtherID uuid.UUID `gorm:"type:uuid;primaryKey"`
+
+	// Newest message that is not deleted, and when it was sent
+	LastMessageID *uuid.UUID `gorm:"type:uuid"`
+	LastActivity  time.Time  `gorm:"not null"`
+
+	// Unread messages from the other user, and how many of them mention
+	// the user
+	UnreadCount    int64 `gorm:"not null;default:0"`
+	UnreadMentions int64 `gorm:"not null;default:0"`
+
+	Other       User     `gorm:"foreignKey:OtherID"`
+	LastMessage *Message `gorm:"foreignKey:LastMessageID"`
+}
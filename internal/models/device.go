@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Device is one install of a client that takes part in end-to-end
+// encryption. The server keeps only its public keys, which it cannot check:
+// clients verify the signed prekey against the identity key themselves.
+type Device struct {
+	ID     uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name   string    `json:"name"`
+
+	IdentityKey  string       `gorm:"not null" json:"identity_key"`
+	SignedPreKey SignedPreKey `gorm:"embedded;embeddedPrefix:signed_prekey_" json:"signed_prekey"`
+
+	CreatedAt time.Time `json:"created"`
+	UpdatedAt time.Time `json:"updated"`
+}
+
+// SignedPreKey is a medium-term key of a device, signed with its identity
+// key.
+type SignedPreKey struct {
+	KeyID     int64  `gorm:"not null" json:"key_id"`
+	PublicKey string `gorm:"not null" json:"public_key"`
+	Signature string `gorm:"not null" json:"signature"`
+}
+
+// PreKey is a one-time prekey of a device. Each is handed out once, to the
+// first user who fetches the device's keys, and deleted.
+type PreKey struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	DeviceID  uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_pre_keys_device_key" json:"-"`
+	KeyID     int64     `gorm:"not null;uniqueIndex:idx_pre_keys_device_key" json:"key_id"`
+	PublicKey string    `gorm:"not null" json:"public_key"`
+	CreatedAt time.Time `json:"-"`
+}
+
+// Envelope is an end-to-end encrypted message for one device of the
+// recipient. The server relays the ciphertext without reading it, and keeps
+// it only until the device acknowledges it.
+type Envelope struct {
+	ID                uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	SenderID          uuid.UUID `gorm:"type:uuid;not null;index" json:"from"`
+	SenderDeviceID    uuid.UUID `gorm:"type:uuid;not null" json:"from_device"`
+	RecipientID       uuid.UUID `gorm:"type:uuid;not null;index" json:"to"`
+	RecipientDeviceID uuid.UUID `gorm:"type:uuid;not null;index:idx_envelopes_recipient_device" json:"device_id"`
+
+	// Chosen by clients, typically telling a prekey message from a
+	// message in an established session
+	CiphertextType int    `gorm:"not null" json:"ciphertext_type"`
+	Ciphertext     string `gorm:"type:text;not null" json:"ciphertext"`
+
+	CreatedAt time.Time `gorm:"not null;index:idx_envelopes_recipient_device" json:"created"`
+}
+
+// EncryptedContact records that UserID sent encrypted messages to OtherID or
+// received them from OtherID, so that each is told when the other's identity
+// keys change, long after the envelopes are gone. There is a row for each
+// direction.
+type EncryptedContact struct {
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	OtherID   uuid.UUID `gorm:"type:uuid;primaryKey;index"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Digest states
+const (
+	DigestPending = "pending"
+	DigestSent    = "sent"
+)
+
+// Digest is an email listing a user's unread messages. It is recorded as
+// pending before the email goes out, and SentAt is when it was claimed
+// until it is marked sent.
+type Digest struct {
+	ID           uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID       uuid.UUID `gorm:"type:uuid;not null;index"`
+	MessageCount int       `gorm:"not null"`
+	Status       string    `gorm:"not null;default:sent"`
+	SentAt       time.Time `gorm:"not null"`
+}
+
+// DigestedMessage marks an unread message the digest job has dealt with, so
+// it is never considered again. DigestID is nil when the message was left
+// out because the recipient's notification settings silenced it.
+type DigestedMessage struct {
+	MessageID uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index"`
+	DigestID  *uuid.UUID `gorm:"type:uuid;index"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Export job states
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// ExportJob is a conversation export written to a file in the background,
+// for histories too long to stream in one request.
+type ExportJob struct {
+	ID      uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID  uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	OtherID uuid.UUID `gorm:"type:uuid;not null" json:"conversation_user_id"`
+
+	Format string     `gorm:"not null" json:"format"`
+	Since  *time.Time `json:"since,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+
+	Status    string     `gorm:"not null;index" json:"status"`
+	StartedAt *time.Time `json:"-"`
+	Error     string     `gorm:"type:text" json:"error,omitempty"`
+
+	// Set once the file is written
+	Size      int64      `json:"size,omitempty"`
+	ExpiresAt *time.Time `gorm:"index" json:"expires_at,omitempty"`
+
+	CreatedAt   time.Time  `json:"created"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IncomingWebhook lets an external tool post messages into one conversation
+// of its owner without a session. Messages are sent as the owner to UserID
+// and shown under Name. Only a hash of the token is stored.
+type IncomingWebhook struct {
+	ID      uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	OwnerID uuid.UUID `gorm:"type:uuid;not null;index" json:"owner_id"`
+	UserID  uuid.UUID `gorm:"type:uuid;not null" json:"user_id"`
+	Name    string    `gorm:"not null" json:"name"`
+	Prefix  string    `gorm:"not null" json:"prefix"`
+
+	TokenHash string `gorm:"not null;uniqueIndex" json:"-"`
+
+	// Messages per minute
+	RateLimit int `gorm:"not null" json:"rate_limit"`
+
+	CreatedAt  time.Time  `json:"created"`
+	LastUsedAt *time.Time `json:"last_used,omitempty"`
+	RevokedAt  *time.Time `json:"-"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// Mention is an @username in a message that resolved to a participant of
+// the conversation. Offset and Length are in characters and include the @.
+type Mention struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	MessageID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	Username  string    `gorm:"not null" json:"username"`
+	Offset    int       `gorm:"column:start_offset;not null" json:"offset"`
+	Length    int       `gorm:"not null" json:"length"`
+}
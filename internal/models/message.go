@@ -1,22 +1,85 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Message formats. Plain text, the format of every message sent by users, is
+// stored as the empty string. Action messages come from /me and are shown
+// as "<sender> <content>".
+const (
+	FormatPlain    = ""
+	FormatMarkdown = "markdown"
+	FormatAction   = "action"
+)
+
 type Message struct {
-	ID         uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey" json:"id"`
-	SenderID   uuid.UUID `gorm:"not null;index" json:"from"`
-	ReceiverID uuid.UUID `gorm:"not null;index" json:"to"`
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	SenderID   uuid.UUID `gorm:"type:uuid;not null;index" json:"from"`
+	ReceiverID uuid.UUID `gorm:"type:uuid;not null;index" json:"to"`
+
+	Content     string      `gorm:"type:text;not null" json:"content"`
+	Format      string      `gorm:"not null;default:''" json:"format,omitempty"`
+	Attachments Attachments `gorm:"type:text" json:"attachments,omitempty"`
+	Mentions    []Mention   `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE" json:"mentions,omitempty"`
+	IsBot       bool        `gorm:"default:false" json:"is_bot"`
+	IsDeleted   bool        `gorm:"default:false" json:"is_deleted"`
+	EditedAt    *time.Time  `json:"edited_at,omitempty"`
 
-	Content   string     `gorm:"type:text;not null" json:"content"`
-	IsDeleted bool       `gorm:"default:false" json:"is_deleted"`
-	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	// Shown instead of the sender's username, for messages posted through
+	// an incoming webhook
+	SenderName string `gorm:"not null;default:''" json:"sender_name,omitempty"`
 
 	IsRead bool       `gorm:"default:false" json:"is_read"`
 	ReadAt *time.Time `json:"read_at,omitempty"`
 
+	// Identifies a message imported from another chat tool, so importing
+	// the same export twice does not duplicate it
+	ImportKey *string `gorm:"uniqueIndex" json:"-"`
+
+	// The conversation key the stored content is encrypted with; nil while
+	// the content is plaintext, as it always is once loaded
+	KeyID *uuid.UUID `gorm:"type:uuid;index" json:"-"`
+
 	CreatedAt time.Time `json:"timestamp"`
 }
+
+// Attachment is a link shown below the text of a message.
+type Attachment struct {
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url"`
+	Text  string `json:"text,omitempty"`
+}
+
+// Attachments is stored as a JSON array.
+type Attachments []Attachment
+
+func (a Attachments) Value() (driver.Value, error) {
+	if len(a) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (a *Attachments) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), a)
+	case []byte:
+		return json.Unmarshal(v, a)
+	default:
+		return fmt.Errorf("cannot scan %T into Attachments", src)
+	}
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageArchive is a file holding the messages of one month that were
+// moved out of the database. A month archived again, after more messages
+// were imported into it, has several.
+type MessageArchive struct {
+	ID uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+
+	// Month the messages were sent in, as 2006-01
+	Month string `gorm:"not null;index" json:"month"`
+
+	// Name of the file in the archive storage
+	Object string `gorm:"not null" json:"object"`
+
+	Messages int64 `gorm:"not null" json:"messages"`
+	Size     int64 `gorm:"not null" json:"size"`
+
+	ArchivedAt time.Time  `gorm:"not null" json:"archived_at"`
+	RestoredAt *time.Time `json:"restored_at,omitempty"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PushSubscription is a browser's Web Push subscription for one device. It
+// is tied to the session that created it and removed when that session is
+// revoked.
+type PushSubscription struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"-"`
+	SessionID *uuid.UUID `gorm:"type:uuid;index" json:"-"`
+
+	Endpoint string `gorm:"type:text;not null;uniqueIndex" json:"endpoint"`
+	P256dh   string `gorm:"not null" json:"-"`
+	Auth     string `gorm:"not null" json:"-"`
+
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created"`
+	LastUsedAt *time.Time `json:"last_used,omitempty"`
+}
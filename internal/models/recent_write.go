@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RecentWrite is when a user's data last changed. Reads routed to replicas
+// go to the primary for a while after, on every instance.
+type RecentWrite struct {
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	WrittenAt time.Time `gorm:"not null;index"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a single login. Every JWT carries the ID of the session it was
+// issued for, so revoking the session invalidates the token.
+type Session struct {
+	ID     uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+
+	DeviceName string `json:"device_name"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+
+	CreatedAt  time.Time  `json:"created"`
+	LastUsedAt time.Time  `json:"last_used"`
+	RevokedAt  *time.Time `json:"-"`
+}
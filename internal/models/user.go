@@ -7,11 +7,37 @@ import (
 )
 
 type User struct {
-	ID           uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	ID           uuid.UUID `gorm:"type:uuid;primaryKey"`
 	Username     string    `gorm:"unique;not null"`
 	Email        string    `gorm:"unique;not null"`
 	PasswordHash string    `gorm:"not null"`
+	IsAdmin      bool      `gorm:"default:false"`
+
+	// Bot accounts cannot log in; they authenticate with API keys issued
+	// by the user that owns them
+	IsBot   bool       `gorm:"default:false"`
+	OwnerID *uuid.UUID `gorm:"type:uuid;index"`
+
+	// Login throttling state
+	FailedLoginCount  int `gorm:"default:0"`
+	LastFailedLoginAt *time.Time
+	LockedUntil       *time.Time
+
+	// Set when the account is deleted. The row is kept, anonymized, so
+	// messages that survive the deletion still have a sender.
+	DeletedAt *time.Time
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
+
+// DeletedUsername is shown in place of the username of deleted accounts.
+const DeletedUsername = "Deleted user"
+
+// DisplayName is the name other users should see for this account.
+func (u *User) DisplayName() string {
+	if u.DeletedAt != nil {
+		return DeletedUsername
+	}
+	return u.Username
+}
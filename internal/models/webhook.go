@@ -0,0 +1,69 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookSubscription asks for events to be POSTed to an external URL. A
+// user's subscription only receives events from their own conversations;
+// AllUsers subscriptions, which only admins can create, receive every event.
+type WebhookSubscription struct {
+	ID      uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	OwnerID uuid.UUID `gorm:"type:uuid;not null;index" json:"owner_id"`
+	URL     string    `gorm:"not null" json:"url"`
+
+	// Key for the HMAC signature on each delivery
+	Secret string `gorm:"not null" json:"-"`
+
+	// Space-separated event names
+	Events   string `gorm:"type:text;not null" json:"-"`
+	AllUsers bool   `gorm:"default:false" json:"all_users"`
+
+	CreatedAt time.Time  `json:"created"`
+	DeletedAt *time.Time `gorm:"index" json:"-"`
+}
+
+func (s *WebhookSubscription) EventList() []string {
+	return strings.Fields(s.Events)
+}
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookDelivery is one event queued for one subscription, and doubles as
+// the delivery log.
+type WebhookDelivery struct {
+	ID             uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	SubscriptionID uuid.UUID `gorm:"type:uuid;not null;index" json:"subscription_id"`
+	Event          string    `gorm:"not null" json:"event"`
+	Payload        string    `gorm:"type:text;not null" json:"-"`
+
+	Status         string    `gorm:"not null;index:idx_webhook_deliveries_due,priority:1" json:"status"`
+	Attempts       int       `gorm:"default:0" json:"attempts"`
+	NextAttemptAt  time.Time `gorm:"index:idx_webhook_deliveries_due,priority:2" json:"next_attempt_at"`
+	LastStatusCode int       `json:"last_status_code,omitempty"`
+	LastError      string    `gorm:"type:text" json:"last_error,omitempty"`
+
+	CreatedAt   time.Time  `json:"created"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// WebhookDeadLetter keeps a copy of a delivery that exhausted its retries.
+type WebhookDeadLetter struct {
+	ID             uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	DeliveryID     uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"delivery_id"`
+	SubscriptionID uuid.UUID `gorm:"type:uuid;not null;index" json:"subscription_id"`
+	Event          string    `gorm:"not null" json:"event"`
+	Payload        string    `gorm:"type:text;not null" json:"payload"`
+	Attempts       int       `json:"attempts"`
+	LastError      string    `gorm:"type:text" json:"last_error"`
+
+	CreatedAt time.Time `json:"created"`
+}
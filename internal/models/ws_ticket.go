@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WSTicket is a single-use ticket for opening a WebSocket, stored so that
+// any instance can redeem it. Only the hash of the ticket is kept.
+type WSTicket struct {
+	TicketHash string    `gorm:"primaryKey"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null"`
+	SessionID  uuid.UUID `gorm:"type:uuid;not null"`
+	IP         string    `gorm:"not null;default:''"`
+	ExpiresAt  time.Time `gorm:"not null;index"`
+}
@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ConversationSetting returns the user's settings for their conversation
+// with otherID, or the defaults if they never changed them.
+func ConversationSetting(db *gorm.DB, userID, otherID uuid.UUID) (models.ConversationSetting, error) {
+	setting := models.ConversationSetting{
+		UserID:  userID,
+		OtherID: otherID,
+		Notify:  models.NotifyAll,
+	}
+
+	err := db.First(&setting, "user_id = ? AND other_id = ?", userID, otherID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return setting, nil
+	}
+	return setting, err
+}
+
+// ConversationSettings returns the user's settings for their conversations
+// with each of otherIDs, in one query.
+func ConversationSettings(db *gorm.DB, userID uuid.UUID, otherIDs []uuid.UUID) (map[uuid.UUID]models.ConversationSetting, error) {
+	settings := make(map[uuid.UUID]models.ConversationSetting, len(otherIDs))
+	for _, id := range otherIDs {
+		settings[id] = models.ConversationSetting{
+			UserID:  userID,
+			OtherID: id,
+			Notify:  models.NotifyAll,
+		}
+	}
+	if len(otherIDs) == 0 {
+		return settings, nil
+	}
+
+	var stored []models.ConversationSetting
+	if err := db.Where("user_id = ? AND other_id IN ?", userID, otherIDs).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	for _, s := range stored {
+		settings[s.OtherID] = s
+	}
+	return settings, nil
+}
+
+// Preference returns the user's global notification settings, or the
+// defaults if they never changed them.
+func Preference(db *gorm.DB, userID uuid.UUID) (models.NotificationPreference, error) {
+	pref := models.NotificationPreference{
+		UserID:   userID,
+		Timezone: "UTC",
+	}
+
+	err := db.First(&pref, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pref, nil
+	}
+	return pref, err
+}
+
+// Allowed reports whether userID wants to be notified at t about a message
+// in their conversation with otherID; mentioned says whether the message
+// mentions them. Every notification path asks it.
+func Allowed(db *gorm.DB, userID, otherID uuid.UUID, mentioned bool, t time.Time) (bool, error) {
+	setting, err := ConversationSetting(db, userID, otherID)
+	if err != nil {
+		return false, err
+	}
+
+	if setting.IsMuted(t) {
+		return false, nil
+	}
+	switch setting.Notify {
+	case models.NotifyNone:
+		return false, nil
+	case models.NotifyMentions:
+		if !mentioned {
+			return false, nil
+		}
+	}
+
+	pref, err := Preference(db, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return !pref.InQuietHours(t), nil
+}
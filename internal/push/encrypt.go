@@ -0,0 +1,126 @@
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+// recordSize is the aes128gcm record size advertised in the header. Push
+// payloads are a single record, so it only has to exceed the payload.
+const recordSize = 4096
+
+// MaxPayload is the largest plaintext that fits in one push message.
+const MaxPayload = 3000
+
+var ErrInvalidKeys = errors.New("invalid subscription keys")
+
+// decodeKey accepts both padded and unpadded base64url, as browsers differ.
+func decodeKey(s string) ([]byte, error) {
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
+
+// ValidateKeys checks the keys a browser reported for a subscription.
+func ValidateKeys(p256dh, auth string) error {
+	pub, err := decodeKey(p256dh)
+	if err != nil {
+		return ErrInvalidKeys
+	}
+	if _, err := ecdh.P256().NewPublicKey(pub); err != nil {
+		return ErrInvalidKeys
+	}
+	secret, err := decodeKey(auth)
+	if err != nil || len(secret) != 16 {
+		return ErrInvalidKeys
+	}
+	return nil
+}
+
+// Encrypt encrypts payload for a subscription with the aes128gcm content
+// encoding of RFC 8291. p256dh and auth are the subscription's keys as the
+// browser reported them.
+func Encrypt(payload []byte, p256dh, auth string) ([]byte, error) {
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return encrypt(payload, p256dh, auth, asPrivate, salt)
+}
+
+// encrypt is Encrypt with the server's key pair and the salt given, which
+// are random for every message.
+func encrypt(payload []byte, p256dh, auth string, asPrivate *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	if len(payload) > MaxPayload {
+		return nil, errors.New("push payload too large")
+	}
+
+	uaPublicRaw, err := decodeKey(p256dh)
+	if err != nil {
+		return nil, ErrInvalidKeys
+	}
+	authSecret, err := decodeKey(auth)
+	if err != nil || len(authSecret) != 16 {
+		return nil, ErrInvalidKeys
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicRaw)
+	if err != nil {
+		return nil, ErrInvalidKeys
+	}
+
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	sharedSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	// Combine the shared secret with the subscription's auth secret
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublicRaw...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm, err := hkdf.Key(sha256.New, sharedSecret, authSecret, string(keyInfo), 32)
+	if err != nil {
+		return nil, err
+	}
+
+	cek, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single, final record: the payload followed by the 0x02 delimiter
+	plaintext := append(append([]byte{}, payload...), 0x02)
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
@@ -0,0 +1,3 @@
+package push
+
+var EncryptWith = encrypt
@@ -0,0 +1,179 @@
+package push
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/events"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/notify"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// How long the push service keeps a message for an unreachable device
+	messageTTL = 24 * time.Hour
+
+	// Longest message preview, in bytes, so the payload stays under
+	// MaxPayload
+	maxPreview = 1000
+)
+
+// Notifier sends a Web Push notification when a message is saved for a user
+// who has no live connection.
+type Notifier struct {
+	DB     *gorm.DB
+	Client *http.Client
+	VAPID  *VAPID
+
+	// Online reports whether the user has a live connection, in which case
+	// the message reaches them over the socket
+	Online func(userID string) bool
+}
+
+// Emit implements events.Sink. Pushes are sent in the background so saving
+// a message never waits for a push service.
+func (n *Notifier) Emit(event string, _ []uuid.UUID, data any) {
+	if event != events.MessageCreated {
+		return
+	}
+
+	fields, ok := data.(map[string]any)
+	if !ok {
+		return
+	}
+	from, _ := fields["from"].(uuid.UUID)
+	to, _ := fields["to"].(uuid.UUID)
+	if from == uuid.Nil || to == uuid.Nil || from == to {
+		return
+	}
+
+	go n.notify(to, from, fields)
+}
+
+func (n *Notifier) notify(userID, fromID uuid.UUID, message map[string]any) {
+	if n.Online(userID.String()) {
+		return
+	}
+
+	mentions, _ := message["mentions"].([]models.Mention)
+	mentioned := slices.ContainsFunc(mentions, func(m models.Mention) bool {
+		return m.UserID == userID
+	})
+
+	allowed, err := notify.Allowed(n.DB, userID, fromID, mentioned, time.Now())
+	if err != nil {
+		log.Println("push: failed to load preferences:", err)
+		return
+	}
+	if !allowed {
+		return
+	}
+
+	var subs []models.PushSubscription
+	if err := n.DB.Where("user_id = ?", userID).Find(&subs).Error; err != nil {
+		log.Println("push: failed to load subscriptions:", err)
+		return
+	}
+	if len(subs) == 0 {
+		return
+	}
+
+	payload, err := n.payload(fromID, message)
+	if err != nil {
+		log.Println("push: failed to build payload:", err)
+		return
+	}
+
+	for i := range subs {
+		n.send(&subs[i], payload)
+	}
+}
+
+// payload is the JSON the service worker receives.
+func (n *Notifier) payload(fromID uuid.UUID, message map[string]any) ([]byte, error) {
+	senderName, _ := message["sender_name"].(string)
+	if senderName == "" {
+		var sender models.User
+		if err := n.DB.First(&sender, "id = ?", fromID).Error; err != nil {
+			return nil, err
+		}
+		senderName = sender.DisplayName()
+	}
+
+	content, _ := message["content"].(string)
+	if len(content) > maxPreview {
+		cut := maxPreview
+		for cut > 0 && !utf8.RuneStart(content[cut]) {
+			cut--
+		}
+		content = content[:cut] + "…"
+	}
+
+	return json.Marshal(map[string]any{
+		"type":            "direct_message",
+		"id":              message["id"],
+		"from":            fromID,
+		"sender_username": senderName,
+		"content":         content,
+		"timestamp":       message["timestamp"],
+	})
+}
+
+// send delivers one push. Subscriptions the push service no longer knows
+// are deleted.
+func (n *Notifier) send(sub *models.PushSubscription, payload []byte) {
+	status, err := n.post(sub, payload)
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		n.DB.Delete(&models.PushSubscription{}, "id = ?", sub.ID)
+	case err != nil:
+		log.Printf("push: delivery to subscription %s failed: %v", sub.ID, err)
+	default:
+		n.DB.Model(&models.PushSubscription{}).
+			Where("id = ?", sub.ID).
+			Update("last_used_at", time.Now())
+	}
+}
+
+func (n *Notifier) post(sub *models.PushSubscription, payload []byte) (int, error) {
+	body, err := Encrypt(payload, sub.P256dh, sub.Auth)
+	if err != nil {
+		return 0, err
+	}
+
+	authorization, err := n.VAPID.Authorization(sub.Endpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", fmt.Sprint(int(messageTTL.Seconds())))
+	req.Header.Set("Urgency", "high")
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("push service responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
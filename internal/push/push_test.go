@@ -0,0 +1,125 @@
+package push_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/push"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The example of RFC 8291, Appendix A
+const (
+	rfcPlaintext  = "When I grow up, I want to be a watermelon"
+	rfcASPrivate  = "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"
+	rfcUAPublic   = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	rfcAuthSecret = "BTBZMqHH6r4Tts7J_aSIgg"
+	rfcSalt       = "DGv6ra1nlYgDCS1FRnbzlw"
+	rfcMessage    = "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+)
+
+func decode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEncryptRFC8291(t *testing.T) {
+	asPrivate, err := ecdh.P256().NewPrivateKey(decode(t, rfcASPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := push.EncryptWith([]byte(rfcPlaintext), rfcUAPublic, rfcAuthSecret, asPrivate, decode(t, rfcSalt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := decode(t, rfcMessage); !bytes.Equal(got, want) {
+		t.Fatalf("message = %s, want %s", base64.RawURLEncoding.EncodeToString(got), rfcMessage)
+	}
+}
+
+func TestEncryptRejectsBadKeys(t *testing.T) {
+	if _, err := push.Encrypt([]byte("hi"), rfcUAPublic, "c2hvcnQ"); err != push.ErrInvalidKeys {
+		t.Fatalf("short auth secret: err = %v", err)
+	}
+	if _, err := push.Encrypt([]byte("hi"), "BAAA", rfcAuthSecret); err != push.ErrInvalidKeys {
+		t.Fatalf("bad public key: err = %v", err)
+	}
+	if _, err := push.Encrypt(make([]byte, push.MaxPayload+1), rfcUAPublic, rfcAuthSecret); err == nil {
+		t.Fatal("oversized payload was encrypted")
+	}
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := push.NewVAPID(base64.RawURLEncoding.EncodeToString(key.Bytes()), "mailto:ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := v.Authorization("https://push.example.net:8443/send/abc?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, k, ok := strings.Cut(strings.TrimPrefix(header, "vapid t="), ", k=")
+	if !ok || !strings.HasPrefix(header, "vapid t=") {
+		t.Fatalf("header = %q", header)
+	}
+	if k != v.PublicKey {
+		t.Fatalf("k = %q, want %q", k, v.PublicKey)
+	}
+
+	// Verify with the public key as a push service gets it
+	pub := decode(t, k)
+	if len(pub) != 65 || pub[0] != 4 {
+		t.Fatalf("public key is not an uncompressed P-256 point")
+	}
+	verifyKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(pub[1:33]),
+		Y:     new(big.Int).SetBytes(pub[33:65]),
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return verifyKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aud, _ := claims.GetAudience(); len(aud) != 1 || aud[0] != "https://push.example.net:8443" {
+		t.Fatalf("aud = %v", aud)
+	}
+	if sub, _ := claims.GetSubject(); sub != "mailto:ops@example.com" {
+		t.Fatalf("sub = %q", sub)
+	}
+	exp, _ := claims.GetExpirationTime()
+	if until := time.Until(exp.Time); until <= 0 || until > 24*time.Hour {
+		t.Fatalf("token expires in %v", until)
+	}
+
+	// Another key must not verify it
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jwt.Parse(token, func(*jwt.Token) (any, error) { return &other.PublicKey, nil })
+	if err == nil {
+		t.Fatal("token verified with another key")
+	}
+}
@@ -0,0 +1,82 @@
+package push
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// vapidTokenTTL is how long the VAPID token on a push request is valid;
+// push services reject anything over 24 hours.
+const vapidTokenTTL = 12 * time.Hour
+
+// VAPID identifies this server to push services (RFC 8292).
+type VAPID struct {
+	// Contact for the push service operator, a mailto: or https: URL
+	Subject string
+
+	// Uncompressed P-256 public key, base64url encoded. Browsers need it to
+	// subscribe.
+	PublicKey string
+
+	key *ecdsa.PrivateKey
+}
+
+// NewVAPID loads a key pair from the base64url encoded raw private key, the
+// format produced by common Web Push tooling.
+func NewVAPID(privateKey, subject string) (*VAPID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("vapid private key: %w", err)
+	}
+
+	priv, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("vapid private key: %w", err)
+	}
+	pub := priv.PublicKey().Bytes()
+
+	if subject == "" {
+		return nil, errors.New("vapid subject is required")
+	}
+
+	return &VAPID{
+		Subject:   subject,
+		PublicKey: base64.RawURLEncoding.EncodeToString(pub),
+		key: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(pub[1:33]),
+				Y:     new(big.Int).SetBytes(pub[33:65]),
+			},
+			D: new(big.Int).SetBytes(raw),
+		},
+	}, nil
+}
+
+// Authorization returns the Authorization header for a push to endpoint.
+func (v *VAPID) Authorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(vapidTokenTTL).Unix(),
+		"sub": v.Subject,
+	}).SignedString(v.key)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("vapid t=%s, k=%s", token, v.PublicKey), nil
+}
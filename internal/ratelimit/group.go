@@ -0,0 +1,35 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Group keeps a separate Limiter per key for callers whose limit is
+// configured individually, such as API keys.
+type Group struct {
+	mu       sync.Mutex
+	window   time.Duration
+	limiters map[string]*Limiter
+}
+
+func NewGroup(window time.Duration) *Group {
+	return &Group{
+		window:   window,
+		limiters: make(map[string]*Limiter),
+	}
+}
+
+// Allow applies limit requests per window to key. A changed limit takes
+// effect immediately with a fresh window.
+func (g *Group) Allow(key string, limit int) bool {
+	g.mu.Lock()
+	l, ok := g.limiters[key]
+	if !ok || l.limit != limit {
+		l = New(limit, g.window)
+		g.limiters[key] = l
+	}
+	g.mu.Unlock()
+
+	return l.Allow(key)
+}
@@ -6,17 +6,19 @@ import (
 )
 
 type Limiter struct {
-	mu       sync.Mutex
-	limit    int
-	window   time.Duration
-	requests map[string][]time.Time
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	requests  map[string][]time.Time
+	lastSweep time.Time
 }
 
 func New(limit int, window time.Duration) *Limiter {
 	return &Limiter{
-		limit:    limit,
-		window:   window,
-		requests: make(map[string][]time.Time),
+		limit:     limit,
+		window:    window,
+		requests:  make(map[string][]time.Time),
+		lastSweep: time.Now(),
 	}
 }
 
@@ -27,6 +29,17 @@ func (l *Limiter) Allow(key string) bool {
 	now := time.Now()
 	cutoff := now.Add(-l.window)
 
+	// Forget keys that have been idle for a whole window so limiters keyed
+	// by IP or email do not grow without bound.
+	if now.Sub(l.lastSweep) > l.window {
+		for k, ts := range l.requests {
+			if len(ts) == 0 || !ts[len(ts)-1].After(cutoff) {
+				delete(l.requests, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
 	timestamps := l.requests[key]
 
 	// Remove old timestamps
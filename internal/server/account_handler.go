@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/db"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/encryption"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// exportFormatVersion is bumped whenever the layout of the data export
+// changes incompatibly.
+const exportFormatVersion = 1
+
+// Export returns everything stored about the authenticated user as a JSON
+// document. Messages are streamed from the database rather than loaded at
+// once, since a long-lived account can have a lot of them.
+func (h *UserHandler) Export(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var user models.User
+	if err := h.DB.First(&user, "id = ?", userID).Error; err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	var sessions []models.Session
+	if err := h.DB.Where("user_id = ?", userID).Order("created_at").Find(&sessions).Error; err != nil {
+		http.Error(w, "failed to export sessions", http.StatusInternalServerError)
+		return
+	}
+
+	type ConvoRow struct {
+		OtherID      uuid.UUID    `gorm:"column:other_id"`
+		MessageCount int64        `gorm:"column:message_count"`
+		FirstMessage db.Timestamp `gorm:"column:first_message_at"`
+		LastMessage  db.Timestamp `gorm:"column:last_message_at"`
+	}
+
+	var convos []ConvoRow
+	err := h.DB.Raw(`
+		SELECT other_id, COUNT(*) as message_count, MIN(created_at) as first_message_at, MAX(created_at) as last_message_at
+		FROM (
+			SELECT receiver_id as other_id, created_at FROM messages WHERE sender_id = ?
+			UNION ALL
+			SELECT sender_id as other_id, created_at FROM messages WHERE receiver_id = ?
+		) sub
+		GROUP BY other_id
+		ORDER BY last_message_at DESC
+	`, userID, userID).Scan(&convos).Error
+	if err != nil {
+		http.Error(w, "failed to export conversations", http.StatusInternalServerError)
+		return
+	}
+
+	otherIDs := make([]uuid.UUID, len(convos))
+	for i, c := range convos {
+		otherIDs[i] = c.OtherID
+	}
+	var others []models.User
+	if err := h.DB.Where("id IN ?", otherIDs).Find(&others).Error; err != nil {
+		http.Error(w, "failed to export conversations", http.StatusInternalServerError)
+		return
+	}
+	names := make(map[uuid.UUID]string, len(others))
+	for _, other := range others {
+		names[other.ID] = other.DisplayName()
+	}
+
+	conversations := make([]map[string]any, 0, len(convos))
+	for _, c := range convos {
+		username, ok := names[c.OtherID]
+		if !ok {
+			username = models.DeletedUsername
+		}
+		conversations = append(conversations, map[string]any{
+			"user_id":          c.OtherID,
+			"username":         username,
+			"message_count":    c.MessageCount,
+			"first_message_at": c.FirstMessage,
+			"last_message_at":  c.LastMessage,
+		})
+	}
+
+	query := h.DB.Model(&models.Message{}).
+		Where("sender_id = ? OR receiver_id = ?", userID, userID).
+		Order("created_at")
+	if err := encryption.LoadKeys(query); err != nil {
+		http.Error(w, "failed to export messages", http.StatusInternalServerError)
+		return
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		http.Error(w, "failed to export messages", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	filename := fmt.Sprintf("export-%s-%s.json", user.Username, time.Now().Format("20060102"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	header, _ := json.Marshal(map[string]any{
+		"format_version": exportFormatVersion,
+		"exported_at":    time.Now(),
+		"profile": map[string]any{
+			"id":       user.ID,
+			"username": user.Username,
+			"email":    user.Email,
+			"created":  user.CreatedAt,
+			"updated":  user.UpdatedAt,
+		},
+		"sessions":      sessions,
+		"conversations": conversations,
+	})
+
+	// Splice the streamed message array into the header object
+	w.Write(header[:len(header)-1])
+	w.Write([]byte(`,"messages":[`))
+
+	enc := json.NewEncoder(w)
+	first := true
+	for rows.Next() {
+		var m models.Message
+		err := h.DB.ScanRows(rows, &m)
+		if err == nil {
+			err = encryption.Decrypt(h.DB, &m)
+		}
+		if err != nil {
+			// Headers are already sent; truncating the document is the only
+			// way left to signal the failure
+			return
+		}
+		if !first {
+			w.Write([]byte(","))
+		}
+		first = false
+		enc.Encode(m)
+	}
+	if rows.Err() != nil {
+		// Leave the document unterminated, as above
+		return
+	}
+
+	w.Write([]byte("]}\n"))
+}
+
+// DeleteMe deletes the authenticated user's account after confirming their
+// password.
+func (h *UserHandler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Store.Users.Get(userID)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if !h.Passwords.Hasher.Verify(user.PasswordHash, strings.TrimSpace(body.Password)) {
+		http.Error(w, "password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	deleted, err := auth.DeleteAccount(h.DB, userID, h.DeletedMessages)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	for _, id := range deleted {
+		h.Hub.DisconnectUser(id.String(), "account deleted")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+)
+
+type AdminHandler struct {
+	Store *store.Store
+}
+
+// UnlockUser lifts a login lockout before it expires on its own.
+func (h *AdminHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	if err := auth.UnlockUser(h.Store.Users, userID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to unlock user", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"log"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/archive"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/config"
+	"gorm.io/gorm"
+)
+
+// NewArchiver sets up message partitioning and archival from the
+// configuration, for the server and the archive command alike.
+func NewArchiver(db *gorm.DB, cfg *config.Config) *archive.Archiver {
+	if err := auth.ValidateMessagePolicy(cfg.DeletedUserMessages); err != nil {
+		log.Fatal("invalid DELETED_USER_MESSAGES: ", err)
+	}
+
+	var storage archive.Storage = &archive.DirStorage{Dir: cfg.ArchiveDir}
+	if cfg.ArchiveS3Endpoint != "" {
+		storage = &archive.S3Storage{
+			Endpoint:  cfg.ArchiveS3Endpoint,
+			Bucket:    cfg.ArchiveS3Bucket,
+			Region:    cfg.ArchiveS3Region,
+			AccessKey: cfg.ArchiveS3AccessKey,
+			SecretKey: cfg.ArchiveS3SecretKey,
+		}
+	}
+
+	return &archive.Archiver{
+		DB:           db,
+		Storage:      storage,
+		AfterMonths:  cfg.ArchiveAfterMonths,
+		AheadMonths:  cfg.PartitionMonthsAhead,
+		PurgeDeleted: cfg.DeletedUserMessages == auth.DeletedMessagesPurge,
+	}
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAPIKeyRateLimit = 60
+	maxAPIKeyRateLimit     = 600
+)
+
+type BotHandler struct {
+	DB *gorm.DB
+}
+
+func botResponse(bot *models.User) map[string]any {
+	return map[string]any{
+		"id":       bot.ID,
+		"username": bot.Username,
+		"owner_id": bot.OwnerID,
+		"created":  bot.CreatedAt,
+	}
+}
+
+func apiKeyResponse(k *models.APIKey) map[string]any {
+	return map[string]any{
+		"id":         k.ID,
+		"bot_id":     k.BotID,
+		"name":       k.Name,
+		"prefix":     k.Prefix,
+		"scopes":     k.ScopeList(),
+		"rate_limit": k.RateLimit,
+		"created":    k.CreatedAt,
+		"last_used":  k.LastUsedAt,
+		"expires_at": k.ExpiresAt,
+	}
+}
+
+// ownedBot loads the bot named in the path if it belongs to the
+// authenticated user, writing the error response otherwise.
+func (h *BotHandler) ownedBot(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	botID, err := uuid.Parse(r.PathValue("botId"))
+	if err != nil {
+		http.Error(w, "invalid bot id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var bot models.User
+	err = h.DB.First(&bot, "id = ? AND is_bot = TRUE AND owner_id = ? AND deleted_at IS NULL", botID, userID).Error
+	if err != nil {
+		http.Error(w, "bot not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return &bot, true
+}
+
+func (h *BotHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	username := strings.TrimSpace(body.Username)
+	if username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+
+	// Bots never log in, so they get an unroutable email and no password
+	bot := models.User{
+		Username:     username,
+		Email:        fmt.Sprintf("%s@bots.invalid", strings.ReplaceAll(uuid.NewString(), "-", "")),
+		PasswordHash: "",
+		IsBot:        true,
+		OwnerID:      &userID,
+	}
+	if err := h.DB.Create(&bot).Error; err != nil {
+		http.Error(w, "username already taken", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(botResponse(&bot))
+}
+
+func (h *BotHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var bots []models.User
+	err := h.DB.
+		Where("is_bot = TRUE AND owner_id = ? AND deleted_at IS NULL", userID).
+		Order("created_at").
+		Find(&bots).Error
+	if err != nil {
+		http.Error(w, "failed to fetch bots", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]map[string]any, 0, len(bots))
+	for i := range bots {
+		response = append(response, botResponse(&bots[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Delete removes a bot the same way a user deletes their account, keeping
+// the messages it sent. Its keys are revoked.
+func (h *BotHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	bot, ok := h.ownedBot(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := auth.DeleteAccount(h.DB, bot.ID, auth.DeletedMessagesKeep); err != nil {
+		http.Error(w, "failed to delete bot", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *BotHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	bot, ok := h.ownedBot(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		RateLimit     int      `json:"rate_limit"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(body.Name) == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if len(body.Scopes) == 0 {
+		http.Error(w, "at least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, s := range body.Scopes {
+		if !auth.ValidScope(s) {
+			http.Error(w, "invalid scope: "+s, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if body.RateLimit == 0 {
+		body.RateLimit = defaultAPIKeyRateLimit
+	}
+	if body.RateLimit < 0 || body.RateLimit > maxAPIKeyRateLimit {
+		http.Error(w, fmt.Sprintf("rate_limit must be between 1 and %d", maxAPIKeyRateLimit), http.StatusBadRequest)
+		return
+	}
+
+	key, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		http.Error(w, "failed to generate key", http.StatusInternalServerError)
+		return
+	}
+
+	apiKey := models.APIKey{
+		BotID:     bot.ID,
+		Name:      strings.TrimSpace(body.Name),
+		Prefix:    key[:12],
+		KeyHash:   hash,
+		Scopes:    strings.Join(body.Scopes, " "),
+		RateLimit: body.RateLimit,
+	}
+	if body.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, body.ExpiresInDays)
+		apiKey.ExpiresAt = &expires
+	}
+
+	if err := h.DB.Create(&apiKey).Error; err != nil {
+		http.Error(w, "failed to create key", http.StatusInternalServerError)
+		return
+	}
+
+	// The plaintext key is only ever returned here
+	response := apiKeyResponse(&apiKey)
+	response["key"] = key
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *BotHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	bot, ok := h.ownedBot(w, r)
+	if !ok {
+		return
+	}
+
+	var keys []models.APIKey
+	err := h.DB.
+		Where("bot_id = ? AND revoked_at IS NULL", bot.ID).
+		Order("created_at").
+		Find(&keys).Error
+	if err != nil {
+		http.Error(w, "failed to fetch keys", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]map[string]any, 0, len(keys))
+	for i := range keys {
+		response = append(response, apiKeyResponse(&keys[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *BotHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	bot, ok := h.ownedBot(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := uuid.Parse(r.PathValue("keyId"))
+	if err != nil {
+		http.Error(w, "invalid key id", http.StatusBadRequest)
+		return
+	}
+
+	res := h.DB.Model(&models.APIKey{}).
+		Where("id = ? AND bot_id = ? AND revoked_at IS NULL", keyID, bot.ID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		http.Error(w, "failed to revoke key", http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,20 +2,31 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/notify"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/websocket"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type ChatHandler struct {
-	DB  *gorm.DB
-	Hub *websocket.Hub
+	Store   *store.Store
+	Hub     *websocket.Hub
+	Service *websocket.MessageService
+
+	// History and the conversation list are read through it
+	Reads *store.Router
+
+	// Used for conversation settings
+	DB *gorm.DB
 }
 
 func (h *ChatHandler) History(w http.ResponseWriter, r *http.Request) {
@@ -30,139 +41,302 @@ func (h *ChatHandler) History(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !middleware.ScopeAllowed(r, auth.ScopeHistoryRead, otherID) {
+		http.Error(w, "api key lacks scope "+auth.ScopeHistoryRead, http.StatusForbidden)
+		return
+	}
+
 	// Pagination params
+	query := r.URL.Query()
 	limit := 20
-	if l := r.URL.Query().Get("limit"); l != "" {
+	if l := query.Get("limit"); l != "" {
 		if v, err := strconv.Atoi(l); err == nil && v > 0 && v <= 100 {
 			limit = v
 		}
 	}
 
-	var before *time.Time
-	if b := r.URL.Query().Get("before"); b != "" {
-		if ts, err := strconv.ParseInt(b, 10, 64); err == nil {
-			t := time.Unix(ts, 0)
-			before = &t
+	given := 0
+	for _, p := range []string{"before", "after", "around"} {
+		if query.Get(p) != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		http.Error(w, "only one of before, after and around may be given", http.StatusBadRequest)
+		return
+	}
+
+	var load func(st *store.Store) (historyPage, error)
+	switch {
+	case query.Get("around") != "":
+		messageID, err := uuid.Parse(query.Get("around"))
+		if err != nil {
+			http.Error(w, "invalid message id", http.StatusBadRequest)
+			return
+		}
+		load = func(st *store.Store) (historyPage, error) {
+			return h.historyAround(st, userID, otherID, messageID, limit)
+		}
+
+	case query.Get("after") != "":
+		after, err := store.ParseHistoryCursor(query.Get("after"))
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		load = func(st *store.Store) (historyPage, error) {
+			return h.historyPage(st, userID, otherID, store.HistoryQuery{After: after, Limit: limit})
+		}
+
+	default:
+		q := store.HistoryQuery{Limit: limit}
+		if b := query.Get("before"); b != "" {
+			before, err := parseBefore(b)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			q.Before = before
+		}
+		load = func(st *store.Store) (historyPage, error) {
+			return h.historyPage(st, userID, otherID, q)
 		}
 	}
 
-	// Fetch messages
-	messages, err := websocket.FetchChatHistory(
-		h.DB,
-		userID,
-		otherID,
-		limit,
-		before,
-	)
+	// All queries of the page go to the same database
+	var page historyPage
+	err = h.Reads.Read(userID, func(st *store.Store) error {
+		var err error
+		page, err = load(st)
+		return err
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		// Only a page around a message looks one up
+		http.Error(w, "message not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "failed to fetch messages", http.StatusInternalServerError)
 		return
 	}
+	messages := page.messages
+
+	if page.next != nil {
+		w.Header().Set("X-Next-Cursor", page.next.String())
+	}
+	if page.prev != nil {
+		w.Header().Set("X-Prev-Cursor", page.prev.String())
+	}
 
 	// Build response
 	type MessageResponse struct {
-		ID        uuid.UUID  `json:"id"`
-		From      uuid.UUID  `json:"from"`
-		To        uuid.UUID  `json:"to"`
-		Content   string     `json:"content"`
-		Timestamp time.Time  `json:"timestamp"`
-		EditedAt  *time.Time `json:"edited_at,omitempty"`
-		IsRead    bool       `json:"is_read"`
-		ReadAt    *time.Time `json:"read_at,omitempty"`
+		ID          uuid.UUID          `json:"id"`
+		From        uuid.UUID          `json:"from"`
+		To          uuid.UUID          `json:"to"`
+		Content     string             `json:"content"`
+		Format      string             `json:"format,omitempty"`
+		Attachments models.Attachments `json:"attachments,omitempty"`
+		Mentions    []models.Mention   `json:"mentions,omitempty"`
+		SenderName  string             `json:"sender_name,omitempty"`
+		IsBot       bool               `json:"is_bot"`
+		Timestamp   time.Time          `json:"timestamp"`
+		EditedAt    *time.Time         `json:"edited_at,omitempty"`
+		IsRead      bool               `json:"is_read"`
+		ReadAt      *time.Time         `json:"read_at,omitempty"`
 	}
 
 	resp := make([]MessageResponse, 0, len(messages))
 	for _, m := range messages {
 		resp = append(resp, MessageResponse{
-			ID:        m.ID,
-			From:      m.SenderID,
-			To:        m.ReceiverID,
-			Content:   m.Content,
-			Timestamp: m.CreatedAt,
-			EditedAt:  m.EditedAt,
-			IsRead:    m.IsRead,
-			ReadAt:    m.ReadAt,
+			ID:          m.ID,
+			From:        m.SenderID,
+			To:          m.ReceiverID,
+			Content:     m.Content,
+			Format:      m.Format,
+			Attachments: m.Attachments,
+			Mentions:    m.Mentions,
+			SenderName:  m.SenderName,
+			IsBot:       m.IsBot,
+			Timestamp:   m.CreatedAt,
+			EditedAt:    m.EditedAt,
+			IsRead:      m.IsRead,
+			ReadAt:      m.ReadAt,
 		})
 	}
 
 	json.NewEncoder(w).Encode(resp)
 }
 
+// historyPage is a page of history, newest first, with the cursors of the
+// pages of older (next) and newer (prev) messages, when there are any.
+type historyPage struct {
+	messages   []models.Message
+	next, prev *store.HistoryCursor
+}
+
+// newHistoryPage sets the cursors of a page from its first and last
+// messages, or from anchor, the position the page was read from, when it
+// is empty.
+func newHistoryPage(messages []models.Message, anchor *store.HistoryCursor, newer, older bool) historyPage {
+	page := historyPage{messages: messages}
+	edge := func(i int) *store.HistoryCursor {
+		if len(messages) == 0 {
+			return anchor
+		}
+		c := store.HistoryCursorOf(&messages[i])
+		return &c
+	}
+	if newer {
+		page.prev = edge(0)
+	}
+	if older {
+		page.next = edge(len(messages) - 1)
+	}
+	return page
+}
+
+// historyPage reads one page of history before or after a cursor. It asks
+// for one more message than the page, to know whether there is another page
+// in that direction; there is always one back across the cursor.
+func (h *ChatHandler) historyPage(st *store.Store, userID, otherID uuid.UUID, q store.HistoryQuery) (historyPage, error) {
+	limit := q.Limit
+	q.Limit++
+	messages, err := st.Messages.History(userID, otherID, q)
+	if err != nil {
+		return historyPage{}, err
+	}
+	more := len(messages) > limit
+
+	if q.After != nil {
+		// The page is the oldest messages after the cursor
+		if more {
+			messages = messages[1:]
+		}
+		return newHistoryPage(messages, q.After, more, true), nil
+	}
+	if more {
+		messages = messages[:limit]
+	}
+	return newHistoryPage(messages, q.Before, q.Before != nil, more), nil
+}
+
+// historyAround reads the page of history centred on a message, for jumping
+// to it from a search result or a link.
+func (h *ChatHandler) historyAround(st *store.Store, userID, otherID, messageID uuid.UUID, limit int) (historyPage, error) {
+	m, err := st.Messages.Get(messageID)
+	if err != nil {
+		return historyPage{}, err
+	}
+	if !(m.SenderID == userID && m.ReceiverID == otherID) && !(m.SenderID == otherID && m.ReceiverID == userID) {
+		return historyPage{}, store.ErrNotFound
+	}
+	at := store.HistoryCursorOf(m)
+
+	newerLimit := (limit - 1) / 2
+	olderLimit := limit - 1 - newerLimit
+
+	newer, err := st.Messages.History(userID, otherID, store.HistoryQuery{After: &at, Limit: newerLimit + 1})
+	if err != nil {
+		return historyPage{}, err
+	}
+	older, err := st.Messages.History(userID, otherID, store.HistoryQuery{Before: &at, Limit: olderLimit + 1})
+	if err != nil {
+		return historyPage{}, err
+	}
+	moreNewer, moreOlder := len(newer) > newerLimit, len(older) > olderLimit
+	if moreNewer {
+		newer = newer[len(newer)-newerLimit:]
+	}
+	if moreOlder {
+		older = older[:olderLimit]
+	}
+
+	messages := newer
+	if !m.IsDeleted {
+		messages = append(messages, *m)
+	}
+	messages = append(messages, older...)
+	return newHistoryPage(messages, &at, moreNewer, moreOlder), nil
+}
+
+// parseBefore reads the before parameter of history, which is a cursor or,
+// as history took before cursors were added, a Unix time in seconds.
+func parseBefore(s string) (*store.HistoryCursor, error) {
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return &store.HistoryCursor{CreatedAt: time.Unix(ts, 0)}, nil
+	}
+	return store.ParseHistoryCursor(s)
+}
+
 func (h *ChatHandler) Conversations(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
 
-	type ConvoRow struct {
-		OtherID      uuid.UUID `gorm:"column:other_id"`
-		LastActivity time.Time `gorm:"column:last_activity"`
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 && v <= 100 {
+			limit = v
+		}
+	}
+
+	var after *store.ConversationCursor
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		cursor, err := store.ParseConversationCursor(c)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		after = cursor
 	}
 
-	var rows []ConvoRow
-	err := h.DB.Raw(`
-		SELECT other_id, MAX(created_at) as last_activity
-		FROM (
-			SELECT receiver_id as other_id, created_at FROM messages WHERE sender_id = ? AND is_deleted = FALSE
-			UNION ALL
-			SELECT sender_id as other_id, created_at FROM messages WHERE receiver_id = ? AND is_deleted = FALSE
-		) sub
-		GROUP BY other_id
-		ORDER BY last_activity DESC
-	`, userID, userID).Scan(&rows).Error
+	// One more than the page, to know whether there is a next one
+	var rows []store.Conversation
+	err := h.Reads.Read(userID, func(st *store.Store) error {
+		var err error
+		rows, err = st.Conversations.List(userID, after, limit+1)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "failed to fetch conversations", http.StatusInternalServerError)
+		return
+	}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		w.Header().Set("X-Next-Cursor", rows[limit-1].Cursor().String())
+	}
 
+	otherIDs := make([]uuid.UUID, len(rows))
+	for i, row := range rows {
+		otherIDs[i] = row.Other.ID
+	}
+	settings, err := notify.ConversationSettings(h.DB, userID, otherIDs)
 	if err != nil {
 		http.Error(w, "failed to fetch conversations", http.StatusInternalServerError)
 		return
 	}
 
 	type ConvoResponse struct {
-		ID          uuid.UUID      `json:"id"`
-		OtherUser   map[string]any `json:"other_user"`
-		LastMessage map[string]any `json:"last_message,omitempty"`
-		UnreadCount int            `json:"unread_count"`
+		ID             uuid.UUID      `json:"id"`
+		OtherUser      map[string]any `json:"other_user"`
+		LastMessage    map[string]any `json:"last_message,omitempty"`
+		LastActivity   time.Time      `json:"last_activity"`
+		UnreadCount    int            `json:"unread_count"`
+		UnreadMentions int            `json:"unread_mentions"`
+		Settings       map[string]any `json:"settings"`
 	}
 
 	response := make([]ConvoResponse, 0, len(rows))
 
 	for _, row := range rows {
-		var otherUser models.User
-		if err := h.DB.First(&otherUser, "id = ?", row.OtherID).Error; err != nil {
-			continue
-		}
-
-		var lastMsg models.Message
-		h.DB.Order("created_at DESC").First(&lastMsg,
-			"((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)) AND is_deleted = FALSE",
-			userID, row.OtherID, row.OtherID, userID,
-		)
-
-		var unreadCount int64
-		h.DB.Model(&models.Message{}).Where(
-			"sender_id = ? AND receiver_id = ? AND is_read = ? AND is_deleted = FALSE",
-			row.OtherID, userID, false,
-		).Count(&unreadCount)
-
-		var lastMsgMap map[string]any
-		if lastMsg.ID != uuid.Nil {
-			lastMsgMap = map[string]any{
-				"id":         lastMsg.ID,
-				"from":       lastMsg.SenderID,
-				"to":         lastMsg.ReceiverID,
-				"content":    lastMsg.Content,
-				"timestamp":  lastMsg.CreatedAt,
-				"edited_at":  lastMsg.EditedAt,
-				"is_deleted": lastMsg.IsDeleted,
-				"is_read":    lastMsg.IsRead,
-			}
-		}
+		setting := settings[row.Other.ID]
 
 		response = append(response, ConvoResponse{
-			ID: row.OtherID,
-			OtherUser: map[string]any{
-				"id":       otherUser.ID,
-				"username": otherUser.Username,
-				"email":    otherUser.Email,
-			},
-			LastMessage: lastMsgMap,
-			UnreadCount: int(unreadCount),
+			ID:             row.Other.ID,
+			OtherUser:      otherUserResponse(&row.Other),
+			LastMessage:    lastMessageResponse(row.LastMessage),
+			LastActivity:   row.LastActivity,
+			UnreadCount:    int(row.UnreadCount),
+			UnreadMentions: int(row.UnreadMentions),
+			Settings:       conversationSettingsResponse(&setting),
 		})
 	}
 
@@ -187,47 +361,31 @@ func (h *ChatHandler) CreateConversation(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var otherUser models.User
-	if err := h.DB.First(&otherUser, "id = ?", otherID).Error; err != nil {
+	otherUser, err := h.Store.Users.Get(otherID)
+	if err != nil {
 		http.Error(w, "user not found", http.StatusNotFound)
 		return
 	}
 
-	var lastMsg models.Message
-	h.DB.Order("created_at DESC").First(&lastMsg,
-		"((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)) AND is_deleted = FALSE",
-		userID, otherID, otherID, userID,
-	)
-
-	var unreadCount int64
-	h.DB.Model(&models.Message{}).Where(
-		"sender_id = ? AND receiver_id = ? AND is_read = ? AND is_deleted = FALSE",
-		otherID, userID, false,
-	).Count(&unreadCount)
-
-	var lastMsgMap map[string]any
-	if lastMsg.ID != uuid.Nil {
-		lastMsgMap = map[string]any{
-			"id":         lastMsg.ID,
-			"from":       lastMsg.SenderID,
-			"to":         lastMsg.ReceiverID,
-			"content":    lastMsg.Content,
-			"timestamp":  lastMsg.CreatedAt,
-			"edited_at":  lastMsg.EditedAt,
-			"is_deleted": lastMsg.IsDeleted,
-			"is_read":    lastMsg.IsRead,
-		}
+	summary, err := h.Store.Conversations.Summary(userID, otherID)
+	if err != nil {
+		http.Error(w, "failed to fetch conversation", http.StatusInternalServerError)
+		return
+	}
+
+	setting, err := notify.ConversationSetting(h.DB, userID, otherID)
+	if err != nil {
+		http.Error(w, "failed to fetch conversation", http.StatusInternalServerError)
+		return
 	}
 
 	response := map[string]any{
-		"id": otherID,
-		"other_user": map[string]any{
-			"id":       otherUser.ID,
-			"username": otherUser.Username,
-			"email":    otherUser.Email,
-		},
-		"last_message": lastMsgMap,
-		"unread_count": unreadCount,
+		"id":              otherID,
+		"other_user":      otherUserResponse(otherUser),
+		"last_message":    lastMessageResponse(summary.LastMessage),
+		"unread_count":    summary.UnreadCount,
+		"unread_mentions": summary.UnreadMentions,
+		"settings":        conversationSettingsResponse(&setting),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -244,14 +402,7 @@ func (h *ChatHandler) MarkConversationRead(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	now := time.Now()
-	err = h.DB.Model(&models.Message{}).
-		Where("sender_id = ? AND receiver_id = ? AND is_read = FALSE", otherID, userID).
-		Updates(map[string]any{
-			"is_read": true,
-			"read_at": &now,
-		}).Error
-
+	err = h.Service.MarkConversationRead(userID, otherID)
 	if err != nil {
 		http.Error(w, "failed to mark messages as read", http.StatusInternalServerError)
 		return
@@ -268,3 +419,35 @@ func (h *ChatHandler) MarkConversationRead(w http.ResponseWriter, r *http.Reques
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// lastMessageResponse describes the last message of a conversation in the
+// conversation list; nil if it has none.
+func lastMessageResponse(m *models.Message) map[string]any {
+	if m == nil {
+		return nil
+	}
+	return map[string]any{
+		"id":         m.ID,
+		"from":       m.SenderID,
+		"to":         m.ReceiverID,
+		"content":    m.Content,
+		"timestamp":  m.CreatedAt,
+		"edited_at":  m.EditedAt,
+		"is_deleted": m.IsDeleted,
+		"is_read":    m.IsRead,
+	}
+}
+
+// otherUserResponse describes the other participant of a conversation. A
+// deleted account is marked as such and its anonymized email left out.
+func otherUserResponse(u *models.User) map[string]any {
+	response := map[string]any{
+		"id":       u.ID,
+		"username": u.DisplayName(),
+		"deleted":  u.DeletedAt != nil,
+	}
+	if u.DeletedAt == nil {
+		response["email"] = u.Email
+	}
+	return response
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/commands"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/webhook"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CommandHandler struct {
+	DB       *gorm.DB
+	Registry *commands.Registry
+}
+
+// List returns the commands available to users, for help and
+// autocomplete. ?prefix= narrows it to names starting with the prefix.
+func (h *CommandHandler) List(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.ToLower(strings.TrimPrefix(r.URL.Query().Get("prefix"), "/"))
+
+	cmds, err := h.Registry.List(prefix)
+	if err != nil {
+		http.Error(w, "failed to fetch commands", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]map[string]any, 0, len(cmds))
+	for _, c := range cmds {
+		response = append(response, map[string]any{
+			"name":        c.Name,
+			"description": c.Description,
+			"usage":       c.Usage,
+			"builtin":     c.Builtin,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Create registers an external command. Admin only.
+func (h *CommandHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var body struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Usage       string `json:"usage"`
+		URL         string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(body.Name), "/"))
+	if err := h.Registry.ValidateName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	target, err := url.Parse(body.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, "url must be an absolute http(s) URL", http.StatusBadRequest)
+		return
+	}
+
+	usage := strings.TrimSpace(body.Usage)
+	if usage == "" {
+		usage = "/" + name
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		http.Error(w, "failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	cmd := models.SlashCommand{
+		Name:        name,
+		Description: strings.TrimSpace(body.Description),
+		Usage:       usage,
+		URL:         target.String(),
+		Secret:      secret,
+		CreatedBy:   userID,
+	}
+	if err := h.DB.Create(&cmd).Error; err != nil {
+		http.Error(w, "command already exists", http.StatusConflict)
+		return
+	}
+
+	// The signing secret is only returned on creation
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":          cmd.ID,
+		"name":        cmd.Name,
+		"description": cmd.Description,
+		"usage":       cmd.Usage,
+		"url":         cmd.URL,
+		"created":     cmd.CreatedAt,
+		"secret":      secret,
+	})
+}
+
+// Delete unregisters an external command. Admin only.
+func (h *CommandHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	res := h.DB.Where("name = ?", r.PathValue("name")).Delete(&models.SlashCommand{})
+	if res.Error != nil {
+		http.Error(w, "failed to delete command", http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "command not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/e2ee"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/websocket"
+	"github.com/google/uuid"
+)
+
+// Envelopes returned per page when a device catches up
+const (
+	defaultEnvelopeLimit = 100
+	maxEnvelopeLimit     = 500
+)
+
+// Key uploads are small; this leaves room for a full batch of prekeys
+const maxKeysBody = 64 << 10
+
+type DeviceHandler struct {
+	Directory *e2ee.Directory
+	Hub       *websocket.Hub
+}
+
+// deviceResponse is a device as its owner sees it.
+type deviceResponse struct {
+	models.Device
+	PreKeysRemaining int64 `json:"prekeys_remaining"`
+}
+
+// writeError answers with the status an error of the directory calls for.
+func (h *DeviceHandler) writeError(w http.ResponseWriter, err error, fallback string) {
+	var invalid *e2ee.InvalidError
+	switch {
+	case errors.As(err, &invalid):
+		http.Error(w, invalid.Message, http.StatusBadRequest)
+	case errors.Is(err, e2ee.ErrDeviceNotFound), errors.Is(err, e2ee.ErrNoDevices):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, e2ee.ErrTooManyDevices), errors.Is(err, e2ee.ErrTooManyPreKeys):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+func (h *DeviceHandler) respond(w http.ResponseWriter, status int, device *models.Device) {
+	counts, err := h.Directory.PreKeyCounts([]uuid.UUID{device.ID})
+	if err != nil {
+		http.Error(w, "failed to count prekeys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(deviceResponse{Device: *device, PreKeysRemaining: counts[device.ID]})
+}
+
+func (h *DeviceHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	devices, err := h.Directory.Devices(userID)
+	if err != nil {
+		http.Error(w, "failed to fetch devices", http.StatusInternalServerError)
+		return
+	}
+
+	ids := make([]uuid.UUID, len(devices))
+	for i, d := range devices {
+		ids[i] = d.ID
+	}
+	counts, err := h.Directory.PreKeyCounts(ids)
+	if err != nil {
+		http.Error(w, "failed to count prekeys", http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]deviceResponse, len(devices))
+	for i, d := range devices {
+		res[i] = deviceResponse{Device: d, PreKeysRemaining: counts[d.ID]}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// Register adds a device with its identity key, signed prekey and a first
+// batch of one-time prekeys.
+func (h *DeviceHandler) Register(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var body struct {
+		Name string `json:"name"`
+		e2ee.Keys
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxKeysBody)).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	device, err := h.Directory.Register(userID, body.Name, body.Keys)
+	if err != nil {
+		h.writeError(w, err, "failed to register device")
+		return
+	}
+	h.respond(w, http.StatusCreated, device)
+}
+
+// Replace swaps every key of a device. Contacts are told when its identity
+// key changed.
+func (h *DeviceHandler) Replace(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	deviceID, err := uuid.Parse(r.PathValue("deviceId"))
+	if err != nil {
+		http.Error(w, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	var keys e2ee.Keys
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxKeysBody)).Decode(&keys); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	device, err := h.Directory.Replace(userID, deviceID, keys)
+	if err != nil {
+		h.writeError(w, err, "failed to update device")
+		return
+	}
+	h.respond(w, http.StatusOK, device)
+}
+
+func (h *DeviceHandler) SetSignedPreKey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	deviceID, err := uuid.Parse(r.PathValue("deviceId"))
+	if err != nil {
+		http.Error(w, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	var key models.SignedPreKey
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxKeysBody)).Decode(&key); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	device, err := h.Directory.SetSignedPreKey(userID, deviceID, key)
+	if err != nil {
+		h.writeError(w, err, "failed to update signed prekey")
+		return
+	}
+	h.respond(w, http.StatusOK, device)
+}
+
+// AddPreKeys uploads more one-time prekeys, as clients do when
+// prekeys_remaining runs low.
+func (h *DeviceHandler) AddPreKeys(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	deviceID, err := uuid.Parse(r.PathValue("deviceId"))
+	if err != nil {
+		http.Error(w, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		PreKeys []models.PreKey `json:"prekeys"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxKeysBody)).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	count, err := h.Directory.AddPreKeys(userID, deviceID, body.PreKeys)
+	if err != nil {
+		h.writeError(w, err, "failed to store prekeys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"prekeys_remaining": count,
+	})
+}
+
+// Remove deletes a device and closes its connections.
+func (h *DeviceHandler) Remove(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	deviceID, err := uuid.Parse(r.PathValue("deviceId"))
+	if err != nil {
+		http.Error(w, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Directory.Remove(userID, deviceID); err != nil {
+		h.writeError(w, err, "failed to remove device")
+		return
+	}
+	h.Hub.DisconnectDevice(deviceID.String(), "device removed")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Keys returns the key bundles of another user's devices, claiming a
+// one-time prekey of each. ?device_id limits it to one device, to set up a
+// session with a device added since.
+func (h *DeviceHandler) Keys(w http.ResponseWriter, r *http.Request) {
+	otherID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	var deviceID *uuid.UUID
+	if raw := r.URL.Query().Get("device_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			http.Error(w, "invalid device_id", http.StatusBadRequest)
+			return
+		}
+		deviceID = &id
+	}
+
+	bundles, err := h.Directory.Bundles(otherID, deviceID)
+	if err != nil {
+		h.writeError(w, err, "failed to fetch keys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{
+		"user_id": otherID,
+		"devices": bundles,
+	})
+}
+
+// Envelopes lists the encrypted messages a device has not acknowledged,
+// for devices that were offline when they were sent.
+func (h *DeviceHandler) Envelopes(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	deviceID, err := uuid.Parse(r.PathValue("deviceId"))
+	if err != nil {
+		http.Error(w, "invalid device id", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultEnvelopeLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxEnvelopeLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxEnvelopeLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := h.Directory.Device(userID, deviceID); err != nil {
+		h.writeError(w, err, "failed to fetch device")
+		return
+	}
+
+	envelopes, err := h.Directory.Pending(userID, deviceID, limit)
+	if err != nil {
+		http.Error(w, "failed to fetch envelopes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(envelopes)
+}
+
+// Ack deletes an envelope the device has decrypted.
+func (h *DeviceHandler) Ack(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	deviceID, err := uuid.Parse(r.PathValue("deviceId"))
+	if err != nil {
+		http.Error(w, "invalid device id", http.StatusBadRequest)
+		return
+	}
+	envelopeID, err := uuid.Parse(r.PathValue("envelopeId"))
+	if err != nil {
+		http.Error(w, "invalid envelope id", http.StatusBadRequest)
+		return
+	}
+
+	found, err := h.Directory.Ack(userID, deviceID, envelopeID)
+	if err != nil {
+		http.Error(w, "failed to acknowledge envelope", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "envelope not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
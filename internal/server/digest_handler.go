@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/digest"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/notify"
+	"gorm.io/gorm"
+)
+
+type DigestHandler struct {
+	DB *gorm.DB
+
+	// Signs unsubscribe links
+	Secret string
+}
+
+// UnsubscribePage asks for confirmation instead of unsubscribing right
+// away, because mail scanners follow links in emails.
+func (h *DigestHandler) UnsubscribePage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if _, err := digest.VerifyUnsubscribeToken(h.Secret, token); err != nil {
+		http.Error(w, "invalid unsubscribe link", http.StatusBadRequest)
+		return
+	}
+
+	action := "/digest/unsubscribe?token=" + url.QueryEscape(token)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!doctype html>
+<title>Unsubscribe</title>
+<form method="post" action="%s">
+<p>Stop emailing me digests of unread messages?</p>
+<button type="submit">Unsubscribe</button>
+</form>
+`, html.EscapeString(action))
+}
+
+// Unsubscribe turns off email digests for the user the link was sent to.
+// Mail clients call it directly for one-click unsubscribe (RFC 8058).
+func (h *DigestHandler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	userID, err := digest.VerifyUnsubscribeToken(h.Secret, r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, "invalid unsubscribe link", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := h.DB.First(&user, "id = ? AND deleted_at IS NULL", userID).Error; err != nil {
+		http.Error(w, "invalid unsubscribe link", http.StatusBadRequest)
+		return
+	}
+
+	pref, err := notify.Preference(h.DB, userID)
+	if err != nil {
+		http.Error(w, "failed to fetch preferences", http.StatusInternalServerError)
+		return
+	}
+
+	pref.EmailDigestDisabled = true
+	if err := h.DB.Save(&pref).Error; err != nil {
+		http.Error(w, "failed to save preferences", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, `<!doctype html>
+<title>Unsubscribed</title>
+<p>You will no longer receive digests of unread messages. You can turn them back on in your notification settings.</p>
+`)
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/export"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxOpenExportJobs caps the export jobs a user can have queued or running.
+const maxOpenExportJobs = 3
+
+type ExportHandler struct {
+	DB     *gorm.DB
+	Worker *export.Worker
+}
+
+// parseExportTime accepts an RFC 3339 timestamp or a YYYY-MM-DD date. A
+// date used as the end of a range includes that whole day.
+func parseExportTime(value string, end bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// exportRequest validates the parameters shared by the streamed export and
+// export jobs, writing the error response itself when they are invalid.
+func (h *ExportHandler) exportRequest(w http.ResponseWriter, r *http.Request, format, since, until string) (*export.Request, bool) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	otherID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if format == "" {
+		format = export.FormatJSON
+	}
+	if !export.ValidFormat(format) {
+		http.Error(w, export.ErrUnknownFormat.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	req := &export.Request{
+		UserID:  userID,
+		OtherID: otherID,
+		Format:  format,
+	}
+	if req.Since, err = parseExportTime(since, false); err != nil {
+		http.Error(w, "since must be an RFC 3339 time or YYYY-MM-DD", http.StatusBadRequest)
+		return nil, false
+	}
+	if req.Until, err = parseExportTime(until, true); err != nil {
+		http.Error(w, "until must be an RFC 3339 time or YYYY-MM-DD", http.StatusBadRequest)
+		return nil, false
+	}
+	if req.Since != nil && req.Until != nil && !req.Until.After(*req.Since) {
+		http.Error(w, "until must be after since", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var other models.User
+	if err := h.DB.First(&other, "id = ?", otherID).Error; err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return req, true
+}
+
+func exportFilename(otherID uuid.UUID, format string, t time.Time) string {
+	name := fmt.Sprintf("chat-%s-%s.%s", otherID, t.Format("20060102"), format)
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+}
+
+// Export streams the whole conversation with another user, or the part of
+// it within ?since= and ?until=.
+func (h *ExportHandler) Export(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req, ok := h.exportRequest(w, r, q.Get("format"), q.Get("since"), q.Get("until"))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(req.Format))
+	w.Header().Set("Content-Disposition", exportFilename(req.OtherID, req.Format, time.Now()))
+
+	if err := export.Write(h.DB, w, req); err != nil {
+		// Headers are already sent; the truncated document is the only way
+		// left to signal the failure
+		log.Println("export: streaming failed:", err)
+	}
+}
+
+// CreateJob queues an export to be written to a file in the background.
+func (h *ExportHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Format string `json:"format"`
+		Since  string `json:"since"`
+		Until  string `json:"until"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req, ok := h.exportRequest(w, r, body.Format, body.Since, body.Until)
+	if !ok {
+		return
+	}
+
+	var open int64
+	err := h.DB.Model(&models.ExportJob{}).
+		Where("user_id = ? AND status IN ?", req.UserID, []string{models.ExportPending, models.ExportRunning}).
+		Count(&open).Error
+	if err != nil {
+		http.Error(w, "failed to queue export", http.StatusInternalServerError)
+		return
+	}
+	if open >= maxOpenExportJobs {
+		http.Error(w, "too many exports in progress", http.StatusTooManyRequests)
+		return
+	}
+
+	job, err := h.Worker.Enqueue(req)
+	if err != nil {
+		http.Error(w, "failed to queue export", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/exports/"+job.ID.String())
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+func (h *ExportHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var jobs []models.ExportJob
+	if err := h.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&jobs).Error; err != nil {
+		http.Error(w, "failed to fetch exports", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func (h *ExportHandler) job(w http.ResponseWriter, r *http.Request) (*models.ExportJob, bool) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid export id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var job models.ExportJob
+	if err := h.DB.First(&job, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		http.Error(w, "export not found", http.StatusNotFound)
+		return nil, false
+	}
+	return &job, true
+}
+
+func (h *ExportHandler) Job(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.job(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// Download returns the file of a finished export job.
+func (h *ExportHandler) Download(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.job(w, r)
+	if !ok {
+		return
+	}
+	if job.Status != models.ExportDone {
+		http.Error(w, "export is not ready", http.StatusConflict)
+		return
+	}
+
+	f, err := os.Open(h.Worker.Path(job))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "export not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to open export", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", export.ContentType(job.Format))
+	w.Header().Set("Content-Disposition", exportFilename(job.OtherID, job.Format, job.CreatedAt))
+	http.ServeContent(w, r, "", *job.CompletedAt, f)
+}
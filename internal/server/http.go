@@ -1,36 +1,176 @@
 package server
 
 import (
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/commands"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/config"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/digest"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/e2ee"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/encryption"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/events"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/export"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/mail"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/push"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/ratelimit"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store/sqlstore"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/webhook"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/websocket"
 	"gorm.io/gorm"
 )
 
-func RegisterRoutes(mux *http.ServeMux, db *gorm.DB, jwtSecret string) {
+// RegisterRoutes serves the API from db. Profiles, user search, history and
+// the conversation list are read from the replicas when there are any.
+func RegisterRoutes(mux *http.ServeMux, db *gorm.DB, cfg *config.Config, replicas ...*gorm.DB) {
+	jwtSecret := cfg.JWTSecret
+
+	// A typo must not quietly keep messages meant to be purged
+	if err := auth.ValidateMessagePolicy(cfg.DeletedUserMessages); err != nil {
+		log.Fatal("invalid DELETED_USER_MESSAGES: ", err)
+	}
+
+	webhooks := webhook.NewDispatcher(
+		db,
+		cfg.WebhookMaxAttempts,
+		time.Duration(cfg.WebhookRetentionDays)*24*time.Hour,
+		cfg.WebhookAllowPrivate,
+	)
+	go webhooks.Run()
+
+	st := sqlstore.New(db)
+
+	replicaStores := make([]*store.Store, len(replicas))
+	for i, replica := range replicas {
+		replicaStores[i] = sqlstore.New(replica)
+	}
+	reads := store.NewRouter(
+		st,
+		replicaStores,
+		time.Duration(cfg.ReplicaReadYourWritesSeconds)*time.Second,
+		time.Duration(cfg.ReplicaRetrySeconds)*time.Second,
+	)
+
 	msgService := &websocket.MessageService{
-		DB: db,
+		Store: st,
+		DB:    db,
+	}
+
+	passwords := &auth.Passwords{
+		Policy: auth.PasswordPolicy{
+			MinLength:     cfg.PasswordMinLength,
+			RequireUpper:  cfg.PasswordRequireUpper,
+			RequireLower:  cfg.PasswordRequireLower,
+			RequireDigit:  cfg.PasswordRequireDigit,
+			RequireSymbol: cfg.PasswordRequireSymbol,
+		},
+		Hasher: auth.Hasher{
+			Algorithm:  cfg.PasswordHash,
+			BcryptCost: cfg.BcryptCost,
+			Argon2: auth.Argon2Params{
+				Memory:  uint32(cfg.Argon2MemoryKB),
+				Time:    uint32(cfg.Argon2Time),
+				Threads: uint8(cfg.Argon2Threads),
+			},
+		},
+	}
+	if cfg.BreachedPasswordsDir != "" {
+		passwords.Breached = &auth.BreachedList{Dir: cfg.BreachedPasswordsDir}
 	}
 
 	authHandler := &auth.Handler{
 		DB: db,
+		Users: st.Users,
 		Secret: jwtSecret,
+		Passwords: passwords,
+		AccountLimiter: ratelimit.New(10, 15*time.Minute),
+		OnLockout: auth.LogLockout,
 	}
 
+	commandRegistry := commands.NewRegistry(db, st.Users, webhook.NewHTTPClient(cfg.WebhookAllowPrivate))
+
+	// Events is set below, once the sinks are known
+	devices := &e2ee.Directory{DB: db}
+
 	hub := websocket.NewHub(msgService)
+	hub.Commands = commandRegistry
+	hub.Devices = devices
 	go hub.Run()
 
+	// The hub delivers mentioned events to the mentioned users' sockets;
+	// reads learn who just wrote, including over the WebSocket
+	sinks := events.Fanout{webhooks, hub, reads}
+
+	var vapid *push.VAPID
+	if cfg.VAPIDPrivateKey != "" {
+		var err error
+		vapid, err = push.NewVAPID(cfg.VAPIDPrivateKey, cfg.VAPIDSubject)
+		if err != nil {
+			log.Fatal("invalid VAPID configuration: ", err)
+		}
+		sinks = append(sinks, &push.Notifier{
+			DB:     db,
+			Client: webhook.NewHTTPClient(cfg.PushAllowPrivate),
+			VAPID:  vapid,
+			Online: hub.IsOnline,
+		})
+	}
+	msgService.Events = sinks
+	devices.Events = sinks
+
+	reminders := &commands.Reminders{
+		DB:      db,
+		Deliver: hub.SendToUser,
+	}
+	go reminders.Run()
+
+	if cfg.SMTPHost != "" {
+		digests := &digest.Job{
+			DB: db,
+			Mailer: &mail.SMTPMailer{
+				Host:     cfg.SMTPHost,
+				Port:     cfg.SMTPPort,
+				Username: cfg.SMTPUsername,
+				Password: cfg.SMTPPassword,
+				From:     cfg.SMTPFrom,
+			},
+			UnreadAfter: time.Duration(cfg.DigestUnreadMinutes) * time.Minute,
+			Interval:    time.Duration(cfg.DigestIntervalMinutes) * time.Minute,
+			BaseURL:     cfg.PublicURL,
+			Secret:      jwtSecret,
+		}
+		go digests.Run()
+	}
+
+	exports := export.NewWorker(db, cfg.ExportDir, time.Duration(cfg.ExportTTLHours)*time.Hour)
+	go exports.Run()
+
+	go NewArchiver(db, cfg).Run()
+
+	if encryption.Enabled(db) {
+		reencryptor := &encryption.Reencryptor{DB: db}
+		go reencryptor.Run()
+	}
+
 	userHandler := &UserHandler{
-		DB: db,
+		Store:           st,
+		DB:              db,
+		Hub:             hub,
+		Passwords:       passwords,
+		Reads:           reads,
+		DeletedMessages: cfg.DeletedUserMessages,
 	}
 
 	chatHandler := &ChatHandler{
-		DB:  db,
-		Hub: hub,
+		Store:   st,
+		Hub:     hub,
+		Service: msgService,
+		Reads:   reads,
+		DB:      db,
 	}
 
 	messageHandler := &MessageHandler{
@@ -38,23 +178,172 @@ func RegisterRoutes(mux *http.ServeMux, db *gorm.DB, jwtSecret string) {
 		Hub:     hub,
 	}
 
-	mux.HandleFunc("/auth/register", authHandler.Register)
-	mux.HandleFunc("/auth/login", authHandler.Login)
+	exportHandler := &ExportHandler{
+		DB:     db,
+		Worker: exports,
+	}
+
+	botHandler := &BotHandler{
+		DB: db,
+	}
+
+	webhookHandler := &WebhookHandler{
+		DB: db,
+	}
+
+	incomingWebhookHandler := &IncomingWebhookHandler{
+		DB:      db,
+		Hub:     hub,
+		Service: msgService,
+		Limits:  ratelimit.NewGroup(time.Minute),
+	}
+
+	commandHandler := &CommandHandler{
+		DB:       db,
+		Registry: commandRegistry,
+	}
+
+	pushHandler := &PushHandler{
+		DB:            db,
+		VAPID:         vapid,
+		AllowInsecure: cfg.PushAllowPrivate,
+	}
+
+	settingsHandler := &SettingsHandler{
+		DB: db,
+	}
+
+	digestHandler := &DigestHandler{
+		DB:     db,
+		Secret: jwtSecret,
+	}
+
+	importHandler := &ImportHandler{
+		DB:       db,
+		MaxBytes: int64(cfg.ImportMaxMB) << 20,
+	}
+
+	adminHandler := &AdminHandler{
+		Store: st,
+	}
 
-	protected := middleware.JWTAuth(jwtSecret)
+	deviceHandler := &DeviceHandler{
+		Directory: devices,
+		Hub:       hub,
+	}
+
+	sessionHandler := &SessionHandler{
+		DB:  db,
+		Hub: hub,
+	}
+
+	tickets := websocket.NewTicketStore(db)
+	ticketHandler := &TicketHandler{
+		Tickets: tickets,
+		BindIP:  cfg.WSTicketBindIP,
+	}
+
+	wsAuth := websocket.AuthConfig{
+		Secret:          jwtSecret,
+		Tickets:         tickets,
+		AllowQueryToken: cfg.WSAllowQueryToken,
+	}
+
+	// The auth endpoints are reachable without a token, so they are
+	// limited per client IP instead of per user
+	registerLimit := middleware.RateLimitByIP(ratelimit.New(5, time.Hour))
+	loginLimit := middleware.RateLimitByIP(ratelimit.New(20, time.Minute))
+
+	mux.Handle("/auth/register", registerLimit(http.HandlerFunc(authHandler.Register)))
+	mux.Handle("/auth/login", loginLimit(http.HandlerFunc(authHandler.Login)))
+
+	// Incoming webhooks authenticate with the token in the URL; the IP
+	// limit slows down token guessing, each webhook has its own limit too
+	hookLimit := middleware.RateLimitByIP(ratelimit.New(120, time.Minute))
+	mux.Handle("POST /hooks/{token}", hookLimit(http.HandlerFunc(incomingWebhookHandler.Post)))
+
+	// Unsubscribe links in digest emails carry their own signed token
+	unsubscribeLimit := middleware.RateLimitByIP(ratelimit.New(30, time.Minute))
+	mux.Handle("GET /digest/unsubscribe", unsubscribeLimit(http.HandlerFunc(digestHandler.UnsubscribePage)))
+	mux.Handle("POST /digest/unsubscribe", unsubscribeLimit(http.HandlerFunc(digestHandler.Unsubscribe)))
+
+	// A user's reads skip the replicas for a while after their own writes
+	trackWrites := middleware.TrackWrites(reads)
+
+	jwtAuth := middleware.JWTAuth(db, jwtSecret)
+	protected := func(next http.Handler) http.Handler {
+		return jwtAuth(trackWrites(next))
+	}
 	restLimiter := ratelimit.New(60, time.Minute)
 	rateLimit := middleware.RateLimit(restLimiter)
+	admin := middleware.RequireAdmin(db)
+
+	// Routes bots may call accept an API key in place of a JWT
+	apiKeyOrJWTAuth := middleware.APIKeyOrJWTAuth(db, jwtSecret, ratelimit.NewGroup(time.Minute))
+	botOrUser := func(next http.Handler) http.Handler {
+		return apiKeyOrJWTAuth(trackWrites(next))
+	}
+
+	// Guessing the current password with a stolen token is throttled
+	// separately from the general REST limit
+	passwordLimit := middleware.RateLimit(ratelimit.New(5, 15*time.Minute))
+
+	// Exports read whole conversations, so they get a tighter limit
+	exportLimit := middleware.RateLimit(ratelimit.New(10, time.Hour))
+
+	// Every key bundle fetched uses up one-time prekeys of another user
+	keysLimit := middleware.RateLimit(ratelimit.New(30, time.Minute))
 
 	mux.Handle(
 		"/users/me",
 		protected(rateLimit(http.HandlerFunc(userHandler.Me))),
 	)
 
+	mux.Handle(
+		"GET /users/me/export",
+		protected(rateLimit(http.HandlerFunc(userHandler.Export))),
+	)
+
+	mux.Handle(
+		"DELETE /users/me",
+		protected(passwordLimit(http.HandlerFunc(userHandler.DeleteMe))),
+	)
+
 	mux.Handle(
 		"/users/me/update",
 		protected(rateLimit(http.HandlerFunc(userHandler.UpdateMe))),
 	)
 
+	mux.Handle(
+		"POST /users/me/password",
+		protected(passwordLimit(http.HandlerFunc(userHandler.ChangePassword))),
+	)
+
+	mux.Handle(
+		"GET /users/me/notifications",
+		protected(rateLimit(http.HandlerFunc(settingsHandler.Notifications))),
+	)
+
+	mux.Handle(
+		"PUT /users/me/notifications",
+		protected(rateLimit(http.HandlerFunc(settingsHandler.UpdateNotifications))),
+	)
+
+	mux.Handle(
+		"GET /users/me/sessions",
+		protected(rateLimit(http.HandlerFunc(sessionHandler.List))),
+	)
+
+	mux.Handle(
+		"DELETE /users/me/sessions/{id}",
+		protected(rateLimit(http.HandlerFunc(sessionHandler.Revoke))),
+	)
+
+	mux.Handle(
+		"GET /users/{userId}/keys",
+		protected(keysLimit(http.HandlerFunc(deviceHandler.Keys))),
+	)
+
 	mux.Handle(
 		"GET /users/search",
 		protected(rateLimit(http.HandlerFunc(userHandler.Search))),
@@ -75,9 +364,49 @@ func RegisterRoutes(mux *http.ServeMux, db *gorm.DB, jwtSecret string) {
 		protected(rateLimit(http.HandlerFunc(chatHandler.MarkConversationRead))),
 	)
 
+	mux.Handle(
+		"GET /conversations/{userId}/settings",
+		protected(rateLimit(http.HandlerFunc(settingsHandler.ConversationSettings))),
+	)
+
+	mux.Handle(
+		"PATCH /conversations/{userId}/settings",
+		protected(rateLimit(http.HandlerFunc(settingsHandler.UpdateConversationSettings))),
+	)
+
 	mux.Handle(
 		"/chats/{userId}",
-		protected(rateLimit(http.HandlerFunc(chatHandler.History))),
+		botOrUser(rateLimit(http.HandlerFunc(chatHandler.History))),
+	)
+
+	mux.Handle(
+		"POST /chats/{userId}/messages",
+		botOrUser(rateLimit(http.HandlerFunc(messageHandler.Send))),
+	)
+
+	mux.Handle(
+		"GET /chats/{userId}/export",
+		protected(exportLimit(http.HandlerFunc(exportHandler.Export))),
+	)
+
+	mux.Handle(
+		"POST /chats/{userId}/exports",
+		protected(exportLimit(http.HandlerFunc(exportHandler.CreateJob))),
+	)
+
+	mux.Handle(
+		"GET /exports",
+		protected(rateLimit(http.HandlerFunc(exportHandler.ListJobs))),
+	)
+
+	mux.Handle(
+		"GET /exports/{id}",
+		protected(rateLimit(http.HandlerFunc(exportHandler.Job))),
+	)
+
+	mux.Handle(
+		"GET /exports/{id}/download",
+		protected(rateLimit(http.HandlerFunc(exportHandler.Download))),
 	)
 
 	mux.Handle(
@@ -95,7 +424,167 @@ func RegisterRoutes(mux *http.ServeMux, db *gorm.DB, jwtSecret string) {
 		protected(rateLimit(http.HandlerFunc(messageHandler.Delete))),
 	)
 
+	mux.Handle(
+		"GET /bots",
+		protected(rateLimit(http.HandlerFunc(botHandler.List))),
+	)
+
+	mux.Handle(
+		"POST /bots",
+		protected(rateLimit(http.HandlerFunc(botHandler.Create))),
+	)
+
+	mux.Handle(
+		"DELETE /bots/{botId}",
+		protected(rateLimit(http.HandlerFunc(botHandler.Delete))),
+	)
+
+	mux.Handle(
+		"GET /bots/{botId}/keys",
+		protected(rateLimit(http.HandlerFunc(botHandler.ListKeys))),
+	)
+
+	mux.Handle(
+		"POST /bots/{botId}/keys",
+		protected(rateLimit(http.HandlerFunc(botHandler.CreateKey))),
+	)
+
+	mux.Handle(
+		"DELETE /bots/{botId}/keys/{keyId}",
+		protected(rateLimit(http.HandlerFunc(botHandler.RevokeKey))),
+	)
+
+	mux.Handle(
+		"GET /webhooks",
+		protected(rateLimit(http.HandlerFunc(webhookHandler.List))),
+	)
+
+	mux.Handle(
+		"POST /webhooks",
+		protected(rateLimit(http.HandlerFunc(webhookHandler.Create))),
+	)
+
+	mux.Handle(
+		"DELETE /webhooks/{id}",
+		protected(rateLimit(http.HandlerFunc(webhookHandler.Delete))),
+	)
+
+	mux.Handle(
+		"GET /webhooks/{id}/deliveries",
+		protected(rateLimit(http.HandlerFunc(webhookHandler.Deliveries))),
+	)
+
+	mux.Handle(
+		"GET /webhooks/{id}/dead-letters",
+		protected(rateLimit(http.HandlerFunc(webhookHandler.DeadLetters))),
+	)
+
+	mux.Handle(
+		"GET /incoming-webhooks",
+		protected(rateLimit(http.HandlerFunc(incomingWebhookHandler.List))),
+	)
+
+	mux.Handle(
+		"POST /incoming-webhooks",
+		protected(rateLimit(http.HandlerFunc(incomingWebhookHandler.Create))),
+	)
+
+	mux.Handle(
+		"DELETE /incoming-webhooks/{id}",
+		protected(rateLimit(http.HandlerFunc(incomingWebhookHandler.Revoke))),
+	)
+
+	mux.Handle(
+		"GET /commands",
+		protected(rateLimit(http.HandlerFunc(commandHandler.List))),
+	)
+
+	mux.Handle(
+		"POST /commands",
+		protected(rateLimit(admin(http.HandlerFunc(commandHandler.Create)))),
+	)
+
+	mux.Handle(
+		"DELETE /commands/{name}",
+		protected(rateLimit(admin(http.HandlerFunc(commandHandler.Delete)))),
+	)
+
+	mux.Handle(
+		"GET /push/vapid-public-key",
+		protected(rateLimit(http.HandlerFunc(pushHandler.PublicKey))),
+	)
+
+	mux.Handle(
+		"GET /push/subscriptions",
+		protected(rateLimit(http.HandlerFunc(pushHandler.List))),
+	)
+
+	mux.Handle(
+		"POST /push/subscriptions",
+		protected(rateLimit(http.HandlerFunc(pushHandler.Subscribe))),
+	)
+
+	mux.Handle(
+		"DELETE /push/subscriptions/{id}",
+		protected(rateLimit(http.HandlerFunc(pushHandler.Unsubscribe))),
+	)
+
+	mux.Handle(
+		"GET /devices",
+		protected(rateLimit(http.HandlerFunc(deviceHandler.List))),
+	)
+
+	mux.Handle(
+		"POST /devices",
+		protected(rateLimit(http.HandlerFunc(deviceHandler.Register))),
+	)
+
+	mux.Handle(
+		"PUT /devices/{deviceId}",
+		protected(rateLimit(http.HandlerFunc(deviceHandler.Replace))),
+	)
+
+	mux.Handle(
+		"DELETE /devices/{deviceId}",
+		protected(rateLimit(http.HandlerFunc(deviceHandler.Remove))),
+	)
+
+	mux.Handle(
+		"PUT /devices/{deviceId}/signed-prekey",
+		protected(rateLimit(http.HandlerFunc(deviceHandler.SetSignedPreKey))),
+	)
+
+	mux.Handle(
+		"POST /devices/{deviceId}/prekeys",
+		protected(rateLimit(http.HandlerFunc(deviceHandler.AddPreKeys))),
+	)
+
+	mux.Handle(
+		"GET /devices/{deviceId}/envelopes",
+		protected(rateLimit(http.HandlerFunc(deviceHandler.Envelopes))),
+	)
+
+	mux.Handle(
+		"DELETE /devices/{deviceId}/envelopes/{envelopeId}",
+		protected(rateLimit(http.HandlerFunc(deviceHandler.Ack))),
+	)
+
+	mux.Handle(
+		"POST /admin/users/{userId}/unlock",
+		protected(rateLimit(admin(http.HandlerFunc(adminHandler.UnlockUser)))),
+	)
+
+	mux.Handle(
+		"POST /admin/imports",
+		protected(rateLimit(admin(http.HandlerFunc(importHandler.Import)))),
+	)
+
+	mux.Handle(
+		"POST /ws/ticket",
+		protected(rateLimit(http.HandlerFunc(ticketHandler.Issue))),
+	)
+
 	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		websocket.ServeWS(hub, jwtSecret, w, r)
+		websocket.ServeWS(hub, wsAuth, w, r)
 	})
 }
\ No newline at end of file
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/importer"
+	"gorm.io/gorm"
+)
+
+type ImportHandler struct {
+	DB *gorm.DB
+
+	// Largest export file accepted
+	MaxBytes int64
+}
+
+// Import reads a Slack or WhatsApp export uploaded as multipart form data
+// and imports its messages. Admin only.
+func (h *ImportHandler) Import(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.MaxBytes)
+
+	// Files above the memory limit are spooled to disk
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "export file is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid multipart form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	opts := importer.Options{
+		DateOrder: r.FormValue("date_order"),
+		Timezone:  r.FormValue("timezone"),
+	}
+	var err error
+	if v := r.FormValue("dry_run"); v != "" {
+		if opts.DryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "dry_run must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.FormValue("create_users"); v != "" {
+		if opts.CreatePlaceholders, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "create_users must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.FormValue("users"); v != "" {
+		if err := json.Unmarshal([]byte(v), &opts.Users); err != nil {
+			http.Error(w, "users must be a JSON object", http.StatusBadRequest)
+			return
+		}
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	var report *importer.Report
+	switch r.FormValue("source") {
+	case importer.SourceSlack:
+		report, err = importer.ImportSlack(h.DB, file, header.Size, opts)
+	case importer.SourceWhatsApp:
+		report, err = importer.ImportWhatsApp(h.DB, file, opts)
+	default:
+		err = importer.ErrUnknownSource
+	}
+	if err != nil {
+		var invalid *importer.InvalidError
+		if errors.As(err, &invalid) {
+			http.Error(w, invalid.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Println("import failed:", err)
+		http.Error(w, "import failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
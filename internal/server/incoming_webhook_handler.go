@@ -0,0 +1,227 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/ratelimit"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/webhook"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/websocket"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultIncomingRateLimit = 30
+	maxIncomingRateLimit     = 600
+
+	maxIncomingBody = 64 << 10
+)
+
+type IncomingWebhookHandler struct {
+	DB      *gorm.DB
+	Hub     *websocket.Hub
+	Service *websocket.MessageService
+	Limits  *ratelimit.Group
+}
+
+func incomingWebhookResponse(hook *models.IncomingWebhook) map[string]any {
+	return map[string]any{
+		"id":         hook.ID,
+		"user_id":    hook.UserID,
+		"name":       hook.Name,
+		"prefix":     hook.Prefix,
+		"rate_limit": hook.RateLimit,
+		"created":    hook.CreatedAt,
+		"last_used":  hook.LastUsedAt,
+	}
+}
+
+func (h *IncomingWebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var body struct {
+		UserID    string `json:"user_id"`
+		Name      string `json:"name"`
+		RateLimit int    `json:"rate_limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	otherID, err := uuid.Parse(body.UserID)
+	if err != nil || otherID == userID {
+		http.Error(w, "invalid user_id", http.StatusBadRequest)
+		return
+	}
+
+	var other models.User
+	if err := h.DB.First(&other, "id = ? AND deleted_at IS NULL", otherID).Error; err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	if body.RateLimit == 0 {
+		body.RateLimit = defaultIncomingRateLimit
+	}
+	if body.RateLimit < 0 || body.RateLimit > maxIncomingRateLimit {
+		http.Error(w, fmt.Sprintf("rate_limit must be between 1 and %d", maxIncomingRateLimit), http.StatusBadRequest)
+		return
+	}
+
+	token, hash, err := webhook.NewIncomingToken()
+	if err != nil {
+		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	hook := models.IncomingWebhook{
+		OwnerID:   userID,
+		UserID:    other.ID,
+		Name:      name,
+		Prefix:    token[:12],
+		TokenHash: hash,
+		RateLimit: body.RateLimit,
+	}
+	if err := h.DB.Create(&hook).Error; err != nil {
+		http.Error(w, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	// The token is only ever returned here
+	response := incomingWebhookResponse(&hook)
+	response["token"] = token
+	response["path"] = "/hooks/" + token
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *IncomingWebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var hooks []models.IncomingWebhook
+	err := h.DB.
+		Where("owner_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at").
+		Find(&hooks).Error
+	if err != nil {
+		http.Error(w, "failed to fetch webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]map[string]any, 0, len(hooks))
+	for i := range hooks {
+		response = append(response, incomingWebhookResponse(&hooks[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *IncomingWebhookHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	res := h.DB.Model(&models.IncomingWebhook{}).
+		Where("id = ? AND owner_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if res.Error != nil {
+		http.Error(w, "failed to revoke webhook", http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Post accepts a message from an external tool. The token in the path is the
+// only credential.
+func (h *IncomingWebhookHandler) Post(w http.ResponseWriter, r *http.Request) {
+	hook, err := webhook.AuthenticateIncoming(h.DB, r.PathValue("token"))
+	if err != nil {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+
+	if !h.Limits.Allow("incoming:"+hook.ID.String(), hook.RateLimit) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	var payload webhook.IncomingPayload
+	r.Body = http.MaxBytesReader(w, r.Body, maxIncomingBody)
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := payload.Normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Either side of the conversation may have deleted their account since
+	// the webhook was created
+	var count int64
+	h.DB.Model(&models.User{}).
+		Where("id IN ? AND deleted_at IS NULL", []uuid.UUID{hook.OwnerID, hook.UserID}).
+		Count(&count)
+	if count != 2 {
+		http.Error(w, "conversation no longer exists", http.StatusGone)
+		return
+	}
+
+	msg := &models.Message{
+		SenderID:    hook.OwnerID,
+		ReceiverID:  hook.UserID,
+		Content:     payload.Text,
+		Format:      payload.Format,
+		Attachments: payload.Attachments,
+		SenderName:  hook.Name,
+		IsBot:       true,
+	}
+	if err := h.Service.Save(msg); err != nil {
+		http.Error(w, "failed to send message", http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(websocket.NewDirectMessage(msg, hook.Name))
+
+	h.Hub.BroadcastToUsers(
+		[]string{
+			msg.SenderID.String(),
+			msg.ReceiverID.String(),
+		},
+		data,
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":        msg.ID,
+		"timestamp": msg.CreatedAt,
+	})
+}
@@ -2,11 +2,13 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"time"
 
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/websocket"
 	"github.com/google/uuid"
 )
@@ -16,6 +18,67 @@ type MessageHandler struct {
 	Hub		*websocket.Hub
 }
 
+// Send posts a message over REST. It is the way bots, which have no
+// WebSocket connection, send messages; users may use it too.
+func (h *MessageHandler) Send(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	otherID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	if !middleware.ScopeAllowed(r, auth.ScopeMessagesSend, otherID) {
+		http.Error(w, "api key lacks scope "+auth.ScopeMessagesSend, http.StatusForbidden)
+		return
+	}
+
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Content == "" {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	sender, err := h.Service.Store.Users.Get(userID)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	receiver, err := h.Service.Store.Users.Get(otherID)
+	if err != nil || receiver.DeletedAt != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	msg := &models.Message{
+		SenderID:   sender.ID,
+		ReceiverID: receiver.ID,
+		Content:    body.Content,
+		IsBot:      sender.IsBot,
+	}
+	if err := h.Service.Save(msg); err != nil {
+		http.Error(w, "failed to send message", http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(websocket.NewDirectMessage(msg, sender.Username))
+
+	h.Hub.BroadcastToUsers(
+		[]string{
+			msg.SenderID.String(),
+			msg.ReceiverID.String(),
+		},
+		data,
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(msg)
+}
+
 func (h *MessageHandler) Edit(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
 
@@ -43,9 +106,10 @@ func (h *MessageHandler) Edit(w http.ResponseWriter, r *http.Request) {
 
 	// Build WS event
 	event := websocket.OutgoingMessage{
-		Type:    "message_edited",
-		ID:      msg.ID.String(),
-		Content: msg.Content,
+		Type:     "message_edited",
+		ID:       msg.ID.String(),
+		Content:  msg.Content,
+		Mentions: msg.Mentions,
 	}
 
 	data, _ := json.Marshal(event)
@@ -109,27 +173,21 @@ func (h *MessageHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var msg models.Message
-	if err := h.Service.DB.First(&msg, "id = ?", messageID).Error; err != nil {
+	msg, changed, err := h.Service.MarkRead(messageID, userID)
+	if errors.Is(err, websocket.ErrNotRecipient) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, store.ErrNotFound) {
 		http.Error(w, "message not found", http.StatusNotFound)
 		return
 	}
-
-	// Verify current user is receiver
-	if msg.ReceiverID != userID {
-		http.Error(w, "forbidden", http.StatusForbidden)
+	if err != nil {
+		http.Error(w, "failed to update message", http.StatusInternalServerError)
 		return
 	}
 
-	if !msg.IsRead {
-		now := time.Now()
-		msg.IsRead = true
-		msg.ReadAt = &now
-		if err := h.Service.DB.Save(&msg).Error; err != nil {
-			http.Error(w, "failed to update message", http.StatusInternalServerError)
-			return
-		}
-
+	if changed {
 		// Broadcast conversation_read event to both users
 		event := map[string]any{
 			"type":      "conversation_read",
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/push"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PushHandler struct {
+	DB *gorm.DB
+
+	// Nil when push notifications are not configured
+	VAPID *push.VAPID
+
+	// Accept plain http endpoints, for a local push service stand-in
+	AllowInsecure bool
+}
+
+func (h *PushHandler) configured(w http.ResponseWriter) bool {
+	if h.VAPID == nil {
+		http.Error(w, "push notifications are not configured", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// PublicKey returns the VAPID key browsers need to subscribe.
+func (h *PushHandler) PublicKey(w http.ResponseWriter, r *http.Request) {
+	if !h.configured(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"public_key": h.VAPID.PublicKey,
+	})
+}
+
+// Subscribe stores the PushSubscription a browser created. Subscribing an
+// endpoint again replaces its keys and moves it to the current session.
+func (h *PushHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	if !h.configured(w) {
+		return
+	}
+
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+	sessionID := r.Context().Value(middleware.SessionIDKey).(uuid.UUID)
+
+	// The shape of PushSubscription.toJSON() in the browser
+	var body struct {
+		Endpoint string `json:"endpoint"`
+		Keys     struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	endpoint, err := url.Parse(body.Endpoint)
+	if err != nil || endpoint.Host == "" ||
+		(endpoint.Scheme != "https" && !(h.AllowInsecure && endpoint.Scheme == "http")) {
+		http.Error(w, "endpoint must be an absolute https URL", http.StatusBadRequest)
+		return
+	}
+
+	if err := push.ValidateKeys(body.Keys.P256dh, body.Keys.Auth); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub := models.PushSubscription{
+		UserID:    userID,
+		SessionID: &sessionID,
+		Endpoint:  endpoint.String(),
+		P256dh:    body.Keys.P256dh,
+		Auth:      body.Keys.Auth,
+		UserAgent: r.UserAgent(),
+	}
+	err = h.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "endpoint"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "session_id", "p256dh", "auth", "user_agent"}),
+	}).Create(&sub).Error
+	if err != nil {
+		http.Error(w, "failed to save subscription", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub)
+}
+
+func (h *PushHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var subs []models.PushSubscription
+	if err := h.DB.Where("user_id = ?", userID).Order("created_at").Find(&subs).Error; err != nil {
+		http.Error(w, "failed to fetch subscriptions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subs)
+}
+
+func (h *PushHandler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid subscription id", http.StatusBadRequest)
+		return
+	}
+
+	res := h.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&models.PushSubscription{})
+	if res.Error != nil {
+		http.Error(w, "failed to delete subscription", http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "subscription not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
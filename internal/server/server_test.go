@@ -0,0 +1,1247 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/config"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/db"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/server"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/webhook"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newServer serves every route on a new migrated SQLite database. The
+// background jobs started with the routes outlive the test; they only poll.
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return newInstances(t, 1, nil)[0]
+}
+
+// newServerWith is newServer with the test configuration changed by
+// configure first.
+func newServerWith(t *testing.T, configure func(cfg *config.Config)) *httptest.Server {
+	t.Helper()
+	return newInstances(t, 1, configure)[0]
+}
+
+// newInstances serves the API n times over one database, as instances
+// behind a load balancer do. configure, if not nil, changes the test
+// configuration before the routes are registered.
+func newInstances(t *testing.T, n int, configure func(cfg *config.Config)) []*httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+
+	conn, err := db.Open("sqlite:" + filepath.Join(dir, "chat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator, err := db.NewMigrator(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(0); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		JWTSecret:            "test-secret",
+		PasswordMinLength:    8,
+		PasswordHash:         "bcrypt",
+		BcryptCost:           bcrypt.MinCost,
+		DeletedUserMessages:  "keep",
+		WebhookMaxAttempts:   1,
+		PublicURL:            "http://localhost",
+		ExportDir:            filepath.Join(dir, "exports"),
+		ExportTTLHours:       1,
+		PartitionMonthsAhead: 1,
+		ArchiveDir:           filepath.Join(dir, "archive"),
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	servers := make([]*httptest.Server, n)
+	for i := range servers {
+		if i > 0 {
+			if conn, err = db.Open("sqlite:" + filepath.Join(dir, "chat.db")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mux := http.NewServeMux()
+		server.RegisterRoutes(mux, conn, cfg)
+
+		servers[i] = httptest.NewServer(mux)
+		t.Cleanup(servers[i].Close)
+	}
+	return servers
+}
+
+// user is a registered user logged in on one session.
+type user struct {
+	t     *testing.T
+	srv   *httptest.Server
+	ID    string
+	Email string
+	Token string
+}
+
+const password = "correct horse battery"
+
+// register creates a user and logs them in.
+func register(t *testing.T, srv *httptest.Server, name string) *user {
+	t.Helper()
+	u := &user{t: t, srv: srv, Email: name + "@example.com"}
+
+	resp := u.request(http.MethodPost, "/auth/register", map[string]string{
+		"username": name,
+		"email":    u.Email,
+		"password": password,
+	})
+	expectStatus(t, resp, http.StatusCreated)
+
+	u.Token = u.login("laptop")
+
+	var me struct {
+		ID string `json:"id"`
+	}
+	decode(t, u.request(http.MethodGet, "/users/me", nil), &me)
+	u.ID = me.ID
+	return u
+}
+
+// login opens another session and returns its token.
+func (u *user) login(device string) string {
+	u.t.Helper()
+	resp := u.request(http.MethodPost, "/auth/login", map[string]string{
+		"email":       u.Email,
+		"password":    password,
+		"device_name": device,
+	})
+	var body struct {
+		Token string `json:"token"`
+	}
+	decode(u.t, resp, &body)
+	if body.Token == "" {
+		u.t.Fatal("login returned no token")
+	}
+	return body.Token
+}
+
+// request sends a request with the user's token, if they have one, and body
+// encoded as JSON.
+func (u *user) request(method, path string, body any) *http.Response {
+	u.t.Helper()
+	return u.requestWith(u.Token, method, path, body)
+}
+
+func (u *user) requestWith(token, method, path string, body any) *http.Response {
+	u.t.Helper()
+	var r bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&r).Encode(body); err != nil {
+			u.t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, u.srv.URL+path, &r)
+	if err != nil {
+		u.t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		u.t.Fatal(err)
+	}
+	u.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// send posts a message to other over REST and returns its id.
+func (u *user) send(other *user, content string) string {
+	u.t.Helper()
+	var msg struct {
+		ID string `json:"id"`
+	}
+	resp := u.request(http.MethodPost, "/chats/"+other.ID+"/messages", map[string]string{"content": content})
+	expectStatus(u.t, resp, http.StatusCreated)
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		u.t.Fatal(err)
+	}
+	return msg.ID
+}
+
+// ticket asks for a WebSocket ticket.
+func (u *user) ticket() string {
+	u.t.Helper()
+	var body struct {
+		Ticket    string `json:"ticket"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	decode(u.t, u.request(http.MethodPost, "/ws/ticket", nil), &body)
+	if body.Ticket == "" || body.ExpiresIn <= 0 {
+		u.t.Fatalf("unexpected ticket response %+v", body)
+	}
+	return body.Ticket
+}
+
+// connect opens a WebSocket with a new ticket and waits until the hub has
+// registered it.
+func (u *user) connect() *websocket.Conn {
+	u.t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL(u.srv, "/ws?ticket="+u.ticket()), nil)
+	if err != nil {
+		u.t.Fatalf("dial: %v (%v)", err, status(resp))
+	}
+	u.t.Cleanup(func() { conn.Close() })
+	next(u.t, conn, "online_users")
+	return conn
+}
+
+func wsURL(srv *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + path
+}
+
+func status(resp *http.Response) string {
+	if resp == nil {
+		return "no response"
+	}
+	return resp.Status
+}
+
+// next reads events from conn until one of the given type arrives.
+func next(t *testing.T, conn *websocket.Conn, typ string) map[string]any {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var event map[string]any
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("waiting for %s: %v", typ, err)
+		}
+		if event["type"] == typ {
+			return event
+		}
+	}
+}
+
+func decode(t *testing.T, resp *http.Response, v any) {
+	t.Helper()
+	expectStatus(t, resp, http.StatusOK)
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectStatus(t *testing.T, resp *http.Response, want int) {
+	t.Helper()
+	if resp.StatusCode != want {
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		t.Fatalf("%s %s: got %s (%s), want %d",
+			resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(body.String()), want)
+	}
+}
+
+// expectClosed reads from conn until the server closes it.
+func expectClosed(t *testing.T, conn *websocket.Conn, what string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				t.Fatalf("%s is still open", what)
+			}
+			return
+		}
+	}
+}
+
+// waitFor polls done until it reports true, for work the server finishes
+// in the background.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	return body.String()
+}
+
+func TestLoginAndSessions(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+
+	resp := alice.request(http.MethodPost, "/auth/login", map[string]string{
+		"email":    alice.Email,
+		"password": "wrong password",
+	})
+	expectStatus(t, resp, http.StatusUnauthorized)
+
+	resp = alice.requestWith("", http.MethodGet, "/users/me", nil)
+	expectStatus(t, resp, http.StatusUnauthorized)
+
+	phone := alice.login("phone")
+
+	type session struct {
+		ID         string `json:"id"`
+		DeviceName string `json:"device_name"`
+		Current    bool   `json:"current"`
+	}
+	var sessions []session
+	decode(t, alice.request(http.MethodGet, "/users/me/sessions", nil), &sessions)
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+	var phoneID string
+	for _, s := range sessions {
+		switch s.DeviceName {
+		case "laptop":
+			if !s.Current {
+				t.Error("the session of the token is not marked current")
+			}
+		case "phone":
+			if s.Current {
+				t.Error("another session is marked current")
+			}
+			phoneID = s.ID
+		default:
+			t.Errorf("unexpected session %+v", s)
+		}
+	}
+
+	// A socket of the revoked session is closed along with it
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(srv, "/ws?ticket="+(&user{t: t, srv: srv, Token: phone}).ticket()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	next(t, conn, "online_users")
+
+	resp = alice.request(http.MethodDelete, "/users/me/sessions/"+phoneID, nil)
+	expectStatus(t, resp, http.StatusNoContent)
+
+	expectClosed(t, conn, "socket of the revoked session")
+
+	resp = alice.requestWith(phone, http.MethodGet, "/users/me", nil)
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp = alice.request(http.MethodGet, "/users/me", nil)
+	expectStatus(t, resp, http.StatusOK)
+
+	resp = alice.request(http.MethodDelete, "/users/me/sessions/"+phoneID, nil)
+	expectStatus(t, resp, http.StatusNotFound)
+}
+
+func TestChangePassword(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+	phone := alice.login("phone")
+
+	change := func(current, next string) *http.Response {
+		return alice.request(http.MethodPost, "/users/me/password", map[string]string{
+			"current_password": current,
+			"new_password":     next,
+		})
+	}
+	refused := func(resp *http.Response, want int, message string) {
+		t.Helper()
+		expectStatus(t, resp, want)
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		if got := strings.TrimSpace(body.String()); got != message {
+			t.Fatalf("got %q, want %q", got, message)
+		}
+	}
+
+	refused(change("wrong password", "another horse battery"), http.StatusForbidden, "current password is incorrect")
+	refused(change(password, "short"), http.StatusBadRequest, "password must be at least 8 characters")
+	refused(change(password, password), http.StatusBadRequest, "new password must differ from the current one")
+
+	expectStatus(t, change(password, "another horse battery"), http.StatusNoContent)
+
+	// Other sessions are signed out, the one that changed it is not
+	expectStatus(t, alice.requestWith(phone, http.MethodGet, "/users/me", nil), http.StatusUnauthorized)
+	expectStatus(t, alice.request(http.MethodGet, "/users/me", nil), http.StatusOK)
+
+	resp := alice.request(http.MethodPost, "/auth/login", map[string]string{
+		"email":    alice.Email,
+		"password": "another horse battery",
+	})
+	expectStatus(t, resp, http.StatusOK)
+}
+
+func TestWebSocketTicket(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL(srv, "/ws"), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial without credentials: %v (%s)", err, status(resp))
+	}
+
+	// The JWT is not accepted in the query string unless enabled
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL(srv, "/ws?token="+alice.Token), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial with a token in the query: %v (%s)", err, status(resp))
+	}
+
+	ticket := alice.ticket()
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(srv, "/ws?ticket="+ticket), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL(srv, "/ws?ticket="+ticket), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial with a used ticket: %v (%s)", err, status(resp))
+	}
+
+	// Browsers pass the ticket as a subprotocol next to the one they speak
+	dialer := websocket.Dialer{Subprotocols: []string{"chat.v1", "ticket." + alice.ticket()}}
+	conn, _, err = dialer.Dial(wsURL(srv, "/ws"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != "chat.v1" {
+		t.Errorf("negotiated subprotocol %q, want chat.v1", conn.Subprotocol())
+	}
+}
+
+func TestWebSocketTicketAcrossInstances(t *testing.T) {
+	instances := newInstances(t, 2, nil)
+	alice := register(t, instances[0], "alice")
+
+	// Issued by one instance, redeemed on the other, and only once
+	ticket := alice.ticket()
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL(instances[1], "/ws?ticket="+ticket), nil)
+	if err != nil {
+		t.Fatalf("dial the other instance: %v (%s)", err, status(resp))
+	}
+	defer conn.Close()
+	next(t, conn, "online_users")
+
+	for _, srv := range instances {
+		_, resp, err = websocket.DefaultDialer.Dial(wsURL(srv, "/ws?ticket="+ticket), nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("dial with a used ticket: %v (%s)", err, status(resp))
+		}
+	}
+}
+
+func TestDirectMessage(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+	aliceConn, bobConn := alice.connect(), bob.connect()
+
+	err := aliceConn.WriteJSON(map[string]string{
+		"type":    "direct_message",
+		"to":      bob.ID,
+		"content": "hello bob",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := next(t, aliceConn, "direct_message")
+	received := next(t, bobConn, "direct_message")
+	for _, event := range []map[string]any{sent, received} {
+		if event["from"] != alice.ID || event["to"] != bob.ID || event["content"] != "hello bob" {
+			t.Fatalf("unexpected direct_message %v", event)
+		}
+	}
+	if sent["id"] != received["id"] {
+		t.Fatalf("sender and receiver got different messages: %v and %v", sent["id"], received["id"])
+	}
+	id := sent["id"].(string)
+
+	// Only the sender may edit
+	resp := bob.request(http.MethodPatch, "/messages/"+id, map[string]string{"content": "hijacked"})
+	expectStatus(t, resp, http.StatusForbidden)
+
+	resp = alice.request(http.MethodPatch, "/messages/"+id, map[string]string{"content": "hello, bob"})
+	expectStatus(t, resp, http.StatusOK)
+	for _, conn := range []*websocket.Conn{aliceConn, bobConn} {
+		if event := next(t, conn, "message_edited"); event["id"] != id || event["content"] != "hello, bob" {
+			t.Fatalf("unexpected message_edited %v", event)
+		}
+	}
+
+	// Only the receiver may mark it read
+	resp = alice.request(http.MethodPost, "/messages/"+id+"/read", nil)
+	expectStatus(t, resp, http.StatusForbidden)
+
+	resp = bob.request(http.MethodPost, "/messages/"+id+"/read", nil)
+	expectStatus(t, resp, http.StatusOK)
+	if event := next(t, aliceConn, "conversation_read"); event["reader_id"] != bob.ID {
+		t.Fatalf("unexpected conversation_read %v", event)
+	}
+
+	type message struct {
+		ID       string  `json:"id"`
+		Content  string  `json:"content"`
+		IsRead   bool    `json:"is_read"`
+		EditedAt *string `json:"edited_at"`
+	}
+	var history []message
+	decode(t, bob.request(http.MethodGet, "/chats/"+alice.ID, nil), &history)
+	if len(history) != 1 {
+		t.Fatalf("got %d messages, want 1", len(history))
+	}
+	if m := history[0]; m.ID != id || m.Content != "hello, bob" || !m.IsRead || m.EditedAt == nil {
+		t.Fatalf("unexpected message %+v", m)
+	}
+
+	resp = bob.request(http.MethodDelete, "/messages/"+id+"/delete", nil)
+	expectStatus(t, resp, http.StatusForbidden)
+
+	resp = alice.request(http.MethodDelete, "/messages/"+id+"/delete", nil)
+	expectStatus(t, resp, http.StatusOK)
+	for _, conn := range []*websocket.Conn{aliceConn, bobConn} {
+		if event := next(t, conn, "message_deleted"); event["id"] != id {
+			t.Fatalf("unexpected message_deleted %v", event)
+		}
+	}
+
+	history = nil
+	decode(t, bob.request(http.MethodGet, "/chats/"+alice.ID, nil), &history)
+	if len(history) != 0 {
+		t.Fatalf("deleted message still in history: %+v", history)
+	}
+}
+
+func TestHistoryCursors(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+
+	const total = 7
+	for i := range total {
+		if i%2 == 0 {
+			alice.send(bob, fmt.Sprint("message ", i))
+		} else {
+			bob.send(alice, fmt.Sprint("message ", i))
+		}
+	}
+
+	type message struct {
+		ID string `json:"id"`
+	}
+	page := func(query string) (ids []string, next, prev string) {
+		t.Helper()
+		resp := alice.request(http.MethodGet, "/chats/"+bob.ID+query, nil)
+		var messages []message
+		decode(t, resp, &messages)
+		for _, m := range messages {
+			ids = append(ids, m.ID)
+		}
+		return ids, resp.Header.Get("X-Next-Cursor"), resp.Header.Get("X-Prev-Cursor")
+	}
+
+	all, next, prev := page("?limit=100")
+	if len(all) != total || next != "" || prev != "" {
+		t.Fatalf("full history: %d messages, next %q, prev %q", len(all), next, prev)
+	}
+
+	// Walk back from the newest page by page
+	var walked []string
+	var pages []string
+	cursor := ""
+	for {
+		query := "?limit=3"
+		if cursor != "" {
+			query += "&before=" + cursor
+		}
+		ids, next, prev := page(query)
+		if (cursor == "") != (prev == "") {
+			t.Fatalf("page before %q has prev cursor %q", cursor, prev)
+		}
+		walked = append(walked, ids...)
+		pages = append(pages, cursor)
+		if next == "" {
+			break
+		}
+		cursor = next
+		if len(pages) > total {
+			t.Fatal("history does not end")
+		}
+	}
+	if strings.Join(walked, ",") != strings.Join(all, ",") {
+		t.Fatalf("paging back gave\n%v\nwant\n%v", walked, all)
+	}
+
+	// The prev cursor of the oldest page leads to the page before it
+	_, _, prev = page("?limit=3&before=" + cursor)
+	ids, next, _ := page("?limit=3&after=" + prev)
+	if strings.Join(ids, ",") != strings.Join(all[3:6], ",") {
+		t.Fatalf("page after the oldest: got %v, want %v", ids, all[3:6])
+	}
+	if next == "" {
+		t.Fatal("page after a cursor has no next cursor")
+	}
+
+	// A page around a message is centred on it
+	ids, next, prev = page("?limit=3&around=" + all[3])
+	if strings.Join(ids, ",") != strings.Join(all[2:5], ",") || next == "" || prev == "" {
+		t.Fatalf("page around %s: got %v (next %q, prev %q), want %v", all[3], ids, next, prev, all[2:5])
+	}
+
+	resp := alice.request(http.MethodGet, "/chats/"+bob.ID+"?before=nonsense", nil)
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp = alice.request(http.MethodGet, "/chats/"+bob.ID+"?before="+cursor+"&after="+cursor, nil)
+	expectStatus(t, resp, http.StatusBadRequest)
+}
+
+func TestConversationList(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+	carol := register(t, srv, "carol")
+
+	alice.send(bob, "hi bob")
+	alice.send(bob, "are you there?")
+	carol.send(bob, "hey")
+
+	type conversation struct {
+		ID        string `json:"id"`
+		OtherUser struct {
+			Username string `json:"username"`
+		} `json:"other_user"`
+		LastMessage struct {
+			Content string `json:"content"`
+		} `json:"last_message"`
+		UnreadCount int `json:"unread_count"`
+	}
+	list := func(token, query string) ([]conversation, string) {
+		t.Helper()
+		resp := bob.requestWith(token, http.MethodGet, "/conversations"+query, nil)
+		var convos []conversation
+		decode(t, resp, &convos)
+		return convos, resp.Header.Get("X-Next-Cursor")
+	}
+
+	// The most recent conversation comes first
+	first, cursor := list(bob.Token, "?limit=1")
+	if len(first) != 1 || cursor == "" {
+		t.Fatalf("first page: %d conversations, cursor %q", len(first), cursor)
+	}
+	if c := first[0]; c.ID != carol.ID || c.OtherUser.Username != "carol" || c.LastMessage.Content != "hey" || c.UnreadCount != 1 {
+		t.Fatalf("unexpected conversation %+v", c)
+	}
+
+	second, cursor := list(bob.Token, "?limit=1&cursor="+cursor)
+	if len(second) != 1 || cursor != "" {
+		t.Fatalf("second page: %d conversations, cursor %q", len(second), cursor)
+	}
+	if c := second[0]; c.ID != alice.ID || c.LastMessage.Content != "are you there?" || c.UnreadCount != 2 {
+		t.Fatalf("unexpected conversation %+v", c)
+	}
+
+	resp := bob.request(http.MethodPost, "/conversations/"+alice.ID+"/read", nil)
+	expectStatus(t, resp, http.StatusOK)
+
+	convos, _ := list(bob.Token, "")
+	if len(convos) != 2 {
+		t.Fatalf("got %d conversations, want 2", len(convos))
+	}
+	for _, c := range convos {
+		want := map[string]int{alice.ID: 0, carol.ID: 1}[c.ID]
+		if c.UnreadCount != want {
+			t.Errorf("conversation with %s has %d unread, want %d", c.OtherUser.Username, c.UnreadCount, want)
+		}
+	}
+
+	// The sender has nothing unread
+	convos, _ = list(alice.Token, "")
+	if len(convos) != 1 || convos[0].ID != bob.ID || convos[0].UnreadCount != 0 {
+		t.Fatalf("unexpected conversations of the sender %+v", convos)
+	}
+
+	resp = bob.request(http.MethodGet, "/conversations?cursor=nonsense", nil)
+	expectStatus(t, resp, http.StatusBadRequest)
+}
+
+func TestConversationWithDeletedUser(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+	alice.send(bob, "hi bob")
+
+	resp := bob.request(http.MethodDelete, "/users/me", map[string]string{"password": password})
+	expectStatus(t, resp, http.StatusNoContent)
+
+	var convos []struct {
+		OtherUser map[string]any `json:"other_user"`
+	}
+	decode(t, alice.request(http.MethodGet, "/conversations", nil), &convos)
+	if len(convos) != 1 {
+		t.Fatalf("got %d conversations, want 1", len(convos))
+	}
+
+	var created struct {
+		OtherUser map[string]any `json:"other_user"`
+	}
+	decode(t, alice.request(http.MethodPost, "/conversations", map[string]string{"user_id": bob.ID}), &created)
+
+	for _, other := range []map[string]any{convos[0].OtherUser, created.OtherUser} {
+		if other["deleted"] != true || other["username"] != "Deleted user" {
+			t.Fatalf("unexpected other user %v", other)
+		}
+		if _, ok := other["email"]; ok {
+			t.Fatalf("the email of a deleted user is shown: %v", other)
+		}
+	}
+}
+
+func TestMentions(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+	register(t, srv, "carol")
+	bobConn := bob.connect()
+
+	// Carol is not in the conversation, and an email address is not a
+	// mention
+	id := alice.send(bob, "@bob ask @carol or mail bob@example.com")
+
+	event := next(t, bobConn, "mentioned")
+	data, _ := event["data"].(map[string]any)
+	if msg, _ := data["message"].(map[string]any); msg["id"] != id {
+		t.Fatalf("unexpected mentioned %v", event)
+	}
+
+	type mention struct {
+		UserID   string `json:"user_id"`
+		Username string `json:"username"`
+		Offset   int    `json:"offset"`
+		Length   int    `json:"length"`
+	}
+	var history []struct {
+		ID       string    `json:"id"`
+		Mentions []mention `json:"mentions"`
+	}
+	decode(t, bob.request(http.MethodGet, "/chats/"+alice.ID, nil), &history)
+	if len(history) != 1 || len(history[0].Mentions) != 1 {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	if m := history[0].Mentions[0]; m.UserID != bob.ID || m.Username != "bob" || m.Offset != 0 || m.Length != len("@bob") {
+		t.Fatalf("unexpected mention %+v", m)
+	}
+
+	unreadMentions := func() int {
+		t.Helper()
+		var convos []struct {
+			UnreadMentions int `json:"unread_mentions"`
+		}
+		decode(t, bob.request(http.MethodGet, "/conversations", nil), &convos)
+		if len(convos) != 1 {
+			t.Fatalf("got %d conversations, want 1", len(convos))
+		}
+		return convos[0].UnreadMentions
+	}
+	if n := unreadMentions(); n != 1 {
+		t.Fatalf("got %d unread mentions, want 1", n)
+	}
+	expectStatus(t, bob.request(http.MethodPost, "/conversations/"+alice.ID+"/read", nil), http.StatusOK)
+	if n := unreadMentions(); n != 0 {
+		t.Fatalf("got %d unread mentions after reading, want 0", n)
+	}
+}
+
+func TestSlashCommands(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+	aliceConn, bobConn := alice.connect(), bob.connect()
+
+	say := func(content string) {
+		t.Helper()
+		err := aliceConn.WriteJSON(map[string]string{
+			"type":    "direct_message",
+			"to":      bob.ID,
+			"content": content,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	reply := func(isError bool) string {
+		t.Helper()
+		event := next(t, aliceConn, "command_response")
+		if event["is_error"] != isError {
+			t.Fatalf("unexpected command_response %v", event)
+		}
+		return event["content"].(string)
+	}
+
+	say("/shrug fine")
+	if event := next(t, aliceConn, "direct_message"); event["content"] != `fine ¯\_(ツ)_/¯` {
+		t.Fatalf("unexpected direct_message %v", event)
+	}
+	say("/me waves")
+	if event := next(t, aliceConn, "direct_message"); event["content"] != "waves" || event["format"] != "action" {
+		t.Fatalf("unexpected direct_message %v", event)
+	}
+	say("//etc/hosts is a path, not a command")
+	next(t, aliceConn, "direct_message")
+
+	say("/help")
+	if help := reply(false); !strings.Contains(help, "/shrug [message]") || !strings.Contains(help, "/mute") {
+		t.Fatalf("unexpected help %q", help)
+	}
+	say("/frobnicate")
+	if got := reply(true); got != "unknown command /frobnicate, see /help" {
+		t.Fatalf("unexpected reply %q", got)
+	}
+	say("/mute 2h")
+	reply(false)
+
+	var settings struct {
+		Muted      bool       `json:"muted"`
+		MutedUntil *time.Time `json:"muted_until"`
+	}
+	decode(t, alice.request(http.MethodGet, "/conversations/"+bob.ID+"/settings", nil), &settings)
+	if !settings.Muted || settings.MutedUntil == nil || settings.MutedUntil.Before(time.Now().Add(time.Hour)) {
+		t.Fatalf("unexpected settings after /mute %+v", settings)
+	}
+
+	// The other side gets the posts and none of the replies
+	say("done")
+	var got []string
+	bobConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(got) == 0 || got[len(got)-1] != "done" {
+		var event map[string]any
+		if err := bobConn.ReadJSON(&event); err != nil {
+			t.Fatalf("waiting for messages: %v", err)
+		}
+		switch event["type"] {
+		case "command_response":
+			t.Fatalf("the other side got a command reply %v", event)
+		case "direct_message":
+			got = append(got, event["content"].(string))
+		}
+	}
+	want := []string{`fine ¯\_(ツ)_/¯`, "waves", "/etc/hosts is a path, not a command", "done"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("the other side got %q, want %q", got, want)
+	}
+
+	var list []struct {
+		Name    string `json:"name"`
+		Builtin bool   `json:"builtin"`
+	}
+	decode(t, alice.request(http.MethodGet, "/commands?prefix=/m", nil), &list)
+	var names []string
+	for _, c := range list {
+		if !c.Builtin {
+			t.Errorf("/%s is not marked built in", c.Name)
+		}
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != "me,mute" {
+		t.Fatalf("commands starting with m: %v", names)
+	}
+}
+
+// delivery is a request received by a webhook endpoint.
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+// newReceiver serves a webhook endpoint that hands every request it gets to
+// the returned channel.
+func newReceiver(t *testing.T) (*httptest.Server, <-chan delivery) {
+	t.Helper()
+	received := make(chan delivery, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- delivery{header: r.Header, body: body}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+// subscribe creates an outgoing webhook to url for the events.
+func (u *user) subscribe(url string, events ...string) (id, secret string) {
+	u.t.Helper()
+	resp := u.request(http.MethodPost, "/webhooks", map[string]any{"url": url, "events": events})
+	expectStatus(u.t, resp, http.StatusCreated)
+	var sub struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&sub); err != nil {
+		u.t.Fatal(err)
+	}
+	return sub.ID, sub.Secret
+}
+
+type webhookDelivery struct {
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+func (u *user) deliveries(subscriptionID string) []webhookDelivery {
+	u.t.Helper()
+	var list []webhookDelivery
+	decode(u.t, u.request(http.MethodGet, "/webhooks/"+subscriptionID+"/deliveries", nil), &list)
+	return list
+}
+
+func TestOutgoingWebhook(t *testing.T) {
+	receiver, received := newReceiver(t)
+	srv := newServerWith(t, func(cfg *config.Config) { cfg.WebhookAllowPrivate = true })
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+	carol := register(t, srv, "carol")
+
+	resp := bob.request(http.MethodPost, "/webhooks", map[string]any{"url": receiver.URL, "events": []string{"message.sent"}})
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp = bob.request(http.MethodPost, "/webhooks", map[string]any{
+		"url":       receiver.URL,
+		"events":    []string{"message.created"},
+		"all_users": true,
+	})
+	expectStatus(t, resp, http.StatusForbidden)
+
+	subID, secret := bob.subscribe(receiver.URL, "message.created")
+	carolSub, _ := carol.subscribe(receiver.URL, "message.created")
+	id := alice.send(bob, "hello bob")
+
+	var d delivery
+	select {
+	case d = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery arrived")
+	}
+	if event := d.header.Get("X-Webhook-Event"); event != "message.created" {
+		t.Fatalf("delivered event %q", event)
+	}
+	var timestamp int64
+	signature := d.header.Get(webhook.SignatureHeader)
+	if _, err := fmt.Sscanf(signature, "t=%d,", &timestamp); err != nil {
+		t.Fatalf("signature %q: %v", signature, err)
+	}
+	if signature != webhook.Sign(secret, timestamp, d.body) {
+		t.Fatalf("signature %q does not match the body", signature)
+	}
+
+	var payload struct {
+		Event string `json:"event"`
+		Data  struct {
+			ID      string `json:"id"`
+			Content string `json:"content"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(d.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "message.created" || payload.Data.ID != id || payload.Data.Content != "hello bob" {
+		t.Fatalf("unexpected payload %s", d.body)
+	}
+
+	// The payload is dropped once delivered
+	waitFor(t, "the delivery to be recorded", func() bool {
+		list := bob.deliveries(subID)
+		if len(list) != 1 {
+			t.Fatalf("got %d deliveries, want 1", len(list))
+		}
+		return list[0].Status == "delivered"
+	})
+	if list := bob.deliveries(subID); list[0].Attempts != 1 || string(list[0].Payload) != "null" {
+		t.Fatalf("unexpected delivery %+v", list[0])
+	}
+
+	// Carol is not in the conversation
+	if list := carol.deliveries(carolSub); len(list) != 0 {
+		t.Fatalf("a subscriber outside the conversation got %d deliveries", len(list))
+	}
+	expectStatus(t, alice.request(http.MethodGet, "/webhooks/"+subID+"/deliveries", nil), http.StatusNotFound)
+}
+
+func TestOutgoingWebhookToPrivateAddress(t *testing.T) {
+	receiver, received := newReceiver(t)
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+
+	subID, _ := bob.subscribe(receiver.URL, "message.created")
+	alice.send(bob, "hello bob")
+
+	// The test server allows one attempt
+	var d webhookDelivery
+	waitFor(t, "the delivery to fail", func() bool {
+		list := bob.deliveries(subID)
+		if len(list) != 1 {
+			t.Fatalf("got %d deliveries, want 1", len(list))
+		}
+		d = list[0]
+		return d.Status != "pending"
+	})
+	if d.Status != "dead" || !strings.Contains(d.LastError, "private address") {
+		t.Fatalf("unexpected delivery %+v", d)
+	}
+	select {
+	case <-received:
+		t.Fatal("a delivery reached a loopback address")
+	default:
+	}
+}
+
+func TestIncomingWebhook(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+	bobConn := bob.connect()
+
+	resp := alice.request(http.MethodPost, "/incoming-webhooks", map[string]string{"user_id": bob.ID, "name": "CI"})
+	expectStatus(t, resp, http.StatusCreated)
+	var hook struct {
+		ID   string `json:"id"`
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&hook); err != nil {
+		t.Fatal(err)
+	}
+
+	// The token in the path is the only credential
+	resp = alice.requestWith("", http.MethodPost, hook.Path, map[string]string{"text": "build passed"})
+	expectStatus(t, resp, http.StatusCreated)
+
+	event := next(t, bobConn, "direct_message")
+	if event["from"] != alice.ID || event["content"] != "build passed" || event["sender_username"] != "CI" || event["is_bot"] != true {
+		t.Fatalf("unexpected direct_message %v", event)
+	}
+
+	resp = alice.requestWith("", http.MethodPost, hook.Path, map[string]string{})
+	expectStatus(t, resp, http.StatusBadRequest)
+
+	expectStatus(t, bob.request(http.MethodDelete, "/incoming-webhooks/"+hook.ID, nil), http.StatusNotFound)
+	expectStatus(t, alice.request(http.MethodDelete, "/incoming-webhooks/"+hook.ID, nil), http.StatusNoContent)
+	resp = alice.requestWith("", http.MethodPost, hook.Path, map[string]string{"text": "build passed"})
+	expectStatus(t, resp, http.StatusNotFound)
+}
+
+func TestExports(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+	alice.send(bob, "hello bob")
+	bob.send(alice, "hi alice")
+
+	var doc struct {
+		User      struct{ Username string } `json:"user"`
+		OtherUser struct{ Username string } `json:"other_user"`
+		Messages  []struct {
+			Sender  string `json:"sender"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	decode(t, alice.request(http.MethodGet, "/chats/"+bob.ID+"/export?format=json", nil), &doc)
+	if doc.User.Username != "alice" || doc.OtherUser.Username != "bob" || len(doc.Messages) != 2 {
+		t.Fatalf("unexpected export %+v", doc)
+	}
+	if m := doc.Messages[0]; m.Sender != "alice" || m.Content != "hello bob" {
+		t.Fatalf("oldest message exported as %+v", m)
+	}
+
+	resp := alice.request(http.MethodGet, "/chats/"+bob.ID+"/export?format=pdf", nil)
+	expectStatus(t, resp, http.StatusBadRequest)
+
+	resp = alice.request(http.MethodPost, "/chats/"+bob.ID+"/exports", map[string]string{"format": "txt"})
+	expectStatus(t, resp, http.StatusAccepted)
+	location := resp.Header.Get("Location")
+	if location == "" {
+		t.Fatal("queued export has no Location")
+	}
+
+	waitFor(t, "the export", func() bool {
+		var job struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		decode(t, alice.request(http.MethodGet, location, nil), &job)
+		if job.Status == "failed" {
+			t.Fatalf("export failed: %s", job.Error)
+		}
+		return job.Status == "done"
+	})
+
+	resp = alice.request(http.MethodGet, location+"/download", nil)
+	expectStatus(t, resp, http.StatusOK)
+	if body := readBody(t, resp); !strings.Contains(body, "hello bob") || !strings.Contains(body, "hi alice") {
+		t.Fatalf("downloaded export:\n%s", body)
+	}
+
+	// Jobs are private to the user who asked for them
+	expectStatus(t, bob.request(http.MethodGet, location, nil), http.StatusNotFound)
+	expectStatus(t, bob.request(http.MethodGet, location+"/download", nil), http.StatusNotFound)
+}
+
+func TestNotificationSettings(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+	bobConn := bob.connect()
+	path := "/conversations/" + alice.ID + "/settings"
+
+	type conversationSettings struct {
+		Muted      bool       `json:"muted"`
+		MutedUntil *time.Time `json:"muted_until"`
+		Notify     string     `json:"notify"`
+	}
+	update := func(body map[string]any) conversationSettings {
+		t.Helper()
+		var s conversationSettings
+		decode(t, bob.request(http.MethodPatch, path, body), &s)
+		return s
+	}
+
+	var s conversationSettings
+	decode(t, bob.request(http.MethodGet, path, nil), &s)
+	if s.Muted || s.MutedUntil != nil || s.Notify != "all" {
+		t.Fatalf("unexpected defaults %+v", s)
+	}
+	expectStatus(t, bob.request(http.MethodPatch, path, map[string]any{"notify": "sometimes"}), http.StatusBadRequest)
+	expectStatus(t, bob.request(http.MethodPatch, path, map[string]any{"muted_until": time.Now().Add(-time.Hour)}), http.StatusBadRequest)
+
+	// Neither a muted conversation nor one set to notify of nothing tells
+	// about mentions
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if s := update(map[string]any{"muted_until": until}); !s.Muted || s.MutedUntil == nil || !s.MutedUntil.Equal(until) {
+		t.Fatalf("unexpected settings after muting %+v", s)
+	}
+	alice.send(bob, "@bob while muted")
+
+	if s := update(map[string]any{"muted": false, "notify": "none"}); s.Muted || s.MutedUntil != nil || s.Notify != "none" {
+		t.Fatalf("unexpected settings after unmuting %+v", s)
+	}
+	alice.send(bob, "@bob with notify none")
+
+	// Nor do quiet hours
+	update(map[string]any{"notify": "mentions"})
+	now := time.Now().UTC()
+	quiet := map[string]any{
+		"enabled":  true,
+		"start":    now.Add(-time.Hour).Format("15:04"),
+		"end":      now.Add(time.Hour).Format("15:04"),
+		"timezone": "UTC",
+	}
+	type preferences struct {
+		QuietHours struct {
+			Enabled  bool   `json:"enabled"`
+			Start    string `json:"start"`
+			End      string `json:"end"`
+			Timezone string `json:"timezone"`
+		} `json:"quiet_hours"`
+		EmailDigest bool `json:"email_digest"`
+	}
+	var prefs preferences
+	resp := bob.request(http.MethodPut, "/users/me/notifications", map[string]any{"quiet_hours": quiet, "email_digest": false})
+	decode(t, resp, &prefs)
+	prefs = preferences{}
+	decode(t, bob.request(http.MethodGet, "/users/me/notifications", nil), &prefs)
+	if q := prefs.QuietHours; !q.Enabled || q.Start != quiet["start"] || q.End != quiet["end"] || q.Timezone != "UTC" || prefs.EmailDigest {
+		t.Fatalf("unexpected preferences %+v", prefs)
+	}
+	alice.send(bob, "@bob in quiet hours")
+
+	quiet["timezone"] = "Mars/Olympus_Mons"
+	resp = bob.request(http.MethodPut, "/users/me/notifications", map[string]any{"quiet_hours": quiet})
+	expectStatus(t, resp, http.StatusBadRequest)
+
+	resp = bob.request(http.MethodPut, "/users/me/notifications", map[string]any{"quiet_hours": map[string]any{"enabled": false}})
+	expectStatus(t, resp, http.StatusOK)
+	alice.send(bob, "@bob hello")
+
+	// Events arrive in order, so the first one is for the last message
+	event := next(t, bobConn, "mentioned")
+	data, _ := event["data"].(map[string]any)
+	if msg, _ := data["message"].(map[string]any); msg["content"] != "@bob hello" {
+		t.Fatalf("mentioned event for a silenced message %v", event)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	srv := newServer(t)
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+	alice.send(bob, "hi bob")
+	bob.send(alice, "hi alice")
+	bobConn := bob.connect()
+
+	resp := bob.request(http.MethodDelete, "/users/me", map[string]string{"password": "wrong password"})
+	expectStatus(t, resp, http.StatusForbidden)
+	resp = bob.request(http.MethodDelete, "/users/me", map[string]string{"password": password})
+	expectStatus(t, resp, http.StatusNoContent)
+
+	expectClosed(t, bobConn, "socket of the deleted account")
+	expectStatus(t, bob.request(http.MethodGet, "/users/me", nil), http.StatusUnauthorized)
+	resp = bob.requestWith("", http.MethodPost, "/auth/login", map[string]string{
+		"email":    bob.Email,
+		"password": password,
+	})
+	expectStatus(t, resp, http.StatusUnauthorized)
+
+	// The messages stay under the default policy, and nothing new can be
+	// sent to the account
+	var history []struct {
+		Content string `json:"content"`
+	}
+	decode(t, alice.request(http.MethodGet, "/chats/"+bob.ID, nil), &history)
+	if len(history) != 2 {
+		t.Fatalf("got %d messages, want 2", len(history))
+	}
+	resp = alice.request(http.MethodPost, "/chats/"+bob.ID+"/messages", map[string]string{"content": "bob?"})
+	expectStatus(t, resp, http.StatusNotFound)
+}
+
+func TestDeleteAccountPurgesMessages(t *testing.T) {
+	srv := newServerWith(t, func(cfg *config.Config) { cfg.DeletedUserMessages = auth.DeletedMessagesPurge })
+	alice := register(t, srv, "alice")
+	bob := register(t, srv, "bob")
+	alice.send(bob, "hi bob")
+	bob.send(alice, "hi alice")
+
+	resp := bob.request(http.MethodDelete, "/users/me", map[string]string{"password": password})
+	expectStatus(t, resp, http.StatusNoContent)
+
+	// Only the messages the account sent go
+	var history []struct {
+		Content string `json:"content"`
+	}
+	decode(t, alice.request(http.MethodGet, "/chats/"+bob.ID, nil), &history)
+	if len(history) != 1 || history[0].Content != "hi bob" {
+		t.Fatalf("history after purging %+v", history)
+	}
+}
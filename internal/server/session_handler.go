@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/websocket"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SessionHandler struct {
+	DB  *gorm.DB
+	Hub *websocket.Hub
+}
+
+func (h *SessionHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+	currentID := r.Context().Value(middleware.SessionIDKey).(uuid.UUID)
+
+	sessions, err := auth.ListSessions(h.DB, userID)
+	if err != nil {
+		http.Error(w, "failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]map[string]any, 0, len(sessions))
+	for _, s := range sessions {
+		response = append(response, map[string]any{
+			"id":          s.ID,
+			"device_name": s.DeviceName,
+			"ip":          s.IP,
+			"user_agent":  s.UserAgent,
+			"created":     s.CreatedAt,
+			"last_used":   s.LastUsedAt,
+			"current":     s.ID == currentID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *SessionHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	sessionID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid session id", http.StatusBadRequest)
+		return
+	}
+
+	if err := auth.RevokeSession(h.DB, userID, sessionID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	// Drop any live sockets authenticated with the revoked session
+	h.Hub.DisconnectSession(sessionID.String(), "session revoked")
+
+	w.WriteHeader(http.StatusNoContent)
+}
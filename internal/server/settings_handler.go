@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/notify"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SettingsHandler struct {
+	DB *gorm.DB
+}
+
+func conversationSettingsResponse(s *models.ConversationSetting) map[string]any {
+	muted := s.IsMuted(time.Now())
+
+	var mutedUntil *time.Time
+	if muted {
+		mutedUntil = s.MutedUntil
+	}
+
+	return map[string]any{
+		"muted":       muted,
+		"muted_until": mutedUntil,
+		"notify":      s.Notify,
+	}
+}
+
+func notificationPreferenceResponse(p *models.NotificationPreference) map[string]any {
+	return map[string]any{
+		"quiet_hours": map[string]any{
+			"enabled":  p.QuietHoursEnabled,
+			"start":    fmt.Sprintf("%02d:%02d", p.QuietStart/60, p.QuietStart%60),
+			"end":      fmt.Sprintf("%02d:%02d", p.QuietEnd/60, p.QuietEnd%60),
+			"timezone": p.Timezone,
+		},
+		"email_digest": !p.EmailDigestDisabled,
+	}
+}
+
+func (h *SettingsHandler) ConversationSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	otherID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	setting, err := notify.ConversationSetting(h.DB, userID, otherID)
+	if err != nil {
+		http.Error(w, "failed to fetch settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversationSettingsResponse(&setting))
+}
+
+// UpdateConversationSettings changes the fields present in the body and
+// leaves the others as they are.
+func (h *SettingsHandler) UpdateConversationSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	otherID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil || otherID == userID {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Muted      *bool      `json:"muted"`
+		MutedUntil *time.Time `json:"muted_until"`
+		Notify     *string    `json:"notify"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var other models.User
+	if err := h.DB.First(&other, "id = ?", otherID).Error; err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	setting, err := notify.ConversationSetting(h.DB, userID, otherID)
+	if err != nil {
+		http.Error(w, "failed to fetch settings", http.StatusInternalServerError)
+		return
+	}
+
+	if body.Muted != nil {
+		setting.Muted = *body.Muted
+		setting.MutedUntil = nil
+	}
+	if body.MutedUntil != nil {
+		if !body.MutedUntil.After(time.Now()) {
+			http.Error(w, "muted_until must be in the future", http.StatusBadRequest)
+			return
+		}
+		setting.Muted = true
+		setting.MutedUntil = body.MutedUntil
+	}
+	if body.Notify != nil {
+		switch *body.Notify {
+		case models.NotifyAll, models.NotifyMentions, models.NotifyNone:
+			setting.Notify = *body.Notify
+		default:
+			http.Error(w, "notify must be all, mentions or none", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.DB.Save(&setting).Error; err != nil {
+		http.Error(w, "failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversationSettingsResponse(&setting))
+}
+
+func (h *SettingsHandler) Notifications(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	pref, err := notify.Preference(h.DB, userID)
+	if err != nil {
+		http.Error(w, "failed to fetch preferences", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notificationPreferenceResponse(&pref))
+}
+
+// UpdateNotifications changes the sections present in the body and leaves
+// the others as they are.
+func (h *SettingsHandler) UpdateNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var body struct {
+		QuietHours *struct {
+			Enabled  bool   `json:"enabled"`
+			Start    string `json:"start"`
+			End      string `json:"end"`
+			Timezone string `json:"timezone"`
+		} `json:"quiet_hours"`
+		EmailDigest *bool `json:"email_digest"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	pref, err := notify.Preference(h.DB, userID)
+	if err != nil {
+		http.Error(w, "failed to fetch preferences", http.StatusInternalServerError)
+		return
+	}
+
+	if quiet := body.QuietHours; quiet != nil {
+		pref.QuietHoursEnabled = quiet.Enabled
+		pref.QuietStart = 0
+		pref.QuietEnd = 0
+		pref.Timezone = quiet.Timezone
+		if pref.Timezone == "" {
+			pref.Timezone = "UTC"
+		}
+		if _, err := time.LoadLocation(pref.Timezone); err != nil {
+			http.Error(w, "unknown timezone", http.StatusBadRequest)
+			return
+		}
+
+		if pref.QuietHoursEnabled {
+			start, err := time.Parse("15:04", quiet.Start)
+			if err != nil {
+				http.Error(w, "start must be HH:MM", http.StatusBadRequest)
+				return
+			}
+			end, err := time.Parse("15:04", quiet.End)
+			if err != nil {
+				http.Error(w, "end must be HH:MM", http.StatusBadRequest)
+				return
+			}
+			pref.QuietStart = start.Hour()*60 + start.Minute()
+			pref.QuietEnd = end.Hour()*60 + end.Minute()
+			if pref.QuietStart == pref.QuietEnd {
+				http.Error(w, "start and end must differ", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	if body.EmailDigest != nil {
+		pref.EmailDigestDisabled = !*body.EmailDigest
+	}
+
+	if err := h.DB.Save(&pref).Error; err != nil {
+		http.Error(w, "failed to save preferences", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notificationPreferenceResponse(&pref))
+}
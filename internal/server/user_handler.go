@@ -2,23 +2,43 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/websocket"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type UserHandler struct {
-	DB *gorm.DB
+	Store     *store.Store
+	DB        *gorm.DB
+	Hub       *websocket.Hub
+	Passwords *auth.Passwords
+
+	// Profiles and searches are read through it
+	Reads *store.Router
+
+	// DeletedMessages is the auth.DeletedMessages* policy applied to a
+	// user's sent messages when they delete their account
+	DeletedMessages string
 }
 
 func (h *UserHandler) Me(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(middleware.UserIDKey)
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
 
-	var user models.User
-	if err := h.DB.First(&user, "id = ?", userID).Error; err != nil {
+	var user *models.User
+	err := h.Reads.Read(userID, func(st *store.Store) error {
+		var err error
+		user, err = st.Users.Get(userID)
+		return err
+	})
+	if err != nil {
 		http.Error(w, "user not found", http.StatusNotFound)
 		return
 	}
@@ -49,36 +69,22 @@ func (h *UserHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build update map (partial updates allowed)
-	updates := map[string]any{}
-
-	if body.Username != nil {
-		updates["username"] = *body.Username
-	}
-
-	if body.Email != nil {
-		updates["email"] = *body.Email
-	}
-
-	if len(updates) == 0 {
+	// Partial updates allowed
+	if body.Username == nil && body.Email == nil {
 		http.Error(w, "no fields to update", http.StatusBadRequest)
 		return
 	}
 
 	// Perform update (authorized by userID)
-	if err := h.DB.
-		Model(&models.User{}).
-		Where("id = ?", userID).
-		Updates(updates).Error; err != nil {
-
+	user, err := h.Store.Users.Update(userID, store.UserChanges{
+		Username: body.Username,
+		Email:    body.Email,
+	})
+	if err != nil {
 		http.Error(w, "update failed", http.StatusBadRequest)
 		return
 	}
 
-	// Fetch updated user
-	var user models.User
-	h.DB.First(&user, "id = ?", userID)
-
 	// Return safe response
 	response := map[string]interface{}{
 		"id":       user.ID,
@@ -98,8 +104,15 @@ func (h *UserHandler) Search(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
 	var users []models.User
-	if err := h.DB.Limit(20).Find(&users, "username LIKE ? OR email LIKE ?", "%"+q+"%", "%"+q+"%").Error; err != nil {
+	err := h.Reads.Read(userID, func(st *store.Store) error {
+		var err error
+		users, err = st.Users.Search(q, 20)
+		return err
+	})
+	if err != nil {
 		http.Error(w, "failed to search users", http.StatusInternalServerError)
 		return
 	}
@@ -116,3 +129,43 @@ func (h *UserHandler) Search(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+	sessionID := r.Context().Value(middleware.SessionIDKey).(uuid.UUID)
+
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := auth.ChangePassword(h.Store.Users, h.Passwords, userID, body.CurrentPassword, body.NewPassword)
+	var policyErr *auth.PolicyError
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		http.Error(w, "current password is incorrect", http.StatusForbidden)
+		return
+	case errors.As(err, &policyErr), errors.Is(err, auth.ErrBreachedPassword), errors.Is(err, auth.ErrSamePassword):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Println("failed to change password:", err)
+		http.Error(w, "failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	// Everyone else holding a token for this account is signed out
+	revoked, err := auth.RevokeOtherSessions(h.DB, userID, sessionID)
+	if err != nil {
+		log.Println("failed to revoke sessions after password change:", err)
+	}
+	for _, id := range revoked {
+		h.Hub.DisconnectSession(id.String(), "password changed")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/events"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/webhook"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type WebhookHandler struct {
+	DB *gorm.DB
+}
+
+func subscriptionResponse(s *models.WebhookSubscription) map[string]any {
+	return map[string]any{
+		"id":        s.ID,
+		"url":       s.URL,
+		"events":    s.EventList(),
+		"all_users": s.AllUsers,
+		"created":   s.CreatedAt,
+	}
+}
+
+// ownedSubscription loads the subscription named in the path if it belongs
+// to the authenticated user, writing the error response otherwise.
+func (h *WebhookHandler) ownedSubscription(w http.ResponseWriter, r *http.Request) (*models.WebhookSubscription, bool) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var sub models.WebhookSubscription
+	if err := h.DB.First(&sub, "id = ? AND owner_id = ? AND deleted_at IS NULL", id, userID).Error; err != nil {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return &sub, true
+}
+
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var body struct {
+		URL      string   `json:"url"`
+		Events   []string `json:"events"`
+		AllUsers bool     `json:"all_users"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	target, err := url.Parse(body.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, "url must be an absolute http(s) URL", http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Events) == 0 {
+		http.Error(w, "at least one event is required", http.StatusBadRequest)
+		return
+	}
+	for _, e := range body.Events {
+		if !slices.Contains(events.All, e) {
+			http.Error(w, "unknown event: "+e, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Receiving every user's events is reserved for admins
+	if body.AllUsers {
+		var user models.User
+		if err := h.DB.First(&user, "id = ?", userID).Error; err != nil || !user.IsAdmin {
+			http.Error(w, "only admins can subscribe to all users", http.StatusForbidden)
+			return
+		}
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		http.Error(w, "failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	sub := models.WebhookSubscription{
+		OwnerID:  userID,
+		URL:      target.String(),
+		Secret:   secret,
+		Events:   strings.Join(body.Events, " "),
+		AllUsers: body.AllUsers,
+	}
+	if err := h.DB.Create(&sub).Error; err != nil {
+		http.Error(w, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	// The signing secret is only returned on creation
+	response := subscriptionResponse(&sub)
+	response["secret"] = secret
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var subs []models.WebhookSubscription
+	err := h.DB.
+		Where("owner_id = ? AND deleted_at IS NULL", userID).
+		Order("created_at").
+		Find(&subs).Error
+	if err != nil {
+		http.Error(w, "failed to fetch webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]map[string]any, 0, len(subs))
+	for i := range subs {
+		response = append(response, subscriptionResponse(&subs[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.DB.Model(sub).Update("deleted_at", time.Now()).Error; err != nil {
+		http.Error(w, "failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries is the delivery log of a subscription, newest first.
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 && v <= 200 {
+			limit = v
+		}
+	}
+
+	query := h.DB.
+		Where("subscription_id = ?", sub.ID).
+		Order("created_at DESC").
+		Limit(limit)
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := query.Find(&deliveries).Error; err != nil {
+		http.Error(w, "failed to fetch deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]map[string]any, 0, len(deliveries))
+	for _, d := range deliveries {
+		// The payload is dropped once the delivery is sent or dead
+		var payload json.RawMessage
+		if d.Payload != "" {
+			payload = json.RawMessage(d.Payload)
+		}
+
+		response = append(response, map[string]any{
+			"id":               d.ID,
+			"event":            d.Event,
+			"status":           d.Status,
+			"attempts":         d.Attempts,
+			"next_attempt_at":  d.NextAttemptAt,
+			"last_status_code": d.LastStatusCode,
+			"last_error":       d.LastError,
+			"created":          d.CreatedAt,
+			"delivered_at":     d.DeliveredAt,
+			"payload":          payload,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeadLetters lists the deliveries of a subscription that exhausted their
+// retries.
+func (h *WebhookHandler) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.ownedSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	var letters []models.WebhookDeadLetter
+	err := h.DB.
+		Where("subscription_id = ?", sub.ID).
+		Order("created_at DESC").
+		Limit(200).
+		Find(&letters).Error
+	if err != nil {
+		http.Error(w, "failed to fetch dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]map[string]any, 0, len(letters))
+	for _, l := range letters {
+		response = append(response, map[string]any{
+			"id":          l.ID,
+			"delivery_id": l.DeliveryID,
+			"event":       l.Event,
+			"attempts":    l.Attempts,
+			"last_error":  l.LastError,
+			"created":     l.CreatedAt,
+			"payload":     json.RawMessage(l.Payload),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/clientip"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/middleware"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/websocket"
+	"github.com/google/uuid"
+)
+
+type TicketHandler struct {
+	Tickets *websocket.TicketStore
+	BindIP  bool
+}
+
+// Issue hands out a single-use ticket for opening a WebSocket connection.
+func (h *TicketHandler) Issue(w http.ResponseWriter, r *http.Request) {
+	claims := &auth.Claims{
+		UserID:    r.Context().Value(middleware.UserIDKey).(uuid.UUID),
+		SessionID: r.Context().Value(middleware.SessionIDKey).(uuid.UUID),
+	}
+
+	ip := ""
+	if h.BindIP {
+		ip = clientip.FromRequest(r)
+	}
+
+	ticket, expiresAt, err := h.Tickets.Issue(claims, ip)
+	if err != nil {
+		http.Error(w, "failed to issue ticket", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{
+		"ticket":     ticket,
+		"expires_at": expiresAt,
+		"expires_in": int(websocket.TicketTTL.Seconds()),
+	})
+}
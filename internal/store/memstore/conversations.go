@@ -0,0 +1,114 @@
+package memstore
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+)
+
+type Conversations struct {
+	*data
+}
+
+func (r *Conversations) List(userID uuid.UUID, after *store.ConversationCursor, limit int) ([]store.Conversation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	byOther := map[uuid.UUID]*store.Conversation{}
+	for _, m := range r.messages {
+		if m.IsDeleted {
+			continue
+		}
+
+		var otherID uuid.UUID
+		switch userID {
+		case m.SenderID:
+			otherID = m.ReceiverID
+		case m.ReceiverID:
+			otherID = m.SenderID
+		default:
+			continue
+		}
+
+		c, ok := byOther[otherID]
+		if !ok {
+			other, ok := r.users[otherID]
+			if !ok {
+				continue
+			}
+			c = &store.Conversation{Other: *other}
+			byOther[otherID] = c
+		}
+		if m.CreatedAt.After(c.LastActivity) {
+			c.LastActivity = m.CreatedAt
+		}
+	}
+
+	list := make([]store.Conversation, 0, len(byOther))
+	for _, c := range byOther {
+		if after != nil && !before(*after, c.Cursor()) {
+			continue
+		}
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return before(list[i].Cursor(), list[j].Cursor())
+	})
+	list = list[:min(limit, len(list))]
+
+	for i := range list {
+		list[i].ConversationSummary = r.summary(userID, list[i].Other.ID)
+	}
+	return list, nil
+}
+
+// before reports whether a comes before b in a conversation list.
+func before(a, b store.ConversationCursor) bool {
+	if !a.LastActivity.Equal(b.LastActivity) {
+		return a.LastActivity.After(b.LastActivity)
+	}
+	return bytes.Compare(a.OtherID[:], b.OtherID[:]) > 0
+}
+
+func (r *Conversations) Summary(userID, otherID uuid.UUID) (*store.ConversationSummary, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	summary := r.summary(userID, otherID)
+	return &summary, nil
+}
+
+// summary computes the summary of a conversation. The caller holds the
+// lock.
+func (r *Conversations) summary(userID, otherID uuid.UUID) store.ConversationSummary {
+	var summary store.ConversationSummary
+	var last *models.Message
+	for _, m := range r.messages {
+		if !between(m, userID, otherID) || m.IsDeleted {
+			continue
+		}
+		if last == nil || m.CreatedAt.After(last.CreatedAt) ||
+			(m.CreatedAt.Equal(last.CreatedAt) && bytes.Compare(m.ID[:], last.ID[:]) > 0) {
+			last = m
+		}
+
+		if m.SenderID != otherID || m.ReceiverID != userID || m.IsRead {
+			continue
+		}
+		summary.UnreadCount++
+		if slices.ContainsFunc(m.Mentions, func(mention models.Mention) bool { return mention.UserID == userID }) {
+			summary.UnreadMentions++
+		}
+	}
+
+	if last != nil {
+		c := copyMessage(last)
+		c.Mentions = nil
+		summary.LastMessage = &c
+	}
+	return summary
+}
@@ -0,0 +1,44 @@
+// Package memstore implements the store repositories in memory, for tests
+// and experiments that should not need a database. Values are copied in and
+// out, so callers never share state with the store.
+package memstore
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+)
+
+// data is the state shared by the repositories of one store.
+type data struct {
+	mu       sync.Mutex
+	users    map[uuid.UUID]*models.User
+	messages map[uuid.UUID]*models.Message
+	writes   map[uuid.UUID]time.Time
+}
+
+// New returns empty repositories.
+func New() *store.Store {
+	d := &data{
+		users:    map[uuid.UUID]*models.User{},
+		messages: map[uuid.UUID]*models.Message{},
+		writes:   map[uuid.UUID]time.Time{},
+	}
+	return &store.Store{
+		Users:         &Users{d},
+		Messages:      &Messages{d},
+		Conversations: &Conversations{d},
+		Writes:        &Writes{d},
+	}
+}
+
+func copyMessage(m *models.Message) models.Message {
+	c := *m
+	c.Mentions = slices.Clone(m.Mentions)
+	c.Attachments = slices.Clone(m.Attachments)
+	return c
+}
@@ -0,0 +1,15 @@
+package memstore_test
+
+import (
+	"testing"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store/memstore"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store/storetest"
+)
+
+func TestContract(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) *store.Store {
+		return memstore.New()
+	})
+}
@@ -0,0 +1,161 @@
+package memstore
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+)
+
+type Messages struct {
+	*data
+}
+
+// between reports whether m was exchanged by the two users.
+func between(m *models.Message, userA, userB uuid.UUID) bool {
+	return (m.SenderID == userA && m.ReceiverID == userB) || (m.SenderID == userB && m.ReceiverID == userA)
+}
+
+// newestFirst orders messages by creation time and then by ID, newest
+// first, as history is.
+func newestFirst(messages []models.Message) {
+	sort.Slice(messages, func(i, j int) bool {
+		return store.HistoryCursorOf(&messages[j]).Before(store.HistoryCursorOf(&messages[i]))
+	})
+}
+
+// setMentions gives the mentions their IDs and message and stores a sorted
+// copy on stored.
+func setMentions(stored *models.Message, mentions []models.Mention) {
+	for i := range mentions {
+		if mentions[i].ID == uuid.Nil {
+			mentions[i].ID = uuid.New()
+		}
+		mentions[i].MessageID = stored.ID
+	}
+	stored.Mentions = append([]models.Mention(nil), mentions...)
+	sort.SliceStable(stored.Mentions, func(i, j int) bool {
+		return stored.Mentions[i].Offset < stored.Mentions[j].Offset
+	})
+}
+
+func (r *Messages) Create(m *models.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	if _, ok := r.messages[m.ID]; ok {
+		return store.ErrConflict
+	}
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now()
+	}
+
+	stored := copyMessage(m)
+	setMentions(&stored, m.Mentions)
+	r.messages[m.ID] = &stored
+	return nil
+}
+
+func (r *Messages) Get(id uuid.UUID) (*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.messages[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	c := copyMessage(m)
+	return &c, nil
+}
+
+func (r *Messages) Edit(m *models.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.messages[m.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	stored.Content = m.Content
+	stored.EditedAt = m.EditedAt
+	setMentions(stored, m.Mentions)
+	return nil
+}
+
+func (r *Messages) Delete(id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.messages[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	m.IsDeleted = true
+	return nil
+}
+
+func (r *Messages) MarkRead(id uuid.UUID, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.messages[id]
+	if !ok {
+		return false, store.ErrNotFound
+	}
+	if m.IsRead {
+		return false, nil
+	}
+	m.IsRead = true
+	m.ReadAt = &at
+	return true, nil
+}
+
+func (r *Messages) MarkConversationRead(readerID, senderID uuid.UUID, at time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for _, m := range r.messages {
+		if m.SenderID == senderID && m.ReceiverID == readerID && !m.IsRead {
+			m.IsRead = true
+			m.ReadAt = &at
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *Messages) History(userA, userB uuid.UUID, q store.HistoryQuery) ([]models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	messages := []models.Message{}
+	for _, m := range r.messages {
+		if !between(m, userA, userB) || m.IsDeleted {
+			continue
+		}
+		c := store.HistoryCursorOf(m)
+		if q.Before != nil && !c.Before(*q.Before) {
+			continue
+		}
+		if q.After != nil && !q.After.Before(c) {
+			continue
+		}
+		messages = append(messages, copyMessage(m))
+	}
+
+	newestFirst(messages)
+	if len(messages) > q.Limit {
+		if q.Before == nil && q.After != nil {
+			messages = messages[len(messages)-q.Limit:]
+		} else {
+			messages = messages[:q.Limit]
+		}
+	}
+	return messages, nil
+}
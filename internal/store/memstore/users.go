@@ -0,0 +1,165 @@
+package memstore
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+)
+
+type Users struct {
+	*data
+}
+
+// taken reports whether another user than id has the username or email.
+func (r *Users) taken(id uuid.UUID, username, email string) bool {
+	for _, u := range r.users {
+		if u.ID != id && (u.Username == username || u.Email == email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Users) Create(u *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	if _, ok := r.users[u.ID]; ok || r.taken(u.ID, u.Username, u.Email) {
+		return store.ErrConflict
+	}
+
+	now := time.Now()
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = now
+	}
+	if u.UpdatedAt.IsZero() {
+		u.UpdatedAt = now
+	}
+
+	c := *u
+	r.users[u.ID] = &c
+	return nil
+}
+
+func (r *Users) Get(id uuid.UUID) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	c := *u
+	return &c, nil
+}
+
+func (r *Users) GetByEmail(email string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.Email == email {
+			c := *u
+			return &c, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (r *Users) Update(id uuid.UUID, changes store.UserChanges) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	username, email := u.Username, u.Email
+	if changes.Username != nil {
+		username = *changes.Username
+	}
+	if changes.Email != nil {
+		email = *changes.Email
+	}
+	if r.taken(id, username, email) {
+		return nil, store.ErrConflict
+	}
+
+	if changes.Username != nil || changes.Email != nil {
+		u.Username, u.Email = username, email
+		u.UpdatedAt = time.Now()
+	}
+	c := *u
+	return &c, nil
+}
+
+func (r *Users) Search(query string, limit int) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := []models.User{}
+	for _, u := range r.users {
+		if u.DeletedAt == nil && (strings.Contains(u.Username, query) || strings.Contains(u.Email, query)) {
+			users = append(users, *u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+// update applies fn to the stored user.
+func (r *Users) update(id uuid.UUID, fn func(u *models.User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	fn(u)
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *Users) SetPasswordHash(id uuid.UUID, hash string) error {
+	return r.update(id, func(u *models.User) {
+		u.PasswordHash = hash
+	})
+}
+
+func (r *Users) RecordFailedLogin(id uuid.UUID, at time.Time) (int, error) {
+	var count int
+	err := r.update(id, func(u *models.User) {
+		u.FailedLoginCount++
+		u.LastFailedLoginAt = &at
+		count = u.FailedLoginCount
+	})
+	return count, err
+}
+
+func (r *Users) LockLogin(id uuid.UUID, until time.Time) error {
+	return r.update(id, func(u *models.User) {
+		u.FailedLoginCount = 0
+		u.LastFailedLoginAt = nil
+		u.LockedUntil = &until
+	})
+}
+
+func (r *Users) ResetFailedLogins(id uuid.UUID) error {
+	return r.update(id, func(u *models.User) {
+		u.FailedLoginCount = 0
+		u.LastFailedLoginAt = nil
+		u.LockedUntil = nil
+	})
+}
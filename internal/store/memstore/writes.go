@@ -0,0 +1,42 @@
+package memstore
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Writes struct {
+	*data
+}
+
+func (r *Writes) Record(userIDs []uuid.UUID, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range userIDs {
+		if at.After(r.writes[id]) {
+			r.writes[id] = at
+		}
+	}
+	return nil
+}
+
+func (r *Writes) Last(userID uuid.UUID) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.writes[userID], nil
+}
+
+func (r *Writes) Prune(before time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, at := range r.writes {
+		if at.Before(before) {
+			delete(r.writes, id)
+		}
+	}
+	return nil
+}
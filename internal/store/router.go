@@ -0,0 +1,172 @@
+package store
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// How often recorded writes older than the window are deleted from the
+// primary
+const writesPruneInterval = time.Minute
+
+// Router sends reads that can tolerate replication lag to read replicas,
+// taking turns between them. A user who changed something recently reads
+// from the primary instead, so that they see their own writes, and so does
+// everyone when no replica is healthy. Writes are recorded in the primary,
+// through its Writes, so this holds whichever instance handled the write.
+type Router struct {
+	primary  *Store
+	replicas []*replica
+	next     atomic.Uint64
+
+	// How long after a user's write their reads stay on the primary, and
+	// how long a failing replica is left alone
+	window time.Duration
+	retry  time.Duration
+
+	// Writes seen by this instance, so their users' reads need not look
+	// up the primary's record
+	mu           sync.Mutex
+	writes       map[uuid.UUID]time.Time
+	lastPruned   time.Time
+	lastPrunedDB time.Time
+}
+
+type replica struct {
+	store     *Store
+	downUntil atomic.Int64 // Unix nanoseconds
+}
+
+// NewRouter routes reads between primary and replicas. Without replicas
+// every read goes to the primary.
+func NewRouter(primary *Store, replicas []*Store, window, retry time.Duration) *Router {
+	r := &Router{
+		primary: primary,
+		window:  window,
+		retry:   retry,
+		writes:  make(map[uuid.UUID]time.Time),
+	}
+	for _, s := range replicas {
+		r.replicas = append(r.replicas, &replica{store: s})
+	}
+	return r
+}
+
+// Read calls fn with the store the user should read from. fn must only
+// read: when it fails on a replica, the replica is taken out of rotation
+// for a while and fn is called again with the primary. A replica that does
+// not find a record may just be behind, so that is retried on the primary
+// too, without counting against the replica.
+func (r *Router) Read(userID uuid.UUID, fn func(st *Store) error) error {
+	rep := r.pick(userID)
+	if rep == nil {
+		return fn(r.primary)
+	}
+
+	err := fn(rep.store)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		log.Println("store: read replica failed, using the primary:", err)
+		rep.downUntil.Store(time.Now().Add(r.retry).UnixNano())
+	}
+	return fn(r.primary)
+}
+
+// pick returns the next healthy replica, or nil when the user must read
+// from the primary.
+func (r *Router) pick(userID uuid.UUID) *replica {
+	if len(r.replicas) == 0 || r.wroteRecently(userID) {
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+	start := r.next.Add(1)
+	for i := range r.replicas {
+		rep := r.replicas[(start+uint64(i))%uint64(len(r.replicas))]
+		if rep.downUntil.Load() <= now {
+			return rep
+		}
+	}
+	return nil
+}
+
+// Wrote records that the users' data changed, so that their reads go to
+// the primary until the replicas have caught up.
+func (r *Router) Wrote(userIDs ...uuid.UUID) {
+	if len(r.replicas) == 0 || len(userIDs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	if err := r.primary.Writes.Record(userIDs, now); err != nil {
+		log.Println("store: failed to record writes:", err)
+	}
+
+	r.mu.Lock()
+	for _, id := range userIDs {
+		r.writes[id] = now
+	}
+
+	// Forget writes older than the window, at most once per window
+	if now.Sub(r.lastPruned) > r.window {
+		for id, at := range r.writes {
+			if now.Sub(at) > r.window {
+				delete(r.writes, id)
+			}
+		}
+		r.lastPruned = now
+	}
+	pruneDB := now.Sub(r.lastPrunedDB) > writesPruneInterval
+	if pruneDB {
+		r.lastPrunedDB = now
+	}
+	r.mu.Unlock()
+
+	if pruneDB {
+		if err := r.primary.Writes.Prune(now.Add(-r.window)); err != nil {
+			log.Println("store: failed to prune recorded writes:", err)
+		}
+	}
+}
+
+// wroteRecently reports whether the user's data changed within the window,
+// on this instance or, as recorded in the primary, on another. When the
+// primary cannot tell, the user reads from it anyway.
+func (r *Router) wroteRecently(userID uuid.UUID) bool {
+	r.mu.Lock()
+	at, ok := r.writes[userID]
+	r.mu.Unlock()
+	if ok && time.Since(at) <= r.window {
+		return true
+	}
+
+	at, err := r.primary.Writes.Last(userID)
+	if err != nil {
+		log.Println("store: failed to look up recorded writes:", err)
+		return true
+	}
+	if time.Since(at) > r.window {
+		return false
+	}
+
+	r.mu.Lock()
+	if at.After(r.writes[userID]) {
+		r.writes[userID] = at
+	}
+	r.mu.Unlock()
+	return true
+}
+
+// Emit implements events.Sink. Every event stands for a change to the
+// messages or conversations of the users it concerns, made over the
+// WebSocket as well as over REST.
+func (r *Router) Emit(event string, userIDs []uuid.UUID, data any) {
+	r.Wrote(userIDs...)
+}
@@ -0,0 +1,60 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store/memstore"
+	"github.com/google/uuid"
+)
+
+// TestRouterSharesWrites checks that a write handled by one instance keeps
+// the user's reads on the primary on another instance too.
+func TestRouterSharesWrites(t *testing.T) {
+	primary, replica := memstore.New(), memstore.New()
+	window := time.Minute
+	a := store.NewRouter(primary, []*store.Store{replica}, window, time.Minute)
+	b := store.NewRouter(primary, []*store.Store{replica}, window, time.Minute)
+
+	alice, bob := uuid.New(), uuid.New()
+	from := func(r *store.Router, user uuid.UUID) string {
+		t.Helper()
+		var got string
+		err := r.Read(user, func(st *store.Store) error {
+			if st == primary {
+				got = "primary"
+			} else {
+				got = "replica"
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got := from(b, alice); got != "replica" {
+		t.Fatalf("before any write alice reads from the %s", got)
+	}
+
+	a.Wrote(alice)
+	if got := from(a, alice); got != "primary" {
+		t.Errorf("alice reads from the %s on the instance that wrote", got)
+	}
+	if got := from(b, alice); got != "primary" {
+		t.Errorf("alice reads from the %s on another instance", got)
+	}
+	if got := from(b, bob); got != "replica" {
+		t.Errorf("bob, who wrote nothing, reads from the %s", got)
+	}
+
+	// Once the window has passed the record no longer counts
+	if err := primary.Writes.Record([]uuid.UUID{bob}, time.Now().Add(-2*window)); err != nil {
+		t.Fatal(err)
+	}
+	if got := from(b, bob); got != "replica" {
+		t.Errorf("bob reads from the %s after the window", got)
+	}
+}
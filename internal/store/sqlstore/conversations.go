@@ -0,0 +1,72 @@
+package sqlstore
+
+import (
+	"errors"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Conversations struct {
+	db *gorm.DB
+}
+
+// List reads one page of conversation_summaries, with the other user and
+// the last message joined in, along its (user_id, last_activity, other_id)
+// index.
+func (r *Conversations) List(userID uuid.UUID, after *store.ConversationCursor, limit int) ([]store.Conversation, error) {
+	query := r.db.
+		InnerJoins("Other").
+		Joins("LastMessage").
+		Where("conversation_summaries.user_id = ?", userID).
+		Order("conversation_summaries.last_activity DESC, conversation_summaries.other_id DESC").
+		Limit(limit)
+	if after != nil {
+		query = query.Where(
+			"(conversation_summaries.last_activity < ? OR (conversation_summaries.last_activity = ? AND conversation_summaries.other_id < ?))",
+			after.LastActivity, after.LastActivity, after.OtherID,
+		)
+	}
+
+	var rows []models.ConversationSummary
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	list := make([]store.Conversation, len(rows))
+	for i, row := range rows {
+		list[i] = store.Conversation{
+			Other:               row.Other,
+			LastActivity:        row.LastActivity,
+			ConversationSummary: summaryOf(&row),
+		}
+	}
+	return list, nil
+}
+
+func (r *Conversations) Summary(userID, otherID uuid.UUID) (*store.ConversationSummary, error) {
+	var row models.ConversationSummary
+	err := r.db.
+		Joins("LastMessage").
+		Where("conversation_summaries.user_id = ? AND conversation_summaries.other_id = ?", userID, otherID).
+		Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &store.ConversationSummary{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	summary := summaryOf(&row)
+	return &summary, nil
+}
+
+func summaryOf(row *models.ConversationSummary) store.ConversationSummary {
+	return store.ConversationSummary{
+		LastMessage:    row.LastMessage,
+		UnreadCount:    row.UnreadCount,
+		UnreadMentions: row.UnreadMentions,
+	}
+}
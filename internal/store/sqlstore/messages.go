@@ -0,0 +1,172 @@
+package sqlstore
+
+import (
+	"slices"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Messages struct {
+	db *gorm.DB
+}
+
+func orderedMentions(db *gorm.DB) *gorm.DB {
+	return db.Order("start_offset")
+}
+
+func (r *Messages) Create(m *models.Message) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(m).Error; err != nil {
+			return err
+		}
+		return addToSummaries(tx, m)
+	})
+}
+
+func (r *Messages) Get(id uuid.UUID) (*models.Message, error) {
+	var msg models.Message
+	if err := r.db.Preload("Mentions", orderedMentions).First(&msg, "id = ?", id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &msg, nil
+}
+
+// change runs fn in a transaction that holds the summaries of the message's
+// conversation, and refreshes them afterwards when fn reports a change.
+func (r *Messages) change(id uuid.UUID, fn func(tx *gorm.DB) (bool, error)) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var msg models.Message
+		if err := tx.Select("sender_id", "receiver_id").Take(&msg, "id = ?", id).Error; err != nil {
+			return translate(err)
+		}
+		return r.changeConversation(tx, msg.SenderID, msg.ReceiverID, fn)
+	})
+}
+
+func (r *Messages) changeConversation(tx *gorm.DB, a, b uuid.UUID, fn func(tx *gorm.DB) (bool, error)) error {
+	if err := lockSummaries(tx, a, b); err != nil {
+		return err
+	}
+	changed, err := fn(tx)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return dropPlaceholders(tx, a, b)
+	}
+	return refreshSummaries(tx, a, b)
+}
+
+func (r *Messages) Edit(m *models.Message) error {
+	return r.change(m.ID, func(tx *gorm.DB) (bool, error) {
+		// The conversation in the model lets the content be encrypted
+		err := tx.Model(&models.Message{ID: m.ID, SenderID: m.SenderID, ReceiverID: m.ReceiverID}).
+			Updates(map[string]any{
+				"content":   m.Content,
+				"edited_at": m.EditedAt,
+			}).Error
+		if err != nil {
+			return false, err
+		}
+
+		if err := tx.Where("message_id = ?", m.ID).Delete(&models.Mention{}).Error; err != nil {
+			return false, err
+		}
+		if len(m.Mentions) == 0 {
+			return true, nil
+		}
+		for i := range m.Mentions {
+			m.Mentions[i].MessageID = m.ID
+		}
+		return true, tx.Create(&m.Mentions).Error
+	})
+}
+
+func (r *Messages) Delete(id uuid.UUID) error {
+	return r.change(id, func(tx *gorm.DB) (bool, error) {
+		err := tx.Model(&models.Message{}).Where("id = ?", id).Update("is_deleted", true).Error
+		return true, err
+	})
+}
+
+func (r *Messages) MarkRead(id uuid.UUID, at time.Time) (bool, error) {
+	var changed bool
+	err := r.change(id, func(tx *gorm.DB) (bool, error) {
+		res := tx.Model(&models.Message{}).
+			Where("id = ? AND is_read = FALSE", id).
+			Updates(map[string]any{
+				"is_read": true,
+				"read_at": at,
+			})
+		changed = res.RowsAffected > 0
+		return changed, res.Error
+	})
+	return changed, err
+}
+
+func (r *Messages) MarkConversationRead(readerID, senderID uuid.UUID, at time.Time) (int64, error) {
+	var marked int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		return r.changeConversation(tx, readerID, senderID, func(tx *gorm.DB) (bool, error) {
+			res := tx.Model(&models.Message{}).
+				Where("sender_id = ? AND receiver_id = ? AND is_read = FALSE", senderID, readerID).
+				Updates(map[string]any{
+					"is_read": true,
+					"read_at": at,
+				})
+			marked = res.RowsAffected
+			return marked > 0, res.Error
+		})
+	})
+	return marked, err
+}
+
+// History reads each direction of the conversation separately, so both are
+// range scans of idx_messages_conversation, and merges them.
+func (r *Messages) History(userA, userB uuid.UUID, q store.HistoryQuery) ([]models.Message, error) {
+	// Only after a cursor, the page is the oldest messages after it
+	ascending := q.Before == nil && q.After != nil
+	order := "created_at DESC, id DESC"
+	if ascending {
+		order = "created_at, id"
+	}
+
+	direction := func(sender, receiver uuid.UUID) *gorm.DB {
+		query := r.db.Model(&models.Message{}).
+			Where("sender_id = ? AND receiver_id = ? AND is_deleted = FALSE", sender, receiver).
+			Order(order).
+			Limit(q.Limit)
+		if q.Before != nil {
+			query = query.Where("(created_at, id) < (?, ?)", q.Before.CreatedAt, q.Before.ID)
+		}
+		if q.After != nil {
+			query = query.Where("(created_at, id) > (?, ?)", q.After.CreatedAt, q.After.ID)
+		}
+		return query
+	}
+
+	from := r.db.Table("(?) AS messages", direction(userA, userB))
+	if userA != userB {
+		from = r.db.Table("(SELECT * FROM (?) AS sent UNION ALL SELECT * FROM (?) AS received) AS messages",
+			direction(userA, userB), direction(userB, userA))
+	}
+
+	messages := []models.Message{}
+	err := from.
+		Order(order).
+		Limit(q.Limit).
+		Preload("Mentions", orderedMentions).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if ascending {
+		slices.Reverse(messages)
+	}
+	return messages, nil
+}
@@ -0,0 +1,32 @@
+// Package sqlstore implements the store repositories with GORM, on Postgres
+// or SQLite. Queries stick to SQL both understand.
+package sqlstore
+
+import (
+	"errors"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"gorm.io/gorm"
+)
+
+// New returns the repositories backed by db, which must be opened with
+// TranslateError, as db.Open does.
+func New(db *gorm.DB) *store.Store {
+	return &store.Store{
+		Users:         &Users{db: db},
+		Messages:      &Messages{db: db},
+		Conversations: &Conversations{db: db},
+		Writes:        &Writes{db: db},
+	}
+}
+
+// translate maps database errors to the store's errors.
+func translate(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return store.ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return store.ErrConflict
+	}
+	return err
+}
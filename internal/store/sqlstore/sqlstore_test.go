@@ -0,0 +1,85 @@
+package sqlstore_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/db"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store/sqlstore"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store/storetest"
+	"gorm.io/gorm"
+)
+
+// TestContractSQLite runs the contract against a new migrated SQLite file
+// per check.
+func TestContractSQLite(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) *store.Store {
+		conn, err := db.Open("sqlite:" + filepath.Join(t.TempDir(), "store.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { closeDB(conn) })
+		if err := migrate(conn); err != nil {
+			t.Fatal(err)
+		}
+		return sqlstore.New(conn)
+	})
+}
+
+// TestContractPostgres runs the contract against the Postgres database in
+// TEST_DATABASE_URL, emptying its tables before every check. It is skipped
+// when the variable is unset.
+func TestContractPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	conn, err := db.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closeDB(conn) })
+	if err := migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	storetest.Run(t, func(t *testing.T) *store.Store {
+		if err := truncate(conn); err != nil {
+			t.Fatal(err)
+		}
+		return sqlstore.New(conn)
+	})
+}
+
+func migrate(conn *gorm.DB) error {
+	migrator, err := db.NewMigrator(conn)
+	if err != nil {
+		return err
+	}
+	_, err = migrator.Up(0)
+	return err
+}
+
+// truncate empties every table but schema_migrations.
+func truncate(conn *gorm.DB) error {
+	var tables []string
+	err := conn.Raw(`SELECT tablename FROM pg_tables
+		WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'`).Scan(&tables).Error
+	if err != nil || len(tables) == 0 {
+		return err
+	}
+	for i, name := range tables {
+		tables[i] = conn.Statement.Quote(name)
+	}
+	return conn.Exec("TRUNCATE " + strings.Join(tables, ", ") + " CASCADE").Error
+}
+
+func closeDB(conn *gorm.DB) {
+	if sqlDB, err := conn.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
@@ -0,0 +1,171 @@
+package sqlstore
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Conversation summaries are kept in step with messages by the transactions
+// that change them. Sending a message adds it to the summaries in place.
+// Every other change locks the summaries first and recomputes them from the
+// messages once it is done, so that changes to one conversation wait for
+// each other and never count from a stale view.
+
+const summaryPair = "((user_id = ? AND other_id = ?) OR (user_id = ? AND other_id = ?))"
+
+// newer is true when the row being upserted holds a message that is newer
+// than the summary's last message.
+const newer = "excluded.last_activity > conversation_summaries.last_activity OR " +
+	"(excluded.last_activity = conversation_summaries.last_activity AND excluded.last_message_id > conversation_summaries.last_message_id)"
+
+// views returns the (user, other) keys of the summaries of the conversation
+// between a and b, ordered by user so that transactions lock them in the
+// same order.
+func views(a, b uuid.UUID) [][2]uuid.UUID {
+	if a == b {
+		return [][2]uuid.UUID{{a, a}}
+	}
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return [][2]uuid.UUID{{a, b}, {b, a}}
+}
+
+// addToSummaries counts a new message in the summaries of its conversation.
+func addToSummaries(tx *gorm.DB, m *models.Message) error {
+	if m.IsDeleted {
+		return nil
+	}
+
+	for _, v := range views(m.SenderID, m.ReceiverID) {
+		row := models.ConversationSummary{
+			UserID:        v[0],
+			OtherID:       v[1],
+			LastMessageID: &m.ID,
+			LastActivity:  m.CreatedAt,
+		}
+		if v[0] == m.ReceiverID && !m.IsRead {
+			row.UnreadCount = 1
+			if slices.ContainsFunc(m.Mentions, func(mention models.Mention) bool { return mention.UserID == v[0] }) {
+				row.UnreadMentions = 1
+			}
+		}
+
+		err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "other_id"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "last_message_id"}, Value: gorm.Expr("CASE WHEN " + newer + " THEN excluded.last_message_id ELSE conversation_summaries.last_message_id END")},
+				{Column: clause.Column{Name: "last_activity"}, Value: gorm.Expr("CASE WHEN " + newer + " THEN excluded.last_activity ELSE conversation_summaries.last_activity END")},
+				{Column: clause.Column{Name: "unread_count"}, Value: gorm.Expr("conversation_summaries.unread_count + excluded.unread_count")},
+				{Column: clause.Column{Name: "unread_mentions"}, Value: gorm.Expr("conversation_summaries.unread_mentions + excluded.unread_mentions")},
+			},
+		}).Create(&row).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockSummaries locks the summaries of the conversation between a and b,
+// creating them first if needed so that there is something to lock.
+// SQLite has no row locks, but its transactions already write one at a
+// time.
+func lockSummaries(tx *gorm.DB, a, b uuid.UUID) error {
+	now := time.Now()
+	for _, v := range views(a, b) {
+		row := models.ConversationSummary{UserID: v[0], OtherID: v[1], LastActivity: now}
+		err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	var locked []models.ConversationSummary
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where(summaryPair, a, b, b, a).
+		Order("user_id").
+		Find(&locked).Error
+}
+
+// dropPlaceholders removes the summaries lockSummaries created, when
+// nothing changed that would fill them in.
+func dropPlaceholders(tx *gorm.DB, a, b uuid.UUID) error {
+	return tx.Where(summaryPair, a, b, b, a).
+		Where("last_message_id IS NULL").
+		Delete(&models.ConversationSummary{}).Error
+}
+
+// refreshSummaries recomputes the locked summaries of the conversation
+// between a and b from its messages, and removes them if none is left.
+func refreshSummaries(tx *gorm.DB, a, b uuid.UUID) error {
+	var last models.Message
+	err := tx.Select("id", "created_at").
+		Where("((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)) AND is_deleted = FALSE", a, b, b, a).
+		Order("created_at DESC, id DESC").
+		Take(&last).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return tx.Where(summaryPair, a, b, b, a).Delete(&models.ConversationSummary{}).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, v := range views(a, b) {
+		unread, mentions, err := countUnread(tx, v[0], v[1])
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.ConversationSummary{}).
+			Where("user_id = ? AND other_id = ?", v[0], v[1]).
+			Updates(map[string]any{
+				"last_message_id": last.ID,
+				"last_activity":   last.CreatedAt,
+				"unread_count":    unread,
+				"unread_mentions": mentions,
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countUnread counts the unread messages otherID sent to userID, and those
+// of them that mention userID.
+func countUnread(tx *gorm.DB, userID, otherID uuid.UUID) (unread, mentions int64, err error) {
+	err = tx.Model(&models.Message{}).
+		Where("sender_id = ? AND receiver_id = ? AND is_read = FALSE AND is_deleted = FALSE", otherID, userID).
+		Count(&unread).Error
+	if err != nil || unread == 0 {
+		return unread, 0, err
+	}
+
+	err = tx.Model(&models.Message{}).
+		Joins("JOIN mentions ON mentions.message_id = messages.id").
+		Where(
+			"messages.sender_id = ? AND messages.receiver_id = ? AND messages.is_read = FALSE AND messages.is_deleted = FALSE AND mentions.user_id = ?",
+			otherID, userID, userID,
+		).
+		Distinct("messages.id").
+		Count(&mentions).Error
+	return unread, mentions, err
+}
+
+// RefreshConversation recomputes the summaries of the conversation between
+// a and b from its messages, for code that changes messages without going
+// through the store, such as imports. Call it in the transaction that made
+// the change.
+func RefreshConversation(tx *gorm.DB, a, b uuid.UUID) error {
+	if err := lockSummaries(tx, a, b); err != nil {
+		return err
+	}
+	return refreshSummaries(tx, a, b)
+}
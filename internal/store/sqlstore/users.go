@@ -0,0 +1,121 @@
+package sqlstore
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Users struct {
+	db *gorm.DB
+}
+
+func (r *Users) Create(u *models.User) error {
+	return translate(r.db.Create(u).Error)
+}
+
+func (r *Users) Get(id uuid.UUID) (*models.User, error) {
+	var user models.User
+	if err := r.db.First(&user, "id = ?", id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (r *Users) GetByEmail(email string) (*models.User, error) {
+	var user models.User
+	if err := r.db.First(&user, "email = ?", email).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (r *Users) Update(id uuid.UUID, changes store.UserChanges) (*models.User, error) {
+	updates := map[string]any{}
+	if changes.Username != nil {
+		updates["username"] = *changes.Username
+	}
+	if changes.Email != nil {
+		updates["email"] = *changes.Email
+	}
+
+	if len(updates) > 0 {
+		res := r.db.Model(&models.User{}).Where("id = ?", id).Updates(updates)
+		if res.Error != nil {
+			return nil, translate(res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return nil, store.ErrNotFound
+		}
+	}
+	return r.Get(id)
+}
+
+func (r *Users) Search(query string, limit int) ([]models.User, error) {
+	pattern := "%" + escapeLike(query) + "%"
+
+	users := []models.User{}
+	err := r.db.
+		Where(`(username LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\') AND deleted_at IS NULL`, pattern, pattern).
+		Order("username").
+		Limit(limit).
+		Find(&users).Error
+	return users, err
+}
+
+func (r *Users) SetPasswordHash(id uuid.UUID, hash string) error {
+	return r.update(id, map[string]any{"password_hash": hash})
+}
+
+func (r *Users) RecordFailedLogin(id uuid.UUID, at time.Time) (int, error) {
+	user := models.User{ID: id}
+	res := r.db.Model(&user).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_login_count"}}}).
+		Updates(map[string]any{
+			"failed_login_count":   gorm.Expr("failed_login_count + 1"),
+			"last_failed_login_at": at,
+		})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return 0, store.ErrNotFound
+	}
+	return user.FailedLoginCount, nil
+}
+
+func (r *Users) LockLogin(id uuid.UUID, until time.Time) error {
+	return r.update(id, map[string]any{
+		"failed_login_count":   0,
+		"last_failed_login_at": nil,
+		"locked_until":         until,
+	})
+}
+
+func (r *Users) ResetFailedLogins(id uuid.UUID) error {
+	return r.update(id, map[string]any{
+		"failed_login_count":   0,
+		"last_failed_login_at": nil,
+		"locked_until":         nil,
+	})
+}
+
+func (r *Users) update(id uuid.UUID, updates map[string]any) error {
+	res := r.db.Model(&models.User{}).Where("id = ?", id).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,52 @@
+package sqlstore
+
+import (
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Writes struct {
+	db *gorm.DB
+}
+
+func (r *Writes) Record(userIDs []uuid.UUID, at time.Time) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	rows := make([]models.RecentWrite, 0, len(userIDs))
+	seen := make(map[uuid.UUID]bool, len(userIDs))
+	for _, id := range userIDs {
+		if !seen[id] {
+			seen[id] = true
+			rows = append(rows, models.RecentWrite{UserID: id, WrittenAt: at})
+		}
+	}
+
+	// A slower request finishing after a newer write keeps the newer time
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "written_at"}, Value: gorm.Expr("CASE WHEN excluded.written_at > recent_writes.written_at THEN excluded.written_at ELSE recent_writes.written_at END")},
+		},
+	}).Create(&rows).Error
+}
+
+func (r *Writes) Last(userID uuid.UUID) (time.Time, error) {
+	var rows []models.RecentWrite
+	if err := r.db.Where("user_id = ?", userID).Limit(1).Find(&rows).Error; err != nil {
+		return time.Time{}, err
+	}
+	if len(rows) == 0 {
+		return time.Time{}, nil
+	}
+	return rows[0].WrittenAt, nil
+}
+
+func (r *Writes) Prune(before time.Time) error {
+	return r.db.Where("written_at < ?", before).Delete(&models.RecentWrite{}).Error
+}
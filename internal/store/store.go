@@ -0,0 +1,243 @@
+// Package store defines the repositories through which handlers and the
+// message service read and write users, messages and conversations, so
+// they can run against a database (sqlstore) or memory (memstore). The
+// storetest package holds the contract every implementation must pass.
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound = errors.New("record not found")
+
+	// ErrConflict means a username or email is already taken
+	ErrConflict = errors.New("already exists")
+
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Store bundles the repositories of one backend.
+type Store struct {
+	Users         Users
+	Messages      Messages
+	Conversations Conversations
+	Writes        Writes
+}
+
+// UserChanges lists the profile fields to update; nil fields are left
+// alone.
+type UserChanges struct {
+	Username *string
+	Email    *string
+}
+
+type Users interface {
+	// Create stores a new user and sets its ID and timestamps. It returns
+	// ErrConflict if the username or email is taken.
+	Create(u *models.User) error
+
+	// Get returns the user with the ID, including deleted accounts.
+	Get(id uuid.UUID) (*models.User, error)
+
+	// GetByEmail returns the user with the email, which must already be
+	// lower case.
+	GetByEmail(email string) (*models.User, error)
+
+	// Update applies the changes and returns the updated user. It returns
+	// ErrConflict if the new username or email is taken.
+	Update(id uuid.UUID, changes UserChanges) (*models.User, error)
+
+	// Search returns up to limit accounts that are not deleted and whose
+	// username or email contains query, ordered by username.
+	Search(query string, limit int) ([]models.User, error)
+
+	SetPasswordHash(id uuid.UUID, hash string) error
+
+	// RecordFailedLogin counts a failed login made at the given time and
+	// returns the number of consecutive failures.
+	RecordFailedLogin(id uuid.UUID, at time.Time) (int, error)
+
+	// LockLogin locks the account until the given time and clears its
+	// failed logins.
+	LockLogin(id uuid.UUID, until time.Time) error
+
+	// ResetFailedLogins clears the failed logins and any lock.
+	ResetFailedLogins(id uuid.UUID) error
+}
+
+type Messages interface {
+	// Create stores a new message with its mentions and sets their IDs.
+	Create(m *models.Message) error
+
+	// Get returns the message with its mentions in order.
+	Get(id uuid.UUID) (*models.Message, error)
+
+	// Edit saves the content and edit time of the message and replaces its
+	// mentions. m is the message as loaded, sender and receiver included.
+	Edit(m *models.Message) error
+
+	// Delete marks the message as deleted.
+	Delete(id uuid.UUID) error
+
+	// MarkRead marks the message as read at the given time. It reports
+	// whether the message was unread before.
+	MarkRead(id uuid.UUID, at time.Time) (bool, error)
+
+	// MarkConversationRead marks every unread message senderID sent to
+	// readerID as read, and returns how many it marked.
+	MarkConversationRead(readerID, senderID uuid.UUID, at time.Time) (int64, error)
+
+	// History returns up to q.Limit messages exchanged by the two users
+	// that are not deleted, newest first. With q.Before set they are the
+	// newest messages before it; with only q.After set, the oldest after
+	// it.
+	History(userA, userB uuid.UUID, q HistoryQuery) ([]models.Message, error)
+}
+
+// HistoryQuery selects a page of a conversation's history.
+type HistoryQuery struct {
+	Before *HistoryCursor
+	After  *HistoryCursor
+	Limit  int
+}
+
+// HistoryCursor is a position in the history of a conversation, which is
+// ordered by creation time and then by message ID.
+type HistoryCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// HistoryCursorOf returns the position of the message in its history.
+func HistoryCursorOf(m *models.Message) HistoryCursor {
+	return HistoryCursor{CreatedAt: m.CreatedAt, ID: m.ID}
+}
+
+// Before reports whether c comes before o in the history.
+func (c HistoryCursor) Before(o HistoryCursor) bool {
+	if !c.CreatedAt.Equal(o.CreatedAt) {
+		return c.CreatedAt.Before(o.CreatedAt)
+	}
+	return bytes.Compare(c.ID[:], o.ID[:]) < 0
+}
+
+// String encodes the cursor for use in a URL.
+func (c HistoryCursor) String() string {
+	return encodeCursor(c.CreatedAt, c.ID)
+}
+
+// ParseHistoryCursor decodes a cursor made by String.
+func ParseHistoryCursor(s string) (*HistoryCursor, error) {
+	t, id, err := decodeCursor(s)
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryCursor{CreatedAt: t, ID: id}, nil
+}
+
+// Conversation is another user that userID exchanged messages with, as
+// shown in userID's conversation list.
+type Conversation struct {
+	Other        models.User
+	LastActivity time.Time
+	ConversationSummary
+}
+
+// Cursor is the position in the list just after the conversation.
+func (c *Conversation) Cursor() ConversationCursor {
+	return ConversationCursor{LastActivity: c.LastActivity, OtherID: c.Other.ID}
+}
+
+// ConversationSummary is what the conversation list shows for one
+// conversation, from the point of view of one of its participants.
+type ConversationSummary struct {
+	// Newest message that is not deleted; nil if there is none
+	LastMessage *models.Message
+
+	// Unread messages from the other user, and how many of them mention
+	// the participant
+	UnreadCount    int64
+	UnreadMentions int64
+}
+
+// ConversationCursor is a position in a conversation list, which is ordered
+// by last activity and then by the other user's ID, both descending.
+type ConversationCursor struct {
+	LastActivity time.Time
+	OtherID      uuid.UUID
+}
+
+// String encodes the cursor for use in a URL.
+func (c ConversationCursor) String() string {
+	return encodeCursor(c.LastActivity, c.OtherID)
+}
+
+// ParseConversationCursor decodes a cursor made by String.
+func ParseConversationCursor(s string) (*ConversationCursor, error) {
+	t, id, err := decodeCursor(s)
+	if err != nil {
+		return nil, err
+	}
+	return &ConversationCursor{LastActivity: t, OtherID: id}, nil
+}
+
+// Cursors are a time and an ID, encoded so they are opaque to clients.
+func encodeCursor(t time.Time, id uuid.UUID) string {
+	raw := t.UTC().Format(time.RFC3339Nano) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	at, rawID, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	return t, id, nil
+}
+
+type Conversations interface {
+	// List returns up to limit conversations of userID, most recent
+	// activity first, starting after the cursor when it is set. Deleted
+	// messages are not counted, and conversations with users that no
+	// longer exist are left out.
+	List(userID uuid.UUID, after *ConversationCursor, limit int) ([]Conversation, error)
+
+	// Summary describes the conversation of userID with otherID.
+	Summary(userID, otherID uuid.UUID) (*ConversationSummary, error)
+}
+
+// Writes remembers when users' data last changed, in the primary database,
+// so that every instance routing reads knows whose reads must skip the
+// replicas.
+type Writes interface {
+	// Record notes that the users' data changed at the given time. A time
+	// earlier than the one recorded for a user is ignored.
+	Record(userIDs []uuid.UUID, at time.Time) error
+
+	// Last returns when the user's data last changed, or the zero time if
+	// no change is recorded.
+	Last(userID uuid.UUID) (time.Time, error)
+
+	// Prune forgets changes made before the given time.
+	Prune(before time.Time) error
+}
@@ -0,0 +1,205 @@
+package storetest
+
+import (
+	"fmt"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+)
+
+func conversationsList(s *store.Store) error {
+	users, err := createUsers(s, "alice", "bob", "carol", "dave")
+	if err != nil {
+		return err
+	}
+	alice, bob, carol, dave := users[0], users[1], users[2], users[3]
+
+	if _, err := send(s, alice, bob, "to bob", 0); err != nil {
+		return err
+	}
+	if _, err := send(s, carol, alice, "@alice from carol", 10, mention(alice, 0)); err != nil {
+		return err
+	}
+	if _, err := send(s, bob, alice, "from bob", 20); err != nil {
+		return err
+	}
+	// Only deleted messages with dave, so no conversation
+	m, err := send(s, alice, dave, "to dave", 30)
+	if err != nil {
+		return err
+	}
+	if err := s.Messages.Delete(m.ID); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+
+	list, err := s.Conversations.List(alice.ID, nil, 10)
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	if len(list) != 2 {
+		return fmt.Errorf("List returned %d conversations, want 2", len(list))
+	}
+	if list[0].Other.ID != bob.ID || list[0].Other.Username != "bob" || !list[0].LastActivity.Equal(at(20)) {
+		return fmt.Errorf("first conversation is with %s at %v, want bob at %v", list[0].Other.ID, list[0].LastActivity, at(20))
+	}
+	if list[1].Other.ID != carol.ID || !list[1].LastActivity.Equal(at(10)) {
+		return fmt.Errorf("second conversation is with %s at %v, want carol at %v", list[1].Other.ID, list[1].LastActivity, at(10))
+	}
+	if last := list[0].LastMessage; last == nil || last.Content != "from bob" || list[0].UnreadCount != 1 || list[0].UnreadMentions != 0 {
+		return fmt.Errorf("conversation with bob has last message %+v, %d unread and %d unread mentions, want from bob, 1 and 0", last, list[0].UnreadCount, list[0].UnreadMentions)
+	}
+	if list[1].UnreadCount != 1 || list[1].UnreadMentions != 1 {
+		return fmt.Errorf("conversation with carol has %d unread and %d unread mentions, want 1 and 1", list[1].UnreadCount, list[1].UnreadMentions)
+	}
+
+	list, err = s.Conversations.List(bob.ID, nil, 10)
+	if err != nil || len(list) != 1 || list[0].Other.ID != alice.ID || list[0].UnreadCount != 1 {
+		return fmt.Errorf("List for bob returned %+v, %v, want alice with 1 unread", list, err)
+	}
+
+	list, err = s.Conversations.List(uuid.New(), nil, 10)
+	if err != nil || len(list) != 0 {
+		return fmt.Errorf("List for a user without messages returned %d conversations, %v", len(list), err)
+	}
+	return nil
+}
+
+func conversationsPages(s *store.Store) error {
+	users, err := createUsers(s, "alice", "bob", "carol", "dave", "erin")
+	if err != nil {
+		return err
+	}
+	alice := users[0]
+
+	// Bob and carol at the same time, so the cursor has to tell them apart
+	for i, u := range users[1:] {
+		if _, err := send(s, u, alice, "hi", []int{10, 10, 5, 0}[i]); err != nil {
+			return err
+		}
+	}
+	all, err := s.Conversations.List(alice.ID, nil, 10)
+	if err != nil || len(all) != 4 {
+		return fmt.Errorf("List returned %d conversations, %v, want 4", len(all), err)
+	}
+
+	var paged []store.Conversation
+	var after *store.ConversationCursor
+	for range all {
+		page, err := s.Conversations.List(alice.ID, after, 1)
+		if err != nil {
+			return fmt.Errorf("List: %w", err)
+		}
+		if len(page) != 1 {
+			return fmt.Errorf("List after %d conversations returned %d, want 1", len(paged), len(page))
+		}
+		paged = append(paged, page[0])
+
+		cursor, err := store.ParseConversationCursor(page[0].Cursor().String())
+		if err != nil {
+			return fmt.Errorf("ParseConversationCursor: %w", err)
+		}
+		after = cursor
+	}
+	for i := range all {
+		if paged[i].Other.ID != all[i].Other.ID {
+			return fmt.Errorf("conversation %d of the pages is with %s, want %s", i, paged[i].Other.Username, all[i].Other.Username)
+		}
+	}
+
+	rest, err := s.Conversations.List(alice.ID, after, 1)
+	if err != nil || len(rest) != 0 {
+		return fmt.Errorf("List after the last conversation returned %d, %v", len(rest), err)
+	}
+	return nil
+}
+
+func conversationsSummary(s *store.Store) error {
+	users, err := createUsers(s, "alice", "bob", "carol")
+	if err != nil {
+		return err
+	}
+	alice, bob, carol := users[0], users[1], users[2]
+
+	// Unread, mentioning alice twice
+	mentioned, err := send(s, bob, alice, "@alice @alice", 0, mention(alice, 0), mention(alice, 7))
+	if err != nil {
+		return err
+	}
+	// Unread
+	if _, err := send(s, bob, alice, "hello", 1); err != nil {
+		return err
+	}
+	// Read, mentioning alice
+	read, err := send(s, bob, alice, "@alice", 2, mention(alice, 0))
+	if err != nil {
+		return err
+	}
+	if _, err := s.Messages.MarkRead(read.ID, at(3)); err != nil {
+		return fmt.Errorf("MarkRead: %w", err)
+	}
+	// Sent by alice, so not unread for her
+	if _, err := send(s, alice, bob, "@bob", 4, mention(bob, 0)); err != nil {
+		return err
+	}
+	// Deleted, newest
+	deleted, err := send(s, bob, alice, "@alice deleted", 5, mention(alice, 0))
+	if err != nil {
+		return err
+	}
+	if err := s.Messages.Delete(deleted.ID); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	// Another conversation
+	if _, err := send(s, carol, alice, "@alice", 6, mention(alice, 0)); err != nil {
+		return err
+	}
+
+	summary, err := s.Conversations.Summary(alice.ID, bob.ID)
+	if err != nil {
+		return fmt.Errorf("Summary: %w", err)
+	}
+	if summary.LastMessage == nil || summary.LastMessage.Content != "@bob" {
+		return fmt.Errorf("Summary returned last message %+v, want @bob", summary.LastMessage)
+	}
+	if summary.UnreadCount != 2 || summary.UnreadMentions != 1 {
+		return fmt.Errorf("Summary returned %d unread and %d unread mentions, want 2 and 1", summary.UnreadCount, summary.UnreadMentions)
+	}
+
+	summary, err = s.Conversations.Summary(bob.ID, alice.ID)
+	if err != nil {
+		return fmt.Errorf("Summary: %w", err)
+	}
+	if summary.UnreadCount != 1 || summary.UnreadMentions != 1 {
+		return fmt.Errorf("Summary for bob returned %d unread and %d unread mentions, want 1 and 1", summary.UnreadCount, summary.UnreadMentions)
+	}
+
+	summary, err = s.Conversations.Summary(bob.ID, carol.ID)
+	if err != nil {
+		return fmt.Errorf("Summary: %w", err)
+	}
+	if summary.LastMessage != nil || summary.UnreadCount != 0 || summary.UnreadMentions != 0 {
+		return fmt.Errorf("Summary of an empty conversation returned %+v", summary)
+	}
+
+	// Editing out the mentions of an unread message
+	edited := at(7)
+	mentioned.Content = "no mentions"
+	mentioned.EditedAt = &edited
+	mentioned.Mentions = nil
+	if err := s.Messages.Edit(mentioned); err != nil {
+		return fmt.Errorf("Edit: %w", err)
+	}
+	summary, err = s.Conversations.Summary(alice.ID, bob.ID)
+	if err != nil || summary.UnreadCount != 2 || summary.UnreadMentions != 0 {
+		return fmt.Errorf("Summary after an edit returned %+v, %v, want 2 unread and no unread mentions", summary, err)
+	}
+
+	if _, err := s.Messages.MarkConversationRead(alice.ID, bob.ID, at(8)); err != nil {
+		return fmt.Errorf("MarkConversationRead: %w", err)
+	}
+	summary, err = s.Conversations.Summary(alice.ID, bob.ID)
+	if err != nil || summary.UnreadCount != 0 || summary.LastMessage == nil || summary.LastMessage.Content != "@bob" {
+		return fmt.Errorf("Summary after reading returned %+v, %v, want nothing unread and last message @bob", summary, err)
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+package storetest
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+)
+
+func messagesCreateGet(s *store.Store) error {
+	users, err := createUsers(s, "alice", "bob")
+	if err != nil {
+		return err
+	}
+	alice, bob := users[0], users[1]
+
+	m := &models.Message{
+		SenderID:    alice.ID,
+		ReceiverID:  bob.ID,
+		Content:     "@bob hi @alice",
+		Format:      models.FormatMarkdown,
+		Attachments: models.Attachments{{Title: "docs", URL: "https://example.com"}},
+		CreatedAt:   at(0),
+		Mentions:    []models.Mention{mention(alice, 8), mention(bob, 0)},
+	}
+	if err := s.Messages.Create(m); err != nil {
+		return fmt.Errorf("Create: %w", err)
+	}
+	if m.ID == uuid.Nil {
+		return fmt.Errorf("Create did not set the ID")
+	}
+	for _, mention := range m.Mentions {
+		if mention.ID == uuid.Nil || mention.MessageID != m.ID {
+			return fmt.Errorf("Create did not set the ID and message of mention %+v", mention)
+		}
+	}
+
+	got, err := s.Messages.Get(m.ID)
+	if err != nil {
+		return fmt.Errorf("Get: %w", err)
+	}
+	if got.SenderID != alice.ID || got.ReceiverID != bob.ID || got.Content != m.Content || got.Format != m.Format {
+		return fmt.Errorf("Get returned %+v", got)
+	}
+	if !got.CreatedAt.Equal(at(0)) {
+		return fmt.Errorf("Get returned CreatedAt %v, want %v", got.CreatedAt, at(0))
+	}
+	if len(got.Attachments) != 1 || got.Attachments[0].URL != "https://example.com" {
+		return fmt.Errorf("Get returned attachments %+v", got.Attachments)
+	}
+	if len(got.Mentions) != 2 || got.Mentions[0].UserID != bob.ID || got.Mentions[1].UserID != alice.ID {
+		return fmt.Errorf("Get returned mentions %+v, want bob then alice", got.Mentions)
+	}
+	if got.IsRead || got.IsDeleted || got.EditedAt != nil {
+		return fmt.Errorf("new message is read %t, deleted %t, edited %v", got.IsRead, got.IsDeleted, got.EditedAt)
+	}
+
+	_, err = s.Messages.Get(uuid.New())
+	return expectErr("Get of missing message", err, store.ErrNotFound)
+}
+
+func messagesEdit(s *store.Store) error {
+	users, err := createUsers(s, "alice", "bob")
+	if err != nil {
+		return err
+	}
+	alice, bob := users[0], users[1]
+
+	m, err := send(s, alice, bob, "@bob hi", 0, mention(bob, 0))
+	if err != nil {
+		return err
+	}
+
+	edited := at(60)
+	m.Content = "hi @alice"
+	m.EditedAt = &edited
+	m.Mentions = []models.Mention{mention(alice, 3)}
+	if err := s.Messages.Edit(m); err != nil {
+		return fmt.Errorf("Edit: %w", err)
+	}
+
+	got, err := s.Messages.Get(m.ID)
+	if err != nil {
+		return fmt.Errorf("Get: %w", err)
+	}
+	if got.Content != "hi @alice" || got.EditedAt == nil || !got.EditedAt.Equal(edited) {
+		return fmt.Errorf("after Edit the message has content %q, edited at %v", got.Content, got.EditedAt)
+	}
+	if len(got.Mentions) != 1 || got.Mentions[0].UserID != alice.ID || got.Mentions[0].Offset != 3 {
+		return fmt.Errorf("after Edit the message has mentions %+v, want only alice", got.Mentions)
+	}
+
+	// Removing every mention
+	m.Mentions = nil
+	if err := s.Messages.Edit(m); err != nil {
+		return fmt.Errorf("Edit: %w", err)
+	}
+	if got, _ := s.Messages.Get(m.ID); len(got.Mentions) != 0 {
+		return fmt.Errorf("after Edit without mentions the message has %d", len(got.Mentions))
+	}
+
+	missing := &models.Message{ID: uuid.New(), Content: "x", EditedAt: &edited}
+	return expectErr("Edit of missing message", s.Messages.Edit(missing), store.ErrNotFound)
+}
+
+func messagesDelete(s *store.Store) error {
+	users, err := createUsers(s, "alice", "bob")
+	if err != nil {
+		return err
+	}
+	m, err := send(s, users[0], users[1], "hi", 0)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Messages.Delete(m.ID); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	got, err := s.Messages.Get(m.ID)
+	if err != nil {
+		return fmt.Errorf("Get of deleted message: %w", err)
+	}
+	if !got.IsDeleted {
+		return fmt.Errorf("Delete did not mark the message deleted")
+	}
+
+	return expectErr("Delete of missing message", s.Messages.Delete(uuid.New()), store.ErrNotFound)
+}
+
+func messagesMarkRead(s *store.Store) error {
+	users, err := createUsers(s, "alice", "bob")
+	if err != nil {
+		return err
+	}
+	m, err := send(s, users[0], users[1], "hi", 0)
+	if err != nil {
+		return err
+	}
+
+	changed, err := s.Messages.MarkRead(m.ID, at(10))
+	if err != nil || !changed {
+		return fmt.Errorf("first MarkRead returned %t, %v; want true", changed, err)
+	}
+	changed, err = s.Messages.MarkRead(m.ID, at(20))
+	if err != nil || changed {
+		return fmt.Errorf("second MarkRead returned %t, %v; want false", changed, err)
+	}
+
+	got, _ := s.Messages.Get(m.ID)
+	if !got.IsRead || got.ReadAt == nil || !got.ReadAt.Equal(at(10)) {
+		return fmt.Errorf("after MarkRead the message is read %t at %v, want %v", got.IsRead, got.ReadAt, at(10))
+	}
+
+	_, err = s.Messages.MarkRead(uuid.New(), at(0))
+	return expectErr("MarkRead of missing message", err, store.ErrNotFound)
+}
+
+func messagesMarkConversationRead(s *store.Store) error {
+	users, err := createUsers(s, "alice", "bob", "carol")
+	if err != nil {
+		return err
+	}
+	alice, bob, carol := users[0], users[1], users[2]
+
+	var fromBob []*models.Message
+	for i := 0; i < 2; i++ {
+		m, err := send(s, bob, alice, fmt.Sprint("bob ", i), i)
+		if err != nil {
+			return err
+		}
+		fromBob = append(fromBob, m)
+	}
+	toBob, err := send(s, alice, bob, "alice", 2)
+	if err != nil {
+		return err
+	}
+	fromCarol, err := send(s, carol, alice, "carol", 3)
+	if err != nil {
+		return err
+	}
+
+	n, err := s.Messages.MarkConversationRead(alice.ID, bob.ID, at(10))
+	if err != nil {
+		return fmt.Errorf("MarkConversationRead: %w", err)
+	}
+	if n != 2 {
+		return fmt.Errorf("MarkConversationRead marked %d messages, want 2", n)
+	}
+	for _, m := range fromBob {
+		if got, _ := s.Messages.Get(m.ID); !got.IsRead || got.ReadAt == nil || !got.ReadAt.Equal(at(10)) {
+			return fmt.Errorf("message %q is read %t at %v", got.Content, got.IsRead, got.ReadAt)
+		}
+	}
+	for _, m := range []*models.Message{toBob, fromCarol} {
+		if got, _ := s.Messages.Get(m.ID); got.IsRead {
+			return fmt.Errorf("message %q was marked read", got.Content)
+		}
+	}
+
+	if n, _ := s.Messages.MarkConversationRead(alice.ID, bob.ID, at(20)); n != 0 {
+		return fmt.Errorf("MarkConversationRead marked %d already read messages", n)
+	}
+	return nil
+}
+
+func messagesHistory(s *store.Store) error {
+	users, err := createUsers(s, "alice", "bob", "carol")
+	if err != nil {
+		return err
+	}
+	alice, bob, carol := users[0], users[1], users[2]
+
+	steps := []struct {
+		from, to *models.User
+		content  string
+	}{
+		{alice, bob, "1"},
+		{bob, alice, "2"},
+		{alice, carol, "other conversation"},
+		{alice, bob, "deleted"},
+		{bob, alice, "3"},
+		{alice, bob, "4"},
+	}
+	for i, step := range steps {
+		m, err := send(s, step.from, step.to, step.content, i, mention(step.to, 0))
+		if err != nil {
+			return err
+		}
+		if step.content == "deleted" {
+			if err := s.Messages.Delete(m.ID); err != nil {
+				return fmt.Errorf("Delete: %w", err)
+			}
+		}
+	}
+
+	messages, err := s.Messages.History(alice.ID, bob.ID, store.HistoryQuery{Limit: 10})
+	if err != nil {
+		return fmt.Errorf("History: %w", err)
+	}
+	if got, want := contents(messages), []string{"4", "3", "2", "1"}; !sameStrings(got, want) {
+		return fmt.Errorf("History returned %v, want %v", got, want)
+	}
+	if len(messages[0].Mentions) != 1 {
+		return fmt.Errorf("History returned %d mentions on a message, want 1", len(messages[0].Mentions))
+	}
+
+	messages, _ = s.Messages.History(bob.ID, alice.ID, store.HistoryQuery{Limit: 2})
+	if got, want := contents(messages), []string{"4", "3"}; !sameStrings(got, want) {
+		return fmt.Errorf("History with limit 2 returned %v, want %v", got, want)
+	}
+
+	before := store.HistoryCursor{CreatedAt: at(4)}
+	messages, _ = s.Messages.History(alice.ID, bob.ID, store.HistoryQuery{Before: &before, Limit: 10})
+	if got, want := contents(messages), []string{"2", "1"}; !sameStrings(got, want) {
+		return fmt.Errorf("History before %v returned %v, want %v", before.CreatedAt, got, want)
+	}
+
+	messages, err = s.Messages.History(bob.ID, carol.ID, store.HistoryQuery{Limit: 10})
+	if err != nil || len(messages) != 0 {
+		return fmt.Errorf("History of an empty conversation returned %d messages, %v", len(messages), err)
+	}
+	return nil
+}
+
+// messagesHistoryPages pages through a history whose messages share their
+// creation times, which only the message IDs tell apart.
+func messagesHistoryPages(s *store.Store) error {
+	users, err := createUsers(s, "alice", "bob")
+	if err != nil {
+		return err
+	}
+	alice, bob := users[0], users[1]
+
+	var sent []models.Message
+	for i := 0; i < 7; i++ {
+		from, to := alice, bob
+		if i%2 == 1 {
+			from, to = bob, alice
+		}
+		m, err := send(s, from, to, fmt.Sprint(i), i/3)
+		if err != nil {
+			return err
+		}
+		sent = append(sent, *m)
+	}
+	newestFirst := func(messages []models.Message) []models.Message {
+		sorted := append([]models.Message(nil), messages...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return store.HistoryCursorOf(&sorted[j]).Before(store.HistoryCursorOf(&sorted[i]))
+		})
+		return sorted
+	}
+	ordered := newestFirst(sent)
+	want := contents(ordered)
+
+	var older []string
+	q := store.HistoryQuery{Limit: 3}
+	for {
+		page, err := s.Messages.History(alice.ID, bob.ID, q)
+		if err != nil {
+			return fmt.Errorf("History: %w", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		older = append(older, contents(page)...)
+		last := store.HistoryCursorOf(&page[len(page)-1])
+		q.Before = &last
+	}
+	if !sameStrings(older, want) {
+		return fmt.Errorf("paging back through History returned %v, want %v", older, want)
+	}
+
+	oldest := store.HistoryCursorOf(&ordered[len(ordered)-1])
+	newer := []string{ordered[len(ordered)-1].Content}
+	q = store.HistoryQuery{After: &oldest, Limit: 3}
+	for {
+		page, err := s.Messages.History(bob.ID, alice.ID, q)
+		if err != nil {
+			return fmt.Errorf("History: %w", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		newer = append(contents(page), newer...)
+		first := store.HistoryCursorOf(&page[0])
+		q.After = &first
+	}
+	if !sameStrings(newer, want) {
+		return fmt.Errorf("paging forward through History returned %v, want %v", newer, want)
+	}
+
+	after, before := store.HistoryCursorOf(&sent[1]), store.HistoryCursorOf(&sent[5])
+	var between []models.Message
+	for i := range sent {
+		c := store.HistoryCursorOf(&sent[i])
+		if after.Before(c) && c.Before(before) {
+			between = append(between, sent[i])
+		}
+	}
+	messages, _ := s.Messages.History(alice.ID, bob.ID, store.HistoryQuery{Before: &before, After: &after, Limit: 10})
+	if got, want := contents(messages), contents(newestFirst(between)); !sameStrings(got, want) {
+		return fmt.Errorf("History between two cursors returned %v, want %v", got, want)
+	}
+	return nil
+}
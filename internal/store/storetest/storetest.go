@@ -0,0 +1,136 @@
+// Package storetest is the contract every store implementation must pass.
+// Call Run from a test with a function that opens empty repositories:
+//
+//	storetest.Run(t, func(t *testing.T) *store.Store { return memstore.New() })
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+)
+
+type check struct {
+	name string
+	run  func(s *store.Store) error
+}
+
+var checks = []check{
+	{"users/create and get", usersCreateGet},
+	{"users/unique", usersUnique},
+	{"users/update", usersUpdate},
+	{"users/search", usersSearch},
+	{"users/login throttling", usersLogin},
+	{"messages/create and get", messagesCreateGet},
+	{"messages/edit", messagesEdit},
+	{"messages/delete", messagesDelete},
+	{"messages/mark read", messagesMarkRead},
+	{"messages/mark conversation read", messagesMarkConversationRead},
+	{"messages/history", messagesHistory},
+	{"messages/history pages", messagesHistoryPages},
+	{"conversations/list", conversationsList},
+	{"conversations/pages", conversationsPages},
+	{"conversations/summary", conversationsSummary},
+	{"writes/record and prune", writesRecord},
+}
+
+// Run runs every check of the contract as a subtest of t, each against
+// fresh repositories from open. open is given the subtest and fails it if
+// the repositories cannot be opened.
+func Run(t *testing.T, open func(t *testing.T) *store.Store) {
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.run(open(t)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// base is the time the messages of the checks are sent from. Times are
+// whole seconds, which every backend stores exactly.
+var base = time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+func at(seconds int) time.Time {
+	return base.Add(time.Duration(seconds) * time.Second)
+}
+
+func createUser(s *store.Store, name string) (*models.User, error) {
+	u := &models.User{
+		Username:     name,
+		Email:        name + "@example.com",
+		PasswordHash: "hash",
+	}
+	if err := s.Users.Create(u); err != nil {
+		return nil, fmt.Errorf("creating user %s: %w", name, err)
+	}
+	return u, nil
+}
+
+// createUsers creates a user for each name.
+func createUsers(s *store.Store, names ...string) ([]*models.User, error) {
+	users := make([]*models.User, len(names))
+	for i, name := range names {
+		u, err := createUser(s, name)
+		if err != nil {
+			return nil, err
+		}
+		users[i] = u
+	}
+	return users, nil
+}
+
+func send(s *store.Store, from, to *models.User, content string, seconds int, mentions ...models.Mention) (*models.Message, error) {
+	m := &models.Message{
+		SenderID:   from.ID,
+		ReceiverID: to.ID,
+		Content:    content,
+		CreatedAt:  at(seconds),
+		Mentions:   mentions,
+	}
+	if err := s.Messages.Create(m); err != nil {
+		return nil, fmt.Errorf("creating message %q: %w", content, err)
+	}
+	return m, nil
+}
+
+func mention(u *models.User, offset int) models.Mention {
+	return models.Mention{
+		UserID:   u.ID,
+		Username: u.Username,
+		Offset:   offset,
+		Length:   len(u.Username) + 1,
+	}
+}
+
+func expectErr(what string, err, want error) error {
+	if !errors.Is(err, want) {
+		return fmt.Errorf("%s: got error %v, want %v", what, err, want)
+	}
+	return nil
+}
+
+// contents lists the contents of the messages, to compare orders.
+func contents(messages []models.Message) []string {
+	list := make([]string, len(messages))
+	for i, m := range messages {
+		list[i] = m.Content
+	}
+	return list
+}
+
+func sameStrings(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package storetest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+)
+
+func usersCreateGet(s *store.Store) error {
+	u, err := createUser(s, "alice")
+	if err != nil {
+		return err
+	}
+	if u.ID == uuid.Nil {
+		return fmt.Errorf("Create did not set the ID")
+	}
+	if u.CreatedAt.IsZero() {
+		return fmt.Errorf("Create did not set CreatedAt")
+	}
+
+	got, err := s.Users.Get(u.ID)
+	if err != nil {
+		return fmt.Errorf("Get: %w", err)
+	}
+	if got.Username != "alice" || got.Email != "alice@example.com" || got.PasswordHash != "hash" {
+		return fmt.Errorf("Get returned %+v", got)
+	}
+
+	got, err = s.Users.GetByEmail("alice@example.com")
+	if err != nil {
+		return fmt.Errorf("GetByEmail: %w", err)
+	}
+	if got.ID != u.ID {
+		return fmt.Errorf("GetByEmail returned user %s, want %s", got.ID, u.ID)
+	}
+
+	// Deleted accounts are still returned
+	deleted := &models.User{Username: "gone", Email: "gone@example.com", DeletedAt: &base}
+	if err := s.Users.Create(deleted); err != nil {
+		return fmt.Errorf("creating deleted user: %w", err)
+	}
+	got, err = s.Users.Get(deleted.ID)
+	if err != nil {
+		return fmt.Errorf("Get of deleted user: %w", err)
+	}
+	if got.DeletedAt == nil || !got.DeletedAt.Equal(base) {
+		return fmt.Errorf("Get returned DeletedAt %v, want %v", got.DeletedAt, base)
+	}
+
+	_, err = s.Users.Get(uuid.New())
+	if err := expectErr("Get of missing user", err, store.ErrNotFound); err != nil {
+		return err
+	}
+	_, err = s.Users.GetByEmail("nobody@example.com")
+	return expectErr("GetByEmail of missing user", err, store.ErrNotFound)
+}
+
+func usersUnique(s *store.Store) error {
+	if _, err := createUser(s, "alice"); err != nil {
+		return err
+	}
+
+	err := s.Users.Create(&models.User{Username: "alice", Email: "other@example.com"})
+	if err := expectErr("Create with taken username", err, store.ErrConflict); err != nil {
+		return err
+	}
+	err = s.Users.Create(&models.User{Username: "other", Email: "alice@example.com"})
+	return expectErr("Create with taken email", err, store.ErrConflict)
+}
+
+func usersUpdate(s *store.Store) error {
+	users, err := createUsers(s, "alice", "bob")
+	if err != nil {
+		return err
+	}
+	alice := users[0]
+
+	name := "alicia"
+	got, err := s.Users.Update(alice.ID, store.UserChanges{Username: &name})
+	if err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+	if got.Username != "alicia" || got.Email != "alice@example.com" {
+		return fmt.Errorf("Update returned %s <%s>", got.Username, got.Email)
+	}
+	if stored, err := s.Users.Get(alice.ID); err != nil || stored.Username != "alicia" {
+		return fmt.Errorf("Get after Update returned %v, %v", stored, err)
+	}
+
+	email := "bob@example.com"
+	_, err = s.Users.Update(alice.ID, store.UserChanges{Email: &email})
+	if err := expectErr("Update to taken email", err, store.ErrConflict); err != nil {
+		return err
+	}
+
+	_, err = s.Users.Update(uuid.New(), store.UserChanges{Username: &name})
+	return expectErr("Update of missing user", err, store.ErrNotFound)
+}
+
+func usersSearch(s *store.Store) error {
+	if _, err := createUsers(s, "carol", "alice", "bob", "alina", "al_x"); err != nil {
+		return err
+	}
+	deleted := &models.User{Username: "albert", Email: "albert@example.com", DeletedAt: &base}
+	if err := s.Users.Create(deleted); err != nil {
+		return fmt.Errorf("creating deleted user: %w", err)
+	}
+
+	users, err := s.Users.Search("al", 10)
+	if err != nil {
+		return fmt.Errorf("Search: %w", err)
+	}
+	got := make([]string, len(users))
+	for i, u := range users {
+		got[i] = u.Username
+	}
+	if want := []string{"al_x", "alice", "alina"}; !sameStrings(got, want) {
+		return fmt.Errorf("Search(al) returned %v, want %v", got, want)
+	}
+
+	if users, _ := s.Users.Search("al", 2); len(users) != 2 {
+		return fmt.Errorf("Search with limit 2 returned %d users", len(users))
+	}
+
+	// The email is searched too
+	if users, _ := s.Users.Search("carol@", 10); len(users) != 1 {
+		return fmt.Errorf("Search(carol@) returned %d users, want 1", len(users))
+	}
+
+	// Wildcards are matched literally
+	users, err = s.Users.Search("l_", 10)
+	if err != nil {
+		return fmt.Errorf("Search: %w", err)
+	}
+	if len(users) != 1 || users[0].Username != "al_x" {
+		return fmt.Errorf("Search(l_) returned %d users, want only al_x", len(users))
+	}
+	if users, _ := s.Users.Search("%", 10); len(users) != 0 {
+		return fmt.Errorf("Search(%%) returned %d users, want none", len(users))
+	}
+	return nil
+}
+
+func usersLogin(s *store.Store) error {
+	u, err := createUser(s, "alice")
+	if err != nil {
+		return err
+	}
+
+	for want := 1; want <= 2; want++ {
+		count, err := s.Users.RecordFailedLogin(u.ID, at(want))
+		if err != nil {
+			return fmt.Errorf("RecordFailedLogin: %w", err)
+		}
+		if count != want {
+			return fmt.Errorf("RecordFailedLogin returned %d, want %d", count, want)
+		}
+	}
+	got, _ := s.Users.Get(u.ID)
+	if got.FailedLoginCount != 2 || got.LastFailedLoginAt == nil || !got.LastFailedLoginAt.Equal(at(2)) {
+		return fmt.Errorf("after two failures the user has count %d, last %v", got.FailedLoginCount, got.LastFailedLoginAt)
+	}
+
+	until := at(900)
+	if err := s.Users.LockLogin(u.ID, until); err != nil {
+		return fmt.Errorf("LockLogin: %w", err)
+	}
+	got, _ = s.Users.Get(u.ID)
+	if got.FailedLoginCount != 0 || got.LastFailedLoginAt != nil || got.LockedUntil == nil || !got.LockedUntil.Equal(until) {
+		return fmt.Errorf("after LockLogin the user has count %d, last %v, locked until %v", got.FailedLoginCount, got.LastFailedLoginAt, got.LockedUntil)
+	}
+
+	if _, err := s.Users.RecordFailedLogin(u.ID, at(901)); err != nil {
+		return fmt.Errorf("RecordFailedLogin: %w", err)
+	}
+	if err := s.Users.ResetFailedLogins(u.ID); err != nil {
+		return fmt.Errorf("ResetFailedLogins: %w", err)
+	}
+	got, _ = s.Users.Get(u.ID)
+	if got.FailedLoginCount != 0 || got.LastFailedLoginAt != nil || got.LockedUntil != nil {
+		return fmt.Errorf("after ResetFailedLogins the user has count %d, last %v, locked until %v", got.FailedLoginCount, got.LastFailedLoginAt, got.LockedUntil)
+	}
+
+	if err := s.Users.SetPasswordHash(u.ID, "new hash"); err != nil {
+		return fmt.Errorf("SetPasswordHash: %w", err)
+	}
+	if got, _ = s.Users.Get(u.ID); got.PasswordHash != "new hash" {
+		return fmt.Errorf("SetPasswordHash stored %q", got.PasswordHash)
+	}
+
+	missing := uuid.New()
+	_, err = s.Users.RecordFailedLogin(missing, time.Now())
+	if err := expectErr("RecordFailedLogin of missing user", err, store.ErrNotFound); err != nil {
+		return err
+	}
+	return expectErr("SetPasswordHash of missing user", s.Users.SetPasswordHash(missing, "x"), store.ErrNotFound)
+}
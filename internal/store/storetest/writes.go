@@ -0,0 +1,53 @@
+package storetest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+)
+
+func writesRecord(s *store.Store) error {
+	alice, bob := uuid.New(), uuid.New()
+
+	last := func(id uuid.UUID, want time.Time) error {
+		got, err := s.Writes.Last(id)
+		if err != nil {
+			return fmt.Errorf("Last: %w", err)
+		}
+		if !got.Equal(want) {
+			return fmt.Errorf("Last returned %v, want %v", got, want)
+		}
+		return nil
+	}
+
+	if err := last(alice, time.Time{}); err != nil {
+		return err
+	}
+	if err := s.Writes.Record([]uuid.UUID{alice, bob, alice}, at(10)); err != nil {
+		return fmt.Errorf("Record: %w", err)
+	}
+	if err := s.Writes.Record([]uuid.UUID{alice}, at(20)); err != nil {
+		return fmt.Errorf("Record: %w", err)
+	}
+
+	// An earlier write recorded late does not move the time back
+	if err := s.Writes.Record([]uuid.UUID{alice, bob}, at(15)); err != nil {
+		return fmt.Errorf("Record: %w", err)
+	}
+	if err := last(alice, at(20)); err != nil {
+		return err
+	}
+	if err := last(bob, at(15)); err != nil {
+		return err
+	}
+
+	if err := s.Writes.Prune(at(20)); err != nil {
+		return fmt.Errorf("Prune: %w", err)
+	}
+	if err := last(alice, at(20)); err != nil {
+		return err
+	}
+	return last(bob, time.Time{})
+}
@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+var errPrivateAddress = errors.New("webhook target resolves to a private address")
+
+// NewHTTPClient returns a client for calling user-supplied URLs. Unless
+// allowPrivate is set it refuses to connect to loopback, private and
+// link-local addresses, so users cannot point webhooks at services inside our
+// network. The check runs on the resolved address at dial time, which also
+// covers DNS names that resolve to internal hosts.
+func NewHTTPClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || isInternal(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 2,
+		},
+		// A redirect could lead anywhere; receivers must answer directly
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func isInternal(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() ||
+		ip.IsMulticast()
+}
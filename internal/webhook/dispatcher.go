@@ -0,0 +1,299 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	batchSize    = 50
+	workers      = 8
+
+	// A claimed delivery is hidden from other dispatchers for this long, so
+	// an instance that dies mid-delivery does not lose it
+	claimLease = time.Minute
+
+	baseBackoff = 10 * time.Second
+	maxBackoff  = time.Hour
+
+	pruneInterval = time.Hour
+)
+
+// Dispatcher turns events into webhook deliveries and sends them in the
+// background. Deliveries are stored before they are sent, so they survive
+// restarts and several instances can share the queue.
+//
+// Payloads carry message content in plaintext, so a delivery keeps its
+// payload only until it is sent or dead-lettered, and dead letters and the
+// delivery log are deleted once older than Retention.
+type Dispatcher struct {
+	DB          *gorm.DB
+	Client      *http.Client
+	MaxAttempts int
+	Retention   time.Duration
+
+	wake      chan struct{}
+	lastPrune time.Time
+}
+
+func NewDispatcher(db *gorm.DB, maxAttempts int, retention time.Duration, allowPrivate bool) *Dispatcher {
+	return &Dispatcher{
+		DB:          db,
+		Client:      NewHTTPClient(allowPrivate),
+		MaxAttempts: maxAttempts,
+		Retention:   retention,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Emit queues a delivery of the event for every subscription that is
+// entitled to see it.
+func (d *Dispatcher) Emit(event string, userIDs []uuid.UUID, data any) {
+	var subs []models.WebhookSubscription
+	err := d.DB.
+		Where("deleted_at IS NULL AND (all_users = TRUE OR owner_id IN ?)", userIDs).
+		Find(&subs).Error
+	if err != nil {
+		log.Println("webhook: failed to load subscriptions:", err)
+		return
+	}
+
+	now := time.Now()
+	var deliveries []models.WebhookDelivery
+	for _, sub := range subs {
+		if !slices.Contains(sub.EventList(), event) {
+			continue
+		}
+
+		id := uuid.New()
+		payload, err := json.Marshal(map[string]any{
+			"id":         id,
+			"event":      event,
+			"created_at": now,
+			"data":       data,
+		})
+		if err != nil {
+			log.Println("webhook: failed to encode payload:", err)
+			return
+		}
+
+		deliveries = append(deliveries, models.WebhookDelivery{
+			ID:             id,
+			SubscriptionID: sub.ID,
+			Event:          event,
+			Payload:        string(payload),
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  now,
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return
+	}
+
+	if err := d.DB.Create(&deliveries).Error; err != nil {
+		log.Println("webhook: failed to queue deliveries:", err)
+		return
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries until the process exits.
+func (d *Dispatcher) Run() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue()
+		if time.Since(d.lastPrune) >= pruneInterval {
+			d.prune()
+			d.lastPrune = time.Now()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue() {
+	for {
+		var due []models.WebhookDelivery
+		err := d.DB.
+			Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, time.Now()).
+			Order("next_attempt_at").
+			Limit(batchSize).
+			Find(&due).Error
+		if err != nil {
+			log.Println("webhook: failed to load due deliveries:", err)
+			return
+		}
+
+		sem := make(chan struct{}, workers)
+		var wg sync.WaitGroup
+		for i := range due {
+			if !d.claim(&due[i]) {
+				continue
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(del *models.WebhookDelivery) {
+				defer func() { <-sem; wg.Done() }()
+				d.attempt(del)
+			}(&due[i])
+		}
+		wg.Wait()
+
+		if len(due) < batchSize {
+			return
+		}
+	}
+}
+
+// claim takes a delivery for this dispatcher by pushing its next attempt
+// time forward, which only succeeds if nobody else did so first.
+func (d *Dispatcher) claim(del *models.WebhookDelivery) bool {
+	res := d.DB.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at = ?", del.ID, models.DeliveryPending, del.NextAttemptAt).
+		Update("next_attempt_at", time.Now().Add(claimLease))
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+func (d *Dispatcher) attempt(del *models.WebhookDelivery) {
+	var sub models.WebhookSubscription
+	if err := d.DB.First(&sub, "id = ? AND deleted_at IS NULL", del.SubscriptionID).Error; err != nil {
+		// Subscription removed while the delivery was queued
+		d.DB.Model(del).Updates(map[string]any{
+			"status":     models.DeliveryDead,
+			"payload":    "",
+			"last_error": "subscription deleted",
+		})
+		return
+	}
+
+	status, sendErr := d.send(&sub, del)
+	attempts := del.Attempts + 1
+	now := time.Now()
+
+	if sendErr == nil {
+		d.DB.Model(del).Updates(map[string]any{
+			"status":           models.DeliveryDelivered,
+			"payload":          "",
+			"attempts":         attempts,
+			"last_status_code": status,
+			"last_error":       "",
+			"delivered_at":     now,
+		})
+		return
+	}
+
+	if attempts >= d.MaxAttempts {
+		d.DB.Transaction(func(tx *gorm.DB) error {
+			// The payload moves to the dead letter
+			err := tx.Create(&models.WebhookDeadLetter{
+				DeliveryID:     del.ID,
+				SubscriptionID: del.SubscriptionID,
+				Event:          del.Event,
+				Payload:        del.Payload,
+				Attempts:       attempts,
+				LastError:      sendErr.Error(),
+			}).Error
+			if err != nil {
+				return err
+			}
+			return tx.Model(del).Updates(map[string]any{
+				"status":           models.DeliveryDead,
+				"payload":          "",
+				"attempts":         attempts,
+				"last_status_code": status,
+				"last_error":       sendErr.Error(),
+			}).Error
+		})
+		return
+	}
+
+	d.DB.Model(del).Updates(map[string]any{
+		"attempts":         attempts,
+		"last_status_code": status,
+		"last_error":       sendErr.Error(),
+		"next_attempt_at":  now.Add(backoff(attempts)),
+	})
+}
+
+func (d *Dispatcher) send(sub *models.WebhookSubscription, del *models.WebhookDelivery) (int, error) {
+	body := []byte(del.Payload)
+
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chat-webhooks/1")
+	req.Header.Set("X-Webhook-Event", del.Event)
+	req.Header.Set("X-Webhook-Delivery", del.ID.String())
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, time.Now().Unix(), body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// prune deletes dead letters and sent or dead deliveries older than the
+// retention period, and blanks the payloads of sent or dead deliveries
+// stored before payloads were dropped on completion.
+func (d *Dispatcher) prune() {
+	cutoff := time.Now().Add(-d.Retention)
+
+	err := d.DB.Where("created_at < ?", cutoff).Delete(&models.WebhookDeadLetter{}).Error
+	if err != nil {
+		log.Println("webhook: failed to prune dead letters:", err)
+	}
+
+	err = d.DB.
+		Where("status <> ? AND created_at < ?", models.DeliveryPending, cutoff).
+		Delete(&models.WebhookDelivery{}).Error
+	if err != nil {
+		log.Println("webhook: failed to prune deliveries:", err)
+	}
+
+	err = d.DB.Model(&models.WebhookDelivery{}).
+		Where("status <> ? AND payload <> ''", models.DeliveryPending).
+		Update("payload", "").Error
+	if err != nil {
+		log.Println("webhook: failed to blank payloads:", err)
+	}
+}
+
+// backoff doubles the wait after every failed attempt, up to maxBackoff.
+func backoff(attempts int) time.Duration {
+	delay := baseBackoff << (attempts - 1)
+	if delay <= 0 || delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
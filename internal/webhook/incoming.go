@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// IncomingTokenPrefix marks the secret in an incoming webhook URL.
+const IncomingTokenPrefix = "whk_"
+
+const (
+	maxIncomingText        = 4000
+	maxIncomingAttachments = 10
+
+	// Last-used times are written at most this often
+	incomingTouchInterval = time.Minute
+)
+
+var ErrInvalidIncomingToken = errors.New("invalid webhook token")
+
+// NewIncomingToken returns a new random token and the hash to store for it.
+func NewIncomingToken() (token string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = IncomingTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashIncomingToken(token), nil
+}
+
+// HashIncomingToken hashes a token for storage and lookup. Tokens carry 256
+// bits of randomness, so a fast unsalted hash is sufficient.
+func HashIncomingToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuthenticateIncoming resolves a token to its active webhook.
+func AuthenticateIncoming(db *gorm.DB, token string) (*models.IncomingWebhook, error) {
+	if !strings.HasPrefix(token, IncomingTokenPrefix) {
+		return nil, ErrInvalidIncomingToken
+	}
+
+	var hook models.IncomingWebhook
+	if err := db.First(&hook, "token_hash = ?", HashIncomingToken(token)).Error; err != nil {
+		return nil, ErrInvalidIncomingToken
+	}
+	if hook.RevokedAt != nil {
+		return nil, ErrInvalidIncomingToken
+	}
+
+	now := time.Now()
+	if hook.LastUsedAt == nil || now.Sub(*hook.LastUsedAt) > incomingTouchInterval {
+		db.Model(&models.IncomingWebhook{}).
+			Where("id = ?", hook.ID).
+			Update("last_used_at", now)
+	}
+
+	return &hook, nil
+}
+
+// IncomingPayload is the body an external tool posts to an incoming webhook.
+type IncomingPayload struct {
+	Text        string              `json:"text"`
+	Format      string              `json:"format"` // "plain" (default) or "markdown"
+	Attachments []models.Attachment `json:"attachments"`
+}
+
+// Normalize validates the payload and converts it to the stored
+// representation.
+func (p *IncomingPayload) Normalize() error {
+	p.Text = strings.TrimSpace(p.Text)
+	if p.Text == "" && len(p.Attachments) == 0 {
+		return errors.New("text or attachments are required")
+	}
+	if len([]rune(p.Text)) > maxIncomingText {
+		return fmt.Errorf("text must be at most %d characters", maxIncomingText)
+	}
+
+	switch p.Format {
+	case "", "plain":
+		p.Format = models.FormatPlain
+	case models.FormatMarkdown:
+	default:
+		return errors.New("format must be plain or markdown")
+	}
+
+	if len(p.Attachments) > maxIncomingAttachments {
+		return fmt.Errorf("at most %d attachments are allowed", maxIncomingAttachments)
+	}
+	for _, a := range p.Attachments {
+		u, err := url.Parse(a.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("attachment url must be an absolute http(s) URL")
+		}
+	}
+
+	return nil
+}
@@ -13,8 +13,12 @@ const (
 )
 
 type Client struct {
-	UserID   string
-	Username string
+	UserID    string
+	SessionID string
+	Username  string
+
+	// Set when the client connected as an end-to-end encryption device
+	DeviceID string
 	Conn     *websocket.Conn
 	Send     chan []byte
 	Hub      *Hub
@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/commands"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// runCommand runs a slash command typed by sender in the conversation with
+// to. Replies go only to the sending connection; posts are saved and
+// delivered like ordinary messages.
+func (h *Hub) runCommand(sender *Client, to string, name string, args string) {
+	userID, err := uuid.Parse(sender.UserID)
+	if err != nil {
+		return
+	}
+	otherID, err := uuid.Parse(to)
+	if err != nil {
+		sendEphemeral(sender, to, name, "invalid recipient", true)
+		return
+	}
+
+	res, err := h.Commands.Run(context.Background(), &commands.Invocation{
+		UserID:   userID,
+		Username: sender.Username,
+		OtherID:  otherID,
+		Name:     name,
+		Args:     args,
+	})
+
+	var cmdErr *commands.Error
+	switch {
+	case errors.Is(err, commands.ErrUnknownCommand):
+		sendEphemeral(sender, to, name, "unknown command /"+name+", see /help", true)
+		return
+	case errors.As(err, &cmdErr):
+		sendEphemeral(sender, to, name, cmdErr.Message, true)
+		return
+	case err != nil:
+		log.Printf("command /%s failed: %v", name, err)
+		sendEphemeral(sender, to, name, "command failed", true)
+		return
+	}
+
+	if res.Ephemeral != "" {
+		sendEphemeral(sender, to, name, res.Ephemeral, false)
+	}
+
+	if res.Post != nil {
+		msg := &models.Message{
+			SenderID:    userID,
+			ReceiverID:  otherID,
+			Content:     res.Post.Content,
+			Format:      res.Post.Format,
+			Attachments: res.Post.Attachments,
+		}
+		if err := h.messageService.Save(msg); err != nil {
+			sendEphemeral(sender, to, name, "failed to send message", true)
+			return
+		}
+
+		data, _ := json.Marshal(NewDirectMessage(msg, sender.Username))
+		h.BroadcastToUsers([]string{sender.UserID, to}, data)
+	}
+}
+
+// sendEphemeral sends a command reply to a single connection.
+func sendEphemeral(c *Client, to string, command string, content string, isError bool) {
+	data, _ := json.Marshal(map[string]any{
+		"type":     "command_response",
+		"command":  command,
+		"to":       to,
+		"content":  content,
+		"is_error": isError,
+	})
+
+	select {
+	case c.Send <- data:
+	default:
+	}
+}
@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/e2ee"
+	"github.com/google/uuid"
+)
+
+// relayEncrypted stores the envelopes of an encrypted message and delivers
+// each to its device, if connected. Devices that are not pick them up over
+// REST. The sending connection is told the outcome.
+func (h *Hub) relayEncrypted(sender *Client, msg IncomingMessage) {
+	if h.Devices == nil {
+		return
+	}
+	if sender.DeviceID == "" {
+		sendEncryptedError(sender, msg.To, "connect with a device_id to send encrypted messages")
+		return
+	}
+
+	senderID, err := uuid.Parse(sender.UserID)
+	if err != nil {
+		return
+	}
+	deviceID, err := uuid.Parse(sender.DeviceID)
+	if err != nil {
+		return
+	}
+	recipientID, err := uuid.Parse(msg.To)
+	if err != nil {
+		sendEncryptedError(sender, msg.To, "invalid recipient")
+		return
+	}
+
+	envelopes, err := h.Devices.Relay(senderID, deviceID, recipientID, msg.Envelopes)
+
+	var mismatch *e2ee.MismatchError
+	var invalid *e2ee.InvalidError
+	switch {
+	case errors.As(err, &mismatch):
+		data, _ := json.Marshal(map[string]any{
+			"type":    "mismatched_devices",
+			"to":      msg.To,
+			"missing": mismatch.Missing,
+			"extra":   mismatch.Extra,
+		})
+		send(sender, data)
+		return
+	case errors.As(err, &invalid):
+		sendEncryptedError(sender, msg.To, invalid.Message)
+		return
+	case errors.Is(err, e2ee.ErrNoDevices), errors.Is(err, e2ee.ErrDeviceNotFound):
+		sendEncryptedError(sender, msg.To, err.Error())
+		return
+	case err != nil:
+		log.Println("failed to relay encrypted message:", err)
+		sendEncryptedError(sender, msg.To, "failed to send message")
+		return
+	}
+
+	ids := make([]string, len(envelopes))
+	for i := range envelopes {
+		e := &envelopes[i]
+		ids[i] = e.ID.String()
+
+		data, _ := json.Marshal(NewEncryptedMessage(e))
+		h.SendToDevice(e.RecipientID.String(), e.RecipientDeviceID.String(), data)
+	}
+
+	data, _ := json.Marshal(map[string]any{
+		"type":      "encrypted_message_sent",
+		"to":        msg.To,
+		"envelopes": ids,
+	})
+	send(sender, data)
+}
+
+func sendEncryptedError(c *Client, to string, content string) {
+	data, _ := json.Marshal(map[string]any{
+		"type":    "encrypted_message_error",
+		"to":      to,
+		"content": content,
+	})
+	send(c, data)
+}
+
+// send queues data on a single connection, dropping it when the connection
+// is not keeping up.
+func send(c *Client, data []byte) {
+	select {
+	case c.Send <- data:
+	default:
+	}
+}
@@ -1,34 +1,106 @@
 package websocket
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
-	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/clientip"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/ratelimit"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+// Subprotocol is the application protocol negotiated with browser clients
+// that authenticate through the Sec-WebSocket-Protocol header. Browsers reject
+// the handshake unless the server selects one of the offered protocols, so
+// such clients offer it alongside their credential.
+const Subprotocol = "chat.v1"
+
+// Credentials passed in Sec-WebSocket-Protocol are prefixed with one of these.
+const (
+	ticketProtocolPrefix = "ticket."
+	bearerProtocolPrefix = "bearer."
+)
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // tighten later
 	},
+	Subprotocols: []string{Subprotocol},
 }
 
-func ServeWS(hub *Hub, secret string, w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
-	if token == "" {
-		http.Error(w, "missing token", http.StatusUnauthorized)
-		return
+type AuthConfig struct {
+	Secret  string
+	Tickets *TicketStore
+
+	// AllowQueryToken keeps the legacy ?token=<jwt> path enabled. JWTs in
+	// the URL end up in proxy and access logs, so it is off by default.
+	AllowQueryToken bool
+}
+
+var errMissingCredentials = errors.New("missing credentials")
+
+// authenticate resolves the identity of a WebSocket handshake. In order of
+// preference it accepts a ticket in the query string, a ticket or JWT in the
+// Sec-WebSocket-Protocol header, and a JWT in the query string.
+func authenticate(cfg AuthConfig, r *http.Request) (*auth.Claims, error) {
+	ip := clientip.FromRequest(r)
+
+	if t := r.URL.Query().Get("ticket"); t != "" {
+		return cfg.Tickets.Redeem(t, ip)
+	}
+
+	for _, p := range websocket.Subprotocols(r) {
+		if t, ok := strings.CutPrefix(p, ticketProtocolPrefix); ok {
+			return cfg.Tickets.Redeem(t, ip)
+		}
+		if t, ok := strings.CutPrefix(p, bearerProtocolPrefix); ok {
+			return auth.ParseJWT(t, cfg.Secret)
+		}
 	}
 
-	userID, err := auth.ParseJWT(token, secret)
+	if t := r.URL.Query().Get("token"); t != "" && cfg.AllowQueryToken {
+		return auth.ParseJWT(t, cfg.Secret)
+	}
+
+	return nil, errMissingCredentials
+}
+
+func ServeWS(hub *Hub, cfg AuthConfig, w http.ResponseWriter, r *http.Request) {
+	claims, err := authenticate(cfg, r)
+	if errors.Is(err, errMissingCredentials) {
+		http.Error(w, "missing credentials", http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
-		http.Error(w, "invalid token", http.StatusUnauthorized)
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
+	if err := auth.ValidateSession(hub.messageService.DB, claims); err != nil {
+		http.Error(w, "session revoked", http.StatusUnauthorized)
+		return
+	}
+	userID := claims.UserID
+
+	// Clients taking part in end-to-end encryption say which of the
+	// user's devices they are, to be sent its encrypted messages
+	deviceID := r.URL.Query().Get("device_id")
+	if deviceID != "" {
+		id, err := uuid.Parse(deviceID)
+		if err == nil && hub.Devices != nil {
+			_, err = hub.Devices.Device(userID, id)
+		}
+		if err != nil || hub.Devices == nil {
+			http.Error(w, "unknown device", http.StatusBadRequest)
+			return
+		}
+		deviceID = id.String()
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
@@ -36,19 +108,20 @@ func ServeWS(hub *Hub, secret string, w http.ResponseWriter, r *http.Request) {
 
 	msgLimiter := ratelimit.New(10, time.Second)
 
-	var user models.User
 	username := "User"
-	if err := hub.messageService.DB.First(&user, "id = ?", userID).Error; err == nil {
+	if user, err := hub.messageService.Store.Users.Get(userID); err == nil {
 		username = user.Username
 	}
 
 	client := &Client{
-		UserID:   userID.String(),
-		Username: username,
-		Conn:     conn,
-		Send:     make(chan []byte, 256),
-		Hub:      hub,
-		Limiter:  msgLimiter,
+		UserID:    userID.String(),
+		SessionID: claims.SessionID.String(),
+		Username:  username,
+		DeviceID:  deviceID,
+		Conn:      conn,
+		Send:      make(chan []byte, 256),
+		Hub:       hub,
+		Limiter:   msgLimiter,
 	}
 
 	hub.register <- client
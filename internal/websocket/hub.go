@@ -2,23 +2,40 @@ package websocket
 
 import (
 	"encoding/json"
-	//"time"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/commands"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/e2ee"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/events"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
 type Hub struct {
 	users      map[string]map[*Client]bool
 	register   chan *Client
 	unregister chan *Client
+	disconnect chan disconnectRequest
+	direct     chan directRequest
 
 	messageService *MessageService
+
+	// Commands handles messages starting with "/". Without it they are
+	// sent as text.
+	Commands *commands.Registry
+
+	// Devices relays encrypted messages. Without it they are dropped.
+	Devices *e2ee.Directory
 }
 
 func NewHub(messageService *MessageService) *Hub {
 	return &Hub{
-		users:           make(map[string]map[*Client]bool),
-		register:        make(chan *Client),
-		unregister:      make(chan *Client),
-		messageService:  messageService,
+		users:          make(map[string]map[*Client]bool),
+		register:       make(chan *Client),
+		unregister:     make(chan *Client),
+		disconnect:     make(chan disconnectRequest),
+		direct:         make(chan directRequest),
+		messageService: messageService,
 	}
 }
 
@@ -47,10 +64,132 @@ func (h *Hub) Run() {
 					h.broadcastPresence(c.UserID, false)
 				}
 			}
+
+		case req := <-h.disconnect:
+			for _, conns := range h.users {
+				for c := range conns {
+					if req.match(c) {
+						closeClient(c, req.reason)
+					}
+				}
+			}
+
+		case req := <-h.direct:
+			delivered := false
+			for c := range h.users[req.userID] {
+				if req.deviceID != "" && c.DeviceID != req.deviceID {
+					continue
+				}
+				delivered = true
+				if req.data != nil {
+					select {
+					case c.Send <- req.data:
+					default:
+					}
+				}
+			}
+			req.delivered <- delivered
 		}
 	}
 }
 
+type directRequest struct {
+	userID    string
+	deviceID  string // empty for every connection of the user
+	data      []byte
+	delivered chan bool
+}
+
+// SendToUser sends data to every connection of the user and reports whether
+// the user had any.
+func (h *Hub) SendToUser(userID string, data []byte) bool {
+	req := directRequest{
+		userID:    userID,
+		data:      data,
+		delivered: make(chan bool, 1),
+	}
+	h.direct <- req
+	return <-req.delivered
+}
+
+// SendToDevice sends data to the connections of one device of the user and
+// reports whether the device had any.
+func (h *Hub) SendToDevice(userID, deviceID string, data []byte) bool {
+	req := directRequest{
+		userID:    userID,
+		deviceID:  deviceID,
+		data:      data,
+		delivered: make(chan bool, 1),
+	}
+	h.direct <- req
+	return <-req.delivered
+}
+
+// IsOnline reports whether the user has any live connection.
+func (h *Hub) IsOnline(userID string) bool {
+	return h.SendToUser(userID, nil)
+}
+
+// Emit implements events.Sink. Mentioned users and the users whose safety
+// numbers changed are told on every connection; the other events reach
+// clients through their handlers.
+func (h *Hub) Emit(event string, userIDs []uuid.UUID, data any) {
+	var eventType string
+	switch event {
+	case events.Mentioned:
+		eventType = "mentioned"
+	case events.IdentityKeyChanged:
+		eventType = "identity_key_changed"
+	default:
+		return
+	}
+
+	payload, _ := json.Marshal(map[string]any{
+		"type": eventType,
+		"data": data,
+	})
+	for _, id := range userIDs {
+		h.SendToUser(id.String(), payload)
+	}
+}
+
+type disconnectRequest struct {
+	match  func(*Client) bool
+	reason string
+}
+
+// DisconnectSession closes every connection opened with the given session.
+func (h *Hub) DisconnectSession(sessionID string, reason string) {
+	h.disconnect <- disconnectRequest{
+		match:  func(c *Client) bool { return c.SessionID == sessionID },
+		reason: reason,
+	}
+}
+
+// DisconnectDevice closes every connection of the given device.
+func (h *Hub) DisconnectDevice(deviceID string, reason string) {
+	h.disconnect <- disconnectRequest{
+		match:  func(c *Client) bool { return c.DeviceID == deviceID },
+		reason: reason,
+	}
+}
+
+// DisconnectUser closes every connection of the given user.
+func (h *Hub) DisconnectUser(userID string, reason string) {
+	h.disconnect <- disconnectRequest{
+		match:  func(c *Client) bool { return c.UserID == userID },
+		reason: reason,
+	}
+}
+
+// closeClient sends a close frame and closes the underlying connection. The
+// client's readPump then fails and unregisters it through the normal path.
+func closeClient(c *Client, reason string) {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	c.Conn.Close()
+}
+
 func (h *Hub) broadcastPresence(userID string, online bool) {
 	event := map[string]any{
 		"type":    "presence_change",
@@ -95,10 +234,23 @@ func (h *Hub) routeMessage(sender *Client, raw []byte) {
 		return
 	}
 
+	if msg.Type == "encrypted_message" {
+		h.relayEncrypted(sender, msg)
+		return
+	}
+
 	if msg.Type != "direct_message" {
 		return
 	}
 
+	if h.Commands != nil {
+		if name, args, ok := commands.Parse(msg.Content); ok {
+			h.runCommand(sender, msg.To, name, args)
+			return
+		}
+		msg.Content = commands.Unescape(msg.Content)
+	}
+
 	//Persist message
 	saved, err := h.messageService.SaveMessage(
 		sender.UserID,
@@ -110,15 +262,7 @@ func (h *Hub) routeMessage(sender *Client, raw []byte) {
 	}
 
 	//Build outgoing message
-	out := OutgoingMessage{
-		Type:           "direct_message",
-		ID:             saved.ID.String(),
-		From:           sender.UserID,
-		To:             msg.To,
-		Content:        saved.Content,
-		Timestamp:      saved.CreatedAt.Unix(),
-		SenderUsername: sender.Username,
-	}
+	out := NewDirectMessage(saved, sender.Username)
 
 	data, _ := json.Marshal(out)
 
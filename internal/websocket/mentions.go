@@ -0,0 +1,114 @@
+package websocket
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
+	"github.com/google/uuid"
+)
+
+type mentionToken struct {
+	name   string
+	offset int
+	length int
+}
+
+func isMentionRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-'
+}
+
+// parseMentions finds the @username tokens in content. An @ preceded by a
+// letter or digit, as in an email address, does not start a mention, and
+// trailing dots and dashes are treated as punctuation.
+func parseMentions(content string) []mentionToken {
+	runes := []rune(content)
+
+	var tokens []mentionToken
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' {
+			continue
+		}
+		if i > 0 && (unicode.IsLetter(runes[i-1]) || unicode.IsDigit(runes[i-1]) || runes[i-1] == '_') {
+			continue
+		}
+
+		j := i + 1
+		for j < len(runes) && isMentionRune(runes[j]) {
+			j++
+		}
+		end := j
+		for end > i+1 && (runes[end-1] == '.' || runes[end-1] == '-') {
+			end--
+		}
+
+		if end > i+1 {
+			tokens = append(tokens, mentionToken{
+				name:   string(runes[i+1 : end]),
+				offset: i,
+				length: end - i,
+			})
+		}
+		i = j - 1
+	}
+
+	return tokens
+}
+
+// resolveMentions returns the mentions in the message's content. Only the
+// two participants can be mentioned, so a message is never announced to
+// anyone outside the conversation; other @names stay plain text.
+func resolveMentions(users store.Users, msg *models.Message) ([]models.Mention, error) {
+	tokens := parseMentions(msg.Content)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	var participants []*models.User
+	for _, id := range []uuid.UUID{msg.SenderID, msg.ReceiverID} {
+		u, err := users.Get(id)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if u.DeletedAt == nil {
+			participants = append(participants, u)
+		}
+	}
+
+	var mentions []models.Mention
+	for _, t := range tokens {
+		for _, u := range participants {
+			if strings.EqualFold(u.Username, t.name) {
+				mentions = append(mentions, models.Mention{
+					MessageID: msg.ID,
+					UserID:    u.ID,
+					Username:  u.Username,
+					Offset:    t.offset,
+					Length:    t.length,
+				})
+				break
+			}
+		}
+	}
+
+	return mentions, nil
+}
+
+// notifiedUsers lists the users to tell about mentions: everyone mentioned
+// except the author and anyone in skip.
+func notifiedUsers(msg *models.Message, mentions []models.Mention, skip []uuid.UUID) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, m := range mentions {
+		if m.UserID == msg.SenderID || slices.Contains(skip, m.UserID) || slices.Contains(ids, m.UserID) {
+			continue
+		}
+		ids = append(ids, m.UserID)
+	}
+	return ids
+}
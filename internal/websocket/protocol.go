@@ -1,9 +1,17 @@
 package websocket
 
+import (
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/e2ee"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+)
+
 type IncomingMessage struct {
-	Type    string `json:"type"`    // "direct_message"
+	Type    string `json:"type"`    // "direct_message" or "encrypted_message"
 	To      string `json:"to"`      // receiver user_id
 	Content string `json:"content"` // message text
+
+	// Ciphertext of an encrypted_message, one per device it is for
+	Envelopes []e2ee.Outgoing `json:"envelopes,omitempty"`
 }
 
 type OutgoingMessage struct {
@@ -14,4 +22,58 @@ type OutgoingMessage struct {
 	Content        string `json:"content,omitempty"`            // message text
 	Timestamp      int64  `json:"timestamp,omitempty"`
 	SenderUsername string `json:"sender_username,omitempty"`    // sender username
+	IsBot          bool   `json:"is_bot,omitempty"`             // sent by a bot account
+	Format         string `json:"format,omitempty"`             // "markdown" or "action", empty for plain text
+	Attachments    models.Attachments `json:"attachments,omitempty"`
+	Mentions       []models.Mention   `json:"mentions,omitempty"`
+}
+
+// NewDirectMessage builds the direct_message event for a persisted message.
+// A display name stored on the message takes precedence over senderUsername.
+func NewDirectMessage(m *models.Message, senderUsername string) OutgoingMessage {
+	if m.SenderName != "" {
+		senderUsername = m.SenderName
+	}
+
+	return OutgoingMessage{
+		Type:           "direct_message",
+		ID:             m.ID.String(),
+		From:           m.SenderID.String(),
+		To:             m.ReceiverID.String(),
+		Content:        m.Content,
+		Timestamp:      m.CreatedAt.Unix(),
+		SenderUsername: senderUsername,
+		IsBot:          m.IsBot,
+		Format:         m.Format,
+		Attachments:    m.Attachments,
+		Mentions:       m.Mentions,
+	}
+}
+
+// EncryptedMessage is the encrypted_message event delivering an envelope to
+// the device it was encrypted for.
+type EncryptedMessage struct {
+	Type           string `json:"type"`
+	ID             string `json:"id"`
+	From           string `json:"from"`
+	FromDevice     string `json:"from_device"`
+	To             string `json:"to"`
+	DeviceID       string `json:"device_id"`
+	CiphertextType int    `json:"ciphertext_type"`
+	Ciphertext     string `json:"ciphertext"`
+	Timestamp      int64  `json:"timestamp"`
+}
+
+func NewEncryptedMessage(e *models.Envelope) EncryptedMessage {
+	return EncryptedMessage{
+		Type:           "encrypted_message",
+		ID:             e.ID.String(),
+		From:           e.SenderID.String(),
+		FromDevice:     e.SenderDeviceID.String(),
+		To:             e.RecipientID.String(),
+		DeviceID:       e.RecipientDeviceID.String(),
+		CiphertextType: e.CiphertextType,
+		Ciphertext:     e.Ciphertext,
+		Timestamp:      e.CreatedAt.Unix(),
+	}
 }
@@ -1,15 +1,81 @@
 package websocket
 
 import (
+	"errors"
+	"log"
 	"time"
 
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/events"
 	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/notify"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/store"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+var ErrNotRecipient = errors.New("only the recipient can mark a message as read")
+
 type MessageService struct {
+	Store *store.Store
+
+	// Used for notification preferences
 	DB *gorm.DB
+
+	// Events is told about every change the service persists. May be nil.
+	Events events.Sink
+}
+
+func (s *MessageService) emit(event string, m *models.Message, data map[string]any) {
+	if s.Events == nil {
+		return
+	}
+	s.Events.Emit(event, []uuid.UUID{m.SenderID, m.ReceiverID}, data)
+}
+
+// emitMentions tells the users mentioned in the message, except those in
+// skip and those whose preferences silence it, that they were mentioned.
+func (s *MessageService) emitMentions(m *models.Message, skip []uuid.UUID) {
+	if s.Events == nil {
+		return
+	}
+
+	// Mentioned users who silenced the conversation are not told
+	var ids []uuid.UUID
+	now := time.Now()
+	for _, id := range notifiedUsers(m, m.Mentions, skip) {
+		allowed, err := notify.Allowed(s.DB, id, m.SenderID, true, now)
+		if err != nil {
+			log.Println("failed to load notification preferences:", err)
+			continue
+		}
+		if allowed {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	s.Events.Emit(events.Mentioned, ids, map[string]any{
+		"message": messageEventData(m),
+	})
+}
+
+// messageEventData is the event payload describing a message.
+func messageEventData(m *models.Message) map[string]any {
+	return map[string]any{
+		"id":          m.ID,
+		"from":        m.SenderID,
+		"to":          m.ReceiverID,
+		"content":     m.Content,
+		"is_bot":      m.IsBot,
+		"sender_name": m.SenderName,
+		"format":      m.Format,
+		"attachments": m.Attachments,
+		"mentions":    m.Mentions,
+		"timestamp":   m.CreatedAt,
+		"edited_at":   m.EditedAt,
+	}
 }
 
 func (s *MessageService) SaveMessage(
@@ -22,38 +88,79 @@ func (s *MessageService) SaveMessage(
 		SenderID:   uuid.MustParse(senderID),
 		ReceiverID: uuid.MustParse(receiverID),
 		Content:    content,
-		CreatedAt:  time.Now(),
 	}
 
-	if err := s.DB.Create(msg).Error; err != nil {
+	if err := s.Save(msg); err != nil {
 		return nil, err
 	}
 
 	return msg, nil
 }
 
+// Save persists a new message built by the caller, for senders that need to
+// set more than the sender, receiver and content.
+func (s *MessageService) Save(msg *models.Message) error {
+	mentions, err := resolveMentions(s.Store.Users, msg)
+	if err != nil {
+		return err
+	}
+	msg.Mentions = mentions
+
+	msg.CreatedAt = time.Now()
+	if err := s.Store.Messages.Create(msg); err != nil {
+		return err
+	}
+
+	s.emit(events.MessageCreated, msg, messageEventData(msg))
+	s.emitMentions(msg, nil)
+	return nil
+}
+
+// own returns the message if userID sent it, and store.ErrNotFound if not.
+func (s *MessageService) own(messageID, userID uuid.UUID) (*models.Message, error) {
+	msg, err := s.Store.Messages.Get(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.SenderID != userID {
+		return nil, store.ErrNotFound
+	}
+	return msg, nil
+}
+
 func (s *MessageService) EditMessage(
 	messageID uuid.UUID,
 	userID uuid.UUID,
 	newContent string,
 ) (*models.Message, error) {
 
-	var msg models.Message
-
-	err := s.DB.First(&msg, "id = ? AND sender_id = ?", messageID, userID).Error
+	msg, err := s.own(messageID, userID)
 	if err != nil {
 		return nil, err
 	}
 
+	// Users who were already mentioned are not told again
+	var previous []uuid.UUID
+	for _, m := range msg.Mentions {
+		previous = append(previous, m.UserID)
+	}
+
 	now := time.Now()
 	msg.Content = newContent
 	msg.EditedAt = &now
 
-	if err := s.DB.Save(&msg).Error; err != nil {
+	msg.Mentions, err = resolveMentions(s.Store.Users, msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Store.Messages.Edit(msg); err != nil {
 		return nil, err
 	}
 
-	return &msg, nil
+	s.emit(events.MessageEdited, msg, messageEventData(msg))
+	s.emitMentions(msg, previous)
+
+	return msg, nil
 }
 
 func (s *MessageService) DeleteMessage(
@@ -61,18 +168,77 @@ func (s *MessageService) DeleteMessage(
 	userID uuid.UUID,
 ) (*models.Message, error) {
 
-	var msg models.Message
-
-	err := s.DB.First(&msg, "id = ? AND sender_id = ?", messageID, userID).Error
+	msg, err := s.own(messageID, userID)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := s.Store.Messages.Delete(msg.ID); err != nil {
+		return nil, err
+	}
 	msg.IsDeleted = true
 
-	if err := s.DB.Save(&msg).Error; err != nil {
-		return nil, err
+	s.emit(events.MessageDeleted, msg, map[string]any{
+		"id":   msg.ID,
+		"from": msg.SenderID,
+		"to":   msg.ReceiverID,
+	})
+
+	return msg, nil
+}
+
+// MarkConversationRead marks every unread message otherID sent to readerID
+// as read. Nothing is emitted when there was nothing unread.
+func (s *MessageService) MarkConversationRead(readerID, otherID uuid.UUID) error {
+	now := time.Now()
+	count, err := s.Store.Messages.MarkConversationRead(readerID, otherID, now)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 && s.Events != nil {
+		s.Events.Emit(events.ConversationRead, []uuid.UUID{readerID, otherID}, map[string]any{
+			"reader_id": readerID,
+			"user_id":   otherID,
+			"read_at":   now,
+		})
+	}
+
+	return nil
+}
+
+// MarkRead marks a single message as read by its recipient. It reports
+// whether the message was unread before.
+func (s *MessageService) MarkRead(messageID, readerID uuid.UUID) (*models.Message, bool, error) {
+	msg, err := s.Store.Messages.Get(messageID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if msg.ReceiverID != readerID {
+		return nil, false, ErrNotRecipient
+	}
+
+	if msg.IsRead {
+		return msg, false, nil
+	}
+
+	now := time.Now()
+	changed, err := s.Store.Messages.MarkRead(msg.ID, now)
+	if err != nil || !changed {
+		return msg, false, err
+	}
+	msg.IsRead = true
+	msg.ReadAt = &now
+
+	if s.Events != nil {
+		s.Events.Emit(events.ConversationRead, []uuid.UUID{readerID, msg.SenderID}, map[string]any{
+			"reader_id":  readerID,
+			"user_id":    msg.SenderID,
+			"message_id": msg.ID,
+			"read_at":    now,
+		})
 	}
 
-	return &msg, nil
+	return msg, true, nil
 }
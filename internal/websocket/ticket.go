@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/auth"
+	"github.com/dakshcodez/real_time_chat_application_backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// TicketTTL is how long a WebSocket ticket can be redeemed after it is issued.
+const TicketTTL = 30 * time.Second
+
+var ErrInvalidTicket = errors.New("invalid ticket")
+
+// TicketStore holds short-lived, single-use tickets that let a client open a
+// WebSocket without putting its JWT in the URL. Tickets are stored in the
+// database, so a ticket issued by one instance can be redeemed on any
+// other.
+type TicketStore struct {
+	DB *gorm.DB
+}
+
+func NewTicketStore(db *gorm.DB) *TicketStore {
+	return &TicketStore{DB: db}
+}
+
+// hashTicket hashes a ticket for storage and lookup. Tickets carry 256 bits
+// of randomness, so a fast unsalted hash is sufficient.
+func hashTicket(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue creates a ticket for the given identity. If ip is non-empty the
+// ticket can only be redeemed from that address.
+func (s *TicketStore) Issue(claims *auth.Claims, ip string) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	value := base64.RawURLEncoding.EncodeToString(buf)
+
+	now := time.Now()
+	expiresAt := now.Add(TicketTTL)
+
+	// Drop expired tickets that were never redeemed
+	if err := s.DB.Where("expires_at < ?", now).Delete(&models.WSTicket{}).Error; err != nil {
+		return "", time.Time{}, err
+	}
+
+	err := s.DB.Create(&models.WSTicket{
+		TicketHash: hashTicket(value),
+		UserID:     claims.UserID,
+		SessionID:  claims.SessionID,
+		IP:         ip,
+		ExpiresAt:  expiresAt,
+	}).Error
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return value, expiresAt, nil
+}
+
+// Redeem consumes a ticket. A ticket is removed on first use whether or not
+// it turns out to be valid, and only the one redemption that removes it
+// succeeds.
+func (s *TicketStore) Redeem(value, ip string) (*auth.Claims, error) {
+	var t models.WSTicket
+	err := s.DB.Take(&t, "ticket_hash = ?", hashTicket(value)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidTicket
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := s.DB.Where("ticket_hash = ?", t.TicketHash).Delete(&models.WSTicket{})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	// Redeemed by another connection in the meantime
+	if res.RowsAffected == 0 {
+		return nil, ErrInvalidTicket
+	}
+
+	if time.Now().After(t.ExpiresAt) {
+		return nil, ErrInvalidTicket
+	}
+	if t.IP != "" && t.IP != ip {
+		return nil, ErrInvalidTicket
+	}
+
+	return &auth.Claims{UserID: t.UserID, SessionID: t.SessionID}, nil
+}